@@ -0,0 +1,201 @@
+// @title           API Gateway
+// @version         1.0
+// @description     Single entry point for the user, product, order and payment microservices.
+// @description     Routes are proxied to the owning service; the composite endpoints below combine several of them.
+
+// @host      localhost:8080
+// @BasePath  /api
+// @schemes   http
+
+// @securityDefinitions.apikey  BearerAuth
+// @in                          header
+// @name                        Authorization
+// @description                 "Bearer " followed by the token from POST /api/auth/login
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"api-gateway/docs"
+	gatewayhttp "api-gateway/internal/gateway/adapter/http"
+	"api-gateway/internal/gateway/adapter/upstream"
+	"api-gateway/internal/gateway/usecase"
+	"api-gateway/pkg/config"
+
+	"shared/auth"
+	"shared/client"
+	"shared/health"
+	"shared/logging"
+	"shared/metrics"
+	"shared/ratelimit"
+	"shared/server"
+	"shared/tracing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/cors"
+	httpSwagger "github.com/swaggo/http-swagger"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func main() {
+	cfg := config.Load()
+	logger := logging.Setup("api-gateway", cfg.Log)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "api-gateway", cfg.Tracing)
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "error", err)
+	}
+
+	// Requests to the services are signed, so that they trust the identity forwarded
+	serviceKey := auth.NewServiceKey(cfg.Service.Secret)
+
+	// One resilient client per upstream, shared by the composite endpoints and the spec aggregator
+	newClient := func(name, baseURL string) *client.Client {
+		return client.New(name, baseURL,
+			client.WithTimeout(cfg.UpstreamTimeout),
+			client.WithBulkhead(50),
+			client.WithHooks(tracing.ClientHooks),
+			client.WithHooks(upstream.ForwardIdentity),
+			client.WithHooks(logging.ClientHooks),
+			client.WithHooks(metrics.ClientHooks),
+			client.WithHooks(serviceKey.ClientHooks()),
+		)
+	}
+	users := newClient("user-ms", cfg.UserURL)
+	products := newClient("product-ms", cfg.ProductURL)
+	orders := newClient("order-ms", cfg.OrderURL)
+	payments := newClient("payment-ms", cfg.PaymentURL)
+
+	uc := usecase.NewCompositeUseCase(
+		upstream.NewUserService(users),
+		upstream.NewProductService(products),
+		upstream.NewOrderService(orders),
+		upstream.NewPaymentService(payments),
+	)
+	handler := gatewayhttp.NewCompositeHandler(uc)
+	specs := gatewayhttp.NewSpecAggregator(docs.SwaggerInfo.ReadDoc(), time.Minute, users, products, orders, payments)
+
+	// Tokens of revoked sessions are refused from a local copy of the list
+	revocations := auth.NewRevocationList(func(ctx context.Context) ([]auth.RevokedSession, error) {
+		var res struct {
+			Sessions []auth.RevokedSession `json:"sessions"`
+		}
+		err := users.GetJSON(ctx, "/internal/revoked-sessions", &res)
+		return res.Sessions, err
+	})
+	go revocations.Run(ctx, cfg.RevocationPollInterval)
+
+	// Bearer JWTs are checked locally, API keys by user-ms
+	verifier := auth.WithAPIKeys(
+		auth.WithRevocation(auth.NewTokenVerifier(cfg.JWTSecret), revocations),
+		auth.NewKeyCache(upstream.APIKeyVerifier(users), cfg.APIKeyCacheTTL),
+	)
+
+	var limits ratelimit.Store = ratelimit.NewMemoryStore()
+	var mongoClient *mongo.Client
+	if cfg.RateLimitStore == "mongo" {
+		mongoClient = config.ConnectMongo(ctx, cfg.MongoURI)
+		store := ratelimit.NewMongoStore(mongoClient.Database(cfg.DBName).Collection("rate_limits"))
+		if err := store.EnsureIndexes(ctx); err != nil {
+			logging.Fatal("Failed to create rate limit indexes", "error", err)
+		}
+		limits = store
+	}
+	// The verified API key first, then the authenticated user, then the
+	// client IP; credentials that did not verify count against the IP
+	clientKey := ratelimit.FirstOf(ratelimit.ByAPIKey, ratelimit.ByUser, ratelimit.ByIP(cfg.TrustProxy))
+
+	userProxy := gatewayhttp.NewProxy("user-ms", mustParse(cfg.UserURL), serviceKey, cfg.TrustProxy)
+	productProxy := gatewayhttp.NewProxy("product-ms", mustParse(cfg.ProductURL), serviceKey, cfg.TrustProxy)
+	orderProxy := gatewayhttp.NewProxy("order-ms", mustParse(cfg.OrderURL), serviceKey, cfg.TrustProxy)
+	paymentProxy := gatewayhttp.NewProxy("payment-ms", mustParse(cfg.PaymentURL), serviceKey, cfg.TrustProxy)
+
+	r := chi.NewRouter()
+	r.Use(cors.Handler(cors.Options{
+		AllowedOrigins: cfg.AllowedOrigins,
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Authorization", "Content-Type", "Accept", "X-API-Key", "If-Match", "If-None-Match", logging.HeaderRequestID},
+		ExposedHeaders: []string{"ETag", "Link", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After", logging.HeaderRequestID},
+		MaxAge:         300,
+	}))
+	r.Use(logging.RequestID, tracing.Middleware, logging.AccessLog(logger), metrics.Middleware)
+
+	// Prometheus metrics of the gateway itself
+	r.Handle("/metrics", metrics.Handler())
+
+	// Swagger UI over the merged spec of all services
+	r.Get("/swagger/doc.json", specs.ServeHTTP)
+	r.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL("/swagger/doc.json")))
+
+	r.Route("/api", func(r chi.Router) {
+		r.Use(gatewayhttp.Authenticate(verifier))
+
+		// Credential endpoints: strict per-IP limit against brute forcing.
+		// API keys are scoped to services and never reach /auth or /api-keys.
+		r.Group(func(r chi.Router) {
+			r.Use(ratelimit.Middleware(limits, cfg.AuthLimit, ratelimit.ByIP(cfg.TrustProxy)))
+			r.With(auth.RequireScope("auth")).Mount("/auth", userProxy)
+		})
+
+		// Catalog browsing: generous, but stops scrapers
+		r.Group(func(r chi.Router) {
+			r.Use(ratelimit.Middleware(limits, cfg.CatalogLimit, clientKey))
+			r.With(auth.RequireScope("products")).Mount("/products", productProxy)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(ratelimit.Middleware(limits, cfg.DefaultLimit, clientKey))
+			handler.RegisterRoutes(r)
+			r.With(auth.RequireScope("users")).Mount("/users", userProxy)
+			r.With(auth.RequireScope("api-keys")).Mount("/api-keys", userProxy)
+			r.With(auth.RequireScope("orders")).Mount("/orders", orderProxy)
+			r.With(auth.RequireScope("payments")).Mount("/payments", paymentProxy)
+		})
+	})
+
+	// Readiness requires the critical upstreams to be alive
+	checks := health.NewChecker(2 * time.Second)
+	upstreams := map[string]*client.Client{
+		users.Name():    users,
+		products.Name(): products,
+		orders.Name():   orders,
+		payments.Name(): payments,
+	}
+	for _, name := range cfg.ReadyUpstreams {
+		up, ok := upstreams[name]
+		if !ok {
+			logging.Fatal("Unknown upstream in READY_UPSTREAMS", "upstream", name)
+		}
+		checks.Add(name, health.Upstream(up))
+	}
+
+	// On SIGINT/SIGTERM: fail readiness, drain requests, then flush traces
+	srv := server.New(cfg.Server)
+	srv.BeforeDrain(checks.Shutdown)
+	if mongoClient != nil {
+		srv.OnShutdown("mongo", mongoClient.Disconnect)
+	}
+	srv.OnShutdown("tracing", shutdownTracing)
+
+	srv.ServeHTTP("gateway", ":"+cfg.Port, checks.Handler(r))
+
+	if err := srv.Wait(ctx); err != nil {
+		logging.Fatal("Stopped with errors", "error", err)
+	}
+}
+
+func mustParse(raw string) *url.URL {
+	u, err := url.Parse(raw)
+	if err != nil {
+		logging.Fatal("Invalid upstream URL", "url", raw, "error", err)
+	}
+	return u
+}
@@ -0,0 +1,73 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"shared/auth"
+)
+
+type route struct {
+	method  string
+	pattern string // exact path, or a prefix when it ends in "/*"
+}
+
+// publicRoutes can be called without a token; everything else behind the gateway requires one
+var publicRoutes = []route{
+	{http.MethodPost, "/api/auth/login"},
+	{http.MethodPost, "/api/auth/verify-email"},
+	{http.MethodPost, "/api/auth/verify-email/resend"},
+	{http.MethodPost, "/api/auth/password-reset"},
+	{http.MethodPost, "/api/auth/password-reset/confirm"},
+	// the 2FA login step; enrollment authenticates with a challenge or a token
+	{http.MethodPost, "/api/auth/2fa/verify"},
+	{http.MethodPost, "/api/auth/2fa/totp"},
+	{http.MethodPost, "/api/auth/2fa/totp/confirm"},
+	{http.MethodPost, "/api/auth/refresh"},
+	// OIDC logins: the provider list, and the redirects to and from the provider
+	{http.MethodGet, "/api/auth/oidc"},
+	{http.MethodGet, "/api/auth/oidc/*"},
+	{http.MethodPost, "/api/users"},
+	{http.MethodGet, "/api/products"},
+	{http.MethodGet, "/api/products/*"},
+}
+
+func isPublic(r *http.Request) bool {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	for _, rt := range publicRoutes {
+		if rt.method != r.Method {
+			continue
+		}
+		if prefix, ok := strings.CutSuffix(rt.pattern, "/*"); ok {
+			if strings.HasPrefix(path, prefix+"/") {
+				return true
+			}
+		} else if path == rt.pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate verifies the bearer token or API key once for every request
+// through the gateway. Public routes accept anonymous callers but still pick up the
+// identity when a valid token is sent.
+func Authenticate(v auth.Verifier) func(http.Handler) http.Handler {
+	required := auth.Middleware(v)
+	return func(next http.Handler) http.Handler {
+		protected := required(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isPublic(r) {
+				protected.ServeHTTP(w, r)
+				return
+			}
+
+			if token := auth.Credential(r); token != "" {
+				if id, err := v.Verify(r.Context(), token); err == nil {
+					r = r.WithContext(auth.NewContext(r.Context(), id))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
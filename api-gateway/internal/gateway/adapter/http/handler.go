@@ -0,0 +1,96 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"api-gateway/internal/gateway/domain"
+
+	"shared/auth"
+	"shared/problem"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type CompositeHandler struct {
+	useCase domain.CompositeUseCase
+}
+
+func NewCompositeHandler(useCase domain.CompositeUseCase) *CompositeHandler {
+	return &CompositeHandler{useCase: useCase}
+}
+
+// RegisterRoutes sets up the composite routes; they take precedence over the proxied ones
+func (h *CompositeHandler) RegisterRoutes(r chi.Router) {
+	r.With(auth.RequireScope("orders", "products", "payments")).Get("/orders/{id}/details", h.GetOrderDetails)
+	r.With(auth.RequireScope("users", "orders")).Get("/users/{id}/overview", h.GetCustomerOverview)
+}
+
+// GetOrderDetails godoc
+// @Summary      Get an order with its product and payments
+// @Description  Combines order-ms, product-ms and payment-ms. Parts that could not be loaded are listed in "missing". For the order's customer or admins.
+// @Tags         composite
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      string  true  "Order ID"
+// @Success      200  {object}  domain.OrderDetails
+// @Failure      401  {object}  problem.Problem  "Unauthorized"
+// @Failure      403  {object}  problem.Problem  "Order of another customer"
+// @Failure      404  {object}  problem.Problem  "Order not found"
+// @Failure      502  {object}  problem.Problem  "Upstream error"
+// @Router       /orders/{id}/details [get]
+func (h *CompositeHandler) GetOrderDetails(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	details, err := h.useCase.GetOrderDetails(r.Context(), id)
+	if errors.Is(err, domain.ErrNotFound) {
+		problem.HTTP(w, r, http.StatusNotFound, "Order not found", err)
+		return
+	}
+	if errors.Is(err, domain.ErrForbidden) {
+		problem.HTTP(w, r, http.StatusForbidden, "Forbidden", err)
+		return
+	}
+	if err != nil {
+		problem.HTTP(w, r, http.StatusBadGateway, err.Error(), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(details)
+}
+
+// GetCustomerOverview godoc
+// @Summary      Get a user with their orders
+// @Description  Combines user-ms and order-ms. Parts that could not be loaded are listed in "missing". For the user themself or admins.
+// @Tags         composite
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      string  true  "User ID"
+// @Success      200  {object}  domain.CustomerOverview
+// @Failure      401  {object}  problem.Problem  "Unauthorized"
+// @Failure      403  {object}  problem.Problem  "Neither the user nor an admin"
+// @Failure      404  {object}  problem.Problem  "User not found"
+// @Failure      502  {object}  problem.Problem  "Upstream error"
+// @Router       /users/{id}/overview [get]
+func (h *CompositeHandler) GetCustomerOverview(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	overview, err := h.useCase.GetCustomerOverview(r.Context(), id)
+	if errors.Is(err, domain.ErrNotFound) {
+		problem.HTTP(w, r, http.StatusNotFound, "User not found", err)
+		return
+	}
+	if errors.Is(err, domain.ErrForbidden) {
+		problem.HTTP(w, r, http.StatusForbidden, "Forbidden", err)
+		return
+	}
+	if err != nil {
+		problem.HTTP(w, r, http.StatusBadGateway, err.Error(), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(overview)
+}
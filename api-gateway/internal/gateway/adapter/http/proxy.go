@@ -0,0 +1,50 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"shared/auth"
+	"shared/logging"
+	"shared/problem"
+	"shared/tracing"
+)
+
+// NewProxy returns a reverse proxy to the upstream at target. The gateway
+// terminates authentication: the bearer token is replaced by the trusted
+// identity headers, signed with key, and identity headers sent by the
+// client are dropped.
+// The request ID and trace context are passed on to the upstream.
+// X-Forwarded-For names the gateway's peer; with trustProxy the chain the
+// peer sent is kept in front of it, so upstreams see the original client.
+func NewProxy(name string, target *url.URL, key *auth.ServiceKey, trustProxy bool) http.Handler {
+	return &httputil.ReverseProxy{
+		Transport: tracing.Transport(nil),
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			if xff := pr.In.Header.Get("X-Forwarded-For"); trustProxy && xff != "" {
+				pr.Out.Header.Set("X-Forwarded-For", xff)
+			}
+			pr.SetXForwarded()
+
+			pr.Out.Header.Del("Authorization")
+			auth.DeleteHeaders(pr.Out.Header)
+			if id, ok := auth.FromContext(pr.In.Context()); ok {
+				auth.SetHeaders(pr.Out.Header, id)
+			}
+			key.Sign(pr.Out)
+			if id := logging.RequestIDFromContext(pr.In.Context()); id != "" {
+				pr.Out.Header.Set(logging.HeaderRequestID, id)
+			}
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			// the gateway already echoes the request ID; avoid a duplicate header
+			resp.Header.Del(logging.HeaderRequestID)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			problem.HTTP(w, r, http.StatusBadGateway, name+" is unavailable", err)
+		},
+	}
+}
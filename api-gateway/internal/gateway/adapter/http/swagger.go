@@ -0,0 +1,130 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"shared/client"
+	"shared/problem"
+)
+
+// SpecAggregator serves one Swagger 2.0 document made of the gateway's own
+// spec plus the spec of every upstream. Upstream definitions are prefixed
+// with the upstream name so models with the same name cannot collide.
+type SpecAggregator struct {
+	base      string
+	upstreams []*client.Client
+	ttl       time.Duration
+
+	mu       sync.Mutex
+	cached   []byte
+	cachedAt time.Time
+}
+
+// NewSpecAggregator merges base (the gateway's spec as JSON) with the
+// /swagger/doc.json of each upstream, caching the result for ttl
+func NewSpecAggregator(base string, ttl time.Duration, upstreams ...*client.Client) *SpecAggregator {
+	return &SpecAggregator{base: base, upstreams: upstreams, ttl: ttl}
+}
+
+func (a *SpecAggregator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	spec, err := a.spec(r.Context())
+	if err != nil {
+		problem.HTTP(w, r, http.StatusInternalServerError, err.Error(), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
+
+func (a *SpecAggregator) spec(ctx context.Context) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.cached != nil && time.Since(a.cachedAt) < a.ttl {
+		return a.cached, nil
+	}
+
+	var merged map[string]any
+	if err := json.Unmarshal([]byte(a.base), &merged); err != nil {
+		return nil, err
+	}
+	paths := object(merged, "paths")
+	definitions := object(merged, "definitions")
+
+	var unavailable []string
+	for _, up := range a.upstreams {
+		var spec map[string]any
+		if err := up.GetJSON(ctx, "/swagger/doc.json", &spec); err != nil {
+			slog.WarnContext(ctx, "swagger spec unavailable", "upstream", up.Name(), "error", err)
+			unavailable = append(unavailable, up.Name())
+			continue
+		}
+		prefix := up.Name() + "."
+		prefixRefs(spec, prefix)
+
+		for name, def := range object(spec, "definitions") {
+			definitions[prefix+name] = def
+		}
+		for path, item := range object(spec, "paths") {
+			ops, _ := item.(map[string]any)
+			existing := object(paths, path)
+			for method, op := range ops {
+				if _, taken := existing[method]; !taken {
+					existing[method] = op
+				}
+			}
+		}
+	}
+
+	if len(unavailable) > 0 {
+		info := object(merged, "info")
+		desc, _ := info["description"].(string)
+		info["description"] = strings.TrimSpace(desc + "\n\nCurrently missing: " + strings.Join(unavailable, ", "))
+	}
+
+	out, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	// don't cache a partial document
+	if len(unavailable) == 0 {
+		a.cached, a.cachedAt = out, time.Now()
+	}
+	return out, nil
+}
+
+// object returns m[key] as an object, creating it when absent
+func object(m map[string]any, key string) map[string]any {
+	if v, ok := m[key].(map[string]any); ok {
+		return v
+	}
+	v := map[string]any{}
+	m[key] = v
+	return v
+}
+
+// prefixRefs rewrites every "#/definitions/X" reference in v to "#/definitions/<prefix>X"
+func prefixRefs(v any, prefix string) {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, child := range t {
+			if ref, ok := child.(string); ok && k == "$ref" {
+				if name, ok := strings.CutPrefix(ref, "#/definitions/"); ok {
+					t[k] = "#/definitions/" + prefix + name
+				}
+				continue
+			}
+			prefixRefs(child, prefix)
+		}
+	case []any:
+		for _, child := range t {
+			prefixRefs(child, prefix)
+		}
+	}
+}
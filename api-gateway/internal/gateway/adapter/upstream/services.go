@@ -0,0 +1,127 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"api-gateway/internal/gateway/domain"
+
+	"shared/auth"
+	"shared/client"
+)
+
+// ForwardIdentity passes the caller verified by the gateway on to the upstream
+var ForwardIdentity = client.Hooks{
+	BeforeAttempt: func(ctx context.Context, req *http.Request) context.Context {
+		if id, ok := auth.FromContext(ctx); ok {
+			auth.SetHeaders(req.Header, id)
+		}
+		return ctx
+	},
+}
+
+// APIKeyVerifier checks API keys with user-ms, for auth.NewKeyCache
+func APIKeyVerifier(c *client.Client) func(context.Context, string) (*auth.Identity, error) {
+	return func(ctx context.Context, key string) (*auth.Identity, error) {
+		var id auth.Identity
+		// verifying only reads the key, so the POST is safe to retry
+		err := c.PostJSON(client.WithIdempotent(ctx), "/internal/api-keys/verify", map[string]string{"key": key}, &id)
+		var statusErr *client.StatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusUnauthorized {
+			return nil, auth.ErrInvalidToken
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &id, nil
+	}
+}
+
+type userService struct {
+	client *client.Client
+}
+
+func NewUserService(c *client.Client) domain.UserService {
+	return &userService{client: c}
+}
+
+func (s *userService) GetUser(ctx context.Context, id string) (*domain.User, error) {
+	var user domain.User
+	if err := s.client.GetJSON(ctx, "/api/users/"+url.PathEscape(id), &user); err != nil {
+		return nil, mapError(err)
+	}
+	return &user, nil
+}
+
+type productService struct {
+	client *client.Client
+}
+
+func NewProductService(c *client.Client) domain.ProductService {
+	return &productService{client: c}
+}
+
+func (s *productService) GetProduct(ctx context.Context, id string) (*domain.Product, error) {
+	var product domain.Product
+	if err := s.client.GetJSON(ctx, "/api/products/"+url.PathEscape(id), &product); err != nil {
+		return nil, mapError(err)
+	}
+	return &product, nil
+}
+
+type orderService struct {
+	client *client.Client
+}
+
+func NewOrderService(c *client.Client) domain.OrderService {
+	return &orderService{client: c}
+}
+
+func (s *orderService) GetOrder(ctx context.Context, id string) (*domain.Order, error) {
+	var order domain.Order
+	if err := s.client.GetJSON(ctx, "/api/orders/"+url.PathEscape(id), &order); err != nil {
+		return nil, mapError(err)
+	}
+	return &order, nil
+}
+
+func (s *orderService) GetOrdersByCustomer(ctx context.Context, customerID string) ([]*domain.Order, error) {
+	var orders []*domain.Order
+	q := url.Values{"customer_id": {customerID}}
+	if err := s.client.GetJSON(ctx, "/api/orders/?"+q.Encode(), &orders); err != nil {
+		return nil, mapError(err)
+	}
+	return orders, nil
+}
+
+type paymentService struct {
+	client *client.Client
+}
+
+func NewPaymentService(c *client.Client) domain.PaymentService {
+	return &paymentService{client: c}
+}
+
+func (s *paymentService) GetPaymentsByOrder(ctx context.Context, orderID string) ([]*domain.Payment, error) {
+	var payments []*domain.Payment
+	q := url.Values{"order_id": {orderID}}
+	if err := s.client.GetJSON(ctx, "/api/payments/?"+q.Encode(), &payments); err != nil {
+		return nil, mapError(err)
+	}
+	return payments, nil
+}
+
+func mapError(err error) error {
+	var statusErr *client.StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusNotFound:
+			return domain.ErrNotFound
+		case http.StatusForbidden:
+			return domain.ErrForbidden
+		}
+	}
+	return err
+}
@@ -0,0 +1,8 @@
+package domain
+
+import "errors"
+
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrForbidden = errors.New("forbidden")
+)
@@ -0,0 +1,27 @@
+package domain
+
+import "context"
+
+// Ports to the upstream services
+
+type UserService interface {
+	GetUser(ctx context.Context, id string) (*User, error)
+}
+
+type ProductService interface {
+	GetProduct(ctx context.Context, id string) (*Product, error)
+}
+
+type OrderService interface {
+	GetOrder(ctx context.Context, id string) (*Order, error)
+	GetOrdersByCustomer(ctx context.Context, customerID string) ([]*Order, error)
+}
+
+type PaymentService interface {
+	GetPaymentsByOrder(ctx context.Context, orderID string) ([]*Payment, error)
+}
+
+type CompositeUseCase interface {
+	GetOrderDetails(ctx context.Context, orderID string) (*OrderDetails, error)
+	GetCustomerOverview(ctx context.Context, userID string) (*CustomerOverview, error)
+}
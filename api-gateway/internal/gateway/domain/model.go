@@ -0,0 +1,60 @@
+package domain
+
+import "time"
+
+// The types below mirror the JSON returned by the upstream services
+
+type User struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+type Product struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Price       float64   `json:"price"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type Order struct {
+	ID         string `json:"id"`
+	CustomerID string `json:"customer_id"`
+	ProductID  string `json:"product_id"`
+	Quantity   int    `json:"quantity"`
+	Status     string `json:"status"`
+	CreatedAt  int64  `json:"created_at"`
+	UpdatedAt  int64  `json:"updated_at"`
+}
+
+type Payment struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	OrderID   string    `json:"orderId"`
+	Amount    float64   `json:"amount"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// OrderDetails is an order together with its product and payments.
+// Parts whose upstream could not be reached are listed in Missing.
+type OrderDetails struct {
+	Order    *Order     `json:"order"`
+	Product  *Product   `json:"product,omitempty"`
+	Payments []*Payment `json:"payments"`
+	Missing  []string   `json:"missing,omitempty" example:"payments"`
+}
+
+// CustomerOverview is a user together with the orders they placed.
+// Parts whose upstream could not be reached are listed in Missing.
+type CustomerOverview struct {
+	User    *User    `json:"user"`
+	Orders  []*Order `json:"orders"`
+	Missing []string `json:"missing,omitempty" example:"orders"`
+}
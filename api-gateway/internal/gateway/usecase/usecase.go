@@ -0,0 +1,105 @@
+package usecase
+
+import (
+	"context"
+	"sync"
+
+	"api-gateway/internal/gateway/domain"
+)
+
+type compositeUseCase struct {
+	users    domain.UserService
+	products domain.ProductService
+	orders   domain.OrderService
+	payments domain.PaymentService
+}
+
+func NewCompositeUseCase(users domain.UserService, products domain.ProductService, orders domain.OrderService, payments domain.PaymentService) domain.CompositeUseCase {
+	return &compositeUseCase{
+		users:    users,
+		products: products,
+		orders:   orders,
+		payments: payments,
+	}
+}
+
+// GetOrderDetails fetches the order, then its product and payments in parallel.
+// Only a failure to load the order itself fails the call.
+func (uc *compositeUseCase) GetOrderDetails(ctx context.Context, orderID string) (*domain.OrderDetails, error) {
+	order, err := uc.orders.GetOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	details := &domain.OrderDetails{Order: order, Payments: []*domain.Payment{}}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	missing := func(part string) {
+		mu.Lock()
+		defer mu.Unlock()
+		details.Missing = append(details.Missing, part)
+	}
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		product, err := uc.products.GetProduct(ctx, order.ProductID)
+		if err != nil {
+			missing("product")
+			return
+		}
+		details.Product = product
+	}()
+	go func() {
+		defer wg.Done()
+		payments, err := uc.payments.GetPaymentsByOrder(ctx, order.ID)
+		if err != nil {
+			missing("payments")
+			return
+		}
+		if payments != nil {
+			details.Payments = payments
+		}
+	}()
+	wg.Wait()
+
+	return details, nil
+}
+
+// GetCustomerOverview fetches the user and their orders in parallel.
+// Only a failure to load the user itself fails the call.
+func (uc *compositeUseCase) GetCustomerOverview(ctx context.Context, userID string) (*domain.CustomerOverview, error) {
+	var (
+		user      *domain.User
+		userErr   error
+		orders    []*domain.Order
+		ordersErr error
+		wg        sync.WaitGroup
+	)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		user, userErr = uc.users.GetUser(ctx, userID)
+	}()
+	go func() {
+		defer wg.Done()
+		orders, ordersErr = uc.orders.GetOrdersByCustomer(ctx, userID)
+	}()
+	wg.Wait()
+
+	if userErr != nil {
+		return nil, userErr
+	}
+
+	overview := &domain.CustomerOverview{User: user, Orders: []*domain.Order{}}
+	if ordersErr != nil {
+		overview.Missing = append(overview.Missing, "orders")
+	} else if orders != nil {
+		overview.Orders = orders
+	}
+	return overview, nil
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"shared/auth"
+	sharedconfig "shared/config"
+	"shared/logging"
+	"shared/ratelimit"
+	"shared/server"
+	"shared/tracing"
+)
+
+// Config holds the gateway settings, see shared/config for how they are loaded
+type Config struct {
+	Port            string        `env:"PORT" default:"8080"`
+	UserURL         string        `env:"USER_SERVICE_URL" default:"http://localhost:8081"`
+	ProductURL      string        `env:"PRODUCT_SERVICE_URL" default:"http://localhost:8082"`
+	OrderURL        string        `env:"ORDER_SERVICE_URL" default:"http://localhost:8083"`
+	PaymentURL      string        `env:"PAYMENT_SERVICE_URL" default:"http://localhost:8084"`
+	UpstreamTimeout time.Duration `env:"UPSTREAM_TIMEOUT" default:"5s"`
+	JWTSecret       string        `env:"JWT_SECRET" required:"true" secret:"true"`
+	AllowedOrigins  []string      `env:"CORS_ALLOWED_ORIGINS" default:"*"`
+	// ReadyUpstreams are the services that must be alive for /readyz to pass
+	ReadyUpstreams []string `env:"READY_UPSTREAMS" default:"user-ms,product-ms,order-ms,payment-ms"`
+	// RevocationPollInterval is how often the revoked sessions are fetched
+	// from user-ms, and so how long a revoked token may still get through
+	RevocationPollInterval time.Duration `env:"REVOCATION_POLL_INTERVAL" default:"10s"`
+	// APIKeyCacheTTL is how long an API key check by user-ms is reused, and
+	// so how long a revoked key may still get through
+	APIKeyCacheTTL time.Duration `env:"API_KEY_CACHE_TTL" default:"30s"`
+
+	// RateLimitStore is "memory" (per replica) or "mongo" (shared by all replicas)
+	RateLimitStore string `env:"RATE_LIMIT_STORE" default:"memory"`
+	MongoURI       string `env:"MONGO_URI" default:"mongodb://localhost:27017" secret:"true"`
+	DBName         string `env:"DB_NAME" default:"gatewaydb"`
+	// TrustProxy makes rate limiting key anonymous clients on X-Forwarded-For,
+	// and passes that header on so services see the client too
+	TrustProxy bool `env:"TRUST_PROXY" default:"false"`
+	// Rate limit policies per route group
+	AuthLimit    ratelimit.Policy `env:"RATE_LIMIT_AUTH" default:"10/1m"`
+	CatalogLimit ratelimit.Policy `env:"RATE_LIMIT_CATALOG" default:"300/1m"`
+	DefaultLimit ratelimit.Policy `env:"RATE_LIMIT_DEFAULT" default:"120/1m"`
+
+	Service auth.ServiceConfig
+	Log     logging.Config
+	Tracing tracing.Config
+	Server  server.Config
+}
+
+func (c Config) Validate() error {
+	if c.RateLimitStore != "memory" && c.RateLimitStore != "mongo" {
+		return fmt.Errorf("RATE_LIMIT_STORE must be memory or mongo, got %q", c.RateLimitStore)
+	}
+	return nil
+}
+
+// Load reads the configuration. It exits listing every invalid setting, or
+// after printing the configuration when started with --print-config.
+func Load() Config {
+	cfg := Config{
+		AuthLimit:    ratelimit.Policy{Name: "auth"},
+		CatalogLimit: ratelimit.Policy{Name: "catalog"},
+		DefaultLimit: ratelimit.Policy{Name: "default"},
+	}
+	sharedconfig.MustLoad(&cfg)
+	return cfg
+}
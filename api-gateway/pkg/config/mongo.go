@@ -0,0 +1,28 @@
+package config
+
+import (
+	"context"
+
+	"shared/health"
+	"shared/logging"
+	"shared/metrics"
+	"shared/tracing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ConnectMongo connects to MongoDB and blocks until the server answers a
+// ping, retrying with backoff. It only gives up once ctx is cancelled.
+func ConnectMongo(ctx context.Context, uri string) *mongo.Client {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetMonitor(tracing.MongoMonitor(metrics.MongoMonitor())))
+	if err != nil {
+		logging.Fatal("Invalid MongoDB configuration", "error", err)
+	}
+
+	if err := health.WaitFor(ctx, "mongo", health.Mongo(client)); err != nil {
+		logging.Fatal("Failed to connect to MongoDB", "error", err)
+	}
+
+	return client
+}
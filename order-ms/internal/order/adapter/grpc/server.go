@@ -0,0 +1,124 @@
+package grpc
+
+import (
+	"context"
+
+	"order-ms/internal/order/domain"
+
+	"shared/grpcserver"
+	orderv1 "shared/proto/order/v1"
+
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+var validate = validator.New()
+
+// OrderServer exposes domain.OrderUseCase over gRPC
+type OrderServer struct {
+	orderv1.UnimplementedOrderServiceServer
+	useCase domain.OrderUseCase
+}
+
+func NewOrderServer(useCase domain.OrderUseCase) *OrderServer {
+	return &OrderServer{useCase: useCase}
+}
+
+func (s *OrderServer) CreateOrder(ctx context.Context, req *orderv1.CreateOrderRequest) (*orderv1.Order, error) {
+	order := domain.Order{
+		CustomerID: req.GetCustomerId(),
+		ProductID:  req.GetProductId(),
+		Quantity:   int(req.GetQuantity()),
+		Status:     req.GetStatus(),
+	}
+	if err := validate.Struct(order); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	createdOrder, err := s.useCase.CreateOrder(ctx, &order)
+	if err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+	return toProto(createdOrder), nil
+}
+
+func (s *OrderServer) GetOrder(ctx context.Context, req *orderv1.GetOrderRequest) (*orderv1.Order, error) {
+	if _, err := primitive.ObjectIDFromHex(req.GetId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid ID format")
+	}
+
+	order, err := s.useCase.GetOrderByID(ctx, req.GetId(), false)
+	if err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+	return toProto(order), nil
+}
+
+func (s *OrderServer) ListOrders(ctx context.Context, req *orderv1.ListOrdersRequest) (*orderv1.ListOrdersResponse, error) {
+	var (
+		orders []*domain.Order
+		err    error
+	)
+	if req.GetCustomerId() != "" {
+		orders, err = s.useCase.GetOrdersByCustomer(ctx, req.GetCustomerId(), false)
+	} else {
+		orders, err = s.useCase.GetOrders(ctx, false)
+	}
+	if err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+
+	res := &orderv1.ListOrdersResponse{Orders: make([]*orderv1.Order, 0, len(orders))}
+	for _, o := range orders {
+		res.Orders = append(res.Orders, toProto(o))
+	}
+	return res, nil
+}
+
+func (s *OrderServer) UpdateOrder(ctx context.Context, req *orderv1.UpdateOrderRequest) (*orderv1.Order, error) {
+	if _, err := primitive.ObjectIDFromHex(req.GetId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid ID format")
+	}
+
+	order := domain.Order{
+		CustomerID: req.GetCustomerId(),
+		ProductID:  req.GetProductId(),
+		Quantity:   int(req.GetQuantity()),
+		Status:     req.GetStatus(),
+	}
+	if err := validate.Struct(order); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	updatedOrder, err := s.useCase.UpdateOrder(ctx, req.GetId(), domain.AnyVersion, &order)
+	if err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+	return toProto(updatedOrder), nil
+}
+
+func (s *OrderServer) DeleteOrder(ctx context.Context, req *orderv1.DeleteOrderRequest) (*emptypb.Empty, error) {
+	if _, err := primitive.ObjectIDFromHex(req.GetId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid ID format")
+	}
+
+	if err := s.useCase.DeleteOrder(ctx, req.GetId(), domain.AnyVersion); err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func toProto(o *domain.Order) *orderv1.Order {
+	return &orderv1.Order{
+		Id:         o.ID.Hex(),
+		CustomerId: o.CustomerID,
+		ProductId:  o.ProductID,
+		Quantity:   int32(o.Quantity),
+		Status:     o.Status,
+		CreatedAt:  o.CreatedAt,
+		UpdatedAt:  o.UpdatedAt,
+	}
+}
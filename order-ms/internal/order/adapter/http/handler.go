@@ -6,12 +6,17 @@ import (
 
 	"order-ms/internal/order/domain"
 
-	"github.com/go-playground/validator/v10"
+	"shared/auth"
+	"shared/etag"
+	"shared/mergepatch"
+	"shared/privacy"
+	"shared/problem"
+	"shared/purchases"
 
 	"github.com/go-chi/chi/v5"
 )
 
-var validate = validator.New()
+var validate = problem.NewValidator()
 
 type OrderHandler struct {
 	useCase domain.OrderUseCase
@@ -23,149 +28,358 @@ func NewOrderHandler(useCase domain.OrderUseCase) *OrderHandler {
 
 func (h *OrderHandler) RegisterRoutes(r chi.Router) {
 	r.Route("/orders", func(r chi.Router) {
+		r.Use(auth.RequireRole(auth.RoleCustomer, auth.RoleAdmin))
 		r.Post("/", h.CreateOrder)
 		r.Get("/", h.GetOrders)
 		r.Get("/{id}", h.GetOrderByID)
 		r.Put("/{id}", h.UpdateOrder)
+		r.Patch("/{id}", h.PatchOrder)
 		r.Delete("/{id}", h.DeleteOrder)
+		r.With(auth.RequireRole(auth.RoleAdmin)).Post("/{id}/restore", h.RestoreOrder)
 	})
 }
 
+// RegisterInternalRoutes registers the routes for other services, which the
+// gateway does not expose
+func (h *OrderHandler) RegisterInternalRoutes(r chi.Router) {
+	r.With(auth.RequireService).Get("/internal/personal-data/{userID}", h.ExportCustomer)
+	r.With(auth.RequireService).Post("/internal/personal-data/{userID}/anonymize", h.AnonymizeCustomer)
+	r.With(auth.RequireService).Get("/internal/customers/{userID}/purchases/{productID}", h.GetPurchase)
+}
+
 // CreateOrder godoc
 // @Summary      Create a new order
-// @Description  Create a new order and store it in the database
+// @Description  Create a new order and store it in the database. Customers may only place pending orders for themselves.
 // @Tags         orders
 // @Accept       json
 // @Produce      json
 // @Param        order  body      domain.Order  true  "Order to create"
 // @Success      201    {object}  domain.Order
-// @Failure      400    {string}  string  "Invalid request"
-// @Failure      500    {string}  string  "Internal error"
+// @Header       201    {string}  ETag  "Version of the order"
+// @Failure      400    {object}  problem.Problem  "Invalid request"
+// @Failure      401    {object}  problem.Problem  "Not authenticated"
+// @Failure      403    {object}  problem.Problem  "Order of another customer, or a status other than pending"
+// @Failure      500    {object}  problem.Problem  "Internal error"
 // @Router       /orders [post]
 func (h *OrderHandler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	var order domain.Order
 	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
 		return
 	}
 
 	if err := validate.Struct(order); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		problem.Error(w, r, err)
 		return
 	}
 
 	createdOrder, err := h.useCase.CreateOrder(r.Context(), &order)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Error(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag.Version(createdOrder.Version))
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(createdOrder)
 }
 
 // GetOrderByID godoc
 // @Summary      Get an order by ID
-// @Description  Retrieve a single order using its ID
+// @Description  Retrieve a single order using its ID. For its customer or admins.
 // @Tags         orders
 // @Produce      json
-// @Param        id   path      string  true  "Order ID"
+// @Param        id               path      string  true   "Order ID"
+// @Param        include_deleted  query     bool    false  "Also find a soft-deleted order (admins only)"
+// @Param        If-None-Match    header    string  false  "ETag of a cached copy"
 // @Success      200  {object}  domain.Order
-// @Failure      404  {string}  string  "Order not found"
-// @Failure      500  {string}  string  "Internal error"
+// @Header       200  {string}  ETag  "Version of the order"
+// @Success      304  "Not modified"
+// @Failure      400  {object}  problem.Problem  "Malformed ID"
+// @Failure      401  {object}  problem.Problem  "Not authenticated"
+// @Failure      403  {object}  problem.Problem  "Order of another customer, or include_deleted without admin role"
+// @Failure      404  {object}  problem.Problem  "Order not found"
+// @Failure      500  {object}  problem.Problem  "Internal error"
 // @Router       /orders/{id} [get]
 func (h *OrderHandler) GetOrderByID(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-
-	order, err := h.useCase.GetOrderByID(r.Context(), id)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	includeDeleted, ok := auth.AdminFlag(w, r, "include_deleted")
+	if !ok {
 		return
 	}
-	if order == nil {
-		http.NotFound(w, r)
+
+	order, err := h.useCase.GetOrderByID(r.Context(), id, includeDeleted)
+	if err != nil {
+		problem.Error(w, r, err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(order)
+	etag.JSON(w, r, etag.Version(order.Version), order)
 }
 
 // GetAllOrders godoc
 // @Summary      Get all orders
-// @Description  Retrieve a list of all orders
+// @Description  Retrieve a list of all orders, optionally only those of one customer. Customers may only list their own orders; all orders are for admins.
 // @Tags         orders
 // @Produce      json
+// @Param        customer_id      query     string  false  "Only orders placed by this customer"
+// @Param        include_deleted  query     bool    false  "Also list soft-deleted orders (admins only)"
+// @Param        If-None-Match    header    string  false  "ETag of a cached copy"
 // @Success      200  {array}   domain.Order
-// @Failure      500  {string}  string  "Internal error"
+// @Success      304  "Not modified"
+// @Failure      401  {object}  problem.Problem  "Not authenticated"
+// @Failure      403  {object}  problem.Problem  "Orders of another customer, or include_deleted without admin role"
+// @Failure      500  {object}  problem.Problem  "Internal error"
 // @Router       /orders [get]
 func (h *OrderHandler) GetOrders(w http.ResponseWriter, r *http.Request) {
-	orders, err := h.useCase.GetOrders(r.Context())
+	includeDeleted, ok := auth.AdminFlag(w, r, "include_deleted")
+	if !ok {
+		return
+	}
+
+	var (
+		orders []*domain.Order
+		err    error
+	)
+	if customerID := r.URL.Query().Get("customer_id"); customerID != "" {
+		orders, err = h.useCase.GetOrdersByCustomer(r.Context(), customerID, includeDeleted)
+	} else {
+		orders, err = h.useCase.GetOrders(r.Context(), includeDeleted)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Error(w, r, err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(orders)
+	etag.JSON(w, r, "", orders)
 }
 
 // UpdateOrder godoc
 // @Summary      Update an order
-// @Description  Update an order by its ID
+// @Description  Update an order by its ID. Customers may only cancel their own orders before they ship; other status changes are for admins.
 // @Tags         orders
 // @Accept       json
 // @Produce      json
-// @Param        id     path      string        true  "Order ID"
-// @Param        order  body      domain.Order  true  "Updated order"
+// @Param        id        path      string        true  "Order ID"
+// @Param        If-Match  header    string        true  "ETag of the order, or *"
+// @Param        order     body      domain.Order  true  "Updated order"
 // @Success      200    {object}  domain.Order
-// @Failure      400    {string}  string  "Invalid request"
-// @Failure      404    {string}  string  "Order not found"
-// @Failure      500    {string}  string  "Internal error"
+// @Header       200    {string}  ETag  "New version of the order"
+// @Failure      400    {object}  problem.Problem  "Invalid request"
+// @Failure      401    {object}  problem.Problem  "Not authenticated"
+// @Failure      403    {object}  problem.Problem  "Order of another customer, or a status change only admins may make"
+// @Failure      404    {object}  problem.Problem  "Order not found"
+// @Failure      412    {object}  problem.Problem  "Order modified since it was read"
+// @Failure      428    {object}  problem.Problem  "If-Match missing"
+// @Failure      500    {object}  problem.Problem  "Internal error"
 // @Router       /orders/{id} [put]
 func (h *OrderHandler) UpdateOrder(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
+		return
+	}
 
 	var order domain.Order
 	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
 		return
 	}
 
 	if err := validate.Struct(order); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		problem.Error(w, r, err)
 		return
 	}
 
-	updatedOrder, err := h.useCase.UpdateOrder(r.Context(), id, &order)
+	updatedOrder, err := h.useCase.UpdateOrder(r.Context(), id, version, &order)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Error(w, r, err)
 		return
 	}
-	if updatedOrder == nil {
-		http.NotFound(w, r)
+
+	w.Header().Set("ETag", etag.Version(updatedOrder.Version))
+	json.NewEncoder(w).Encode(updatedOrder)
+}
+
+// PatchOrder godoc
+// @Summary      Partially update an order
+// @Description  Applies an RFC 7396 JSON merge patch: present fields are replaced, omitted fields are kept. The merged order must still be valid. Customers may only cancel their own orders before they ship; other status changes are for admins.
+// @Tags         orders
+// @Accept       application/merge-patch+json
+// @Accept       json
+// @Produce      json
+// @Param        id        path      string                    true  "Order ID"
+// @Param        If-Match  header    string                    true  "ETag of the order, or *"
+// @Param        order     body      domain.PatchOrderRequest  true  "Fields to change"
+// @Success      200    {object}  domain.Order
+// @Header       200    {string}  ETag  "New version of the order"
+// @Failure      400    {object}  problem.Problem  "Invalid patch or result"
+// @Failure      401    {object}  problem.Problem  "Not authenticated"
+// @Failure      403    {object}  problem.Problem  "Order of another customer, or a status change only admins may make"
+// @Failure      404    {object}  problem.Problem  "Order not found"
+// @Failure      412    {object}  problem.Problem  "Order modified since it was read"
+// @Failure      415    {object}  problem.Problem  "Unsupported media type"
+// @Failure      428    {object}  problem.Problem  "If-Match missing"
+// @Failure      500    {object}  problem.Problem  "Internal error"
+// @Router       /orders/{id} [patch]
+func (h *OrderHandler) PatchOrder(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	patch, ok := mergepatch.Read(w, r)
+	if !ok {
+		return
+	}
+
+	order, err := h.useCase.GetOrderByID(r.Context(), id, false)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	req := domain.PatchOrderRequest{
+		CustomerID: order.CustomerID,
+		ProductID:  order.ProductID,
+		Quantity:   order.Quantity,
+		Status:     order.Status,
+	}
+	fields, err := mergepatch.Struct(&req, patch)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		problem.Error(w, r, err)
 		return
 	}
 
+	updatedOrder, err := h.useCase.PatchOrder(r.Context(), id, version, fields)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag.Version(updatedOrder.Version))
 	json.NewEncoder(w).Encode(updatedOrder)
 }
 
 // DeleteOrder godoc
 // @Summary      Delete an order
-// @Description  Delete an order by its ID
+// @Description  Soft-deletes a cancelled order; an admin can restore it. Orders in any other status are part of the sales history and cannot be deleted.
 // @Tags         orders
 // @Produce      json
-// @Param        id   path      string  true  "Order ID"
+// @Param        id        path      string  true  "Order ID"
+// @Param        If-Match  header    string  true  "ETag of the order, or *"
 // @Success      204  {string}  string  "No content"
-// @Failure      404  {string}  string  "Order not found"
-// @Failure      500  {string}  string  "Internal error"
+// @Failure      401  {object}  problem.Problem  "Not authenticated"
+// @Failure      403  {object}  problem.Problem  "Order of another customer"
+// @Failure      404  {object}  problem.Problem  "Order not found"
+// @Failure      409  {object}  problem.Problem  "Order is not cancelled"
+// @Failure      412  {object}  problem.Problem  "Order modified since it was read"
+// @Failure      428  {object}  problem.Problem  "If-Match missing"
+// @Failure      500  {object}  problem.Problem  "Internal error"
 // @Router       /orders/{id} [delete]
 func (h *OrderHandler) DeleteOrder(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
-	err := h.useCase.DeleteOrder(r.Context(), id)
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.useCase.DeleteOrder(r.Context(), id, version)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Error(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// RestoreOrder godoc
+// @Summary      Restore a deleted order
+// @Description  Undoes a soft delete. Admins only.
+// @Tags         orders
+// @Produce      json
+// @Param        id        path      string  true  "Order ID"
+// @Param        If-Match  header    string  true  "ETag of the deleted order, or *"
+// @Success      200  {object}  domain.Order
+// @Header       200  {string}  ETag  "New version of the order"
+// @Failure      403  {object}  problem.Problem  "Not an admin"
+// @Failure      404  {object}  problem.Problem  "Order not found"
+// @Failure      409  {object}  problem.Problem  "Order is not deleted"
+// @Failure      412  {object}  problem.Problem  "Order modified since it was read"
+// @Failure      428  {object}  problem.Problem  "If-Match missing"
+// @Router       /orders/{id}/restore [post]
+func (h *OrderHandler) RestoreOrder(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	order, err := h.useCase.RestoreOrder(r.Context(), id, version)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag.Version(order.Version))
+	json.NewEncoder(w).Encode(order)
+}
+
+// ExportCustomer lists every order of a customer, deleted ones included, for
+// a data export by user-ms. It is internal, so it has no Swagger docs.
+func (h *OrderHandler) ExportCustomer(w http.ResponseWriter, r *http.Request) {
+	orders, err := h.useCase.GetOrdersByCustomer(r.Context(), chi.URLParam(r, "userID"), true)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	if orders == nil {
+		orders = []*domain.Order{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(privacy.Export{Records: orders})
+}
+
+// AnonymizeCustomer replaces a customer's ID in their orders, for an erasure
+// by user-ms. It is internal, so it has no Swagger docs.
+func (h *OrderHandler) AnonymizeCustomer(w http.ResponseWriter, r *http.Request) {
+	var req privacy.AnonymizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	n, err := h.useCase.AnonymizeCustomer(r.Context(), chi.URLParam(r, "userID"), req.Pseudonym)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(privacy.AnonymizeResponse{Anonymized: n})
+}
+
+// GetPurchase tells whether a customer received a product, for the verified
+// purchase flag of reviews in product-ms. It is internal, so it has no
+// Swagger docs.
+func (h *OrderHandler) GetPurchase(w http.ResponseWriter, r *http.Request) {
+	delivered, err := h.useCase.HasDelivered(r.Context(), chi.URLParam(r, "userID"), chi.URLParam(r, "productID"))
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(purchases.Purchase{Delivered: delivered})
+}
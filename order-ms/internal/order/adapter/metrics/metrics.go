@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"order-ms/internal/order/domain"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type orderMetrics struct {
+	placed *prometheus.CounterVec
+}
+
+// NewOrderMetrics returns domain.OrderMetrics backed by Prometheus counters
+func NewOrderMetrics() domain.OrderMetrics {
+	return &orderMetrics{
+		placed: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "orders_placed_total",
+			Help: "Orders placed, by initial status.",
+		}, []string{"status"}),
+	}
+}
+
+func (m *orderMetrics) OrderPlaced(status string) {
+	m.placed.WithLabelValues(status).Inc()
+}
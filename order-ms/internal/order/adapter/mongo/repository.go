@@ -3,6 +3,7 @@ package mongo
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"order-ms/internal/order/domain"
@@ -10,6 +11,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // orderRepository is the struct that implements domain.OrderRepository
@@ -30,23 +32,26 @@ func (r *orderRepository) Create(ctx context.Context, order *domain.Order) (*dom
 	order.UpdatedAt = time.Now().Unix()
 
 	_, err := r.collection.InsertOne(ctx, order)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, domain.ErrConflict
+	}
 	if err != nil {
 		return nil, err
 	}
 	return order, nil
 }
 
-func (r *orderRepository) FindByID(ctx context.Context, id string) (*domain.Order, error) {
-	objectID, err := primitive.ObjectIDFromHex(id)
+func (r *orderRepository) FindByID(ctx context.Context, id string, includeDeleted bool) (*domain.Order, error) {
+	objID, err := objectID(id)
 	if err != nil {
 		return nil, err
 	}
 
 	var order domain.Order
-	err = r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&order)
+	err = r.collection.FindOne(ctx, scoped(bson.M{"_id": objID}, includeDeleted)).Decode(&order)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, nil
+			return nil, domain.ErrNotFound
 		}
 		return nil, err
 	}
@@ -54,8 +59,16 @@ func (r *orderRepository) FindByID(ctx context.Context, id string) (*domain.Orde
 	return &order, nil
 }
 
-func (r *orderRepository) FindAll(ctx context.Context) ([]*domain.Order, error) {
-	cursor, err := r.collection.Find(ctx, bson.M{})
+func (r *orderRepository) FindAll(ctx context.Context, includeDeleted bool) ([]*domain.Order, error) {
+	return r.find(ctx, scoped(bson.M{}, includeDeleted))
+}
+
+func (r *orderRepository) FindByCustomerID(ctx context.Context, customerID string, includeDeleted bool) ([]*domain.Order, error) {
+	return r.find(ctx, scoped(bson.M{"customer_id": customerID}, includeDeleted))
+}
+
+func (r *orderRepository) find(ctx context.Context, filter bson.M) ([]*domain.Order, error) {
+	cursor, err := r.collection.Find(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -77,32 +90,161 @@ func (r *orderRepository) FindAll(ctx context.Context) ([]*domain.Order, error)
 	return orders, nil
 }
 
-func (r *orderRepository) Update(ctx context.Context, id string, order *domain.Order) (*domain.Order, error) {
-	objectID, err := primitive.ObjectIDFromHex(id)
+func (r *orderRepository) Update(ctx context.Context, id string, version int64, order *domain.Order) (*domain.Order, error) {
+	// list the fields so that created_at and _id are never overwritten
+	return r.Patch(ctx, id, version, map[string]any{
+		"customer_id": order.CustomerID,
+		"product_id":  order.ProductID,
+		"quantity":    order.Quantity,
+		"status":      order.Status,
+		"updated_at":  time.Now().Unix(),
+	})
+}
+
+func (r *orderRepository) Patch(ctx context.Context, id string, version int64, fields map[string]any) (*domain.Order, error) {
+	objID, err := objectID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	order.UpdatedAt = time.Now().Unix()
+	var order domain.Order
+	update := bson.M{"$set": fields, "$inc": bson.M{"version": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = r.collection.FindOneAndUpdate(ctx, versioned(objID, version), update, opts).Decode(&order)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, r.missed(ctx, objID)
+		}
+		return nil, err
+	}
 
-	update := bson.M{
-		"$set": order,
+	return &order, nil
+}
+
+func (r *orderRepository) Delete(ctx context.Context, id string, version int64, deletedBy string) error {
+	objID, err := objectID(id)
+	if err != nil {
+		return err
 	}
 
-	_, err = r.collection.UpdateByID(ctx, objectID, update)
+	deleted := bson.M{"deleted_at": time.Now().Unix()}
+	if deletedBy != "" {
+		deleted["deleted_by"] = deletedBy
+	}
+	update := bson.M{"$set": deleted, "$inc": bson.M{"version": 1}}
+
+	res, err := r.collection.UpdateOne(ctx, versioned(objID, version), update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return r.missed(ctx, objID)
+	}
+	return nil
+}
+
+func (r *orderRepository) Restore(ctx context.Context, id string, version int64) (*domain.Order, error) {
+	objID, err := objectID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	return r.FindByID(ctx, id)
+	filter := versioned(objID, version)
+	filter["deleted_at"] = bson.M{"$exists": true}
+	update := bson.M{
+		"$unset": bson.M{"deleted_at": "", "deleted_by": ""},
+		"$set":   bson.M{"updated_at": time.Now().Unix()},
+		"$inc":   bson.M{"version": 1},
+	}
+
+	var order domain.Order
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&order)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+		// the order is gone, live, or at another version
+		if _, err := r.FindByID(ctx, id, false); err == nil {
+			return nil, domain.ErrNotDeleted
+		}
+		if _, err := r.FindByID(ctx, id, true); err != nil {
+			return nil, err
+		}
+		return nil, domain.ErrVersionMismatch
+	}
+
+	return &order, nil
+}
+
+// notDeleted matches documents without deleted_at, i.e. orders that are not soft-deleted
+var notDeleted = bson.M{"$exists": false}
+
+func (r *orderRepository) AnonymizeCustomer(ctx context.Context, customerID, pseudonym string) (int64, error) {
+	var changed int64
+	// the customer's own orders, and orders of others they deleted
+	for _, field := range []string{"customer_id", "deleted_by"} {
+		update := bson.M{
+			"$set": bson.M{field: pseudonym, "updated_at": time.Now().Unix()},
+			"$inc": bson.M{"version": 1},
+		}
+		res, err := r.collection.UpdateMany(ctx, bson.M{field: customerID}, update)
+		if err != nil {
+			return changed, err
+		}
+		changed += res.ModifiedCount
+	}
+	return changed, nil
+}
+
+func (r *orderRepository) HasDelivered(ctx context.Context, customerID, productID string) (bool, error) {
+	filter := scoped(bson.M{"customer_id": customerID, "product_id": productID, "status": domain.StatusDelivered}, false)
+	n, err := r.collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// scoped restricts filter to live orders unless includeDeleted
+func scoped(filter bson.M, includeDeleted bool) bson.M {
+	if !includeDeleted {
+		filter["deleted_at"] = notDeleted
+	}
+	return filter
 }
 
-func (r *orderRepository) Delete(ctx context.Context, id string) error {
-	objectID, err := primitive.ObjectIDFromHex(id)
+// missed explains a conditional write that matched nothing: the order is
+// gone or soft-deleted, or is at another version
+func (r *orderRepository) missed(ctx context.Context, objID primitive.ObjectID) error {
+	n, err := r.collection.CountDocuments(ctx, bson.M{"_id": objID, "deleted_at": notDeleted})
 	if err != nil {
 		return err
 	}
+	if n == 0 {
+		return domain.ErrNotFound
+	}
+	return domain.ErrVersionMismatch
+}
 
-	_, err = r.collection.DeleteOne(ctx, bson.M{"_id": objectID})
-	return err
+// versioned matches the live order with objID at version. Orders stored
+// before versioning have no version field and are at version 0.
+func versioned(objID primitive.ObjectID, version int64) bson.M {
+	filter := bson.M{"_id": objID, "deleted_at": notDeleted}
+	switch version {
+	case domain.AnyVersion:
+	case 0:
+		filter["version"] = bson.M{"$exists": false}
+	default:
+		filter["version"] = version
+	}
+	return filter
+}
+
+func objectID(id string) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return objID, fmt.Errorf("%w: malformed ID %q", domain.ErrValidation, id)
+	}
+	return objID, nil
 }
@@ -0,0 +1,16 @@
+package domain
+
+import "shared/apperr"
+
+var (
+	ErrNotFound   = apperr.New(apperr.NotFound, "order not found")
+	ErrConflict   = apperr.New(apperr.Conflict, "an order with this ID already exists")
+	ErrValidation = apperr.New(apperr.Validation, "invalid input")
+	ErrForbidden  = apperr.New(apperr.Forbidden, "not allowed to access this order")
+
+	ErrStatusForbidden = apperr.New(apperr.Forbidden, "customers may only place pending orders and cancel them before they ship")
+
+	ErrVersionMismatch = apperr.New(apperr.PreconditionFailed, "the order was modified since it was read; fetch it again")
+	ErrNotDeleted      = apperr.New(apperr.Conflict, "the order is not deleted")
+	ErrNotCancelled    = apperr.New(apperr.Conflict, "only cancelled orders can be deleted")
+)
@@ -6,27 +6,80 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// AnyVersion makes a write unconditional. It equals etag.Any, which
+// "If-Match: *" yields; internal gRPC callers use it as well.
+const AnyVersion int64 = -1
+
 type Order struct {
 	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	CustomerID string             `bson:"customer_id" json:"customer_id" validate:"required"`
 	ProductID  string             `bson:"product_id" json:"product_id" validate:"required"`
 	Quantity   int                `bson:"quantity" json:"quantity" validate:"required,min=1"`
-	Status     string             `bson:"status" json:"status" validate:"required,oneof=pending confirmed shipped delivered"`
+	Status     string             `bson:"status" json:"status" validate:"required,oneof=pending confirmed shipped delivered cancelled"`
 	CreatedAt  int64              `bson:"created_at" json:"created_at"`
 	UpdatedAt  int64              `bson:"updated_at" json:"updated_at"`
+	// Version is incremented by every write and served as the ETag
+	Version int64 `bson:"version" json:"version"`
+	// DeletedAt is set when the order is soft-deleted; such orders are
+	// hidden from every query but the admin views and can be restored
+	DeletedAt int64  `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy string `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
 }
+
+const (
+	// StatusPending is the status customers place orders in
+	StatusPending   = "pending"
+	StatusConfirmed = "confirmed"
+	// StatusCancelled is the only status in which an order may be deleted
+	StatusCancelled = "cancelled"
+	// StatusDelivered makes an order a purchase its customer may review as
+	// such; only admins and other services may set it
+	StatusDelivered = "delivered"
+)
+
 type OrderRepository interface {
 	Create(ctx context.Context, order *Order) (*Order, error)
-	FindByID(ctx context.Context, id string) (*Order, error)
-	FindAll(ctx context.Context) ([]*Order, error)
-	Update(ctx context.Context, id string, order *Order) (*Order, error)
-	Delete(ctx context.Context, id string) error
+	// Reads skip soft-deleted orders unless includeDeleted
+	FindByID(ctx context.Context, id string, includeDeleted bool) (*Order, error)
+	FindAll(ctx context.Context, includeDeleted bool) ([]*Order, error)
+	FindByCustomerID(ctx context.Context, customerID string, includeDeleted bool) ([]*Order, error)
+	// Writes apply only while the order is at version (see AnyVersion) and
+	// fail with ErrVersionMismatch otherwise
+	Update(ctx context.Context, id string, version int64, order *Order) (*Order, error)
+	// Patch sets only the given fields, keyed by bson name
+	Patch(ctx context.Context, id string, version int64, fields map[string]any) (*Order, error)
+	// Delete soft-deletes the order, recording who did it
+	Delete(ctx context.Context, id string, version int64, deletedBy string) error
+	// Restore undoes Delete; it fails with ErrNotDeleted for a live order
+	Restore(ctx context.Context, id string, version int64) (*Order, error)
+	// AnonymizeCustomer replaces customerID by pseudonym wherever an order
+	// records it, deleted orders included, and returns how many changed
+	AnonymizeCustomer(ctx context.Context, customerID, pseudonym string) (int64, error)
+	// HasDelivered reports whether a live order of the customer for the
+	// product is delivered
+	HasDelivered(ctx context.Context, customerID, productID string) (bool, error)
 }
 
 type OrderUseCase interface {
 	CreateOrder(ctx context.Context, order *Order) (*Order, error)
-	GetOrderByID(ctx context.Context, id string) (*Order, error)
-	GetOrders(ctx context.Context) ([]*Order, error)
-	UpdateOrder(ctx context.Context, id string, order *Order) (*Order, error)
-	DeleteOrder(ctx context.Context, id string) error
+	GetOrderByID(ctx context.Context, id string, includeDeleted bool) (*Order, error)
+	GetOrders(ctx context.Context, includeDeleted bool) ([]*Order, error)
+	GetOrdersByCustomer(ctx context.Context, customerID string, includeDeleted bool) ([]*Order, error)
+	UpdateOrder(ctx context.Context, id string, version int64, order *Order) (*Order, error)
+	PatchOrder(ctx context.Context, id string, version int64, fields map[string]any) (*Order, error)
+	// DeleteOrder soft-deletes a cancelled order; other orders are part of
+	// the sales history and fail with ErrNotCancelled
+	DeleteOrder(ctx context.Context, id string, version int64) error
+	RestoreOrder(ctx context.Context, id string, version int64) (*Order, error)
+	// AnonymizeCustomer unlinks a customer's orders from them for an erasure
+	// request; the orders stay as sales records
+	AnonymizeCustomer(ctx context.Context, customerID, pseudonym string) (int64, error)
+	// HasDelivered reports whether the customer received the product, which
+	// product-ms shows on their reviews as a verified purchase
+	HasDelivered(ctx context.Context, customerID, productID string) (bool, error)
+}
+
+// OrderMetrics records business events for monitoring
+type OrderMetrics interface {
+	OrderPlaced(status string)
 }
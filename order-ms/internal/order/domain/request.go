@@ -7,6 +7,15 @@ type CreateOrderRequest struct {
 	Status     string   `json:"status" validate:"required,oneof=placed shipped delivered cancelled"`
 }
 
+// PatchOrderRequest is the document PATCH merge patches are applied to;
+// bson names the fields a patch may change
+type PatchOrderRequest struct {
+	CustomerID string `json:"customer_id" bson:"customer_id" validate:"required"`
+	ProductID  string `json:"product_id" bson:"product_id" validate:"required"`
+	Quantity   int    `json:"quantity" bson:"quantity" validate:"required,min=1"`
+	Status     string `json:"status" bson:"status" validate:"required,oneof=pending confirmed shipped delivered cancelled"`
+}
+
 type UpdateOrderRequest struct {
 	ProductIDs []string `json:"product_ids" validate:"required,dive,required"`
 	Total      float64  `json:"total" validate:"required,gt=0"`
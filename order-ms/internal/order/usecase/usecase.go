@@ -4,46 +4,190 @@ import (
 	"context"
 	"order-ms/internal/order/domain"
 	"time"
+
+	"shared/auth"
 )
 
 // OrderUseCase is the interface that defines business logic for orders
 type OrderUseCase interface {
 	CreateOrder(ctx context.Context, order *domain.Order) (*domain.Order, error)
-	GetOrderByID(ctx context.Context, id string) (*domain.Order, error)
-	GetOrders(ctx context.Context) ([]*domain.Order, error)
-	UpdateOrder(ctx context.Context, id string, order *domain.Order) (*domain.Order, error)
-	DeleteOrder(ctx context.Context, id string) error
+	GetOrderByID(ctx context.Context, id string, includeDeleted bool) (*domain.Order, error)
+	GetOrders(ctx context.Context, includeDeleted bool) ([]*domain.Order, error)
+	GetOrdersByCustomer(ctx context.Context, customerID string, includeDeleted bool) ([]*domain.Order, error)
+	UpdateOrder(ctx context.Context, id string, version int64, order *domain.Order) (*domain.Order, error)
+	PatchOrder(ctx context.Context, id string, version int64, fields map[string]any) (*domain.Order, error)
+	DeleteOrder(ctx context.Context, id string, version int64) error
+	RestoreOrder(ctx context.Context, id string, version int64) (*domain.Order, error)
+	AnonymizeCustomer(ctx context.Context, customerID, pseudonym string) (int64, error)
+	HasDelivered(ctx context.Context, customerID, productID string) (bool, error)
 }
 
 // orderUseCase is the struct that implements OrderUseCase interface
 type orderUseCase struct {
-	repo domain.OrderRepository
+	repo    domain.OrderRepository
+	metrics domain.OrderMetrics
 }
 
 // NewOrderUseCase creates a new instance of orderUseCase
-func NewOrderUseCase(r domain.OrderRepository) OrderUseCase {
-	return &orderUseCase{repo: r}
+func NewOrderUseCase(r domain.OrderRepository, m domain.OrderMetrics) OrderUseCase {
+	return &orderUseCase{repo: r, metrics: m}
 }
 
 func (uc *orderUseCase) CreateOrder(ctx context.Context, order *domain.Order) (*domain.Order, error) {
+	if err := authorizeWrite(ctx, nil, order.CustomerID, order.Status); err != nil {
+		return nil, err
+	}
 	order.CreatedAt = time.Now().Unix()
 	order.UpdatedAt = time.Now().Unix()
-	return uc.repo.Create(ctx, order)
+	order.Version = 1
+	created, err := uc.repo.Create(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	uc.metrics.OrderPlaced(created.Status)
+	return created, nil
+}
+
+func (uc *orderUseCase) GetOrderByID(ctx context.Context, id string, includeDeleted bool) (*domain.Order, error) {
+	order, err := uc.repo.FindByID(ctx, id, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeCustomer(ctx, order.CustomerID); err != nil {
+		return nil, err
+	}
+	return order, nil
 }
 
-func (uc *orderUseCase) GetOrderByID(ctx context.Context, id string) (*domain.Order, error) {
-	return uc.repo.FindByID(ctx, id)
+func (uc *orderUseCase) GetOrders(ctx context.Context, includeDeleted bool) ([]*domain.Order, error) {
+	if caller, ok := auth.FromContext(ctx); ok && caller.Role != auth.RoleAdmin {
+		return nil, domain.ErrForbidden
+	}
+	return uc.repo.FindAll(ctx, includeDeleted)
 }
 
-func (uc *orderUseCase) GetOrders(ctx context.Context) ([]*domain.Order, error) {
-	return uc.repo.FindAll(ctx)
+func (uc *orderUseCase) GetOrdersByCustomer(ctx context.Context, customerID string, includeDeleted bool) ([]*domain.Order, error) {
+	if err := authorizeCustomer(ctx, customerID); err != nil {
+		return nil, err
+	}
+	return uc.repo.FindByCustomerID(ctx, customerID, includeDeleted)
 }
 
-func (uc *orderUseCase) UpdateOrder(ctx context.Context, id string, order *domain.Order) (*domain.Order, error) {
+func (uc *orderUseCase) UpdateOrder(ctx context.Context, id string, version int64, order *domain.Order) (*domain.Order, error) {
+	current, err := uc.repo.FindByID(ctx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeWrite(ctx, current, order.CustomerID, order.Status); err != nil {
+		return nil, err
+	}
+	// pin the version that was checked, so the status cannot change in between
+	if version == domain.AnyVersion {
+		version = current.Version
+	}
+
 	order.UpdatedAt = time.Now().Unix()
-	return uc.repo.Update(ctx, id, order)
+	return uc.repo.Update(ctx, id, version, order)
+}
+
+// PatchOrder writes even an empty patch, so that it too checks and bumps the version
+func (uc *orderUseCase) PatchOrder(ctx context.Context, id string, version int64, fields map[string]any) (*domain.Order, error) {
+	current, err := uc.repo.FindByID(ctx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	customerID, status := current.CustomerID, current.Status
+	if v, ok := fields["customer_id"].(string); ok {
+		customerID = v
+	}
+	if v, ok := fields["status"].(string); ok {
+		status = v
+	}
+	if err := authorizeWrite(ctx, current, customerID, status); err != nil {
+		return nil, err
+	}
+	if version == domain.AnyVersion {
+		version = current.Version
+	}
+
+	fields["updated_at"] = time.Now().Unix()
+	return uc.repo.Patch(ctx, id, version, fields)
+}
+
+func (uc *orderUseCase) DeleteOrder(ctx context.Context, id string, version int64) error {
+	order, err := uc.repo.FindByID(ctx, id, false)
+	if err != nil {
+		return err
+	}
+	if err := authorizeCustomer(ctx, order.CustomerID); err != nil {
+		return err
+	}
+	if order.Status != domain.StatusCancelled {
+		return domain.ErrNotCancelled
+	}
+	// pin the version that was checked, so the order cannot leave the
+	// cancelled status before it is deleted
+	if version == domain.AnyVersion {
+		version = order.Version
+	}
+
+	var deletedBy string
+	if caller, ok := auth.FromContext(ctx); ok {
+		deletedBy = caller.UserID
+	}
+	return uc.repo.Delete(ctx, id, version, deletedBy)
 }
 
-func (uc *orderUseCase) DeleteOrder(ctx context.Context, id string) error {
-	return uc.repo.Delete(ctx, id)
+func (uc *orderUseCase) RestoreOrder(ctx context.Context, id string, version int64) (*domain.Order, error) {
+	return uc.repo.Restore(ctx, id, version)
+}
+
+func (uc *orderUseCase) AnonymizeCustomer(ctx context.Context, customerID, pseudonym string) (int64, error) {
+	return uc.repo.AnonymizeCustomer(ctx, customerID, pseudonym)
+}
+
+func (uc *orderUseCase) HasDelivered(ctx context.Context, customerID, productID string) (bool, error) {
+	return uc.repo.HasDelivered(ctx, customerID, productID)
+}
+
+// authorizeCustomer allows only admins and the customer themself to access
+// the orders of customerID. Calls without a caller come from other services: the HTTP
+// routes require one, and gRPC and the internal routes only accept signed
+// calls.
+func authorizeCustomer(ctx context.Context, customerID string) error {
+	caller, ok := auth.FromContext(ctx)
+	if !ok || caller.Role == auth.RoleAdmin || caller.UserID == customerID {
+		return nil
+	}
+	return domain.ErrForbidden
+}
+
+// authorizeWrite checks a write that leaves an order with customerID and
+// status; current is the stored order, nil for a new one. Customers may only
+// place pending orders for themselves and cancel them before they ship.
+// Confirming, shipping and delivering are up to admins and other services,
+// since product-ms trusts a delivered order as a verified purchase.
+func authorizeWrite(ctx context.Context, current *domain.Order, customerID, status string) error {
+	caller, ok := auth.FromContext(ctx)
+	if !ok || caller.Role == auth.RoleAdmin {
+		return nil
+	}
+	if customerID != caller.UserID || (current != nil && current.CustomerID != caller.UserID) {
+		return domain.ErrForbidden
+	}
+
+	switch {
+	case current == nil:
+		if status != domain.StatusPending {
+			return domain.ErrStatusForbidden
+		}
+	case status == current.Status:
+	case status == domain.StatusCancelled:
+		if current.Status != domain.StatusPending && current.Status != domain.StatusConfirmed {
+			return domain.ErrStatusForbidden
+		}
+	default:
+		return domain.ErrStatusForbidden
+	}
+	return nil
 }
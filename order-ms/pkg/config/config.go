@@ -0,0 +1,30 @@
+package config
+
+import (
+	"shared/auth"
+	sharedconfig "shared/config"
+	"shared/logging"
+	"shared/server"
+	"shared/tracing"
+)
+
+// Config holds the order-ms settings, see shared/config for how they are loaded
+type Config struct {
+	Port     string `env:"PORT" default:"8083"`
+	GRPCPort string `env:"GRPC_PORT" default:"9093"`
+	MongoURI string `env:"MONGO_URI" default:"mongodb://localhost:27017" secret:"true"`
+	DBName   string `env:"DB_NAME" default:"orderdb"`
+
+	Service auth.ServiceConfig
+	Log     logging.Config
+	Tracing tracing.Config
+	Server  server.Config
+}
+
+// Load reads the configuration. It exits listing every invalid setting, or
+// after printing the configuration when started with --print-config.
+func Load() Config {
+	var cfg Config
+	sharedconfig.MustLoad(&cfg)
+	return cfg
+}
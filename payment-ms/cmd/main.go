@@ -7,42 +7,61 @@
 package main
 
 import (
-	"log"
-	"net/http"
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	_ "payment-ms/docs"
 
+	paymentgrpc "payment-ms/internal/payment/adapter/grpc"
 	paymenthttp "payment-ms/internal/payment/adapter/http"
+	paymentmetrics "payment-ms/internal/payment/adapter/metrics"
 	"payment-ms/internal/payment/adapter/mongo"
 	"payment-ms/internal/payment/usecase"
 	"payment-ms/pkg/config"
 
+	"shared/auth"
+	"shared/grpcserver"
+	"shared/health"
+	"shared/logging"
+	"shared/metrics"
+	paymentv1 "shared/proto/payment/v1"
+	"shared/server"
+	"shared/tracing"
+
 	"github.com/go-chi/chi/v5"
-	"github.com/joho/godotenv"
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
-func init() {
-	// Load environment variables from .env file
-	if err := godotenv.Load(); err != nil {
-		log.Println("⚠️  No .env file found or error loading .env")
-	}
-}
-
 func main() {
-	if err := godotenv.Load(); err != nil {
-		log.Println("⚠️ .env file not found. Using system environment variables.")
+	cfg := config.Load()
+	logger := logging.Setup("payment-ms", cfg.Log)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "payment-ms", cfg.Tracing)
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "error", err)
 	}
 
-	db := config.ConnectMongo()
-	col := db.Database("paymentdb").Collection("payments")
+	db := config.ConnectMongo(ctx, cfg.MongoURI)
+	col := db.Database(cfg.DBName).Collection("payments")
 
 	repo := mongo.NewPaymentRepository(col)
-	uc := usecase.NewPaymentUseCase(repo)
+	uc := usecase.NewPaymentUseCase(repo, paymentmetrics.NewPaymentMetrics())
 	handler := paymenthttp.NewPaymentHandler(uc)
 
+	// The gateway and the other services sign their requests, see auth.ServiceKey
+	serviceKey := auth.NewServiceKey(cfg.Service.Secret)
+
 	r := chi.NewRouter()
+	r.Use(logging.RequestID, tracing.Middleware, logging.AccessLog(logger), metrics.Middleware, auth.FromHeaders(serviceKey))
+
+	// Prometheus metrics for scraping
+	r.Handle("/metrics", metrics.Handler())
 
 	// Register Swagger UI
 	r.Get("/swagger/*", httpSwagger.WrapHandler)
@@ -52,12 +71,28 @@ func main() {
 		handler.RegisterRoutes(r)
 	})
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT is not set in the environment")
-	}
+	// Internal routes, called by other services and not exposed by the gateway
+	handler.RegisterInternalRoutes(r)
+
+	// gRPC server for internal service-to-service calls
+	grpcServer, grpcHealth := grpcserver.New(serviceKey)
+	paymentv1.RegisterPaymentServiceServer(grpcServer, paymentgrpc.NewPaymentServer(uc))
+	grpcserver.MarkServing(grpcServer, grpcHealth)
 
-	log.Printf("🚀 Server running at http://localhost:%s\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	checks := health.NewChecker(2 * time.Second)
+	checks.Add("mongo", health.Mongo(db))
 
+	// On SIGINT/SIGTERM: fail readiness, drain requests, then close Mongo and flush traces
+	srv := server.New(cfg.Server)
+	srv.BeforeDrain(checks.Shutdown)
+	srv.BeforeDrain(grpcHealth.Shutdown)
+	srv.OnShutdown("mongo", db.Disconnect)
+	srv.OnShutdown("tracing", shutdownTracing)
+
+	srv.ServeGRPC("grpc", grpcServer, cfg.GRPCPort)
+	srv.ServeHTTP("http", ":"+cfg.Port, checks.Handler(r))
+
+	if err := srv.Wait(ctx); err != nil {
+		logging.Fatal("Stopped with errors", "error", err)
+	}
 }
@@ -0,0 +1,112 @@
+package grpc
+
+import (
+	"context"
+
+	"payment-ms/internal/payment/domain"
+
+	"shared/grpcserver"
+	paymentv1 "shared/proto/payment/v1"
+
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// PaymentServer exposes domain.PaymentUseCase over gRPC
+type PaymentServer struct {
+	paymentv1.UnimplementedPaymentServiceServer
+	useCase  domain.PaymentUseCase
+	validate *validator.Validate
+}
+
+func NewPaymentServer(useCase domain.PaymentUseCase) *PaymentServer {
+	return &PaymentServer{
+		useCase:  useCase,
+		validate: validator.New(),
+	}
+}
+
+func (s *PaymentServer) CreatePayment(ctx context.Context, req *paymentv1.CreatePaymentRequest) (*paymentv1.Payment, error) {
+	in := domain.CreatePaymentRequest{
+		OrderID: req.GetOrderId(),
+		UserID:  req.GetUserId(),
+		Amount:  req.GetAmount(),
+		Method:  req.GetMethod(),
+	}
+	if err := s.validate.Struct(in); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	payment, err := s.useCase.CreatePayment(ctx, &in)
+	if err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+	return toProto(payment), nil
+}
+
+func (s *PaymentServer) GetPayment(ctx context.Context, req *paymentv1.GetPaymentRequest) (*paymentv1.Payment, error) {
+	payment, err := s.useCase.GetPaymentByID(ctx, req.GetId())
+	if err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+	return toProto(payment), nil
+}
+
+func (s *PaymentServer) ListPayments(ctx context.Context, req *paymentv1.ListPaymentsRequest) (*paymentv1.ListPaymentsResponse, error) {
+	var (
+		payments []*domain.Payment
+		err      error
+	)
+	if req.GetOrderId() != "" {
+		payments, err = s.useCase.GetPaymentsByOrderID(ctx, req.GetOrderId())
+	} else {
+		payments, err = s.useCase.GetAllPayments(ctx)
+	}
+	if err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+
+	res := &paymentv1.ListPaymentsResponse{Payments: make([]*paymentv1.Payment, 0, len(payments))}
+	for _, p := range payments {
+		res.Payments = append(res.Payments, toProto(p))
+	}
+	return res, nil
+}
+
+func (s *PaymentServer) UpdatePayment(ctx context.Context, req *paymentv1.UpdatePaymentRequest) (*paymentv1.Payment, error) {
+	in := domain.UpdatePaymentRequest{
+		Amount: req.GetAmount(),
+		Status: req.GetStatus(),
+	}
+	if err := s.validate.Struct(in); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	payment, err := s.useCase.UpdatePayment(ctx, req.GetId(), domain.AnyVersion, &in)
+	if err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+	return toProto(payment), nil
+}
+
+func (s *PaymentServer) DeletePayment(ctx context.Context, req *paymentv1.DeletePaymentRequest) (*emptypb.Empty, error) {
+	if err := s.useCase.DeletePayment(ctx, req.GetId()); err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func toProto(p *domain.Payment) *paymentv1.Payment {
+	return &paymentv1.Payment{
+		Id:        p.ID.Hex(),
+		UserId:    p.UserID,
+		OrderId:   p.OrderID,
+		Amount:    p.Amount,
+		Status:    p.Status,
+		CreatedAt: timestamppb.New(p.CreatedAt),
+		UpdatedAt: timestamppb.New(p.UpdatedAt),
+	}
+}
@@ -3,8 +3,15 @@ package http
 import (
 	"encoding/json"
 	"net/http"
+
 	"payment-ms/internal/payment/domain"
 
+	"shared/auth"
+	"shared/etag"
+	"shared/mergepatch"
+	"shared/privacy"
+	"shared/problem"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/go-playground/validator/v10"
 )
@@ -17,7 +24,7 @@ type PaymentHandler struct {
 func NewPaymentHandler(useCase domain.PaymentUseCase) *PaymentHandler {
 	return &PaymentHandler{
 		useCase:  useCase,
-		validate: validator.New(),
+		validate: problem.NewValidator(),
 	}
 }
 
@@ -27,10 +34,18 @@ func (h *PaymentHandler) RegisterRoutes(r chi.Router) {
 		r.Get("/", h.GetAllPayments)       // Read All
 		r.Get("/{id}", h.GetPaymentByID)   // Read One
 		r.Put("/{id}", h.UpdatePayment)    // Update
+		r.Patch("/{id}", h.PatchPayment)   // Partial update
 		r.Delete("/{id}", h.DeletePayment) // Delete
 	})
 }
 
+// RegisterInternalRoutes registers the routes for other services, which the
+// gateway does not expose
+func (h *PaymentHandler) RegisterInternalRoutes(r chi.Router) {
+	r.With(auth.RequireService).Get("/internal/personal-data/{userID}", h.ExportUser)
+	r.With(auth.RequireService).Post("/internal/personal-data/{userID}/anonymize", h.AnonymizeUser)
+}
+
 // CreatePayment godoc
 // @Summary Create a new payment
 // @Tags payments
@@ -38,26 +53,29 @@ func (h *PaymentHandler) RegisterRoutes(r chi.Router) {
 // @Produce json
 // @Param payment body domain.CreatePaymentRequest true "Payment Data"
 // @Success 200 {object} domain.Payment
-// @Failure 400 {string} string "Invalid request"
+// @Header 200 {string} ETag "Version of the payment"
+// @Failure 400 {object} problem.Problem "Invalid request"
+// @Failure 500 {object} problem.Problem "Internal server error"
 // @Router /payments [post]
 func (h *PaymentHandler) CreatePayment(w http.ResponseWriter, r *http.Request) {
 	var req domain.CreatePaymentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
 		return
 	}
 
 	if err := h.validate.Struct(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		problem.Error(w, r, err)
 		return
 	}
 
 	payment, err := h.useCase.CreatePayment(r.Context(), &req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Error(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag.Version(payment.Version))
 	json.NewEncoder(w).Encode(payment)
 }
 
@@ -66,32 +84,47 @@ func (h *PaymentHandler) CreatePayment(w http.ResponseWriter, r *http.Request) {
 // @Tags payments
 // @Produce json
 // @Param id path string true "Payment ID"
+// @Param If-None-Match header string false "ETag of a cached copy"
 // @Success 200 {object} domain.Payment
-// @Failure 404 {string} string "Payment not found"
+// @Header 200 {string} ETag "Version of the payment"
+// @Success 304 "Not modified"
+// @Failure 404 {object} problem.Problem "Payment not found"
 // @Router /payments/{id} [get]
 func (h *PaymentHandler) GetPaymentByID(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	payment, err := h.useCase.GetPaymentByID(r.Context(), id)
 	if err != nil {
-		http.Error(w, "Payment not found", http.StatusNotFound)
+		problem.Error(w, r, err)
 		return
 	}
-	json.NewEncoder(w).Encode(payment)
+	etag.JSON(w, r, etag.Version(payment.Version), payment)
 }
 
 // GetAllPayments godoc
 // @Summary List all payments
 // @Tags payments
 // @Produce json
+// @Param order_id query string false "Only payments for this order"
+// @Param If-None-Match header string false "ETag of a cached copy"
 // @Success 200 {array} domain.Payment
+// @Success 304 "Not modified"
+// @Failure 500 {object} problem.Problem "Internal server error"
 // @Router /payments [get]
 func (h *PaymentHandler) GetAllPayments(w http.ResponseWriter, r *http.Request) {
-	payments, err := h.useCase.GetAllPayments(r.Context())
+	var (
+		payments []*domain.Payment
+		err      error
+	)
+	if orderID := r.URL.Query().Get("order_id"); orderID != "" {
+		payments, err = h.useCase.GetPaymentsByOrderID(r.Context(), orderID)
+	} else {
+		payments, err = h.useCase.GetAllPayments(r.Context())
+	}
 	if err != nil {
-		http.Error(w, "Could not fetch payments", http.StatusInternalServerError)
+		problem.Error(w, r, err)
 		return
 	}
-	json.NewEncoder(w).Encode(payments)
+	etag.JSON(w, r, "", payments)
 }
 
 // UpdatePayment godoc
@@ -100,44 +133,153 @@ func (h *PaymentHandler) GetAllPayments(w http.ResponseWriter, r *http.Request)
 // @Accept json
 // @Produce json
 // @Param id path string true "Payment ID"
+// @Param If-Match header string true "ETag of the payment, or *"
 // @Param status body map[string]string true "New Status"
 // @Success 200 {object} domain.Payment
+// @Header 200 {string} ETag "New version of the payment"
+// @Failure 400 {object} problem.Problem "Invalid request"
+// @Failure 404 {object} problem.Problem "Payment not found"
+// @Failure 412 {object} problem.Problem "Payment modified since it was read"
+// @Failure 428 {object} problem.Problem "If-Match missing"
 // @Router /payments/{id} [put]
 func (h *PaymentHandler) UpdatePayment(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
+		return
+	}
 
 	var req domain.UpdatePaymentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
 		return
 	}
 
 	if err := h.validate.Struct(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		problem.Error(w, r, err)
 		return
 	}
 
-	payment, err := h.useCase.UpdatePayment(r.Context(), id, &req)
+	payment, err := h.useCase.UpdatePayment(r.Context(), id, version, &req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Error(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag.Version(payment.Version))
+	json.NewEncoder(w).Encode(payment)
+}
+
+// PatchPayment godoc
+// @Summary Partially update a payment
+// @Description Applies an RFC 7396 JSON merge patch: present fields are replaced, omitted fields are kept. The merged payment must still be valid.
+// @Tags payments
+// @Accept application/merge-patch+json
+// @Accept json
+// @Produce json
+// @Param id path string true "Payment ID"
+// @Param If-Match header string true "ETag of the payment, or *"
+// @Param patch body domain.UpdatePaymentRequest true "Fields to change"
+// @Success 200 {object} domain.Payment
+// @Header 200 {string} ETag "New version of the payment"
+// @Failure 400 {object} problem.Problem "Invalid patch or result"
+// @Failure 404 {object} problem.Problem "Payment not found"
+// @Failure 412 {object} problem.Problem "Payment modified since it was read"
+// @Failure 415 {object} problem.Problem "Unsupported media type"
+// @Failure 428 {object} problem.Problem "If-Match missing"
+// @Router /payments/{id} [patch]
+func (h *PaymentHandler) PatchPayment(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	patch, ok := mergepatch.Read(w, r)
+	if !ok {
+		return
+	}
+
+	payment, err := h.useCase.GetPaymentByID(r.Context(), id)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	req := domain.UpdatePaymentRequest{Amount: payment.Amount, Status: payment.Status}
+	fields, err := mergepatch.Struct(&req, patch)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	if err := h.validate.Struct(req); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	payment, err = h.useCase.PatchPayment(r.Context(), id, version, fields)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag.Version(payment.Version))
 	json.NewEncoder(w).Encode(payment)
 }
 
 // DeletePayment godoc
 // @Summary Delete a payment
+// @Description Always refused: payments are financial records. Mark a payment failed instead.
 // @Tags payments
 // @Param id path string true "Payment ID"
 // @Success 204
-// @Failure 500 {string} string "Error deleting"
+// @Failure 404 {object} problem.Problem "Payment not found"
+// @Failure 409 {object} problem.Problem "Payments cannot be deleted"
 // @Router /payments/{id} [delete]
 func (h *PaymentHandler) DeletePayment(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if err := h.useCase.DeletePayment(r.Context(), id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Error(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// ExportUser lists every payment of a user, for a data export by user-ms.
+// It is internal, so it has no Swagger docs.
+func (h *PaymentHandler) ExportUser(w http.ResponseWriter, r *http.Request) {
+	payments, err := h.useCase.GetPaymentsByUserID(r.Context(), chi.URLParam(r, "userID"))
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	if payments == nil {
+		payments = []*domain.Payment{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(privacy.Export{Records: payments})
+}
+
+// AnonymizeUser replaces a user's ID in their payments, for an erasure by
+// user-ms. It is internal, so it has no Swagger docs.
+func (h *PaymentHandler) AnonymizeUser(w http.ResponseWriter, r *http.Request) {
+	var req privacy.AnonymizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if err := h.validate.Struct(req); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	n, err := h.useCase.AnonymizeUser(r.Context(), chi.URLParam(r, "userID"), req.Pseudonym)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(privacy.AnonymizeResponse{Anonymized: n})
+}
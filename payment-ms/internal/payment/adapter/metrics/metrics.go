@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"payment-ms/internal/payment/domain"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type paymentMetrics struct {
+	payments *prometheus.CounterVec
+}
+
+// NewPaymentMetrics returns domain.PaymentMetrics backed by Prometheus counters
+func NewPaymentMetrics() domain.PaymentMetrics {
+	return &paymentMetrics{
+		payments: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "payments_total",
+			Help: "Payments entering a status, by method and status (pending, completed, failed).",
+		}, []string{"method", "status"}),
+	}
+}
+
+func (m *paymentMetrics) PaymentRecorded(method, status string) {
+	m.payments.WithLabelValues(method, status).Inc()
+}
@@ -3,6 +3,7 @@ package mongo
 import (
 	"context"
 	"errors"
+	"fmt"
 	"payment-ms/internal/payment/domain"
 	"time"
 
@@ -25,6 +26,9 @@ func (r *paymentRepository) CreatePayment(ctx context.Context, payment *domain.P
 	payment.ID = objectID
 
 	_, err := r.collection.InsertOne(ctx, payment)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, domain.ErrConflict
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -33,22 +37,37 @@ func (r *paymentRepository) CreatePayment(ctx context.Context, payment *domain.P
 }
 
 func (r *paymentRepository) GetPaymentByID(ctx context.Context, id string) (*domain.Payment, error) {
-	objID, err := primitive.ObjectIDFromHex(id)
+	objID, err := objectID(id)
 	if err != nil {
-		return nil, errors.New("invalid payment ID")
+		return nil, err
 	}
 
 	var payment domain.Payment
 	err = r.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&payment)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, domain.ErrNotFound
+	}
 	if err != nil {
-		return nil, errors.New("payment not found")
+		return nil, err
 	}
 
 	return &payment, nil
 }
 
 func (r *paymentRepository) GetAllPayments(ctx context.Context) ([]*domain.Payment, error) {
-	cursor, err := r.collection.Find(ctx, bson.M{})
+	return r.find(ctx, bson.M{})
+}
+
+func (r *paymentRepository) GetPaymentsByOrderID(ctx context.Context, orderID string) ([]*domain.Payment, error) {
+	return r.find(ctx, bson.M{"orderId": orderID})
+}
+
+func (r *paymentRepository) GetPaymentsByUserID(ctx context.Context, userID string) ([]*domain.Payment, error) {
+	return r.find(ctx, bson.M{"userId": userID})
+}
+
+func (r *paymentRepository) find(ctx context.Context, filter bson.M) ([]*domain.Payment, error) {
+	cursor, err := r.collection.Find(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -65,53 +84,82 @@ func (r *paymentRepository) GetAllPayments(ctx context.Context) ([]*domain.Payme
 	return payments, nil
 }
 
-func (r *paymentRepository) UpdatePayment(ctx context.Context, id string, payment *domain.Payment) (*domain.Payment, error) {
-	objID, err := primitive.ObjectIDFromHex(id)
+func (r *paymentRepository) UpdatePayment(ctx context.Context, id string, version int64, payment *domain.Payment) (*domain.Payment, error) {
+	return r.PatchPayment(ctx, id, version, map[string]any{
+		"amount": payment.Amount,
+		"status": payment.Status,
+	})
+}
+
+func (r *paymentRepository) PatchPayment(ctx context.Context, id string, version int64, fields map[string]any) (*domain.Payment, error) {
+	objID, err := objectID(id)
 	if err != nil {
-		return nil, errors.New("invalid payment ID")
+		return nil, err
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"amount":    payment.Amount,
-			"userId":    payment.UserID,
-			"orderId":   payment.OrderID,
-			"status":    payment.Status,
-			"updatedAt": time.Now(),
-		},
+	set := bson.M{"updatedAt": time.Now()}
+	for k, v := range fields {
+		set[k] = v
 	}
 
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
 	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 
-	result := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": objID}, update, opts)
-	if result.Err() != nil {
-		if result.Err() == mongo.ErrNoDocuments {
-			return nil, errors.New("payment not found")
-		}
-		return nil, result.Err()
-	}
-
 	var updated domain.Payment
-	if err := result.Decode(&updated); err != nil {
+	err = r.collection.FindOneAndUpdate(ctx, versioned(objID, version), update, opts).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, r.missed(ctx, objID)
+	}
+	if err != nil {
 		return nil, err
 	}
 
 	return &updated, nil
 }
 
-func (r *paymentRepository) DeletePayment(ctx context.Context, id string) error {
-	objID, err := primitive.ObjectIDFromHex(id)
+func (r *paymentRepository) AnonymizeUser(ctx context.Context, userID, pseudonym string) (int64, error) {
+	update := bson.M{
+		"$set": bson.M{"userId": pseudonym, "updatedAt": time.Now()},
+		"$inc": bson.M{"version": 1},
+	}
+	res, err := r.collection.UpdateMany(ctx, bson.M{"userId": userID}, update)
 	if err != nil {
-		return errors.New("invalid payment ID")
+		return 0, err
 	}
+	return res.ModifiedCount, nil
+}
 
-	res, err := r.collection.DeleteOne(ctx, bson.M{"_id": objID})
+// missed explains a conditional write that matched nothing: the payment is
+// gone, or is at another version
+func (r *paymentRepository) missed(ctx context.Context, objID primitive.ObjectID) error {
+	n, err := r.collection.CountDocuments(ctx, bson.M{"_id": objID})
 	if err != nil {
-		return errors.New("failed to delete payment")
+		return err
+	}
+	if n == 0 {
+		return domain.ErrNotFound
 	}
-	if res.DeletedCount == 0 {
-		return errors.New("payment not found")
+	return domain.ErrVersionMismatch
+}
+
+// versioned matches the payment with objID at version. Payments stored
+// before versioning have no version field and are at version 0.
+func versioned(objID primitive.ObjectID, version int64) bson.M {
+	filter := bson.M{"_id": objID}
+	switch version {
+	case domain.AnyVersion:
+	case 0:
+		filter["version"] = bson.M{"$exists": false}
+	default:
+		filter["version"] = version
 	}
+	return filter
+}
 
-	return nil
+func objectID(id string) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return objID, fmt.Errorf("%w: malformed ID %q", domain.ErrValidation, id)
+	}
+	return objID, nil
 }
@@ -0,0 +1,13 @@
+package domain
+
+import "shared/apperr"
+
+var (
+	ErrNotFound   = apperr.New(apperr.NotFound, "payment not found")
+	ErrConflict   = apperr.New(apperr.Conflict, "a payment with this ID already exists")
+	ErrValidation = apperr.New(apperr.Validation, "invalid input")
+	ErrForbidden  = apperr.New(apperr.Forbidden, "not allowed to access this payment")
+
+	ErrVersionMismatch = apperr.New(apperr.PreconditionFailed, "the payment was modified since it was read; fetch it again")
+	ErrUndeletable     = apperr.New(apperr.Conflict, "payments are financial records and cannot be deleted; mark the payment failed instead")
+)
@@ -6,14 +6,37 @@ type PaymentRepository interface {
 	CreatePayment(ctx context.Context, payment *Payment) (*Payment, error)
 	GetPaymentByID(ctx context.Context, id string) (*Payment, error)
 	GetAllPayments(ctx context.Context) ([]*Payment, error)
-	UpdatePayment(ctx context.Context, id string, payment *Payment) (*Payment, error)
-	DeletePayment(ctx context.Context, id string) error
+	GetPaymentsByOrderID(ctx context.Context, orderID string) ([]*Payment, error)
+	GetPaymentsByUserID(ctx context.Context, userID string) ([]*Payment, error)
+	// Writes apply only while the payment is at version (see AnyVersion)
+	// and fail with ErrVersionMismatch otherwise
+	UpdatePayment(ctx context.Context, id string, version int64, payment *Payment) (*Payment, error)
+	// PatchPayment sets only the given fields, keyed by bson name
+	PatchPayment(ctx context.Context, id string, version int64, fields map[string]any) (*Payment, error)
+	// AnonymizeUser replaces userID by pseudonym in the user's payments and
+	// returns how many changed
+	AnonymizeUser(ctx context.Context, userID, pseudonym string) (int64, error)
 }
 
 type PaymentUseCase interface {
 	CreatePayment(ctx context.Context, req *CreatePaymentRequest) (*Payment, error)
 	GetPaymentByID(ctx context.Context, id string) (*Payment, error)
 	GetAllPayments(ctx context.Context) ([]*Payment, error)
-	UpdatePayment(ctx context.Context, id string, req *UpdatePaymentRequest) (*Payment, error)
+	GetPaymentsByOrderID(ctx context.Context, orderID string) ([]*Payment, error)
+	UpdatePayment(ctx context.Context, id string, version int64, req *UpdatePaymentRequest) (*Payment, error)
+	PatchPayment(ctx context.Context, id string, version int64, fields map[string]any) (*Payment, error)
+	// DeletePayment always fails: payments are financial records and are
+	// never deleted, not even softly
 	DeletePayment(ctx context.Context, id string) error
+	GetPaymentsByUserID(ctx context.Context, userID string) ([]*Payment, error)
+	// AnonymizeUser unlinks a user's payments from them for an erasure
+	// request; the payments stay as financial records
+	AnonymizeUser(ctx context.Context, userID, pseudonym string) (int64, error)
+}
+
+// PaymentMetrics records business events for monitoring
+type PaymentMetrics interface {
+	// PaymentRecorded counts a payment entering status: pending on creation,
+	// completed or failed once settled
+	PaymentRecorded(method, status string)
 }
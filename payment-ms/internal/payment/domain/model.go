@@ -6,12 +6,19 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// AnyVersion makes a write unconditional. It equals etag.Any, which
+// "If-Match: *" yields; internal gRPC callers use it as well.
+const AnyVersion int64 = -1
+
 type Payment struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	UserID    string             `json:"userId" bson:"userId"`
 	OrderID   string             `json:"orderId" bson:"orderId"`
 	Amount    float64            `json:"amount" bson:"amount"`
+	Method    string             `json:"method" bson:"method"`
 	Status    string             `json:"status" bson:"status"` // e.g., "pending", "completed", "failed"
 	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
+	// Version is incremented by every write and served as the ETag
+	Version int64 `json:"version" bson:"version"`
 }
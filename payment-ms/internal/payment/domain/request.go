@@ -7,7 +7,9 @@ type CreatePaymentRequest struct {
 	Method  string  `json:"method" validate:"required,oneof=credit_card paypal bank_transfer"`
 }
 
+// UpdatePaymentRequest is the body of PUT and the document PATCH merge
+// patches are applied to
 type UpdatePaymentRequest struct {
-	Amount float64 `json:"amount" validate:"required,gt=0"`
-	Status string  `json:"status" validate:"required,oneof=pending completed failed"`
+	Amount float64 `json:"amount" bson:"amount" validate:"required,gt=0"`
+	Status string  `json:"status" bson:"status" validate:"required,oneof=pending completed failed"`
 }
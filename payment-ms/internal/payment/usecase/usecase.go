@@ -8,11 +8,12 @@ import (
 )
 
 type paymentUseCase struct {
-	repo domain.PaymentRepository
+	repo    domain.PaymentRepository
+	metrics domain.PaymentMetrics
 }
 
-func NewPaymentUseCase(repo domain.PaymentRepository) domain.PaymentUseCase {
-	return &paymentUseCase{repo: repo}
+func NewPaymentUseCase(repo domain.PaymentRepository, metrics domain.PaymentMetrics) domain.PaymentUseCase {
+	return &paymentUseCase{repo: repo, metrics: metrics}
 }
 
 func (uc *paymentUseCase) CreatePayment(ctx context.Context, req *domain.CreatePaymentRequest) (*domain.Payment, error) {
@@ -20,11 +21,18 @@ func (uc *paymentUseCase) CreatePayment(ctx context.Context, req *domain.CreateP
 		UserID:    req.UserID,
 		OrderID:   req.OrderID,
 		Amount:    req.Amount,
+		Method:    req.Method,
 		Status:    "pending", // default status
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
+		Version:   1,
 	}
-	return uc.repo.CreatePayment(ctx, payment)
+	created, err := uc.repo.CreatePayment(ctx, payment)
+	if err != nil {
+		return nil, err
+	}
+	uc.metrics.PaymentRecorded(created.Method, created.Status)
+	return created, nil
 }
 
 func (uc *paymentUseCase) GetPaymentByID(ctx context.Context, id string) (*domain.Payment, error) {
@@ -35,14 +43,77 @@ func (uc *paymentUseCase) GetAllPayments(ctx context.Context) ([]*domain.Payment
 	return uc.repo.GetAllPayments(ctx)
 }
 
-func (uc *paymentUseCase) UpdatePayment(ctx context.Context, id string, req *domain.UpdatePaymentRequest) (*domain.Payment, error) {
+func (uc *paymentUseCase) GetPaymentsByOrderID(ctx context.Context, orderID string) ([]*domain.Payment, error) {
+	return uc.repo.GetPaymentsByOrderID(ctx, orderID)
+}
+
+func (uc *paymentUseCase) UpdatePayment(ctx context.Context, id string, version int64, req *domain.UpdatePaymentRequest) (*domain.Payment, error) {
+	before, err := uc.current(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
 	updated := &domain.Payment{
 		Status: req.Status,
 		Amount: req.Amount,
 	}
-	return uc.repo.UpdatePayment(ctx, id, updated)
+	payment, err := uc.repo.UpdatePayment(ctx, id, before.Version, updated)
+	if err != nil {
+		return nil, err
+	}
+	uc.recordSettlement(before, payment)
+	return payment, nil
+}
+
+// PatchPayment writes even an empty patch, so that it too checks and bumps the version
+func (uc *paymentUseCase) PatchPayment(ctx context.Context, id string, version int64, fields map[string]any) (*domain.Payment, error) {
+	before, err := uc.current(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	payment, err := uc.repo.PatchPayment(ctx, id, before.Version, fields)
+	if err != nil {
+		return nil, err
+	}
+	uc.recordSettlement(before, payment)
+	return payment, nil
+}
+
+// current returns a payment that is still at version (see AnyVersion). The
+// write that follows is made at the version returned, so that the status it
+// changes from is exactly the one read.
+func (uc *paymentUseCase) current(ctx context.Context, id string, version int64) (*domain.Payment, error) {
+	payment, err := uc.repo.GetPaymentByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if version != domain.AnyVersion && version != payment.Version {
+		return nil, domain.ErrVersionMismatch
+	}
+	return payment, nil
+}
+
+// recordSettlement counts a payment whose status a write moved to completed
+// or failed. Writes to other fields of a settled payment count nothing.
+func (uc *paymentUseCase) recordSettlement(before, after *domain.Payment) {
+	if after.Status == before.Status {
+		return
+	}
+	if after.Status == "completed" || after.Status == "failed" {
+		uc.metrics.PaymentRecorded(after.Method, after.Status)
+	}
 }
 
 func (uc *paymentUseCase) DeletePayment(ctx context.Context, id string) error {
-	return uc.repo.DeletePayment(ctx, id)
+	if _, err := uc.repo.GetPaymentByID(ctx, id); err != nil {
+		return err
+	}
+	return domain.ErrUndeletable
+}
+
+func (uc *paymentUseCase) GetPaymentsByUserID(ctx context.Context, userID string) ([]*domain.Payment, error) {
+	return uc.repo.GetPaymentsByUserID(ctx, userID)
+}
+
+func (uc *paymentUseCase) AnonymizeUser(ctx context.Context, userID, pseudonym string) (int64, error) {
+	return uc.repo.AnonymizeUser(ctx, userID, pseudonym)
 }
@@ -17,48 +17,100 @@
 package main
 
 import (
-	"log"
+	"context"
+	"crypto/rand"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/joho/godotenv"
 
 	// This line is crucial for Swagger to work. It initializes the generated docs.
 	_ "product-ms/docs" // Import the generated docs package. Note the underscore alias.
 
 	httpSwagger "github.com/swaggo/http-swagger"
 
+	"product-ms/internal/product/adapter/blob"
+	productgrpc "product-ms/internal/product/adapter/grpc"
 	producthttp "product-ms/internal/product/adapter/http"
 	"product-ms/internal/product/adapter/mongo"
+	"product-ms/internal/product/adapter/upstream"
+	"product-ms/internal/product/domain"
 	"product-ms/internal/product/usecase"
 	"product-ms/pkg/config"
-)
 
-func init() {
-	// Load environment variables from .env file
-	if err := godotenv.Load(); err != nil {
-		log.Println("⚠️  No .env file found or error loading .env")
-	}
-}
+	"shared/auth"
+	"shared/client"
+	"shared/grpcserver"
+	"shared/health"
+	"shared/logging"
+	"shared/metrics"
+	productv1 "shared/proto/product/v1"
+	"shared/server"
+	"shared/tracing"
+)
 
 func main() {
-	// Load env
-	if err := godotenv.Load(); err != nil {
-		log.Println("⚠️ .env file not found. Using system environment variables.")
+	cfg := config.Load()
+	logger := logging.Setup("product-ms", cfg.Log)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "product-ms", cfg.Tracing)
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "error", err)
 	}
 
 	// Mongo connection
-	db := config.ConnectMongo()
-	productCollection := db.Database("productdb").Collection("products")
+	db := config.ConnectMongo(ctx, cfg.MongoURI)
+	productCollection := db.Database(cfg.DBName).Collection("products")
+	reviewCollection := db.Database(cfg.DBName).Collection("reviews")
+	voteCollection := db.Database(cfg.DBName).Collection("review_votes")
+	if err := mongo.EnsureReviewIndexes(ctx, reviewCollection, voteCollection); err != nil {
+		logging.Fatal("Failed to create review indexes", "error", err)
+	}
+	imageCollection := db.Database(cfg.DBName).Collection("product_images")
+	if err := mongo.EnsureImageIndexes(ctx, imageCollection); err != nil {
+		logging.Fatal("Failed to create image indexes", "error", err)
+	}
+	blobs, blobServer := mediaStore(ctx, cfg)
+
+	// The gateway and the other services sign their requests, see auth.ServiceKey
+	serviceKey := auth.NewServiceKey(cfg.Service.Secret)
+
+	// order-ms answers whether a reviewer bought the product
+	orders := client.New("order-ms", cfg.OrderURL,
+		client.WithTimeout(cfg.UpstreamTimeout),
+		client.WithBulkhead(10),
+		client.WithHooks(tracing.ClientHooks),
+		client.WithHooks(logging.ClientHooks),
+		client.WithHooks(metrics.ClientHooks),
+		client.WithHooks(serviceKey.ClientHooks()),
+	)
 
 	// Dependency injection
 	repo := mongo.NewProductRepository(productCollection)
-	uc := usecase.NewProductUseCase(repo)
+	reviews := mongo.NewReviewRepository(reviewCollection, voteCollection)
+	uc := usecase.NewProductUseCase(repo, reviews, upstream.NewPurchaseChecker(orders),
+		mongo.NewImageRepository(imageCollection), blobs, usecase.Options{
+			MaxImagePixels: cfg.MaxImagePixels,
+			ThumbnailSizes: cfg.Thumbnails(),
+			MediaURLTTL:    cfg.MediaURLTTL,
+		})
 	handler := producthttp.NewProductHandler(uc)
+	handler.MaxUploadSize = cfg.MaxUploadSize
+	handler.Blobs = blobServer
 
 	// Setup router
 	r := chi.NewRouter()
+	r.Use(logging.RequestID, tracing.Middleware, logging.AccessLog(logger), metrics.Middleware, auth.FromHeaders(serviceKey))
+
+	// Prometheus metrics for scraping
+	r.Handle("/metrics", metrics.Handler())
 
 	// Register Swagger UI endpoint
 	// This makes the Swagger documentation accessible at http://localhost:8080/swagger/index.html
@@ -70,11 +122,61 @@ func main() {
 		// will be prefixed with /api, e.g., /api/products
 		handler.RegisterRoutes(r)
 	})
+	// Internal routes, called by other services and not exposed by the gateway
+	handler.RegisterInternalRoutes(r)
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT is not set in the environment")
+	// gRPC server for internal service-to-service calls, backed by the same use case
+	grpcServer, grpcHealth := grpcserver.New(serviceKey)
+	productv1.RegisterProductServiceServer(grpcServer, productgrpc.NewProductServer(uc))
+	grpcserver.MarkServing(grpcServer, grpcHealth)
+
+	checks := health.NewChecker(2 * time.Second)
+	checks.Add("mongo", health.Mongo(db))
+
+	// On SIGINT/SIGTERM: fail readiness, drain requests, then close Mongo and flush traces
+	srv := server.New(cfg.Server)
+	srv.BeforeDrain(checks.Shutdown)
+	srv.BeforeDrain(grpcHealth.Shutdown)
+	srv.OnShutdown("mongo", db.Disconnect)
+	srv.OnShutdown("tracing", shutdownTracing)
+
+	srv.ServeGRPC("grpc", grpcServer, cfg.GRPCPort)
+	srv.ServeHTTP("http", ":"+cfg.Port, checks.Handler(r))
+
+	if err := srv.Wait(ctx); err != nil {
+		logging.Fatal("Stopped with errors", "error", err)
+	}
+}
+
+// mediaStore returns the blob store of the configuration, and what serves
+// its files when it cannot do so itself
+func mediaStore(ctx context.Context, cfg config.Config) (domain.BlobStore, producthttp.BlobServer) {
+	if cfg.MediaStore == "s3" {
+		store := blob.NewS3(blob.S3Config{
+			Endpoint:       cfg.S3Endpoint,
+			PublicEndpoint: cfg.S3PublicEndpoint,
+			Region:         cfg.S3Region,
+			Bucket:         cfg.S3Bucket,
+			AccessKey:      cfg.S3AccessKey,
+			SecretKey:      cfg.S3SecretKey,
+		}, &http.Client{Transport: tracing.Transport(nil), Timeout: cfg.UpstreamTimeout})
+		if cfg.S3CreateBucket {
+			if err := store.CreateBucket(ctx); err != nil {
+				logging.Fatal("Failed to create the media bucket", "bucket", cfg.S3Bucket, "error", err)
+			}
+		}
+		return store, nil
+	}
+
+	secret := []byte(cfg.MediaURLSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		rand.Read(secret)
+		slog.Warn("MEDIA_URL_SECRET is not set: image URLs are signed with a random secret and break on restart")
+	}
+	store, err := blob.NewFilesystem(cfg.MediaDir, cfg.MediaPublicURL, secret)
+	if err != nil {
+		logging.Fatal("Failed to open the media directory", "dir", cfg.MediaDir, "error", err)
 	}
-	log.Printf("🚀 Server running at http://localhost:%s\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	return store, store
 }
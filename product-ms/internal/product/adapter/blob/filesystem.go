@@ -0,0 +1,133 @@
+// Package blob implements the domain.BlobStore port on a local directory
+// and on S3-compatible object storage
+package blob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"shared/problem"
+)
+
+// Filesystem keeps blobs as files under a directory. Unlike S3 it cannot
+// serve them itself: ServeBlob does, at URLs signed with an HMAC.
+type Filesystem struct {
+	dir     string
+	baseURL string
+	secret  []byte
+}
+
+// NewFilesystem stores blobs under dir. Signed URLs start with baseURL,
+// where ServeBlob must be reachable, and are signed with secret.
+func NewFilesystem(dir, baseURL string, secret []byte) (*Filesystem, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Filesystem{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/"), secret: secret}, nil
+}
+
+// Put writes to a temporary file that is renamed into place, so that a
+// blob is never served half written
+func (f *Filesystem) Put(ctx context.Context, key, contentType string, data []byte) error {
+	name, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (f *Filesystem) Delete(ctx context.Context, key string) error {
+	name, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (f *Filesystem) SignedURL(ctx context.Context, key string, signedAt time.Time, ttl time.Duration) (string, error) {
+	expires := strconv.FormatInt(signedAt.Add(ttl).Unix(), 10)
+	q := url.Values{"expires": {expires}, "signature": {f.sign(key, expires)}}
+	return f.baseURL + "/" + key + "?" + q.Encode(), nil
+}
+
+// ServeBlob answers a GET of a signed URL, key being the part of its path
+// after the base URL
+func (f *Filesystem) ServeBlob(w http.ResponseWriter, r *http.Request, key string) {
+	expires := r.URL.Query().Get("expires")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !hmac.Equal([]byte(f.sign(key, expires)), []byte(r.URL.Query().Get("signature"))) {
+		problem.HTTP(w, r, http.StatusForbidden, "Invalid signature", err)
+		return
+	}
+	remaining := time.Until(time.Unix(unix, 0))
+	if remaining <= 0 {
+		problem.HTTP(w, r, http.StatusForbidden, "The URL has expired", nil)
+		return
+	}
+
+	name, err := f.path(key)
+	if err != nil {
+		problem.HTTP(w, r, http.StatusNotFound, "Not found", err)
+		return
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		problem.HTTP(w, r, http.StatusNotFound, "Not found", nil)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		problem.HTTP(w, r, http.StatusNotFound, "Not found", err)
+		return
+	}
+
+	// blobs never change: a key is used for one file only
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(remaining.Seconds())))
+	http.ServeContent(w, r, path.Base(key), info.ModTime(), file)
+}
+
+func (f *Filesystem) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// path returns the file of key, refusing keys that would escape the
+// directory or name the directory itself
+func (f *Filesystem) path(key string) (string, error) {
+	if key == "" || key == "." || !fs.ValidPath(key) {
+		return "", errors.New("invalid blob key " + strconv.Quote(key))
+	}
+	return filepath.Join(f.dir, filepath.FromSlash(key)), nil
+}
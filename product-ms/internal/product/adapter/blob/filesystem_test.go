@@ -0,0 +1,174 @@
+package blob
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const baseURL = "http://localhost:8082/media"
+
+func newFilesystem(t *testing.T) *Filesystem {
+	t.Helper()
+	f, err := NewFilesystem(t.TempDir(), baseURL+"/", []byte("test secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestFilesystemPath(t *testing.T) {
+	f := newFilesystem(t)
+	valid := []string{
+		"products/1/2/original.jpg",
+		"a",
+		"a b/ü.png",
+	}
+	for _, key := range valid {
+		name, err := f.path(key)
+		if err != nil {
+			t.Errorf("path(%q): %v", key, err)
+			continue
+		}
+		if want := filepath.Join(f.dir, filepath.FromSlash(key)); name != want {
+			t.Errorf("path(%q) = %q, want %q", key, name, want)
+		}
+	}
+
+	invalid := []string{
+		"",
+		".",
+		"..",
+		"../secret",
+		"products/../../secret",
+		"products/../x",
+		"/etc/passwd",
+		"products//x",
+		"products/x/",
+		"./products/x",
+	}
+	for _, key := range invalid {
+		if name, err := f.path(key); err == nil {
+			t.Errorf("path(%q) = %q, want an error", key, name)
+		}
+	}
+}
+
+func TestFilesystemPutDelete(t *testing.T) {
+	f := newFilesystem(t)
+	ctx := context.Background()
+	key := "products/1/2/original.png"
+
+	if err := f.Put(ctx, key, "image/png", []byte("png")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(f.dir, "products", "1", "2", "original.png"))
+	if err != nil || string(data) != "png" {
+		t.Fatalf("stored %q, %v; want png", data, err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(f.dir, "products", "1", "2"))
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only the blob", len(entries))
+	}
+
+	if err := f.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(f.dir, "products", "1", "2", "original.png")); !os.IsNotExist(err) {
+		t.Errorf("blob still there after Delete: %v", err)
+	}
+	if err := f.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing blob: %v", err)
+	}
+
+	if err := f.Put(ctx, "../escape", "image/png", []byte("x")); err == nil {
+		t.Error("Put of an escaping key succeeded")
+	}
+}
+
+func TestFilesystemServeBlob(t *testing.T) {
+	f := newFilesystem(t)
+	ctx := context.Background()
+	key := "products/1/2/original.png"
+	if err := f.Put(ctx, key, "image/png", []byte("\x89PNG\r\n\x1a\n")); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := f.SignedURL(ctx, key, time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(signed, baseURL+"/"+key+"?") {
+		t.Fatalf("SignedURL = %s, want it under %s", signed, baseURL)
+	}
+	u, _ := url.Parse(signed)
+	q := u.Query()
+
+	withQuery := func(change func(url.Values)) string {
+		q := u.Query()
+		change(q)
+		return u.Path + "?" + q.Encode()
+	}
+	expiredAt := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	missingKey := "products/1/2/missing.png"
+	escapingKey := "products/../../secret"
+
+	tests := []struct {
+		name   string
+		target string
+		key    string
+		status int
+	}{
+		{"signed", signed, key, http.StatusOK},
+		{"tampered signature", withQuery(func(q url.Values) { q.Set("signature", strings.Repeat("0", 64)) }), key, http.StatusForbidden},
+		{"no signature", withQuery(func(q url.Values) { q.Del("signature") }), key, http.StatusForbidden},
+		{"extended expiry", withQuery(func(q url.Values) { q.Set("expires", strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10)) }), key, http.StatusForbidden},
+		{"no expiry", withQuery(func(q url.Values) { q.Del("expires") }), key, http.StatusForbidden},
+		{"another key", signed, "products/1/2/400.png", http.StatusForbidden},
+		{"expired", "/media/x?" + url.Values{"expires": {expiredAt}, "signature": {f.sign(key, expiredAt)}}.Encode(), key, http.StatusForbidden},
+		{"missing file", "/media/x?" + url.Values{"expires": {q.Get("expires")}, "signature": {f.sign(missingKey, q.Get("expires"))}}.Encode(), missingKey, http.StatusNotFound},
+		{"escaping key", "/media/x?" + url.Values{"expires": {q.Get("expires")}, "signature": {f.sign(escapingKey, q.Get("expires"))}}.Encode(), escapingKey, http.StatusNotFound},
+		{"directory", "/media/x?" + url.Values{"expires": {q.Get("expires")}, "signature": {f.sign("products/1", q.Get("expires"))}}.Encode(), "products/1", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			f.ServeBlob(w, httptest.NewRequest(http.MethodGet, tt.target, nil), tt.key)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+				t.Errorf("Content-Type = %q, want image/png", ct)
+			}
+			maxAge, err := strconv.Atoi(strings.TrimPrefix(w.Header().Get("Cache-Control"), "private, max-age="))
+			if err != nil || maxAge <= 0 || maxAge > 3600 {
+				t.Errorf("Cache-Control = %q, want private and at most the remaining hour", w.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
+
+// TestFilesystemSignedURLStable checks that URLs signed at the same time
+// are the same, so that clients can cache the images
+func TestFilesystemSignedURLStable(t *testing.T) {
+	f := newFilesystem(t)
+	at := time.Unix(1700000000, 0)
+	a, _ := f.SignedURL(context.Background(), "k.png", at, time.Hour)
+	b, _ := f.SignedURL(context.Background(), "k.png", at, time.Hour)
+	if a != b {
+		t.Errorf("SignedURL differs for the same time: %s, %s", a, b)
+	}
+	other, _ := NewFilesystem(t.TempDir(), baseURL, []byte("other secret"))
+	if c, _ := other.SignedURL(context.Background(), "k.png", at, time.Hour); c == a {
+		t.Error("SignedURL is the same with another secret")
+	}
+}
@@ -0,0 +1,130 @@
+package grpc
+
+import (
+	"context"
+
+	"product-ms/internal/product/domain"
+
+	"shared/grpcserver"
+	productv1 "shared/proto/product/v1"
+
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ProductServer exposes domain.ProductUseCase over gRPC
+type ProductServer struct {
+	productv1.UnimplementedProductServiceServer
+	UseCase   domain.ProductUseCase
+	Validator *validator.Validate
+}
+
+// NewProductServer creates a new gRPC server with validation setup
+func NewProductServer(uc domain.ProductUseCase) *ProductServer {
+	return &ProductServer{
+		UseCase:   uc,
+		Validator: validator.New(),
+	}
+}
+
+func (s *ProductServer) CreateProduct(ctx context.Context, req *productv1.CreateProductRequest) (*productv1.Product, error) {
+	in := domain.ProductRequest{
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+		Price:       req.GetPrice(),
+	}
+	if err := s.Validator.Struct(in); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	created, err := s.UseCase.CreateProduct(ctx, &domain.Product{
+		Name:        in.Name,
+		Description: in.Description,
+		Price:       in.Price,
+	})
+	if err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+	return toProto(created), nil
+}
+
+func (s *ProductServer) GetProduct(ctx context.Context, req *productv1.GetProductRequest) (*productv1.Product, error) {
+	if _, err := primitive.ObjectIDFromHex(req.GetId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid ID format")
+	}
+
+	product, err := s.UseCase.GetProductByID(ctx, req.GetId(), false)
+	if err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+	return toProto(product), nil
+}
+
+func (s *ProductServer) ListProducts(ctx context.Context, _ *productv1.ListProductsRequest) (*productv1.ListProductsResponse, error) {
+	products, err := s.UseCase.ListProducts(ctx, false)
+	if err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+
+	res := &productv1.ListProductsResponse{Products: make([]*productv1.Product, 0, len(products))}
+	for _, p := range products {
+		res.Products = append(res.Products, toProto(p))
+	}
+	return res, nil
+}
+
+func (s *ProductServer) UpdateProduct(ctx context.Context, req *productv1.UpdateProductRequest) (*productv1.Product, error) {
+	objectID, err := primitive.ObjectIDFromHex(req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid ID format")
+	}
+
+	in := domain.ProductRequest{
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+		Price:       req.GetPrice(),
+	}
+	if err := s.Validator.Struct(in); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Validation failed: "+err.Error())
+	}
+
+	updated, err := s.UseCase.UpdateProduct(ctx, req.GetId(), domain.AnyVersion, &domain.Product{
+		ID:          objectID,
+		Name:        in.Name,
+		Description: in.Description,
+		Price:       in.Price,
+	})
+	if err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+	return toProto(updated), nil
+}
+
+func (s *ProductServer) DeleteProduct(ctx context.Context, req *productv1.DeleteProductRequest) (*emptypb.Empty, error) {
+	if _, err := primitive.ObjectIDFromHex(req.GetId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid ID format")
+	}
+
+	if err := s.UseCase.DeleteProduct(ctx, req.GetId(), domain.AnyVersion); err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func toProto(p *domain.Product) *productv1.Product {
+	return &productv1.Product{
+		Id:          p.ID.Hex(),
+		Name:        p.Name,
+		Description: p.Description,
+		Price:       p.Price,
+		CreatedAt:   timestamppb.New(p.CreatedAt),
+		UpdatedAt:   timestamppb.New(p.UpdatedAt),
+
+		RatingAverage: p.RatingAverage,
+		RatingCount:   p.RatingCount,
+	}
+}
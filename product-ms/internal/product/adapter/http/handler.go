@@ -2,10 +2,20 @@ package http
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 
 	"product-ms/internal/product/domain"
-	"product-ms/internal/product/usecase"
+
+	"shared/auth"
+	"shared/etag"
+	"shared/mergepatch"
+	"shared/privacy"
+	"shared/problem"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-playground/validator/v10"
@@ -13,15 +23,34 @@ import (
 )
 
 type ProductHandler struct {
-	UseCase   *usecase.ProductUseCase
+	UseCase   domain.ProductUseCase
 	Validator *validator.Validate
+	// MaxUploadSize is the largest image file accepted, in bytes
+	MaxUploadSize int64
+	// Blobs serves the images at their signed URLs, when the blob store
+	// cannot serve them itself
+	Blobs BlobServer
+}
+
+// BlobServer answers a GET of a signed URL of the blob store, key being
+// the part of its path after /api/products/media/
+type BlobServer interface {
+	ServeBlob(w http.ResponseWriter, r *http.Request, key string)
 }
 
+// defaultMaxUploadSize is MaxUploadSize unless set otherwise
+const defaultMaxUploadSize = 10 << 20
+
+// multipartOverhead is allowed on top of MaxUploadSize for the boundaries,
+// part headers and text fields of an upload
+const multipartOverhead = 64 << 10
+
 // NewProductHandler creates a new handler with validation setup
-func NewProductHandler(uc *usecase.ProductUseCase) *ProductHandler {
+func NewProductHandler(uc domain.ProductUseCase) *ProductHandler {
 	return &ProductHandler{
-		UseCase:   uc,
-		Validator: validator.New(),
+		UseCase:       uc,
+		Validator:     problem.NewValidator(),
+		MaxUploadSize: defaultMaxUploadSize,
 	}
 }
 
@@ -32,10 +61,43 @@ func (h *ProductHandler) RegisterRoutes(r chi.Router) {
 		r.Get("/", h.ListProducts)
 		r.Get("/{id}", h.GetProductByID)
 		r.Put("/{id}", h.UpdateProduct)
+		r.Patch("/{id}", h.PatchProduct)
 		r.Delete("/{id}", h.DeleteProduct)
+		r.With(auth.RequireRole(auth.RoleAdmin)).Post("/{id}/restore", h.RestoreProduct)
+		r.With(auth.RequireRole(auth.RoleAdmin)).Post("/{id}/rating/recompute", h.RecomputeRating)
+
+		r.With(auth.RequireRole(auth.RoleAdmin)).Get("/reviews", h.ListAllReviews)
+		r.Route("/{id}/reviews", func(r chi.Router) {
+			r.Post("/", h.CreateReview)
+			r.Get("/", h.ListReviews)
+			r.Get("/{reviewID}", h.GetReview)
+			r.Put("/{reviewID}", h.UpdateReview)
+			r.Delete("/{reviewID}", h.DeleteReview)
+			r.With(auth.RequireRole(auth.RoleAdmin)).Post("/{reviewID}/moderation", h.ModerateReview)
+			r.Put("/{reviewID}/helpful", h.VoteHelpful)
+			r.Delete("/{reviewID}/helpful", h.UnvoteHelpful)
+		})
+
+		r.Route("/{id}/images", func(r chi.Router) {
+			r.Post("/", h.UploadImage)
+			r.Get("/", h.ListImages)
+			r.Put("/order", h.ReorderImages)
+			r.Get("/{imageID}", h.GetImage)
+			r.Delete("/{imageID}", h.DeleteImage)
+		})
+		if h.Blobs != nil {
+			r.Get("/media/*", h.ServeMedia)
+		}
 	})
 }
 
+// RegisterInternalRoutes registers the routes for other services, which the
+// gateway does not expose
+func (h *ProductHandler) RegisterInternalRoutes(r chi.Router) {
+	r.With(auth.RequireService).Get("/internal/personal-data/{userID}", h.ExportUser)
+	r.With(auth.RequireService).Post("/internal/personal-data/{userID}/anonymize", h.AnonymizeUser)
+}
+
 // CreateProduct godoc
 // @Summary Create a new product
 // @Description Adds a new product to the database
@@ -44,18 +106,19 @@ func (h *ProductHandler) RegisterRoutes(r chi.Router) {
 // @Produce json
 // @Param product body domain.Product true "Product to create"
 // @Success 201 {object} domain.Product
-// @Failure 400 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Header 201 {string} ETag "Version of the product"
+// @Failure 400 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
 // @Router /products [post]
 func (h *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
-	var req ProductRequest
+	var req domain.ProductRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
 		return
 	}
 
 	if err := h.Validator.Struct(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		problem.Error(w, r, err)
 		return
 	}
 
@@ -67,10 +130,11 @@ func (h *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 
 	created, err := h.UseCase.CreateProduct(r.Context(), &product)
 	if err != nil {
-		http.Error(w, "Failed to create product", http.StatusInternalServerError)
+		problem.Error(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag.Version(created.Version))
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(created)
 }
@@ -80,16 +144,24 @@ func (h *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 // @Description Lists all available products
 // @Tags products
 // @Produce json
+// @Param include_deleted query bool false "Also list soft-deleted products (admins only)"
+// @Param If-None-Match header string false "ETag of a cached copy"
 // @Success 200 {array} domain.Product
-// @Failure 500 {object} map[string]string
+// @Success 304 "Not modified"
+// @Failure 403 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
 // @Router /products [get]
 func (h *ProductHandler) ListProducts(w http.ResponseWriter, r *http.Request) {
-	products, err := h.UseCase.ListProducts(r.Context())
+	includeDeleted, ok := auth.AdminFlag(w, r, "include_deleted")
+	if !ok {
+		return
+	}
+	products, err := h.UseCase.ListProducts(r.Context(), includeDeleted)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Error(w, r, err)
 		return
 	}
-	json.NewEncoder(w).Encode(products)
+	etag.JSON(w, r, "", products)
 }
 
 // GetProductByID godoc
@@ -98,25 +170,35 @@ func (h *ProductHandler) ListProducts(w http.ResponseWriter, r *http.Request) {
 // @Tags products
 // @Produce json
 // @Param id path string true "Product ID"
+// @Param include_deleted query bool false "Also find a soft-deleted product (admins only)"
+// @Param If-None-Match header string false "ETag of a cached copy"
 // @Success 200 {object} domain.Product
-// @Failure 404 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Header 200 {string} ETag "Version of the product"
+// @Success 304 "Not modified"
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
 // @Router /products/{id} [get]
 func (h *ProductHandler) GetProductByID(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
 	// Validate ID format
 	if _, err := primitive.ObjectIDFromHex(id); err != nil {
-		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid ID format", err)
 		return
 	}
 
-	product, err := h.UseCase.GetProductByID(r.Context(), id)
+	includeDeleted, ok := auth.AdminFlag(w, r, "include_deleted")
+	if !ok {
+		return
+	}
+
+	product, err := h.UseCase.GetProductByID(r.Context(), id, includeDeleted)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		problem.Error(w, r, err)
 		return
 	}
-	json.NewEncoder(w).Encode(product)
+	etag.JSON(w, r, etag.Version(product.Version), product)
 }
 
 // UpdateProduct godoc
@@ -126,33 +208,42 @@ func (h *ProductHandler) GetProductByID(w http.ResponseWriter, r *http.Request)
 // @Accept json
 // @Produce json
 // @Param id path string true "Product ID"
+// @Param If-Match header string true "ETag of the product, or *"
 // @Param product body domain.Product true "Updated product"
 // @Success 200 {object} domain.Product
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Header 200 {string} ETag "New version of the product"
+// @Failure 400 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Failure 428 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
 // @Router /products/{id} [put]
 func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if id == "" {
-		http.Error(w, "Product ID is required", http.StatusBadRequest)
+		problem.HTTP(w, r, http.StatusBadRequest, "Product ID is required", nil)
 		return
 	}
 
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid ID format", err)
+		return
+	}
+
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
 		return
 	}
 
-	var req ProductRequest
+	var req domain.ProductRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
 		return
 	}
 
 	if err := h.Validator.Struct(req); err != nil {
-		http.Error(w, "Validation failed: "+err.Error(), http.StatusBadRequest)
+		problem.Error(w, r, err)
 		return
 	}
 
@@ -163,44 +254,718 @@ func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		Price:       req.Price,
 	}
 
-	updatedProduct, err := h.UseCase.UpdateProduct(r.Context(), id, &productToUpdate)
+	updatedProduct, err := h.UseCase.UpdateProduct(r.Context(), id, version, &productToUpdate)
 	if err != nil {
-		if err.Error() == "product not found" {
-			http.Error(w, "Product not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, "Failed to update product: "+err.Error(), http.StatusInternalServerError)
+		problem.Error(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag.Version(updatedProduct.Version))
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(updatedProduct)
 }
 
+// PatchProduct godoc
+// @Summary Partially update a product
+// @Description Applies an RFC 7396 JSON merge patch: present fields are replaced, omitted fields are kept. The merged product must still be valid.
+// @Tags products
+// @Accept application/merge-patch+json
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param If-Match header string true "ETag of the product, or *"
+// @Param product body domain.ProductRequest true "Fields to change"
+// @Success 200 {object} domain.Product
+// @Header 200 {string} ETag "New version of the product"
+// @Failure 400 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Failure 415 {object} problem.Problem
+// @Failure 428 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /products/{id} [patch]
+func (h *ProductHandler) PatchProduct(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	patch, ok := mergepatch.Read(w, r)
+	if !ok {
+		return
+	}
+
+	product, err := h.UseCase.GetProductByID(r.Context(), id, false)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	req := domain.ProductRequest{
+		Name:        product.Name,
+		Description: product.Description,
+		Price:       product.Price,
+	}
+	fields, err := mergepatch.Struct(&req, patch)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	if err := h.Validator.Struct(req); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	updatedProduct, err := h.UseCase.PatchProduct(r.Context(), id, version, fields)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag.Version(updatedProduct.Version))
+	json.NewEncoder(w).Encode(updatedProduct)
+}
+
 // DeleteProduct godoc
 // @Summary Delete a product
-// @Description Removes a product from the database
+// @Description Soft-deletes a product: it disappears from the catalog but can be restored by an admin
 // @Tags products
 // @Produce json
 // @Param id path string true "Product ID"
+// @Param If-Match header string true "ETag of the product, or *"
 // @Success 204
-// @Failure 404 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Failure 404 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Failure 428 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
 // @Router /products/{id} [delete]
 func (h *ProductHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
 	if _, err := primitive.ObjectIDFromHex(id); err != nil {
-		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid ID format", err)
+		return
+	}
+
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.UseCase.DeleteProduct(r.Context(), id, version)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreProduct godoc
+// @Summary Restore a deleted product
+// @Description Undoes a soft delete. Admins only.
+// @Tags products
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param If-Match header string true "ETag of the deleted product, or *"
+// @Success 200 {object} domain.Product
+// @Header 200 {string} ETag "New version of the product"
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Failure 428 {object} problem.Problem
+// @Router /products/{id}/restore [post]
+func (h *ProductHandler) RestoreProduct(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	restored, err := h.UseCase.RestoreProduct(r.Context(), id, version)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag.Version(restored.Version))
+	json.NewEncoder(w).Encode(restored)
+}
+
+// RecomputeRating godoc
+// @Summary Recompute a product's rating
+// @Description Rebuilds the average rating and the rating count of a product from its approved reviews. They are kept up to date as reviews change; this repairs them should an update have failed. Admins only.
+// @Tags reviews
+// @Produce json
+// @Param id path string true "Product ID"
+// @Success 200 {object} domain.Product
+// @Header 200 {string} ETag "New version of the product"
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /products/{id}/rating/recompute [post]
+func (h *ProductHandler) RecomputeRating(w http.ResponseWriter, r *http.Request) {
+	product, err := h.UseCase.RecomputeRating(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag.Version(product.Version))
+	json.NewEncoder(w).Encode(product)
+}
+
+// Page sizes of review lists
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// CreateReview godoc
+// @Summary Review a product
+// @Description Adds the caller's review of a product. It is flagged as a verified purchase when order-ms has delivered the product to the caller, and is shown to others once an admin approves it. Each user reviews a product once, and edits that review afterwards.
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param review body domain.ReviewRequest true "Review to add"
+// @Success 201 {object} domain.Review
+// @Header 201 {string} ETag "Version of the review"
+// @Header 201 {string} Location "URL of the review"
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /products/{id}/reviews [post]
+func (h *ProductHandler) CreateReview(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.reviewRequest(w, r)
+	if !ok {
+		return
+	}
+
+	review, err := h.UseCase.CreateReview(r.Context(), chi.URLParam(r, "id"), req)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag.Version(review.Version))
+	w.Header().Set("Location", "/api/products/"+review.ProductID.Hex()+"/reviews/"+review.ID.Hex())
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(review)
+}
+
+// ListReviews godoc
+// @Summary List the reviews of a product
+// @Description Lists the reviews of a product newest first, a page at a time. When there are more, the Link header holds the URL of the next page (rel="next").
+// @Tags reviews
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param status query string false "approved (default); the other states are for admins only" Enums(approved, pending, rejected, any)
+// @Param limit query int false "Page size, 1-100" default(20)
+// @Param cursor query string false "Opaque cursor from the next link"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Success 200 {array} domain.Review
+// @Header 200 {string} Link "URL of the next page"
+// @Success 304 "Not modified"
+// @Failure 400 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /products/{id}/reviews [get]
+func (h *ProductHandler) ListReviews(w http.ResponseWriter, r *http.Request) {
+	h.listReviews(w, r, chi.URLParam(r, "id"), domain.ReviewApproved)
+}
+
+// ListAllReviews godoc
+// @Summary List the reviews of every product
+// @Description Lists reviews across products newest first, a page at a time, by default those awaiting moderation. When there are more, the Link header holds the URL of the next page (rel="next"). Admins only.
+// @Tags reviews
+// @Produce json
+// @Param status query string false "Moderation state" Enums(pending, approved, rejected, any) default(pending)
+// @Param limit query int false "Page size, 1-100" default(20)
+// @Param cursor query string false "Opaque cursor from the next link"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Success 200 {array} domain.Review
+// @Header 200 {string} Link "URL of the next page"
+// @Success 304 "Not modified"
+// @Failure 400 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /products/reviews [get]
+func (h *ProductHandler) ListAllReviews(w http.ResponseWriter, r *http.Request) {
+	h.listReviews(w, r, "", domain.ReviewPending)
+}
+
+func (h *ProductHandler) listReviews(w http.ResponseWriter, r *http.Request, productID, defaultStatus string) {
+	filter, err := reviewFilter(r.URL.Query(), defaultStatus)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	filter.ProductID = productID
+	if filter.Status != domain.ReviewApproved && !auth.HasRole(r.Context(), auth.RoleAdmin) {
+		problem.HTTP(w, r, http.StatusForbidden, "Only admins may list reviews that are not approved", nil)
+		return
+	}
+
+	reviews, next, err := h.UseCase.ListReviews(r.Context(), filter)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	if next != "" {
+		q := r.URL.Query()
+		q.Set("cursor", next)
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, q.Encode()))
+	}
+	etag.JSON(w, r, "", reviews)
+}
+
+// reviewFilter parses the query parameters of the review lists
+func reviewFilter(q url.Values, defaultStatus string) (domain.ReviewFilter, error) {
+	f := domain.ReviewFilter{
+		Status: q.Get("status"),
+		Before: q.Get("cursor"),
+		Limit:  defaultPageSize,
+	}
+	switch f.Status {
+	case "":
+		f.Status = defaultStatus
+	case domain.ReviewApproved, domain.ReviewPending, domain.ReviewRejected, domain.ReviewAny:
+	default:
+		return f, fmt.Errorf("%w: status must be approved, pending, rejected or any", domain.ErrValidation)
+	}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			return f, fmt.Errorf("%w: limit must be between 1 and %d", domain.ErrValidation, maxPageSize)
+		}
+		f.Limit = n
+	}
+	return f, nil
+}
+
+// GetReview godoc
+// @Summary Get a review
+// @Description Fetches a review of a product. Reviews that are not approved are only shown to their author and admins.
+// @Tags reviews
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param reviewID path string true "Review ID"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Success 200 {object} domain.Review
+// @Header 200 {string} ETag "Version of the review"
+// @Success 304 "Not modified"
+// @Failure 404 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /products/{id}/reviews/{reviewID} [get]
+func (h *ProductHandler) GetReview(w http.ResponseWriter, r *http.Request) {
+	review, err := h.UseCase.GetReview(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "reviewID"))
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	etag.JSON(w, r, etag.Version(review.Version), review)
+}
+
+// UpdateReview godoc
+// @Summary Edit a review
+// @Description Replaces the rating, title and body of the caller's review. The review awaits moderation again, and is taken out of the product's rating until approved. The verified purchase flag is checked again.
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param reviewID path string true "Review ID"
+// @Param If-Match header string true "ETag of the review, or *"
+// @Param review body domain.ReviewRequest true "Edited review"
+// @Success 200 {object} domain.Review
+// @Header 200 {string} ETag "New version of the review"
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Failure 428 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /products/{id}/reviews/{reviewID} [put]
+func (h *ProductHandler) UpdateReview(w http.ResponseWriter, r *http.Request) {
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
+		return
+	}
+	req, ok := h.reviewRequest(w, r)
+	if !ok {
 		return
 	}
 
-	err := h.UseCase.DeleteProduct(r.Context(), id)
+	review, err := h.UseCase.UpdateReview(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "reviewID"), version, req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Error(w, r, err)
+		return
+	}
+	writeReview(w, review)
+}
+
+// DeleteReview godoc
+// @Summary Delete a review
+// @Description Deletes a review and its helpful votes, and takes it out of the product's rating. For its author or admins.
+// @Tags reviews
+// @Param id path string true "Product ID"
+// @Param reviewID path string true "Review ID"
+// @Param If-Match header string true "ETag of the review, or *"
+// @Success 204
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Failure 428 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /products/{id}/reviews/{reviewID} [delete]
+func (h *ProductHandler) DeleteReview(w http.ResponseWriter, r *http.Request) {
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
 		return
 	}
 
+	err := h.UseCase.DeleteReview(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "reviewID"), version)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// ModerateReview godoc
+// @Summary Moderate a review
+// @Description Approves a review, which shows it to everyone and adds it to the product's rating, or rejects it, with a note for the author. A moderated review can be moderated again. Admins only.
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param reviewID path string true "Review ID"
+// @Param If-Match header string true "ETag of the review, or *"
+// @Param moderation body domain.ModerationRequest true "Decision"
+// @Success 200 {object} domain.Review
+// @Header 200 {string} ETag "New version of the review"
+// @Failure 400 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Failure 428 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /products/{id}/reviews/{reviewID}/moderation [post]
+func (h *ProductHandler) ModerateReview(w http.ResponseWriter, r *http.Request) {
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
+		return
+	}
+	var req domain.ModerationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if err := h.Validator.Struct(req); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	review, err := h.UseCase.ModerateReview(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "reviewID"), version, req)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	writeReview(w, review)
+}
+
+// VoteHelpful godoc
+// @Summary Mark a review as helpful
+// @Description Adds the caller's helpful vote to an approved review of someone else. Voting again changes nothing.
+// @Tags reviews
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param reviewID path string true "Review ID"
+// @Success 200 {object} domain.Review
+// @Header 200 {string} ETag "Version of the review"
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /products/{id}/reviews/{reviewID}/helpful [put]
+func (h *ProductHandler) VoteHelpful(w http.ResponseWriter, r *http.Request) {
+	review, err := h.UseCase.VoteHelpful(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "reviewID"))
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	writeReview(w, review)
+}
+
+// UnvoteHelpful godoc
+// @Summary Withdraw a helpful vote
+// @Description Removes the caller's helpful vote from a review. Without a vote it changes nothing.
+// @Tags reviews
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param reviewID path string true "Review ID"
+// @Success 200 {object} domain.Review
+// @Header 200 {string} ETag "Version of the review"
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /products/{id}/reviews/{reviewID}/helpful [delete]
+func (h *ProductHandler) UnvoteHelpful(w http.ResponseWriter, r *http.Request) {
+	review, err := h.UseCase.UnvoteHelpful(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "reviewID"))
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	writeReview(w, review)
+}
+
+// reviewRequest reads and validates the body of CreateReview and UpdateReview
+func (h *ProductHandler) reviewRequest(w http.ResponseWriter, r *http.Request) (domain.ReviewRequest, bool) {
+	var req domain.ReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
+		return req, false
+	}
+	if err := h.Validator.Struct(req); err != nil {
+		problem.Error(w, r, err)
+		return req, false
+	}
+	return req, true
+}
+
+func writeReview(w http.ResponseWriter, review *domain.Review) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag.Version(review.Version))
+	json.NewEncoder(w).Encode(review)
+}
+
+// UploadImage godoc
+// @Summary Upload an image of a product
+// @Description Adds a JPEG, PNG or GIF picture at the end of a gallery of the product: its main gallery, or that of a variant such as a color. The type is sniffed from the file's content. Thumbnails fitting the configured sizes are made of pictures larger than them. The URLs of the picture and of its thumbnails are signed and expire.
+// @Tags images
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param file formData file true "Picture, 10 MB at most by default"
+// @Param variant formData string false "Variant whose gallery the picture is added to; the main gallery when empty"
+// @Param alt_text formData string false "Description of the picture for those who cannot see it"
+// @Success 201 {object} domain.Image
+// @Header 201 {string} Location "URL of the image"
+// @Failure 400 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 413 {object} problem.Problem
+// @Failure 415 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /products/{id}/images [post]
+func (h *ProductHandler) UploadImage(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.MaxUploadSize+multipartOverhead)
+	if err := r.ParseMultipartForm(h.MaxUploadSize + multipartOverhead); err != nil {
+		h.uploadError(w, r, err)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		problem.HTTP(w, r, http.StatusBadRequest, "The upload has no file field", err)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, h.MaxUploadSize+1))
+	if err != nil {
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if int64(len(data)) > h.MaxUploadSize {
+		h.uploadError(w, r, &http.MaxBytesError{Limit: h.MaxUploadSize})
+		return
+	}
+
+	upload := domain.ImageUpload{Variant: r.FormValue("variant"), AltText: r.FormValue("alt_text"), Data: data}
+	if err := h.Validator.Struct(upload); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	image, err := h.UseCase.UploadImage(r.Context(), chi.URLParam(r, "id"), upload)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/products/"+image.ProductID.Hex()+"/images/"+image.ID.Hex())
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(image)
+}
+
+// uploadError reports a request body that is not a multipart form or is too large
+func (h *ProductHandler) uploadError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		problem.HTTP(w, r, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("The file is too large, the most is %d bytes", h.MaxUploadSize), err)
+	case errors.Is(err, http.ErrNotMultipart):
+		problem.HTTP(w, r, http.StatusUnsupportedMediaType, "Upload images as multipart/form-data", err)
+	default:
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
+	}
+}
+
+// ListImages godoc
+// @Summary List the images of a product
+// @Description Lists the images of a product gallery by gallery, the main one first, each in its order. The URLs are signed and expire; a listing is cacheable until they are signed anew.
+// @Tags images
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param variant query string false "Only the gallery of this variant; empty for the main gallery"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Success 200 {array} domain.Image
+// @Success 304 "Not modified"
+// @Failure 404 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /products/{id}/images [get]
+func (h *ProductHandler) ListImages(w http.ResponseWriter, r *http.Request) {
+	var variant *string
+	if q := r.URL.Query(); q.Has("variant") {
+		v := q.Get("variant")
+		variant = &v
+	}
+	images, err := h.UseCase.ListImages(r.Context(), chi.URLParam(r, "id"), variant)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	etag.JSON(w, r, "", images)
+}
+
+// GetImage godoc
+// @Summary Get an image of a product
+// @Description Fetches an image with freshly signed URLs
+// @Tags images
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param imageID path string true "Image ID"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Success 200 {object} domain.Image
+// @Success 304 "Not modified"
+// @Failure 404 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /products/{id}/images/{imageID} [get]
+func (h *ProductHandler) GetImage(w http.ResponseWriter, r *http.Request) {
+	image, err := h.UseCase.GetImage(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "imageID"))
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	etag.JSON(w, r, "", image)
+}
+
+// ReorderImages godoc
+// @Summary Reorder a gallery
+// @Description Puts the images of a gallery in the order listed, which must name each of its images once
+// @Tags images
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param order body domain.ImageOrderRequest true "Gallery and its images in the new order"
+// @Success 200 {array} domain.Image
+// @Failure 400 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /products/{id}/images/order [put]
+func (h *ProductHandler) ReorderImages(w http.ResponseWriter, r *http.Request) {
+	var req domain.ImageOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if err := h.Validator.Struct(req); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	images, err := h.UseCase.ReorderImages(r.Context(), chi.URLParam(r, "id"), req)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(images)
+}
+
+// DeleteImage godoc
+// @Summary Delete an image of a product
+// @Description Removes an image from its gallery, and its files from the blob store
+// @Tags images
+// @Param id path string true "Product ID"
+// @Param imageID path string true "Image ID"
+// @Success 204 "No content"
+// @Failure 404 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /products/{id}/images/{imageID} [delete]
+func (h *ProductHandler) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	if err := h.UseCase.DeleteImage(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "imageID")); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ServeMedia serves the files of images from the filesystem blob store, at
+// the URLs signed for them. They are not documented: clients only follow them.
+func (h *ProductHandler) ServeMedia(w http.ResponseWriter, r *http.Request) {
+	h.Blobs.ServeBlob(w, r, chi.URLParam(r, "*"))
+}
+
+// ExportUser returns the reviews and helpful votes of a user, for a data
+// export by user-ms. It is internal, so it has no Swagger docs.
+func (h *ProductHandler) ExportUser(w http.ResponseWriter, r *http.Request) {
+	data, err := h.UseCase.ExportUser(r.Context(), chi.URLParam(r, "userID"))
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(privacy.Export{Records: data})
+}
+
+// AnonymizeUser replaces a user's ID in their reviews and votes, for an
+// erasure by user-ms. It is internal, so it has no Swagger docs.
+func (h *ProductHandler) AnonymizeUser(w http.ResponseWriter, r *http.Request) {
+	var req privacy.AnonymizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if err := h.Validator.Struct(req); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	n, err := h.UseCase.AnonymizeUser(r.Context(), chi.URLParam(r, "userID"), req.Pseudonym)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(privacy.AnonymizeResponse{Anonymized: n})
+}
@@ -0,0 +1,109 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"product-ms/internal/product/domain"
+)
+
+// EnsureImageIndexes creates the index listing the galleries of a product in order
+func EnsureImageIndexes(ctx context.Context, col *mongo.Collection) error {
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "variant", Value: 1}, {Key: "position", Value: 1}},
+		Options: options.Index().SetName("product_galleries"),
+	})
+	return err
+}
+
+type imageRepository struct {
+	collection *mongo.Collection
+}
+
+func NewImageRepository(col *mongo.Collection) domain.ImageRepository {
+	return &imageRepository{collection: col}
+}
+
+// galleryOrder sorts images by gallery and position; images that got the
+// same position from concurrent uploads keep the order they came in
+var galleryOrder = bson.D{{Key: "variant", Value: 1}, {Key: "position", Value: 1}, {Key: "_id", Value: 1}}
+
+func (r *imageRepository) CreateImage(ctx context.Context, image *domain.Image) (*domain.Image, error) {
+	var last domain.Image
+	opts := options.FindOne().SetSort(bson.D{{Key: "position", Value: -1}}).SetProjection(bson.M{"position": 1})
+	err := r.collection.FindOne(ctx, bson.M{"product_id": image.ProductID, "variant": image.Variant}, opts).Decode(&last)
+	switch {
+	case err == nil:
+		image.Position = last.Position + 1
+	case errors.Is(err, mongo.ErrNoDocuments):
+		image.Position = 0
+	default:
+		return nil, err
+	}
+
+	if _, err := r.collection.InsertOne(ctx, image); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+func (r *imageRepository) GetImage(ctx context.Context, id string) (*domain.Image, error) {
+	objID, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+	var image domain.Image
+	err = r.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&image)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, domain.ErrImageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+func (r *imageRepository) ListImages(ctx context.Context, productID primitive.ObjectID, variant *string) ([]*domain.Image, error) {
+	filter := bson.M{"product_id": productID}
+	if variant != nil {
+		filter["variant"] = *variant
+	}
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetSort(galleryOrder))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	images := []*domain.Image{}
+	if err := cursor.All(ctx, &images); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (r *imageRepository) SetPositions(ctx context.Context, productID primitive.ObjectID, variant string, ids []primitive.ObjectID) error {
+	models := make([]mongo.WriteModel, len(ids))
+	for i, id := range ids {
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": id, "product_id": productID, "variant": variant}).
+			SetUpdate(bson.M{"$set": bson.M{"position": i}})
+	}
+	_, err := r.collection.BulkWrite(ctx, models)
+	return err
+}
+
+func (r *imageRepository) DeleteImage(ctx context.Context, id primitive.ObjectID) error {
+	res, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return domain.ErrImageNotFound
+	}
+	return nil
+}
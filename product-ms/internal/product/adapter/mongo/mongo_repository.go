@@ -3,11 +3,13 @@ package mongo
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"product-ms/internal/product/domain"
 )
@@ -22,6 +24,9 @@ func NewProductRepository(col *mongo.Collection) domain.ProductRepository {
 
 func (r *productRepository) Create(ctx context.Context, p *domain.Product) (*domain.Product, error) {
 	res, err := r.collection.InsertOne(ctx, p)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, domain.ErrConflict
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -29,8 +34,12 @@ func (r *productRepository) Create(ctx context.Context, p *domain.Product) (*dom
 	return p, nil
 }
 
-func (r *productRepository) GetAll(ctx context.Context) ([]*domain.Product, error) {
-	cursor, err := r.collection.Find(ctx, bson.M{})
+func (r *productRepository) GetAll(ctx context.Context, includeDeleted bool) ([]*domain.Product, error) {
+	filter := bson.M{}
+	if !includeDeleted {
+		filter["deleted_at"] = notDeleted
+	}
+	cursor, err := r.collection.Find(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -47,52 +56,195 @@ func (r *productRepository) GetAll(ctx context.Context) ([]*domain.Product, erro
 	return products, nil
 }
 
-func (r *productRepository) GetByID(ctx context.Context, id string) (*domain.Product, error) {
-	objID, err := primitive.ObjectIDFromHex(id)
+func (r *productRepository) GetByID(ctx context.Context, id string, includeDeleted bool) (*domain.Product, error) {
+	objID, err := objectID(id)
 	if err != nil {
 		return nil, err
 	}
+	filter := bson.M{"_id": objID}
+	if !includeDeleted {
+		filter["deleted_at"] = notDeleted
+	}
 	var product domain.Product
-	err = r.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&product)
+	err = r.collection.FindOne(ctx, filter).Decode(&product)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, domain.ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &product, nil
 }
 
-func (r *productRepository) Update(ctx context.Context, id string, product *domain.Product) (*domain.Product, error) {
-	objID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return nil, err
-	}
-
-	filter := bson.M{"_id": objID}
-	update := bson.M{"$set": bson.M{
+func (r *productRepository) Update(ctx context.Context, id string, version int64, product *domain.Product) (*domain.Product, error) {
+	return r.Patch(ctx, id, version, map[string]any{
 		"name":        product.Name,
 		"description": product.Description,
 		"price":       product.Price,
-		"updated_at":  time.Now(),
-	}}
+	})
+}
 
-	_, err = r.collection.UpdateOne(ctx, filter, update)
+func (r *productRepository) Patch(ctx context.Context, id string, version int64, fields map[string]any) (*domain.Product, error) {
+	objID, err := objectID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	return r.GetByID(ctx, id)
+	set := bson.M{"updated_at": time.Now()}
+	for k, v := range fields {
+		set[k] = v
+	}
+
+	var product domain.Product
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = r.collection.FindOneAndUpdate(ctx, versioned(objID, version), update, opts).Decode(&product)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, r.missed(ctx, objID)
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, domain.ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
 }
 
-func (r *productRepository) Delete(ctx context.Context, id string) error {
-	objID, err := primitive.ObjectIDFromHex(id)
+func (r *productRepository) Delete(ctx context.Context, id string, version int64, deletedBy string) error {
+	objID, err := objectID(id)
 	if err != nil {
 		return err
 	}
-	res, err := r.collection.DeleteOne(ctx, bson.M{"_id": objID})
+	deleted := bson.M{"deleted_at": time.Now()}
+	if deletedBy != "" {
+		deleted["deleted_by"] = deletedBy
+	}
+	update := bson.M{"$set": deleted, "$inc": bson.M{"version": 1}}
+	res, err := r.collection.UpdateOne(ctx, versioned(objID, version), update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return r.missed(ctx, objID)
+	}
+	return nil
+}
+
+func (r *productRepository) Restore(ctx context.Context, id string, version int64) (*domain.Product, error) {
+	objID, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := versioned(objID, version)
+	filter["deleted_at"] = bson.M{"$exists": true}
+	update := bson.M{
+		"$unset": bson.M{"deleted_at": "", "deleted_by": ""},
+		"$set":   bson.M{"updated_at": time.Now()},
+		"$inc":   bson.M{"version": 1},
+	}
+
+	var product domain.Product
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&product)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// the product is gone, live, or at another version
+		if _, err := r.GetByID(ctx, id, false); err == nil {
+			return nil, domain.ErrNotDeleted
+		}
+		if _, err := r.GetByID(ctx, id, true); err != nil {
+			return nil, err
+		}
+		return nil, domain.ErrVersionMismatch
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+func (r *productRepository) AddRating(ctx context.Context, id primitive.ObjectID, count, sum int64) error {
+	update := ratingUpdate(
+		bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$rating_count", 0}}, count}},
+		bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$rating_sum", 0}}, sum}},
+	)
+	res, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
 	if err != nil {
 		return err
 	}
-	if res.DeletedCount == 0 {
-		return errors.New("not found")
+	if res.MatchedCount == 0 {
+		return domain.ErrNotFound
 	}
 	return nil
 }
+
+func (r *productRepository) SetRating(ctx context.Context, id primitive.ObjectID, count, sum int64) (*domain.Product, error) {
+	var product domain.Product
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.collection.FindOneAndUpdate(ctx, versioned(id, domain.AnyVersion), ratingUpdate(count, sum), opts).Decode(&product)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// ratingUpdate is an update pipeline that sets the rating count and sum,
+// which may be expressions over the current ones, and derives the average
+// from the result. It bumps the version, since the rating is part of the
+// product served under its ETag.
+func ratingUpdate(count, sum any) bson.A {
+	return bson.A{
+		bson.M{"$set": bson.M{
+			"rating_count": count,
+			"rating_sum":   sum,
+			"version":      bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$version", 0}}, 1}},
+		}},
+		bson.M{"$set": bson.M{"rating_average": bson.M{"$cond": bson.A{
+			bson.M{"$gt": bson.A{"$rating_count", 0}},
+			bson.M{"$round": bson.A{bson.M{"$divide": bson.A{"$rating_sum", "$rating_count"}}, 2}},
+			0,
+		}}}},
+	}
+}
+
+// notDeleted matches documents without deleted_at, i.e. products that are not soft-deleted
+var notDeleted = bson.M{"$exists": false}
+
+// missed explains a conditional write that matched nothing: the product is
+// gone or soft-deleted, or is at another version
+func (r *productRepository) missed(ctx context.Context, objID primitive.ObjectID) error {
+	n, err := r.collection.CountDocuments(ctx, bson.M{"_id": objID, "deleted_at": notDeleted})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrNotFound
+	}
+	return domain.ErrVersionMismatch
+}
+
+// versioned matches the live product with objID at version. Products
+// stored before versioning have no version field and are at version 0.
+func versioned(objID primitive.ObjectID, version int64) bson.M {
+	filter := bson.M{"_id": objID, "deleted_at": notDeleted}
+	switch version {
+	case domain.AnyVersion:
+	case 0:
+		filter["version"] = bson.M{"$exists": false}
+	default:
+		filter["version"] = version
+	}
+	return filter
+}
+
+func objectID(id string) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return objID, fmt.Errorf("%w: malformed ID %q", domain.ErrValidation, id)
+	}
+	return objID, nil
+}
@@ -0,0 +1,279 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"product-ms/internal/product/domain"
+)
+
+// EnsureReviewIndexes creates the indexes of the reviews and review_votes
+// collections: one review per user and product, one vote per user and
+// review, and the ones listing reviews newest first
+func EnsureReviewIndexes(ctx context.Context, reviews, votes *mongo.Collection) error {
+	_, err := reviews.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "user_id", Value: 1}},
+			Options: options.Index().SetName("product_user_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "status", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("product_reviews"),
+		},
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("moderation_queue"),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetName("user_reviews"),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = votes.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "review_id", Value: 1}, {Key: "user_id", Value: 1}},
+			Options: options.Index().SetName("review_user_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetName("user_votes"),
+		},
+	})
+	return err
+}
+
+type reviewRepository struct {
+	reviews *mongo.Collection
+	votes   *mongo.Collection
+}
+
+func NewReviewRepository(reviews, votes *mongo.Collection) domain.ReviewRepository {
+	return &reviewRepository{reviews: reviews, votes: votes}
+}
+
+func (r *reviewRepository) CreateReview(ctx context.Context, review *domain.Review) (*domain.Review, error) {
+	review.ID = primitive.NewObjectID()
+	_, err := r.reviews.InsertOne(ctx, review)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, domain.ErrAlreadyReviewed
+	}
+	if err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+func (r *reviewRepository) GetReview(ctx context.Context, id string) (*domain.Review, error) {
+	objID, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+	var review domain.Review
+	err = r.reviews.FindOne(ctx, bson.M{"_id": objID}).Decode(&review)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, domain.ErrReviewNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+func (r *reviewRepository) ListReviews(ctx context.Context, f domain.ReviewFilter) ([]*domain.Review, error) {
+	filter := bson.M{}
+	if f.ProductID != "" {
+		productID, err := objectID(f.ProductID)
+		if err != nil {
+			return nil, err
+		}
+		filter["product_id"] = productID
+	}
+	if f.Status != domain.ReviewAny {
+		filter["status"] = f.Status
+	}
+	if f.Before != "" {
+		before, err := primitive.ObjectIDFromHex(f.Before)
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed cursor %q", domain.ErrValidation, f.Before)
+		}
+		filter["_id"] = bson.M{"$lt": before}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	if f.Limit > 0 {
+		opts.SetLimit(int64(f.Limit))
+	}
+	cursor, err := r.reviews.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	reviews := []*domain.Review{}
+	if err := cursor.All(ctx, &reviews); err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
+
+func (r *reviewRepository) UpdateReview(ctx context.Context, id string, version int64, fields map[string]any) (*domain.Review, error) {
+	objID, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	set := bson.M{"updated_at": time.Now()}
+	unset := bson.M{}
+	for k, v := range fields {
+		if v == nil {
+			unset[k] = ""
+		} else {
+			set[k] = v
+		}
+	}
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	var review domain.Review
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = r.reviews.FindOneAndUpdate(ctx, bson.M{"_id": objID, "version": version}, update, opts).Decode(&review)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, r.missed(ctx, objID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+func (r *reviewRepository) DeleteReview(ctx context.Context, id string, version int64) error {
+	objID, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	res, err := r.reviews.DeleteOne(ctx, bson.M{"_id": objID, "version": version})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return r.missed(ctx, objID)
+	}
+	_, err = r.votes.DeleteMany(ctx, bson.M{"review_id": objID})
+	return err
+}
+
+func (r *reviewRepository) AddVote(ctx context.Context, vote *domain.HelpfulVote) (bool, error) {
+	vote.ID = primitive.NewObjectID()
+	_, err := r.votes.InsertOne(ctx, vote)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, r.addHelpful(ctx, vote.ReviewID, 1)
+}
+
+func (r *reviewRepository) RemoveVote(ctx context.Context, reviewID primitive.ObjectID, userID string) (bool, error) {
+	res, err := r.votes.DeleteOne(ctx, bson.M{"review_id": reviewID, "user_id": userID})
+	if err != nil || res.DeletedCount == 0 {
+		return false, err
+	}
+	return true, r.addHelpful(ctx, reviewID, -1)
+}
+
+// addHelpful adjusts the helpful count of a review, which is served with it
+// and so bumps its version
+func (r *reviewRepository) addHelpful(ctx context.Context, id primitive.ObjectID, delta int64) error {
+	update := bson.M{"$inc": bson.M{"helpful_count": delta, "version": 1}}
+	_, err := r.reviews.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+func (r *reviewRepository) RatingTotals(ctx context.Context, productID primitive.ObjectID) (int64, int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"product_id": productID, "status": domain.ReviewApproved}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "count": bson.M{"$sum": 1}, "sum": bson.M{"$sum": "$rating"}}}},
+	}
+	cursor, err := r.reviews.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var totals []struct {
+		Count int64 `bson:"count"`
+		Sum   int64 `bson:"sum"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return 0, 0, err
+	}
+	if len(totals) == 0 {
+		return 0, 0, nil
+	}
+	return totals[0].Count, totals[0].Sum, nil
+}
+
+func (r *reviewRepository) PersonalData(ctx context.Context, userID string) (*domain.PersonalData, error) {
+	data := &domain.PersonalData{Reviews: []*domain.Review{}, HelpfulVotes: []*domain.HelpfulVote{}}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	cursor, err := r.reviews.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &data.Reviews); err != nil {
+		return nil, err
+	}
+	cursor, err = r.votes.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &data.HelpfulVotes); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (r *reviewRepository) AnonymizeUser(ctx context.Context, userID, pseudonym string) (int64, error) {
+	var changed int64
+	// the user's own reviews, and reviews of others they moderated
+	for _, field := range []string{"user_id", "moderated_by"} {
+		update := bson.M{"$set": bson.M{field: pseudonym}, "$inc": bson.M{"version": 1}}
+		res, err := r.reviews.UpdateMany(ctx, bson.M{field: userID}, update)
+		if err != nil {
+			return changed, err
+		}
+		changed += res.ModifiedCount
+	}
+	res, err := r.votes.UpdateMany(ctx, bson.M{"user_id": userID}, bson.M{"$set": bson.M{"user_id": pseudonym}})
+	if err != nil {
+		return changed, err
+	}
+	return changed + res.ModifiedCount, nil
+}
+
+// missed explains a conditional write that matched nothing: the review is
+// gone or at another version
+func (r *reviewRepository) missed(ctx context.Context, objID primitive.ObjectID) error {
+	n, err := r.reviews.CountDocuments(ctx, bson.M{"_id": objID})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrReviewNotFound
+	}
+	return domain.ErrReviewVersionMismatch
+}
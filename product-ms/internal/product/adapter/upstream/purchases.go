@@ -0,0 +1,29 @@
+// Package upstream implements the domain.PurchaseChecker port over the
+// internal endpoint of order-ms, see shared/purchases
+package upstream
+
+import (
+	"context"
+
+	"product-ms/internal/product/domain"
+
+	"shared/client"
+	"shared/purchases"
+)
+
+type purchaseChecker struct {
+	client *client.Client
+}
+
+// NewPurchaseChecker returns a domain.PurchaseChecker asking order-ms through c
+func NewPurchaseChecker(c *client.Client) domain.PurchaseChecker {
+	return &purchaseChecker{client: c}
+}
+
+func (p *purchaseChecker) HasDelivered(ctx context.Context, customerID, productID string) (bool, error) {
+	var purchase purchases.Purchase
+	if err := p.client.GetJSON(ctx, purchases.Path(customerID, productID), &purchase); err != nil {
+		return false, err
+	}
+	return purchase.Delivered, nil
+}
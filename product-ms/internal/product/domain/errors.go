@@ -0,0 +1,26 @@
+package domain
+
+import "shared/apperr"
+
+var (
+	ErrNotFound   = apperr.New(apperr.NotFound, "product not found")
+	ErrConflict   = apperr.New(apperr.Conflict, "a product with this ID already exists")
+	ErrValidation = apperr.New(apperr.Validation, "invalid input")
+	ErrForbidden  = apperr.New(apperr.Forbidden, "not allowed to access this product")
+
+	ErrVersionMismatch = apperr.New(apperr.PreconditionFailed, "the product was modified since it was read; fetch it again")
+	ErrNotDeleted      = apperr.New(apperr.Conflict, "the product is not deleted")
+
+	ErrUnauthorized          = apperr.New(apperr.Unauthorized, "authentication required")
+	ErrReviewNotFound        = apperr.New(apperr.NotFound, "review not found")
+	ErrAlreadyReviewed       = apperr.New(apperr.Conflict, "you have already reviewed this product; edit your review instead")
+	ErrNotReviewAuthor       = apperr.New(apperr.Forbidden, "only the author may edit a review")
+	ErrOwnReview             = apperr.New(apperr.Forbidden, "you cannot vote on your own review")
+	ErrReviewVersionMismatch = apperr.New(apperr.PreconditionFailed, "the review was modified since it was read; fetch it again")
+
+	ErrImageNotFound    = apperr.New(apperr.NotFound, "image not found")
+	ErrUnsupportedImage = apperr.New(apperr.UnsupportedMediaType, "unsupported image type")
+	ErrImageTooLarge    = apperr.New(apperr.TooLarge, "the image is too large")
+	ErrInvalidImage     = apperr.New(apperr.Validation, "the file is not a readable image")
+	ErrGalleryMismatch  = apperr.New(apperr.Conflict, "the images listed are not those of the gallery")
+)
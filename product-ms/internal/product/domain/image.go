@@ -0,0 +1,80 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Image is a picture in a product's gallery. The files, the original and
+// its thumbnails, are kept in the BlobStore and served at signed URLs.
+type Image struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty" example:"66b0c1d24c77c5b41f5a9d10"`
+	ProductID primitive.ObjectID `json:"product_id" bson:"product_id" example:"64b22dd94c77c5b41f5a9b0d"`
+	// Variant names the gallery of a variant of the product, e.g. a color.
+	// Products have no variants of their own, so it is free text; the empty
+	// variant is the product's main gallery.
+	Variant string `json:"variant" bson:"variant" example:"blue"`
+	// Position orders the images of a gallery, lowest first
+	Position    int    `json:"position" bson:"position" example:"0"`
+	AltText     string `json:"alt_text" bson:"alt_text" example:"Blue bottle, front"`
+	ContentType string `json:"content_type" bson:"content_type" example:"image/jpeg"`
+	Width       int    `json:"width" bson:"width" example:"2400"`
+	Height      int    `json:"height" bson:"height" example:"1600"`
+	Size        int64  `json:"size" bson:"size" example:"843211"`
+	// Key locates the original in the blob store
+	Key string `json:"-" bson:"key"`
+	// URL serves the original until the signature expires; it is signed
+	// whenever the image is read
+	URL        string      `json:"url" bson:"-"`
+	Thumbnails []Thumbnail `json:"thumbnails" bson:"thumbnails"`
+	CreatedAt  time.Time   `json:"created_at" bson:"created_at"`
+}
+
+// Thumbnail is a scaled-down copy of an image, fitting in a square of one
+// of the configured sizes
+type Thumbnail struct {
+	Width       int    `json:"width" bson:"width" example:"480"`
+	Height      int    `json:"height" bson:"height" example:"320"`
+	ContentType string `json:"content_type" bson:"content_type" example:"image/jpeg"`
+	Size        int64  `json:"size" bson:"size" example:"31207"`
+	Key         string `json:"-" bson:"key"`
+	URL         string `json:"url" bson:"-"`
+}
+
+// ImageUpload is an uploaded picture with its gallery and description
+type ImageUpload struct {
+	Variant string `validate:"max=64"`
+	AltText string `validate:"max=250"`
+	Data    []byte
+}
+
+// ImageOrderRequest is the body of PUT /products/{id}/images/order
+type ImageOrderRequest struct {
+	Variant string `json:"variant" validate:"max=64" example:"blue"`
+	// ImageIDs lists every image of the gallery, in the new order
+	ImageIDs []string `json:"image_ids" validate:"required,min=1,dive,mongodb" example:"66b0c1d24c77c5b41f5a9d10,66b0c1e04c77c5b41f5a9d11"`
+}
+
+type ImageRepository interface {
+	// CreateImage adds the image at the end of its gallery
+	CreateImage(ctx context.Context, image *Image) (*Image, error)
+	GetImage(ctx context.Context, id string) (*Image, error)
+	// ListImages returns the images of a product in gallery order, grouped
+	// by variant; variant, when not nil, picks one gallery
+	ListImages(ctx context.Context, productID primitive.ObjectID, variant *string) ([]*Image, error)
+	// SetPositions numbers the images of a gallery in the order of ids
+	SetPositions(ctx context.Context, productID primitive.ObjectID, variant string, ids []primitive.ObjectID) error
+	DeleteImage(ctx context.Context, id primitive.ObjectID) error
+}
+
+// BlobStore keeps the files of images under keys such as
+// products/<product ID>/<image ID>/original.jpg
+type BlobStore interface {
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	// Delete succeeds for a key that does not exist
+	Delete(ctx context.Context, key string) error
+	// SignedURL returns a URL that serves the blob for ttl from signedAt
+	SignedURL(ctx context.Context, key string, signedAt time.Time, ttl time.Duration) (string, error)
+}
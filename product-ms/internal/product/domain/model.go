@@ -6,11 +6,27 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// AnyVersion makes a write unconditional. It equals etag.Any, which
+// "If-Match: *" yields; internal gRPC callers use it as well.
+const AnyVersion int64 = -1
+
 type Product struct {
 	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty" example:"64b22dd94c77c5b41f5a9b0d"`
 	Name        string             `json:"name" bson:"name" example:"Water Bottle"`
 	Description string             `json:"description" bson:"description" example:"Reusable plastic bottle"`
 	Price       float64            `json:"price" bson:"price" example:"8.99"`
-	CreatedAt   time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
-	UpdatedAt   time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	// RatingAverage and RatingCount summarize the approved reviews. They are
+	// kept up to date as reviews change rather than computed on every read.
+	RatingAverage float64 `json:"rating_average" bson:"rating_average" example:"4.25"`
+	RatingCount   int64   `json:"rating_count" bson:"rating_count" example:"12"`
+	// RatingSum is the total of the approved ratings, which the average derives from
+	RatingSum int64     `json:"-" bson:"rating_sum"`
+	CreatedAt time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	// Version is incremented by every write and served as the ETag
+	Version int64 `json:"version" bson:"version" example:"3"`
+	// DeletedAt is set when the product is soft-deleted; such products are
+	// hidden from every query but the admin views and can be restored
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty" bson:"deleted_by,omitempty"`
 }
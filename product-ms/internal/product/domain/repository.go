@@ -1,11 +1,28 @@
 package domain
 
-import "context"
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type ProductRepository interface {
 	Create(ctx context.Context, product *Product) (*Product, error)
-	GetAll(ctx context.Context) ([]*Product, error)
-	GetByID(ctx context.Context, id string) (*Product, error)
-	Update(ctx context.Context, id string, product *Product) (*Product, error)
-	Delete(ctx context.Context, id string) error
+	// Reads skip soft-deleted products unless includeDeleted
+	GetAll(ctx context.Context, includeDeleted bool) ([]*Product, error)
+	GetByID(ctx context.Context, id string, includeDeleted bool) (*Product, error)
+	// Writes apply only while the product is at version (see AnyVersion)
+	// and fail with ErrVersionMismatch otherwise
+	Update(ctx context.Context, id string, version int64, product *Product) (*Product, error)
+	// Patch sets only the given fields, keyed by bson name
+	Patch(ctx context.Context, id string, version int64, fields map[string]any) (*Product, error)
+	// Delete soft-deletes the product, recording who did it
+	Delete(ctx context.Context, id string, version int64, deletedBy string) error
+	// Restore undoes Delete; it fails with ErrNotDeleted for a live product
+	Restore(ctx context.Context, id string, version int64) (*Product, error)
+	// AddRating adds to the rating count and sum of a product, deleted or
+	// not, and derives the average from them
+	AddRating(ctx context.Context, id primitive.ObjectID, count, sum int64) error
+	// SetRating replaces the rating count and sum of a live product
+	SetRating(ctx context.Context, id primitive.ObjectID, count, sum int64) (*Product, error)
 }
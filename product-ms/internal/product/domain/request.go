@@ -0,0 +1,23 @@
+package domain
+
+// ProductRequest is the body of POST and PUT, and the document PATCH merge
+// patches are applied to
+type ProductRequest struct {
+	Name        string  `json:"name" bson:"name" validate:"required,min=3,max=100"`
+	Description string  `json:"description" bson:"description" validate:"required,min=5"`
+	Price       float64 `json:"price" bson:"price" validate:"required,gt=0"`
+}
+
+// ReviewRequest is the body of POST and PUT of a review
+type ReviewRequest struct {
+	Rating int    `json:"rating" validate:"required,min=1,max=5" example:"4"`
+	Title  string `json:"title" validate:"required,min=3,max=120" example:"Does not leak"`
+	Body   string `json:"body" validate:"max=5000" example:"Keeps water cold for hours."`
+}
+
+// ModerationRequest approves or rejects a review
+type ModerationRequest struct {
+	Status string `json:"status" validate:"required,oneof=approved rejected" example:"rejected"`
+	// Note is shown to the author, to explain a rejection
+	Note string `json:"note" validate:"max=500" example:"Reviews must be about the product"`
+}
@@ -0,0 +1,105 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Moderation states of a review. A review is pending until an admin
+// approves or rejects it, and only approved reviews are shown to everyone
+// and count towards the product's rating.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+	// ReviewAny lists reviews in every state (admins only)
+	ReviewAny = "any"
+)
+
+type Review struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty" example:"66a1f3c24c77c5b41f5a9c21"`
+	ProductID primitive.ObjectID `json:"product_id" bson:"product_id" example:"64b22dd94c77c5b41f5a9b0d"`
+	UserID    string             `json:"user_id" bson:"user_id"`
+	Rating    int                `json:"rating" bson:"rating" example:"4"`
+	Title     string             `json:"title" bson:"title" example:"Does not leak"`
+	Body      string             `json:"body" bson:"body" example:"Keeps water cold for hours."`
+	// VerifiedPurchase is set when order-ms had delivered the product to the
+	// author when they wrote or last edited the review
+	VerifiedPurchase bool   `json:"verified_purchase" bson:"verified_purchase"`
+	Status           string `json:"status" bson:"status" example:"approved"`
+	// ModerationNote tells the author why their review was rejected
+	ModerationNote string     `json:"moderation_note,omitempty" bson:"moderation_note,omitempty"`
+	ModeratedBy    string     `json:"moderated_by,omitempty" bson:"moderated_by,omitempty"`
+	ModeratedAt    *time.Time `json:"moderated_at,omitempty" bson:"moderated_at,omitempty"`
+	HelpfulCount   int64      `json:"helpful_count" bson:"helpful_count" example:"7"`
+	CreatedAt      time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" bson:"updated_at"`
+	// Version is incremented by every write and served as the ETag
+	Version int64 `json:"version" bson:"version" example:"2"`
+}
+
+// Counts reports whether the review is part of its product's rating
+func (r *Review) Counts() bool {
+	return r.Status == ReviewApproved
+}
+
+// HelpfulVote records that a user found a review helpful; each user votes
+// at most once per review
+type HelpfulVote struct {
+	ID        primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	ReviewID  primitive.ObjectID `json:"review_id" bson:"review_id"`
+	UserID    string             `json:"user_id" bson:"user_id"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+type ReviewFilter struct {
+	// ProductID restricts the list to one product; empty lists every product
+	ProductID string
+	// Status is a moderation state, or ReviewAny
+	Status string
+	// Before is a page cursor: only reviews with a smaller ID are returned
+	Before string
+	// Limit caps the number of reviews returned; 0 means no cap
+	Limit int
+}
+
+// PersonalData is what product-ms holds about a user, for a data export
+type PersonalData struct {
+	Reviews      []*Review      `json:"reviews"`
+	HelpfulVotes []*HelpfulVote `json:"helpful_votes"`
+}
+
+type ReviewRepository interface {
+	// CreateReview fails with ErrAlreadyReviewed when the user has a review
+	// of the product already
+	CreateReview(ctx context.Context, review *Review) (*Review, error)
+	GetReview(ctx context.Context, id string) (*Review, error)
+	// ListReviews returns reviews newest first
+	ListReviews(ctx context.Context, f ReviewFilter) ([]*Review, error)
+	// UpdateReview sets the given fields, keyed by bson name, while the
+	// review is at version and fails with ErrReviewVersionMismatch otherwise
+	UpdateReview(ctx context.Context, id string, version int64, fields map[string]any) (*Review, error)
+	// DeleteReview deletes the review at version and its votes
+	DeleteReview(ctx context.Context, id string, version int64) error
+	// AddVote records a vote and adds it to the review's helpful count. It
+	// reports false, changing nothing, when the user had voted already.
+	AddVote(ctx context.Context, vote *HelpfulVote) (bool, error)
+	// RemoveVote undoes AddVote; it reports false when there was no vote
+	RemoveVote(ctx context.Context, reviewID primitive.ObjectID, userID string) (bool, error)
+	// RatingTotals counts and sums the ratings of a product's approved reviews
+	RatingTotals(ctx context.Context, productID primitive.ObjectID) (count, sum int64, err error)
+	// PersonalData returns the reviews and votes of a user
+	PersonalData(ctx context.Context, userID string) (*PersonalData, error)
+	// AnonymizeUser replaces userID by pseudonym in reviews and votes, the
+	// reviews the user moderated included, and returns how many changed
+	AnonymizeUser(ctx context.Context, userID, pseudonym string) (int64, error)
+}
+
+// PurchaseChecker asks order-ms about purchases, see shared/purchases
+type PurchaseChecker interface {
+	// HasDelivered reports whether an order of the customer for the product
+	// has been delivered
+	HasDelivered(ctx context.Context, customerID, productID string) (bool, error)
+}
@@ -0,0 +1,52 @@
+package domain
+
+import "context"
+
+type ProductUseCase interface {
+	CreateProduct(ctx context.Context, p *Product) (*Product, error)
+	ListProducts(ctx context.Context, includeDeleted bool) ([]*Product, error)
+	GetProductByID(ctx context.Context, id string, includeDeleted bool) (*Product, error)
+	UpdateProduct(ctx context.Context, id string, version int64, p *Product) (*Product, error)
+	PatchProduct(ctx context.Context, id string, version int64, fields map[string]any) (*Product, error)
+	DeleteProduct(ctx context.Context, id string, version int64) error
+	RestoreProduct(ctx context.Context, id string, version int64) (*Product, error)
+	// RecomputeRating rebuilds a product's rating from its approved reviews,
+	// should the incremental updates ever have missed one
+	RecomputeRating(ctx context.Context, id string) (*Product, error)
+
+	// CreateReview adds the caller's review of a product, pending moderation
+	CreateReview(ctx context.Context, productID string, req ReviewRequest) (*Review, error)
+	// ListReviews returns a page of reviews and the cursor of the next page,
+	// empty on the last
+	ListReviews(ctx context.Context, f ReviewFilter) ([]*Review, string, error)
+	// GetReview returns a review of the product. Reviews not approved are
+	// only found by their author and admins.
+	GetReview(ctx context.Context, productID, reviewID string) (*Review, error)
+	// UpdateReview lets the author rewrite their review, which then awaits
+	// moderation again
+	UpdateReview(ctx context.Context, productID, reviewID string, version int64, req ReviewRequest) (*Review, error)
+	// DeleteReview deletes a review, for its author or an admin
+	DeleteReview(ctx context.Context, productID, reviewID string, version int64) error
+	ModerateReview(ctx context.Context, productID, reviewID string, version int64, req ModerationRequest) (*Review, error)
+	// VoteHelpful and UnvoteHelpful add and remove the caller's helpful vote
+	// on an approved review of someone else; repeating either changes nothing
+	VoteHelpful(ctx context.Context, productID, reviewID string) (*Review, error)
+	UnvoteHelpful(ctx context.Context, productID, reviewID string) (*Review, error)
+
+	// UploadImage stores a picture and its thumbnails and adds it at the end
+	// of its gallery
+	UploadImage(ctx context.Context, productID string, upload ImageUpload) (*Image, error)
+	// ListImages returns the images of a product in gallery order; variant,
+	// when not nil, picks one gallery
+	ListImages(ctx context.Context, productID string, variant *string) ([]*Image, error)
+	GetImage(ctx context.Context, productID, imageID string) (*Image, error)
+	// ReorderImages puts a gallery in the order requested, which must list
+	// each of its images once
+	ReorderImages(ctx context.Context, productID string, req ImageOrderRequest) ([]*Image, error)
+	DeleteImage(ctx context.Context, productID, imageID string) error
+
+	// ExportUser and AnonymizeUser serve the data export and erasure requests
+	// of user-ms, see shared/privacy
+	ExportUser(ctx context.Context, userID string) (*PersonalData, error)
+	AnonymizeUser(ctx context.Context, userID, pseudonym string) (int64, error)
+}
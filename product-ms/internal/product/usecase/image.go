@@ -0,0 +1,235 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"product-ms/internal/product/domain"
+	"product-ms/pkg/imaging"
+)
+
+// imageTypes are the pictures that can be uploaded, with their file
+// extensions; they are the ones the standard library decodes
+var imageTypes = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// blobFile is a file of an image to put in the blob store
+type blobFile struct {
+	key         string
+	contentType string
+	data        []byte
+}
+
+func (uc *ProductUseCase) UploadImage(ctx context.Context, productID string, upload domain.ImageUpload) (*domain.Image, error) {
+	product, err := uc.repo.GetByID(ctx, productID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// the type is sniffed from the content: the client's word for it is not trusted
+	contentType, _, _ := strings.Cut(mimetype.Detect(upload.Data).String(), ";")
+	ext, ok := imageTypes[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s, upload a JPEG, PNG or GIF", domain.ErrUnsupportedImage, contentType)
+	}
+	width, height, err := imaging.DecodeConfig(upload.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidImage, err)
+	}
+	if width*height > uc.opts.MaxImagePixels {
+		return nil, fmt.Errorf("%w: %d×%d pixels, the most is %d", domain.ErrImageTooLarge, width, height, uc.opts.MaxImagePixels)
+	}
+	img, err := imaging.Decode(upload.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidImage, err)
+	}
+
+	image := &domain.Image{
+		ID:          primitive.NewObjectID(),
+		ProductID:   product.ID,
+		Variant:     upload.Variant,
+		AltText:     upload.AltText,
+		ContentType: contentType,
+		Width:       width,
+		Height:      height,
+		Size:        int64(len(upload.Data)),
+		Thumbnails:  []domain.Thumbnail{},
+		CreatedAt:   time.Now(),
+	}
+	prefix := "products/" + product.ID.Hex() + "/" + image.ID.Hex() + "/"
+	image.Key = prefix + "original." + ext
+	files := []blobFile{{key: image.Key, contentType: contentType, data: upload.Data}}
+
+	for _, size := range uc.opts.ThumbnailSizes {
+		if width <= size && height <= size {
+			continue // it would be the original again
+		}
+		thumb := imaging.Thumbnail(img, size)
+		// photos stay JPEGs; PNGs and GIFs may be transparent, so become PNGs
+		thumbType, thumbExt, encode := "image/png", "png", imaging.EncodePNG
+		if contentType == "image/jpeg" {
+			thumbType, thumbExt, encode = "image/jpeg", "jpg", imaging.EncodeJPEG
+		}
+		data, err := encode(thumb)
+		if err != nil {
+			return nil, err
+		}
+		key := prefix + strconv.Itoa(size) + "." + thumbExt
+		image.Thumbnails = append(image.Thumbnails, domain.Thumbnail{
+			Width:       thumb.Rect.Dx(),
+			Height:      thumb.Rect.Dy(),
+			ContentType: thumbType,
+			Size:        int64(len(data)),
+			Key:         key,
+		})
+		files = append(files, blobFile{key: key, contentType: thumbType, data: data})
+	}
+
+	for i, f := range files {
+		if err := uc.blobs.Put(ctx, f.key, f.contentType, f.data); err != nil {
+			uc.deleteBlobs(ctx, files[:i])
+			return nil, err
+		}
+	}
+	if _, err := uc.images.CreateImage(ctx, image); err != nil {
+		uc.deleteBlobs(ctx, files)
+		return nil, err
+	}
+	if err := uc.sign(ctx, image); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+func (uc *ProductUseCase) ListImages(ctx context.Context, productID string, variant *string) ([]*domain.Image, error) {
+	product, err := uc.repo.GetByID(ctx, productID, false)
+	if err != nil {
+		return nil, err
+	}
+	images, err := uc.images.ListImages(ctx, product.ID, variant)
+	if err != nil {
+		return nil, err
+	}
+	if err := uc.sign(ctx, images...); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (uc *ProductUseCase) GetImage(ctx context.Context, productID, imageID string) (*domain.Image, error) {
+	image, err := uc.image(ctx, productID, imageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := uc.sign(ctx, image); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+func (uc *ProductUseCase) ReorderImages(ctx context.Context, productID string, req domain.ImageOrderRequest) ([]*domain.Image, error) {
+	product, err := uc.repo.GetByID(ctx, productID, false)
+	if err != nil {
+		return nil, err
+	}
+	gallery, err := uc.images.ListImages(ctx, product.ID, &req.Variant)
+	if err != nil {
+		return nil, err
+	}
+
+	// a gallery changed by an upload or a deletion since the client read it
+	// is refused rather than half reordered
+	if len(req.ImageIDs) != len(gallery) {
+		return nil, fmt.Errorf("%w: it has %d images, %d were listed", domain.ErrGalleryMismatch, len(gallery), len(req.ImageIDs))
+	}
+	unlisted := make(map[string]bool, len(gallery))
+	for _, image := range gallery {
+		unlisted[image.ID.Hex()] = true
+	}
+	ids := make([]primitive.ObjectID, len(req.ImageIDs))
+	for i, id := range req.ImageIDs {
+		if !unlisted[id] {
+			return nil, fmt.Errorf("%w: %s is not in it, or is listed twice", domain.ErrGalleryMismatch, id)
+		}
+		delete(unlisted, id)
+		ids[i], _ = primitive.ObjectIDFromHex(id)
+	}
+
+	if err := uc.images.SetPositions(ctx, product.ID, req.Variant, ids); err != nil {
+		return nil, err
+	}
+	return uc.ListImages(ctx, productID, &req.Variant)
+}
+
+// DeleteImage deletes the files after the image, so that a failure leaves
+// unused files rather than an image without them
+func (uc *ProductUseCase) DeleteImage(ctx context.Context, productID, imageID string) error {
+	image, err := uc.image(ctx, productID, imageID)
+	if err != nil {
+		return err
+	}
+	if err := uc.images.DeleteImage(ctx, image.ID); err != nil {
+		return err
+	}
+	files := []blobFile{{key: image.Key}}
+	for _, thumb := range image.Thumbnails {
+		files = append(files, blobFile{key: thumb.Key})
+	}
+	uc.deleteBlobs(ctx, files)
+	return nil
+}
+
+// image returns an image, which must be of the product
+func (uc *ProductUseCase) image(ctx context.Context, productID, imageID string) (*domain.Image, error) {
+	image, err := uc.images.GetImage(ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+	if image.ProductID.Hex() != productID {
+		return nil, domain.ErrImageNotFound
+	}
+	return image, nil
+}
+
+// sign fills in the URLs of images. They are signed as of the start of the
+// current half of MediaURLTTL, so that they stay the same, and cacheable,
+// for a while, yet always have at least half of it left.
+func (uc *ProductUseCase) sign(ctx context.Context, images ...*domain.Image) error {
+	ttl := uc.opts.MediaURLTTL
+	signedAt := time.Now().Truncate(ttl / 2)
+	for _, image := range images {
+		url, err := uc.blobs.SignedURL(ctx, image.Key, signedAt, ttl)
+		if err != nil {
+			return err
+		}
+		image.URL = url
+		for i := range image.Thumbnails {
+			thumb := &image.Thumbnails[i]
+			if thumb.URL, err = uc.blobs.SignedURL(ctx, thumb.Key, signedAt, ttl); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteBlobs removes files that are no longer or not yet referenced. A
+// failure is only logged: the file is left unused.
+func (uc *ProductUseCase) deleteBlobs(ctx context.Context, files []blobFile) {
+	ctx = context.WithoutCancel(ctx)
+	for _, f := range files {
+		if err := uc.blobs.Delete(ctx, f.key); err != nil {
+			slog.ErrorContext(ctx, "failed to delete an image file", "key", f.key, "error", err)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package usecase
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"product-ms/internal/product/domain"
+
+	"shared/auth"
+)
+
+func (uc *ProductUseCase) CreateReview(ctx context.Context, productID string, req domain.ReviewRequest) (*domain.Review, error) {
+	userID, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	product, err := uc.repo.GetByID(ctx, productID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return uc.reviews.CreateReview(ctx, &domain.Review{
+		ProductID:        product.ID,
+		UserID:           userID,
+		Rating:           req.Rating,
+		Title:            req.Title,
+		Body:             req.Body,
+		VerifiedPurchase: uc.verifiedPurchase(ctx, userID, productID),
+		Status:           domain.ReviewPending,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+		Version:          1,
+	})
+}
+
+func (uc *ProductUseCase) ListReviews(ctx context.Context, f domain.ReviewFilter) ([]*domain.Review, string, error) {
+	if f.ProductID != "" {
+		if _, err := uc.repo.GetByID(ctx, f.ProductID, false); err != nil {
+			return nil, "", err
+		}
+	}
+	limit := f.Limit
+	if limit > 0 {
+		// one extra review tells whether there is a next page
+		f.Limit++
+	}
+	reviews, err := uc.reviews.ListReviews(ctx, f)
+	if err != nil {
+		return nil, "", err
+	}
+	if limit > 0 && len(reviews) > limit {
+		reviews = reviews[:limit]
+		return reviews, reviews[limit-1].ID.Hex(), nil
+	}
+	return reviews, "", nil
+}
+
+func (uc *ProductUseCase) GetReview(ctx context.Context, productID, reviewID string) (*domain.Review, error) {
+	review, err := uc.review(ctx, productID, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	if !review.Counts() && !auth.HasRole(ctx, auth.RoleAdmin) {
+		if userID, _ := callerID(ctx); userID != review.UserID {
+			return nil, domain.ErrReviewNotFound
+		}
+	}
+	return review, nil
+}
+
+func (uc *ProductUseCase) UpdateReview(ctx context.Context, productID, reviewID string, version int64, req domain.ReviewRequest) (*domain.Review, error) {
+	userID, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	before, err := uc.current(ctx, productID, reviewID, version)
+	if err != nil {
+		return nil, err
+	}
+	if before.UserID != userID {
+		return nil, domain.ErrNotReviewAuthor
+	}
+
+	// the rewrite has not been moderated; the purchase may have arrived since
+	after, err := uc.reviews.UpdateReview(ctx, reviewID, before.Version, map[string]any{
+		"rating":            req.Rating,
+		"title":             req.Title,
+		"body":              req.Body,
+		"verified_purchase": uc.verifiedPurchase(ctx, userID, productID),
+		"status":            domain.ReviewPending,
+		"moderation_note":   nil,
+		"moderated_by":      nil,
+		"moderated_at":      nil,
+	})
+	if err != nil {
+		return nil, err
+	}
+	uc.updateRating(ctx, before, after)
+	return after, nil
+}
+
+func (uc *ProductUseCase) DeleteReview(ctx context.Context, productID, reviewID string, version int64) error {
+	userID, err := callerID(ctx)
+	if err != nil {
+		return err
+	}
+	before, err := uc.current(ctx, productID, reviewID, version)
+	if err != nil {
+		return err
+	}
+	if before.UserID != userID && !auth.HasRole(ctx, auth.RoleAdmin) {
+		return domain.ErrNotReviewAuthor
+	}
+
+	if err := uc.reviews.DeleteReview(ctx, reviewID, before.Version); err != nil {
+		return err
+	}
+	uc.updateRating(ctx, before, nil)
+	return nil
+}
+
+func (uc *ProductUseCase) ModerateReview(ctx context.Context, productID, reviewID string, version int64, req domain.ModerationRequest) (*domain.Review, error) {
+	before, err := uc.current(ctx, productID, reviewID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]any{
+		"status":          req.Status,
+		"moderation_note": nil,
+		"moderated_at":    time.Now(),
+	}
+	if req.Note != "" {
+		fields["moderation_note"] = req.Note
+	}
+	if userID, err := callerID(ctx); err == nil {
+		fields["moderated_by"] = userID
+	}
+	after, err := uc.reviews.UpdateReview(ctx, reviewID, before.Version, fields)
+	if err != nil {
+		return nil, err
+	}
+	uc.updateRating(ctx, before, after)
+	return after, nil
+}
+
+func (uc *ProductUseCase) VoteHelpful(ctx context.Context, productID, reviewID string) (*domain.Review, error) {
+	review, userID, err := uc.votable(ctx, productID, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = uc.reviews.AddVote(ctx, &domain.HelpfulVote{ReviewID: review.ID, UserID: userID, CreatedAt: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	return uc.reviews.GetReview(ctx, reviewID)
+}
+
+func (uc *ProductUseCase) UnvoteHelpful(ctx context.Context, productID, reviewID string) (*domain.Review, error) {
+	review, userID, err := uc.votable(ctx, productID, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := uc.reviews.RemoveVote(ctx, review.ID, userID); err != nil {
+		return nil, err
+	}
+	return uc.reviews.GetReview(ctx, reviewID)
+}
+
+// RecomputeRating may race with reviews changing meanwhile, which the next
+// recomputation corrects
+func (uc *ProductUseCase) RecomputeRating(ctx context.Context, id string) (*domain.Product, error) {
+	product, err := uc.repo.GetByID(ctx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	count, sum, err := uc.reviews.RatingTotals(ctx, product.ID)
+	if err != nil {
+		return nil, err
+	}
+	return uc.repo.SetRating(ctx, product.ID, count, sum)
+}
+
+func (uc *ProductUseCase) ExportUser(ctx context.Context, userID string) (*domain.PersonalData, error) {
+	return uc.reviews.PersonalData(ctx, userID)
+}
+
+// AnonymizeUser keeps the reviews, and so the ratings they make up
+func (uc *ProductUseCase) AnonymizeUser(ctx context.Context, userID, pseudonym string) (int64, error) {
+	return uc.reviews.AnonymizeUser(ctx, userID, pseudonym)
+}
+
+// review returns a review, which must be of the product
+func (uc *ProductUseCase) review(ctx context.Context, productID, reviewID string) (*domain.Review, error) {
+	review, err := uc.reviews.GetReview(ctx, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	if review.ProductID.Hex() != productID {
+		return nil, domain.ErrReviewNotFound
+	}
+	return review, nil
+}
+
+// current returns a review that is still at version (see AnyVersion). The
+// write that follows is made at the version returned, so that the review
+// it changes is exactly the one the rating is corrected for.
+func (uc *ProductUseCase) current(ctx context.Context, productID, reviewID string, version int64) (*domain.Review, error) {
+	review, err := uc.review(ctx, productID, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	if version != domain.AnyVersion && version != review.Version {
+		return nil, domain.ErrReviewVersionMismatch
+	}
+	return review, nil
+}
+
+// votable returns an approved review for the caller to vote on
+func (uc *ProductUseCase) votable(ctx context.Context, productID, reviewID string) (*domain.Review, string, error) {
+	userID, err := callerID(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	review, err := uc.review(ctx, productID, reviewID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !review.Counts() {
+		return nil, "", domain.ErrReviewNotFound
+	}
+	if review.UserID == userID {
+		return nil, "", domain.ErrOwnReview
+	}
+	return review, userID, nil
+}
+
+// updateRating moves the product's rating from the review before a write to
+// the review after it, nil when it was deleted. The review is written
+// already, so a failure is only logged: the rating is off until
+// RecomputeRating.
+func (uc *ProductUseCase) updateRating(ctx context.Context, before, after *domain.Review) {
+	var count, sum int64
+	if before.Counts() {
+		count--
+		sum -= int64(before.Rating)
+	}
+	if after != nil && after.Counts() {
+		count++
+		sum += int64(after.Rating)
+	}
+	if count == 0 && sum == 0 {
+		return
+	}
+
+	if err := uc.repo.AddRating(context.WithoutCancel(ctx), before.ProductID, count, sum); err != nil {
+		slog.ErrorContext(ctx, "failed to update the product rating", "product_id", before.ProductID.Hex(), "error", err)
+	}
+}
+
+// verifiedPurchase asks order-ms whether the user received the product. A
+// review is not held up by order-ms being down; it is checked again when
+// the review is edited.
+func (uc *ProductUseCase) verifiedPurchase(ctx context.Context, userID, productID string) bool {
+	delivered, err := uc.purchases.HasDelivered(ctx, userID, productID)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to check for a purchase", "product_id", productID, "error", err)
+		return false
+	}
+	return delivered
+}
+
+// callerID returns the ID of the authenticated user
+func callerID(ctx context.Context) (string, error) {
+	caller, ok := auth.FromContext(ctx)
+	if !ok || caller.UserID == "" {
+		return "", domain.ErrUnauthorized
+	}
+	return caller.UserID, nil
+}
@@ -5,35 +5,68 @@ import (
 	"time"
 
 	"product-ms/internal/product/domain"
+
+	"shared/auth"
 )
 
+// Options configures the image uploads of the use case
+type Options struct {
+	// MaxImagePixels refuses pictures with more pixels, which would take
+	// too much memory to decode
+	MaxImagePixels int
+	// ThumbnailSizes are the squares, in pixels, that thumbnails are made to fit
+	ThumbnailSizes []int
+	// MediaURLTTL is how long the signed URLs of images serve them
+	MediaURLTTL time.Duration
+}
+
 type ProductUseCase struct {
-	repo domain.ProductRepository
+	repo      domain.ProductRepository
+	reviews   domain.ReviewRepository
+	purchases domain.PurchaseChecker
+	images    domain.ImageRepository
+	blobs     domain.BlobStore
+	opts      Options
 }
 
-func NewProductUseCase(r domain.ProductRepository) *ProductUseCase {
-	return &ProductUseCase{repo: r}
+func NewProductUseCase(r domain.ProductRepository, reviews domain.ReviewRepository, purchases domain.PurchaseChecker,
+	images domain.ImageRepository, blobs domain.BlobStore, opts Options) *ProductUseCase {
+	return &ProductUseCase{repo: r, reviews: reviews, purchases: purchases, images: images, blobs: blobs, opts: opts}
 }
 
 func (uc *ProductUseCase) CreateProduct(ctx context.Context, p *domain.Product) (*domain.Product, error) {
 	p.CreatedAt = time.Now()
 	p.UpdatedAt = time.Now()
+	p.Version = 1
 	return uc.repo.Create(ctx, p)
 }
 
-func (uc *ProductUseCase) ListProducts(ctx context.Context) ([]*domain.Product, error) {
-	return uc.repo.GetAll(ctx)
+func (uc *ProductUseCase) ListProducts(ctx context.Context, includeDeleted bool) ([]*domain.Product, error) {
+	return uc.repo.GetAll(ctx, includeDeleted)
 }
 
-func (uc *ProductUseCase) GetProductByID(ctx context.Context, id string) (*domain.Product, error) {
-	return uc.repo.GetByID(ctx, id)
+func (uc *ProductUseCase) GetProductByID(ctx context.Context, id string, includeDeleted bool) (*domain.Product, error) {
+	return uc.repo.GetByID(ctx, id, includeDeleted)
 }
 
-func (uc *ProductUseCase) UpdateProduct(ctx context.Context, id string, p *domain.Product) (*domain.Product, error) {
+func (uc *ProductUseCase) UpdateProduct(ctx context.Context, id string, version int64, p *domain.Product) (*domain.Product, error) {
 	p.UpdatedAt = time.Now()
-	return uc.repo.Update(ctx, id, p)
+	return uc.repo.Update(ctx, id, version, p)
+}
+
+// PatchProduct writes even an empty patch, so that it too checks and bumps the version
+func (uc *ProductUseCase) PatchProduct(ctx context.Context, id string, version int64, fields map[string]any) (*domain.Product, error) {
+	return uc.repo.Patch(ctx, id, version, fields)
+}
+
+func (uc *ProductUseCase) DeleteProduct(ctx context.Context, id string, version int64) error {
+	var deletedBy string
+	if caller, ok := auth.FromContext(ctx); ok {
+		deletedBy = caller.UserID
+	}
+	return uc.repo.Delete(ctx, id, version, deletedBy)
 }
 
-func (uc *ProductUseCase) DeleteProduct(ctx context.Context, id string) error {
-	return uc.repo.Delete(ctx, id)
+func (uc *ProductUseCase) RestoreProduct(ctx context.Context, id string, version int64) (*domain.Product, error) {
+	return uc.repo.Restore(ctx, id, version)
 }
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"shared/auth"
+	sharedconfig "shared/config"
+	"shared/logging"
+	"shared/server"
+	"shared/tracing"
+)
+
+// Config holds the product-ms settings, see shared/config for how they are loaded
+type Config struct {
+	Port     string `env:"PORT" default:"8082"`
+	GRPCPort string `env:"GRPC_PORT" default:"9092"`
+	MongoURI string `env:"MONGO_URI" default:"mongodb://localhost:27017" secret:"true"`
+	DBName   string `env:"DB_NAME" default:"productdb"`
+
+	// OrderURL is asked whether a reviewer bought the product
+	OrderURL        string        `env:"ORDER_SERVICE_URL" default:"http://localhost:8083"`
+	UpstreamTimeout time.Duration `env:"UPSTREAM_TIMEOUT" default:"5s"`
+
+	// MediaStore keeps the files of product images: filesystem, or s3 for S3
+	// and compatible stores such as MinIO
+	MediaStore string `env:"MEDIA_STORE" default:"filesystem"`
+	// MediaURLTTL is how long a signed image URL serves the file; S3 allows 7 days at most
+	MediaURLTTL time.Duration `env:"MEDIA_URL_TTL" default:"1h"`
+	// MaxUploadSize is the largest image file accepted, in bytes
+	MaxUploadSize int64 `env:"MEDIA_MAX_UPLOAD_SIZE" default:"10485760"`
+	// MaxImagePixels refuses larger pictures, which take 4 bytes a pixel to decode
+	MaxImagePixels int `env:"MEDIA_MAX_PIXELS" default:"16000000"`
+	// ThumbnailSizes are the squares, in pixels, thumbnails are made to fit
+	ThumbnailSizes []string `env:"MEDIA_THUMBNAIL_SIZES" default:"160,640"`
+
+	// The filesystem store keeps files under MediaDir and serves them at
+	// MediaPublicURL, through the gateway. MediaURLSecret signs the URLs;
+	// when empty a random one is made at startup, so URLs break on restarts
+	// and between replicas.
+	MediaDir       string `env:"MEDIA_DIR" default:"./media"`
+	MediaPublicURL string `env:"MEDIA_PUBLIC_URL" default:"http://localhost:8080/api/products/media"`
+	MediaURLSecret string `env:"MEDIA_URL_SECRET" secret:"true"`
+
+	// The s3 store reaches the bucket at S3Endpoint and signs URLs for
+	// S3PublicEndpoint, the address browsers reach it at when it differs
+	S3Endpoint       string `env:"MEDIA_S3_ENDPOINT" default:"http://localhost:9000"`
+	S3PublicEndpoint string `env:"MEDIA_S3_PUBLIC_ENDPOINT"`
+	S3Region         string `env:"MEDIA_S3_REGION" default:"us-east-1"`
+	S3Bucket         string `env:"MEDIA_S3_BUCKET" default:"product-media"`
+	S3AccessKey      string `env:"MEDIA_S3_ACCESS_KEY"`
+	S3SecretKey      string `env:"MEDIA_S3_SECRET_KEY" secret:"true"`
+	// S3CreateBucket creates the bucket at startup, for local stand-ins
+	S3CreateBucket bool `env:"MEDIA_S3_CREATE_BUCKET" default:"false"`
+
+	Service auth.ServiceConfig
+	Log     logging.Config
+	Tracing tracing.Config
+	Server  server.Config
+}
+
+func (c Config) Validate() error {
+	if c.MediaStore != "filesystem" && c.MediaStore != "s3" {
+		return fmt.Errorf("MEDIA_STORE must be filesystem or s3, got %q", c.MediaStore)
+	}
+	if c.MediaURLTTL < time.Second || (c.MediaStore == "s3" && c.MediaURLTTL > 7*24*time.Hour) {
+		return fmt.Errorf("MEDIA_URL_TTL must be at least 1s, and at most 168h with s3")
+	}
+	if c.MaxUploadSize < 1 || c.MaxImagePixels < 1 {
+		return fmt.Errorf("MEDIA_MAX_UPLOAD_SIZE and MEDIA_MAX_PIXELS must be at least 1")
+	}
+	for _, size := range c.ThumbnailSizes {
+		if n, err := strconv.Atoi(size); err != nil || n < 1 {
+			return fmt.Errorf("MEDIA_THUMBNAIL_SIZES: %q is not a size in pixels", size)
+		}
+	}
+	if c.MediaStore == "s3" && (c.S3AccessKey == "" || c.S3SecretKey == "") {
+		return fmt.Errorf("MEDIA_S3_ACCESS_KEY and MEDIA_S3_SECRET_KEY are required with s3")
+	}
+	return nil
+}
+
+// Thumbnails returns ThumbnailSizes as numbers
+func (c Config) Thumbnails() []int {
+	sizes := make([]int, len(c.ThumbnailSizes))
+	for i, size := range c.ThumbnailSizes {
+		sizes[i], _ = strconv.Atoi(size)
+	}
+	return sizes
+}
+
+// Load reads the configuration. It exits listing every invalid setting, or
+// after printing the configuration when started with --print-config.
+func Load() Config {
+	var cfg Config
+	sharedconfig.MustLoad(&cfg)
+	return cfg
+}
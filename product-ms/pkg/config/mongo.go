@@ -2,28 +2,26 @@ package config
 
 import (
 	"context"
-	"log"
-	"os"
-	"time"
+
+	"shared/health"
+	"shared/logging"
+	"shared/metrics"
+	"shared/tracing"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func ConnectMongo() *mongo.Client {
-	uri := os.Getenv("MONGODB_URI")
-	if uri == "" {
-		uri = "mongodb://localhost:27017"
-	}
-	client, err := mongo.NewClient(options.Client().ApplyURI(uri))
+// ConnectMongo connects to MongoDB and blocks until the server answers a
+// ping, retrying with backoff. It only gives up once ctx is cancelled.
+func ConnectMongo(ctx context.Context, uri string) *mongo.Client {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetMonitor(tracing.MongoMonitor(metrics.MongoMonitor())))
 	if err != nil {
-		log.Fatalf("Failed to create MongoDB client: %v", err)
+		logging.Fatal("Invalid MongoDB configuration", "error", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := client.Connect(ctx); err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	if err := health.WaitFor(ctx, "mongo", health.Mongo(client)); err != nil {
+		logging.Fatal("Failed to connect to MongoDB", "error", err)
 	}
 
 	return client
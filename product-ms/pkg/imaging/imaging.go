@@ -0,0 +1,101 @@
+// Package imaging scales pictures down into thumbnails, with the standard
+// library's decoders and encoders only
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	// registers GIF with image.Decode
+	_ "image/gif"
+)
+
+// JPEGQuality is the quality JPEG thumbnails are encoded with
+const JPEGQuality = 85
+
+// Decode decodes a JPEG, PNG or GIF; of a GIF, the first frame
+func Decode(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// DecodeConfig returns the dimensions of a picture without decoding it, so
+// that oversized ones can be refused before they take up memory
+func DecodeConfig(data []byte) (width, height int, err error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	return cfg.Width, cfg.Height, err
+}
+
+// Fit returns the size of a width×height picture scaled down to fit in a
+// size×size square, keeping its aspect ratio. Smaller pictures keep theirs.
+func Fit(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+	if width >= height {
+		return size, max(1, (height*size+width/2)/width)
+	}
+	return max(1, (width*size+height/2)/height), size
+}
+
+// Thumbnail scales src down to fit in a size×size square. Each pixel of the
+// thumbnail is the average of the source pixels it covers, which keeps fine
+// detail from turning into noise the way sampling single pixels does.
+func Thumbnail(src image.Image, size int) *image.RGBA {
+	rgba := toRGBA(src)
+	sw, sh := rgba.Rect.Dx(), rgba.Rect.Dy()
+	dw, dh := Fit(sw, sh, size)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for dy := range dh {
+		y0, y1 := dy*sh/dh, (dy+1)*sh/dh
+		for dx := range dw {
+			x0, x1 := dx*sw/dw, (dx+1)*sw/dw
+			// the channels are premultiplied by alpha, so they average as is
+			var sum [4]uint64
+			for y := y0; y < y1; y++ {
+				off := rgba.PixOffset(x0, y)
+				for x := x0; x < x1; x++ {
+					for c := range sum {
+						sum[c] += uint64(rgba.Pix[off+c])
+					}
+					off += 4
+				}
+			}
+			n := uint64((y1 - y0) * (x1 - x0))
+			off := dst.PixOffset(dx, dy)
+			for c := range sum {
+				dst.Pix[off+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
+
+// EncodeJPEG encodes img as a JPEG, which has no transparency
+func EncodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: JPEGQuality})
+	return buf.Bytes(), err
+}
+
+// EncodePNG encodes img as a PNG, keeping its transparency
+func EncodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), err
+}
+
+// toRGBA returns src as an RGBA image with its origin at 0,0
+func toRGBA(src image.Image) *image.RGBA {
+	if rgba, ok := src.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	b := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Rect, src, b.Min, draw.Src)
+	return rgba
+}
@@ -0,0 +1,41 @@
+// Package apperr defines the kinds of error the domain packages of every
+// service report, so that transports can map them onto HTTP statuses and
+// gRPC codes without knowing each service's errors.
+package apperr
+
+import "errors"
+
+// Error kinds. Test for them with errors.Is.
+var (
+	NotFound     = errors.New("not found")
+	Conflict     = errors.New("conflict")
+	Validation   = errors.New("validation failed")
+	Forbidden    = errors.New("forbidden")
+	Unauthorized = errors.New("unauthorized")
+	// PreconditionFailed means an optimistic concurrency check failed: the
+	// resource changed since the caller read it
+	PreconditionFailed = errors.New("precondition failed")
+	// RateLimited means the caller must wait before trying again
+	RateLimited = errors.New("too many requests")
+	// TooLarge means the request's content exceeds a size limit
+	TooLarge = errors.New("content too large")
+	// UnsupportedMediaType means the request's content is of a type the
+	// operation does not accept
+	UnsupportedMediaType = errors.New("unsupported media type")
+)
+
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string { return e.msg }
+func (e *kindError) Unwrap() error { return e.kind }
+
+// New returns an error of the given kind with message msg, for use as a
+// domain sentinel:
+//
+//	var ErrNotFound = apperr.New(apperr.NotFound, "user not found")
+func New(kind error, msg string) error {
+	return &kindError{kind: kind, msg: msg}
+}
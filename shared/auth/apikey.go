@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"shared/problem"
+)
+
+// APIKeyPrefix starts every API key, which tells keys apart from JWTs
+const APIKeyPrefix = "sk_"
+
+// HeaderAPIKey carries an API key. Keys are accepted as bearer tokens too.
+const HeaderAPIKey = "X-API-Key"
+
+// Permissions an API key can have on a service
+const (
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+)
+
+// Services lists the services API keys can be scoped to
+var Services = []string{"users", "products", "orders", "payments"}
+
+// Scope names a permission on a service, e.g. orders:read
+func Scope(service, permission string) string {
+	return service + ":" + permission
+}
+
+// ValidScope reports whether scope names one of Services and a permission
+func ValidScope(scope string) bool {
+	service, permission, ok := strings.Cut(scope, ":")
+	return ok && slices.Contains(Services, service) &&
+		(permission == PermissionRead || permission == PermissionWrite)
+}
+
+// Allows reports whether the caller has permission on service. Users always
+// do, since their role decides what they may do; API keys need the scope.
+func (id *Identity) Allows(service, permission string) bool {
+	return id.KeyID == "" || slices.Contains(id.Scopes, Scope(service, permission))
+}
+
+// RequireScope rejects API keys that lack, for any of services, the
+// permission the request method needs: read for GET, HEAD and OPTIONS,
+// write for the rest. Anonymous callers and users pass.
+func RequireScope(services ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			permission := PermissionWrite
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				permission = PermissionRead
+			}
+
+			if id, ok := FromContext(r.Context()); ok {
+				for _, service := range services {
+					if !id.Allows(service, permission) {
+						problem.HTTP(w, r, http.StatusForbidden, "The API key lacks the "+Scope(service, permission)+" scope", nil)
+						return
+					}
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WithAPIKeys returns a Verifier that passes API keys to keys and every
+// other credential to tokens
+func WithAPIKeys(tokens, keys Verifier) Verifier {
+	return &apiKeyVerifier{tokens: tokens, keys: keys}
+}
+
+type apiKeyVerifier struct {
+	tokens, keys Verifier
+}
+
+func (v *apiKeyVerifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	if strings.HasPrefix(token, APIKeyPrefix) {
+		return v.keys.Verify(ctx, token)
+	}
+	return v.tokens.Verify(ctx, token)
+}
+
+// maxCachedKeys caps the answers a KeyCache holds
+const maxCachedKeys = 1024
+
+// KeyCache verifies API keys with the service that holds them, remembering
+// each answer for a while so that most requests need no call. A revoked key
+// keeps working until its cached answer expires.
+type KeyCache struct {
+	verify func(context.Context, string) (*Identity, error)
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]cachedKey
+}
+
+type cachedKey struct {
+	id        *Identity
+	expiresAt time.Time
+}
+
+// NewKeyCache caches the results of verify for ttl. verify must fail with
+// ErrInvalidToken for keys that are unknown, expired or revoked; other
+// errors, e.g. when the service is unreachable, are not cached.
+func NewKeyCache(verify func(ctx context.Context, key string) (*Identity, error), ttl time.Duration) *KeyCache {
+	return &KeyCache{verify: verify, ttl: ttl, entries: map[[sha256.Size]byte]cachedKey{}}
+}
+
+// Verify returns the identity of key, asking the service only when no
+// answer is cached
+func (c *KeyCache) Verify(ctx context.Context, key string) (*Identity, error) {
+	// keys are cached by hash, so a heap dump does not reveal them
+	sum := sha256.Sum256([]byte(key))
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[sum]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		if entry.id == nil {
+			return nil, ErrInvalidToken
+		}
+		return entry.id, nil
+	}
+
+	id, err := c.verify(ctx, key)
+	if err != nil && !errors.Is(err, ErrInvalidToken) {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if len(c.entries) >= maxCachedKeys {
+		for k, e := range c.entries {
+			if now.After(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	// when full of live answers, e.g. during a flood of made-up keys, the
+	// new answer is not kept
+	if len(c.entries) < maxCachedKeys {
+		c.entries[sum] = cachedKey{id: id, expiresAt: now.Add(c.ttl)}
+	}
+	c.mu.Unlock()
+
+	if id == nil {
+		return nil, ErrInvalidToken
+	}
+	return id, nil
+}
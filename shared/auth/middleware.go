@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"shared/logging"
+	"shared/problem"
+)
+
+// Identity headers set by the gateway once it has verified the caller.
+// Services behind the gateway read them with FromHeaders instead of
+// verifying the token again; the gateway signs them, see ServiceKey.
+const (
+	HeaderUserID    = "X-User-ID"
+	HeaderUserEmail = "X-User-Email"
+	HeaderUserRole  = "X-User-Role"
+	HeaderSessionID = "X-Session-ID"
+	HeaderKeyID     = "X-API-Key-ID"
+	HeaderScopes    = "X-API-Key-Scopes"
+)
+
+// Verifier turns a bearer token or API key into an identity
+type Verifier interface {
+	Verify(ctx context.Context, token string) (*Identity, error)
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying id. The user is also recorded
+// for the logs of the request ctx belongs to.
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	logging.SetUser(ctx, id.UserID)
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the identity stored by one of the middlewares
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(ctxKey{}).(*Identity)
+	return id, ok
+}
+
+// BearerToken extracts the token from an "Authorization: Bearer" header
+func BearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+// Credential returns the bearer token of r or, failing that, its API key
+func Credential(r *http.Request) string {
+	if token := BearerToken(r); token != "" {
+		return token
+	}
+	return strings.TrimSpace(r.Header.Get(HeaderAPIKey))
+}
+
+// Middleware rejects requests without a valid bearer token or API key and
+// stores the caller's identity in the request context. API keys are only
+// accepted when v handles them, see WithAPIKeys.
+func Middleware(v Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := Credential(r)
+			if token == "" {
+				unauthorized(w, r, "Missing bearer token", nil)
+				return
+			}
+
+			id, err := v.Verify(r.Context(), token)
+			if err != nil {
+				unauthorized(w, r, "Invalid bearer token", err)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+		})
+	}
+}
+
+// HasRole reports whether the caller stored in ctx has one of roles
+func HasRole(ctx context.Context, roles ...string) bool {
+	id, ok := FromContext(ctx)
+	return ok && slices.Contains(roles, id.Role)
+}
+
+// AdminFlag reads the boolean query parameter name, which only admins may
+// set, e.g. ?include_deleted=true. When anyone else sets it, it writes a 403
+// and ok is false.
+func AdminFlag(w http.ResponseWriter, r *http.Request, name string) (value, ok bool) {
+	value, _ = strconv.ParseBool(r.URL.Query().Get(name))
+	if value && !HasRole(r.Context(), RoleAdmin) {
+		problem.HTTP(w, r, http.StatusForbidden, "Only admins may set "+name, nil)
+		return false, false
+	}
+	return value, true
+}
+
+// RequireRole rejects callers whose role is not one of roles.
+// It must run after Middleware or FromHeaders.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, ok := FromContext(r.Context())
+			if !ok {
+				unauthorized(w, r, "Authentication required", nil)
+				return
+			}
+			if !slices.Contains(roles, id.Role) {
+				problem.HTTP(w, r, http.StatusForbidden, "Insufficient role", nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SetHeaders writes id into the trusted identity headers
+func SetHeaders(h http.Header, id *Identity) {
+	h.Set(HeaderUserID, id.UserID)
+	h.Set(HeaderUserEmail, id.Email)
+	h.Set(HeaderUserRole, id.Role)
+	if id.SessionID != "" {
+		h.Set(HeaderSessionID, id.SessionID)
+	}
+	if id.KeyID != "" {
+		h.Set(HeaderKeyID, id.KeyID)
+		h.Set(HeaderScopes, strings.Join(id.Scopes, ","))
+	}
+}
+
+// DeleteHeaders removes the identity headers, e.g. so clients cannot spoof them
+func DeleteHeaders(h http.Header) {
+	h.Del(HeaderUserID)
+	h.Del(HeaderUserEmail)
+	h.Del(HeaderUserRole)
+	h.Del(HeaderSessionID)
+	h.Del(HeaderKeyID)
+	h.Del(HeaderScopes)
+	h.Del(HeaderServiceSignature)
+}
+
+// FromHeaders checks the service signature of requests and stores the
+// identity forwarded by the gateway in the request context when present.
+// Unsigned requests pass as anonymous, unless they carry identity headers:
+// those, and requests with a bad signature, are rejected.
+func FromHeaders(key *ServiceKey) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(HeaderServiceSignature) == "" {
+				if hasIdentityHeaders(r.Header) {
+					unauthorized(w, r, "Unsigned identity headers", nil)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if err := key.Verify(r); err != nil {
+				unauthorized(w, r, "Invalid service signature", err)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), serviceCtxKey{}, true)
+			if userID := r.Header.Get(HeaderUserID); userID != "" {
+				id := &Identity{
+					UserID:    userID,
+					Email:     r.Header.Get(HeaderUserEmail),
+					Role:      r.Header.Get(HeaderUserRole),
+					SessionID: r.Header.Get(HeaderSessionID),
+					KeyID:     r.Header.Get(HeaderKeyID),
+				}
+				if scopes := r.Header.Get(HeaderScopes); scopes != "" {
+					id.Scopes = strings.Split(scopes, ",")
+				}
+				ctx = NewContext(ctx, id)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func hasIdentityHeaders(h http.Header) bool {
+	for _, name := range []string{HeaderUserID, HeaderUserEmail, HeaderUserRole, HeaderSessionID, HeaderKeyID, HeaderScopes} {
+		if h.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="ecommerce"`)
+	problem.HTTP(w, r, http.StatusUnauthorized, msg, err)
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrTokenRevoked is returned for tokens of a session that was revoked
+var ErrTokenRevoked = errors.New("token revoked")
+
+// RevokedSession is a session whose access tokens must be refused. After
+// ExpiresAt the last of them has expired anyway and it can be forgotten.
+type RevokedSession struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RevocationList is an in-memory copy of the revoked sessions, so that
+// checking a token costs a map lookup rather than a call to user-ms. Run
+// keeps it current; revocations take effect within one poll interval.
+type RevocationList struct {
+	fetch func(context.Context) ([]RevokedSession, error)
+
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+}
+
+// NewRevocationList returns an empty list that fetch fills in
+func NewRevocationList(fetch func(context.Context) ([]RevokedSession, error)) *RevocationList {
+	return &RevocationList{fetch: fetch, revoked: map[string]time.Time{}}
+}
+
+// Revoked reports whether the session is revoked
+func (l *RevocationList) Revoked(sessionID string) bool {
+	l.mu.RLock()
+	expiresAt, ok := l.revoked[sessionID]
+	l.mu.RUnlock()
+	return ok && time.Now().Before(expiresAt)
+}
+
+// Run refreshes the list now and then every interval until ctx is done.
+// When a refresh fails the previous list is kept.
+func (l *RevocationList) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		l.refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *RevocationList) refresh(ctx context.Context) {
+	sessions, err := l.fetch(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "revocation list refresh failed", "error", err)
+		return
+	}
+
+	revoked := make(map[string]time.Time, len(sessions))
+	for _, s := range sessions {
+		revoked[s.ID] = s.ExpiresAt
+	}
+	l.mu.Lock()
+	l.revoked = revoked
+	l.mu.Unlock()
+}
+
+// WithRevocation wraps v to also refuse tokens of sessions in l
+func WithRevocation(v Verifier, l *RevocationList) Verifier {
+	return &revocationVerifier{verifier: v, list: l}
+}
+
+type revocationVerifier struct {
+	verifier Verifier
+	list     *RevocationList
+}
+
+func (v *revocationVerifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	id, err := v.verifier.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if id.SessionID != "" && v.list.Revoked(id.SessionID) {
+		return nil, ErrTokenRevoked
+	}
+	return id, nil
+}
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned when the upstream's circuit breaker rejects a call
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState is the state of a circuit breaker
+type BreakerState int
+
+const (
+	StateClosed BreakerState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerSettings configures when a breaker trips and how it recovers
+type BreakerSettings struct {
+	// FailureThreshold is the number of consecutive failures that opens the breaker
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before letting a probe through
+	OpenTimeout time.Duration
+	// HalfOpenMaxCalls is the number of probe calls allowed while half-open
+	HalfOpenMaxCalls int
+}
+
+// DefaultBreakerSettings are used when a client is built without WithCircuitBreaker
+var DefaultBreakerSettings = BreakerSettings{
+	FailureThreshold: 5,
+	OpenTimeout:      30 * time.Second,
+	HalfOpenMaxCalls: 1,
+}
+
+// breaker is a consecutive-failure circuit breaker for a single upstream
+type breaker struct {
+	name     string
+	settings BreakerSettings
+	onChange func(name string, from, to BreakerState)
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	inFlight int
+	openedAt time.Time
+	now      func() time.Time
+}
+
+func newBreaker(name string, s BreakerSettings, onChange func(string, BreakerState, BreakerState)) *breaker {
+	if s.FailureThreshold <= 0 {
+		s.FailureThreshold = DefaultBreakerSettings.FailureThreshold
+	}
+	if s.OpenTimeout <= 0 {
+		s.OpenTimeout = DefaultBreakerSettings.OpenTimeout
+	}
+	if s.HalfOpenMaxCalls <= 0 {
+		s.HalfOpenMaxCalls = DefaultBreakerSettings.HalfOpenMaxCalls
+	}
+	return &breaker{name: name, settings: s, onChange: onChange, now: time.Now}
+}
+
+// State returns the current state, moving from open to half-open when the timeout has passed
+func (b *breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh()
+	return b.state
+}
+
+// allow reports whether a call may proceed and reserves a probe slot when half-open
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh()
+
+	switch b.state {
+	case StateOpen:
+		return ErrCircuitOpen
+	case StateHalfOpen:
+		if b.inFlight >= b.settings.HalfOpenMaxCalls {
+			return ErrCircuitOpen
+		}
+		b.inFlight++
+	}
+	return nil
+}
+
+// record reports the outcome of a call admitted by allow
+func (b *breaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateHalfOpen && b.inFlight > 0 {
+		b.inFlight--
+	}
+
+	if success {
+		b.failures = 0
+		if b.state != StateClosed {
+			b.setState(StateClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.settings.FailureThreshold {
+		b.openedAt = b.now()
+		b.setState(StateOpen)
+	}
+}
+
+// abandon releases a call admitted by allow without counting it either way,
+// e.g. when the caller gave up before the upstream answered
+func (b *breaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateHalfOpen && b.inFlight > 0 {
+		b.inFlight--
+	}
+}
+
+func (b *breaker) refresh() {
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.settings.OpenTimeout {
+		b.setState(StateHalfOpen)
+	}
+}
+
+func (b *breaker) setState(to BreakerState) {
+	from := b.state
+	if from == to {
+		return
+	}
+	b.state = to
+	b.inFlight = 0
+	if to == StateClosed {
+		b.failures = 0
+	}
+	if b.onChange != nil {
+		b.onChange(b.name, from, to)
+	}
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testBreaker returns a breaker that opens after 3 failures and lets one
+// probe through a minute later, with a clock that advance moves forward
+func testBreaker(onChange func(string, BreakerState, BreakerState)) (b *breaker, advance func(time.Duration)) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b = newBreaker("test", BreakerSettings{FailureThreshold: 3, OpenTimeout: time.Minute, HalfOpenMaxCalls: 1}, onChange)
+	b.now = func() time.Time { return now }
+	return b, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestBreakerStates(t *testing.T) {
+	// Steps:
+	//   ok, fail  a call is admitted and succeeds or fails
+	//   reject    a call is turned away with ErrCircuitOpen
+	//   hold      a call is admitted and still in flight
+	//   abandon   the held call is given up on
+	//   wait      the open timeout passes
+	tests := []struct {
+		name  string
+		steps []string
+		want  []BreakerState
+	}{
+		{
+			name:  "failures below the threshold keep it closed",
+			steps: []string{"fail", "fail"},
+			want:  []BreakerState{StateClosed, StateClosed},
+		},
+		{
+			name:  "a success resets the failure count",
+			steps: []string{"fail", "fail", "ok", "fail", "fail"},
+			want:  []BreakerState{StateClosed, StateClosed, StateClosed, StateClosed, StateClosed},
+		},
+		{
+			name:  "consecutive failures open it",
+			steps: []string{"fail", "fail", "fail", "reject"},
+			want:  []BreakerState{StateClosed, StateClosed, StateOpen, StateOpen},
+		},
+		{
+			name:  "it stays open until the timeout",
+			steps: []string{"fail", "fail", "fail", "reject", "wait"},
+			want:  []BreakerState{StateClosed, StateClosed, StateOpen, StateOpen, StateHalfOpen},
+		},
+		{
+			name:  "a successful probe closes it",
+			steps: []string{"fail", "fail", "fail", "wait", "ok", "fail"},
+			want:  []BreakerState{StateClosed, StateClosed, StateOpen, StateHalfOpen, StateClosed, StateClosed},
+		},
+		{
+			name:  "a failed probe reopens it",
+			steps: []string{"fail", "fail", "fail", "wait", "fail", "reject"},
+			want:  []BreakerState{StateClosed, StateClosed, StateOpen, StateHalfOpen, StateOpen, StateOpen},
+		},
+		{
+			name:  "half-open admits one probe at a time",
+			steps: []string{"fail", "fail", "fail", "wait", "hold", "reject", "abandon", "ok"},
+			want:  []BreakerState{StateClosed, StateClosed, StateOpen, StateHalfOpen, StateHalfOpen, StateHalfOpen, StateHalfOpen, StateClosed},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, advance := testBreaker(nil)
+			for i, step := range tt.steps {
+				var err error
+				switch step {
+				case "ok", "fail", "hold":
+					if err = b.allow(); err == nil && step != "hold" {
+						b.record(step == "ok")
+					}
+				case "reject":
+					if err = b.allow(); errors.Is(err, ErrCircuitOpen) {
+						err = nil
+					} else {
+						err = fmt.Errorf("allow = %v, want ErrCircuitOpen", err)
+					}
+				case "abandon":
+					b.abandon()
+				case "wait":
+					advance(time.Minute)
+				}
+				if err != nil {
+					t.Fatalf("step %d (%s): %v", i, step, err)
+				}
+				if got := b.State(); got != tt.want[i] {
+					t.Fatalf("step %d (%s): state %v, want %v", i, step, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerOnChange(t *testing.T) {
+	var changes []string
+	b, advance := testBreaker(func(name string, from, to BreakerState) {
+		changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, from, to))
+	})
+
+	for range 3 {
+		b.allow()
+		b.record(false)
+	}
+	advance(time.Minute)
+	b.allow()
+	b.record(true)
+
+	want := []string{"test: closed -> open", "test: open -> half-open", "test: half-open -> closed"}
+	if !slices.Equal(changes, want) {
+		t.Errorf("changes = %q, want %q", changes, want)
+	}
+}
+
+func TestNewBreakerDefaults(t *testing.T) {
+	b := newBreaker("test", BreakerSettings{}, nil)
+	if b.settings != DefaultBreakerSettings {
+		t.Errorf("settings = %+v, want %+v", b.settings, DefaultBreakerSettings)
+	}
+}
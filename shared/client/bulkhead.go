@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrBulkheadFull is returned when the upstream's concurrency limit is reached
+// and no slot frees up before the call's context is done
+var ErrBulkheadFull = errors.New("bulkhead concurrency limit reached")
+
+// bulkhead caps the number of concurrent in-flight calls to one upstream
+type bulkhead struct {
+	slots chan struct{}
+}
+
+func newBulkhead(limit int) *bulkhead {
+	if limit <= 0 {
+		return nil
+	}
+	return &bulkhead{slots: make(chan struct{}, limit)}
+}
+
+// acquire waits for a free slot; a nil bulkhead never blocks
+func (b *bulkhead) acquire(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ErrBulkheadFull
+	}
+}
+
+func (b *bulkhead) release() {
+	if b == nil {
+		return
+	}
+	<-b.slots
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBulkhead(t *testing.T) {
+	short := func() context.Context {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		t.Cleanup(cancel)
+		return ctx
+	}
+
+	b := newBulkhead(2)
+	for i := range 2 {
+		if err := b.acquire(short()); err != nil {
+			t.Fatalf("acquire %d: %v", i, err)
+		}
+	}
+	if err := b.acquire(short()); !errors.Is(err, ErrBulkheadFull) {
+		t.Fatalf("acquire beyond the limit = %v, want ErrBulkheadFull", err)
+	}
+	b.release()
+	if err := b.acquire(short()); err != nil {
+		t.Fatalf("acquire after a release: %v", err)
+	}
+}
+
+func TestBulkheadUnlimited(t *testing.T) {
+	for _, limit := range []int{0, -1} {
+		b := newBulkhead(limit)
+		if b != nil {
+			t.Errorf("newBulkhead(%d) = %v, want nil", limit, b)
+		}
+		for range 100 {
+			if err := b.acquire(context.Background()); err != nil {
+				t.Fatalf("acquire on newBulkhead(%d): %v", limit, err)
+			}
+		}
+		b.release()
+	}
+}
+
+// TestDoBulkhead checks that a call holds its slot until its body is closed
+func TestDoBulkhead(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	c := New("test", srv.URL, WithBulkhead(1))
+	do := func(timeout time.Duration) (*http.Response, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		t.Cleanup(cancel)
+		req, err := c.NewRequest(ctx, http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.Do(req)
+	}
+
+	first, err := do(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := do(20 * time.Millisecond); !errors.Is(err, ErrBulkheadFull) {
+		t.Fatalf("second call while the first is open = %v, want ErrBulkheadFull", err)
+	}
+	first.Body.Close()
+
+	second, err := do(time.Second)
+	if err != nil {
+		t.Fatalf("call after the first body was closed: %v", err)
+	}
+	second.Body.Close()
+}
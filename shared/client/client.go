@@ -0,0 +1,292 @@
+// Package client provides an HTTP client for calling other services with
+// per-call deadlines, jittered retries for idempotent calls, a circuit
+// breaker and a concurrency limit per upstream, and hooks for observability.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatusError is returned by the JSON helpers when the upstream answers with a non-2xx status
+type StatusError struct {
+	Upstream   string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s responded with %d: %s", e.Upstream, e.StatusCode, e.Body)
+}
+
+// Client calls a single upstream service
+type Client struct {
+	name     string
+	baseURL  string
+	http     *http.Client
+	timeout  time.Duration
+	retry    RetryPolicy
+	headers  http.Header
+	hooks    hookChain
+	breaker  *breaker
+	bulkhead *bulkhead
+
+	breakerSettings BreakerSettings
+	maxConcurrent   int
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithTimeout sets the deadline applied to each call, retries included.
+// A shorter deadline already on the caller's context wins.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithRetry sets the retry policy for idempotent calls
+func WithRetry(p RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+// WithCircuitBreaker sets the circuit breaker settings for the upstream
+func WithCircuitBreaker(s BreakerSettings) Option {
+	return func(c *Client) { c.breakerSettings = s }
+}
+
+// WithBulkhead limits the number of concurrent calls to the upstream; 0 means unlimited
+func WithBulkhead(maxConcurrent int) Option {
+	return func(c *Client) { c.maxConcurrent = maxConcurrent }
+}
+
+// WithHooks registers observability hooks; it may be given more than once
+func WithHooks(h Hooks) Option {
+	return func(c *Client) { c.hooks = append(c.hooks, h) }
+}
+
+// WithHeader adds a header sent on every request
+func WithHeader(key, value string) Option {
+	return func(c *Client) { c.headers.Add(key, value) }
+}
+
+// WithTransport replaces the underlying round tripper
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) { c.http.Transport = rt }
+}
+
+// New builds a client for the upstream called name, rooted at baseURL
+func New(name, baseURL string, opts ...Option) *Client {
+	c := &Client{
+		name:            name,
+		baseURL:         strings.TrimRight(baseURL, "/"),
+		http:            &http.Client{Transport: http.DefaultTransport},
+		timeout:         5 * time.Second,
+		retry:           DefaultRetryPolicy,
+		headers:         make(http.Header),
+		breakerSettings: DefaultBreakerSettings,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.retry.MaxAttempts < 1 {
+		c.retry.MaxAttempts = 1
+	}
+	c.breaker = newBreaker(name, c.breakerSettings, c.hooks.breakerStateChange)
+	c.bulkhead = newBulkhead(c.maxConcurrent)
+	return c
+}
+
+// Name returns the upstream name
+func (c *Client) Name() string {
+	return c.name
+}
+
+// BreakerState returns the current circuit breaker state for the upstream
+func (c *Client) BreakerState() BreakerState {
+	return c.breaker.State()
+}
+
+// NewRequest builds a request for path relative to the base URL, encoding body as JSON when non-nil
+func (c *Client) NewRequest(ctx context.Context, method, path string, body any) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// Do sends req, retrying idempotent calls on transport errors, 429 and 502-504.
+// The caller must close the response body.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	cancel := context.CancelFunc(func() {})
+	if c.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+	}
+
+	if err := c.bulkhead.acquire(ctx); err != nil {
+		cancel()
+		return nil, err
+	}
+	done := func() {
+		c.bulkhead.release()
+		cancel()
+	}
+
+	attempts := 1
+	if isIdempotent(req) {
+		attempts = c.retry.MaxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := c.attempt(ctx, req, attempt)
+		if attempt >= attempts || !retryable(resp, err) {
+			if err != nil {
+				done()
+				return nil, err
+			}
+			resp.Body = &releasingBody{ReadCloser: resp.Body, release: done}
+			return resp, nil
+		}
+
+		wait := max(c.retry.backoff(attempt), retryAfter(resp))
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			// no budget for another attempt: hand back what we have
+			if err != nil {
+				done()
+				return nil, err
+			}
+			resp.Body = &releasingBody{ReadCloser: resp.Body, release: done}
+			return resp, nil
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err := sleep(ctx, wait); err != nil {
+			done()
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, req *http.Request, attempt int) (*http.Response, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	out := req.Clone(ctx)
+	for k, vs := range c.headers {
+		if out.Header.Get(k) == "" {
+			out.Header[k] = vs
+		}
+	}
+	if req.Body != nil && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			c.breaker.abandon()
+			return nil, err
+		}
+		out.Body = body
+	}
+
+	attemptCtx := c.hooks.beforeAttempt(ctx, out)
+	out = out.WithContext(attemptCtx)
+
+	start := time.Now()
+	resp, err := c.http.Do(out)
+	info := CallInfo{
+		Upstream: c.name,
+		Method:   req.Method,
+		Path:     req.URL.Path,
+		Attempt:  attempt,
+		Err:      err,
+		Duration: time.Since(start),
+	}
+	if resp != nil {
+		info.StatusCode = resp.StatusCode
+	}
+	c.hooks.afterAttempt(attemptCtx, info)
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// the caller gave up; that says nothing about the upstream
+		c.breaker.abandon()
+	case err != nil:
+		c.breaker.record(false)
+	default:
+		c.breaker.record(resp.StatusCode < http.StatusInternalServerError)
+	}
+	return resp, err
+}
+
+// GetJSON sends a GET to path and decodes the JSON response into out
+func (c *Client) GetJSON(ctx context.Context, path string, out any) error {
+	return c.DoJSON(ctx, http.MethodGet, path, nil, out)
+}
+
+// PostJSON sends in as JSON to path and decodes the JSON response into out
+func (c *Client) PostJSON(ctx context.Context, path string, in, out any) error {
+	return c.DoJSON(ctx, http.MethodPost, path, in, out)
+}
+
+// DoJSON sends in as JSON and decodes the response into out, which may be nil.
+// Non-2xx responses are returned as *StatusError.
+func (c *Client) DoJSON(ctx context.Context, method, path string, in, out any) error {
+	req, err := c.NewRequest(ctx, method, path, in)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &StatusError{Upstream: c.name, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// releasingBody frees the call's bulkhead slot and deadline once the body is closed
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// CallInfo describes a single attempt against an upstream
+type CallInfo struct {
+	Upstream   string
+	Method     string
+	Path       string
+	Attempt    int
+	StatusCode int
+	Err        error
+	Duration   time.Duration
+}
+
+// Hooks lets callers observe outgoing calls for metrics and tracing.
+// Every field is optional. Hooks run synchronously on the calling goroutine
+// (BreakerStateChange runs with the breaker locked) and must not call back
+// into the same Client.
+type Hooks struct {
+	// BeforeAttempt runs before each attempt and may return a derived context,
+	// e.g. one carrying a span; the request is sent with that context
+	BeforeAttempt func(ctx context.Context, req *http.Request) context.Context
+	// AfterAttempt runs when an attempt has a response or an error
+	AfterAttempt func(ctx context.Context, info CallInfo)
+	// BreakerStateChange runs when the upstream's circuit breaker changes state
+	BreakerStateChange func(upstream string, from, to BreakerState)
+}
+
+type hookChain []Hooks
+
+func (hc hookChain) beforeAttempt(ctx context.Context, req *http.Request) context.Context {
+	for _, h := range hc {
+		if h.BeforeAttempt != nil {
+			ctx = h.BeforeAttempt(ctx, req)
+		}
+	}
+	return ctx
+}
+
+func (hc hookChain) afterAttempt(ctx context.Context, info CallInfo) {
+	for _, h := range hc {
+		if h.AfterAttempt != nil {
+			h.AfterAttempt(ctx, info)
+		}
+	}
+}
+
+func (hc hookChain) breakerStateChange(upstream string, from, to BreakerState) {
+	for _, h := range hc {
+		if h.BreakerStateChange != nil {
+			h.BreakerStateChange(upstream, from, to)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		status int
+		err    error
+		want   bool
+	}{
+		{status: http.StatusOK},
+		{status: http.StatusNotFound},
+		{status: http.StatusInternalServerError},
+		{status: http.StatusTooManyRequests, want: true},
+		{status: http.StatusBadGateway, want: true},
+		{status: http.StatusServiceUnavailable, want: true},
+		{status: http.StatusGatewayTimeout, want: true},
+		{err: errors.New("connection refused"), want: true},
+		{err: context.Canceled},
+		{err: context.DeadlineExceeded},
+		{err: fmt.Errorf("dial: %w", context.DeadlineExceeded)},
+		{err: ErrCircuitOpen},
+	}
+	for _, tt := range tests {
+		var resp *http.Response
+		if tt.err == nil {
+			resp = &http.Response{StatusCode: tt.status}
+		}
+		if got := retryable(resp, tt.err); got != tt.want {
+			t.Errorf("retryable(%d, %v) = %v, want %v", tt.status, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestIsIdempotent(t *testing.T) {
+	tests := []struct {
+		method string
+		key    string
+		marked bool
+		want   bool
+	}{
+		{method: http.MethodGet, want: true},
+		{method: http.MethodHead, want: true},
+		{method: http.MethodOptions, want: true},
+		{method: http.MethodPut, want: true},
+		{method: http.MethodDelete, want: true},
+		{method: http.MethodPost},
+		{method: http.MethodPatch},
+		{method: http.MethodPost, key: "abc", want: true},
+		{method: http.MethodPost, marked: true, want: true},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.marked {
+			ctx = WithIdempotent(ctx)
+		}
+		req := httptest.NewRequestWithContext(ctx, tt.method, "/", nil)
+		if tt.key != "" {
+			req.Header.Set("Idempotency-Key", tt.key)
+		}
+		if got := isIdempotent(req); got != tt.want {
+			t.Errorf("isIdempotent(%s, key %q, marked %v) = %v, want %v", tt.method, tt.key, tt.marked, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{10, time.Second},
+		// the shift overflows; the cap still applies
+		{100, time.Second},
+	}
+	for _, tt := range tests {
+		for range 100 {
+			if d := p.backoff(tt.attempt); d < 0 || d > tt.max {
+				t.Fatalf("backoff(%d) = %v, want within [0, %v]", tt.attempt, d, tt.max)
+			}
+		}
+	}
+
+	if d := (RetryPolicy{}).backoff(1); d != 0 {
+		t.Errorf("backoff without delays = %v, want 0", d)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"0", 0},
+		{"3", 3 * time.Second},
+		{"-1", 0},
+		{"soon", 0},
+		// HTTP dates are not supported
+		{"Wed, 21 Oct 2015 07:28:00 GMT", 0},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tt.header != "" {
+			resp.Header.Set("Retry-After", tt.header)
+		}
+		if got := retryAfter(resp); got != tt.want {
+			t.Errorf("retryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+	if got := retryAfter(nil); got != 0 {
+		t.Errorf("retryAfter(nil) = %v, want 0", got)
+	}
+}
+
+func TestDoRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		idempotent bool
+		statuses   []int
+		wantCalls  int32
+		wantStatus int
+	}{
+		{"a GET is retried until it succeeds", http.MethodGet, false, []int{503, 502, 200}, 3, 200},
+		{"retries stop after MaxAttempts", http.MethodGet, false, []int{503, 503, 503, 200}, 3, 503},
+		{"a 500 is not retried", http.MethodGet, false, []int{500, 200}, 1, 500},
+		{"a 404 is not retried", http.MethodGet, false, []int{404, 200}, 1, 404},
+		{"a POST is not retried", http.MethodPost, false, []int{503, 200}, 1, 503},
+		{"a POST marked idempotent is retried", http.MethodPost, true, []int{429, 200}, 2, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := calls.Add(1)
+				w.WriteHeader(tt.statuses[n-1])
+			}))
+			defer srv.Close()
+
+			c := New("test", srv.URL,
+				WithRetry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+				WithCircuitBreaker(BreakerSettings{FailureThreshold: 10}))
+			ctx := context.Background()
+			if tt.idempotent {
+				ctx = WithIdempotent(ctx)
+			}
+			req, err := c.NewRequest(ctx, tt.method, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDoOpenBreaker(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	c := New("test", srv.URL, WithRetry(NoRetry), WithCircuitBreaker(BreakerSettings{FailureThreshold: 2, OpenTimeout: time.Hour}))
+	for range 2 {
+		var statusErr *StatusError
+		if err := c.GetJSON(context.Background(), "/", nil); !errors.As(err, &statusErr) {
+			t.Fatalf("GetJSON = %v, want a *StatusError", err)
+		}
+	}
+	if got := c.BreakerState(); got != StateOpen {
+		t.Fatalf("state = %v, want open", got)
+	}
+	if err := c.GetJSON(context.Background(), "/", nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("GetJSON = %v, want ErrCircuitOpen", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("calls = %d, want 2", got)
+	}
+}
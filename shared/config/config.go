@@ -0,0 +1,337 @@
+// Package config populates a service's typed configuration struct. Each
+// setting is a struct field tagged with its environment variable:
+//
+//	Port      string        `env:"PORT" default:"8081"`
+//	JWTSecret string        `env:"JWT_SECRET" required:"true" secret:"true"`
+//	JWTTTL    time.Duration `env:"JWT_TTL" default:"1h"`
+//
+// Values are taken, from highest to lowest precedence, from the environment,
+// the .env file in the working directory, an optional YAML file and the
+// default tag. The YAML file is flat and uses the variable names as keys,
+// in upper or lower case. It is set with --config or CONFIG_FILE.
+//
+// Supported field types are string, bool, ints, time.Duration, []string
+// (comma-separated) and encoding.TextUnmarshaler. Untagged struct fields are
+// walked recursively, so shared packages can contribute their own settings.
+// Any struct implementing Validate() error is checked after loading.
+package config
+
+import (
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Error lists every problem found while loading a configuration
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// MustLoad parses the command line flags --config and --print-config and
+// loads dst, a pointer to a struct. It exits with status 2 after listing the
+// problems when the configuration is invalid, and with status 0 after
+// printing the resolved settings, secrets redacted, for --print-config.
+func MustLoad(dst any) {
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	file := flags.String("config", os.Getenv("CONFIG_FILE"), "optional YAML configuration `file`")
+	printConfig := flags.Bool("print-config", false, "print the resolved configuration and exit")
+	_ = flags.Parse(os.Args[1:])
+
+	l, err := load(dst, *file)
+	if *printConfig && l != nil {
+		l.print(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *printConfig {
+		os.Exit(0)
+	}
+}
+
+// setting is one tagged field
+type setting struct {
+	key      string
+	def      string
+	required bool
+	secret   bool
+	value    reflect.Value
+	source   string
+}
+
+type loader struct {
+	settings []*setting
+	problems []string
+}
+
+func load(dst any, file string) (*loader, error) {
+	root := reflect.ValueOf(dst)
+	if root.Kind() != reflect.Pointer || root.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config: %T is not a pointer to a struct", dst)
+	}
+
+	l := &loader{}
+	l.collect(root.Elem())
+
+	dotenv := l.readDotenv(".env")
+	fromFile := l.readFile(file)
+
+	for _, s := range l.settings {
+		raw, source := s.def, "default"
+		if v, ok := fromFile[s.key]; ok {
+			raw, source = v, file
+		}
+		if v := os.Getenv(s.key); v != "" {
+			raw, source = v, "env"
+			if dotenv[s.key] {
+				source = ".env"
+			}
+		}
+		s.source = source
+
+		if raw == "" {
+			if s.required {
+				l.problems = append(l.problems, s.key+" is required")
+			}
+			continue
+		}
+		if err := set(s.value, raw); err != nil {
+			l.problems = append(l.problems, fmt.Sprintf("%s (from %s): %v", s.key, source, err))
+		}
+	}
+
+	l.validate(root)
+
+	if len(l.problems) > 0 {
+		return l, &Error{Problems: l.problems}
+	}
+	return l, nil
+}
+
+// collect registers the tagged fields of v, descending into untagged structs
+func (l *loader) collect(v reflect.Value) {
+	t := v.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		key, ok := f.Tag.Lookup("env")
+		if !ok {
+			if f.Type.Kind() == reflect.Struct {
+				l.collect(v.Field(i))
+			}
+			continue
+		}
+		l.settings = append(l.settings, &setting{
+			key:      key,
+			def:      f.Tag.Get("default"),
+			required: f.Tag.Get("required") == "true",
+			secret:   f.Tag.Get("secret") == "true",
+			value:    v.Field(i),
+		})
+	}
+}
+
+// readDotenv exports the variables of the .env file that are not already
+// set and returns the names it exported
+func (l *loader) readDotenv(path string) map[string]bool {
+	vars, err := godotenv.Read(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		l.problems = append(l.problems, fmt.Sprintf("%s: %v", path, err))
+		return nil
+	}
+
+	exported := make(map[string]bool, len(vars))
+	for k, v := range vars {
+		if _, ok := os.LookupEnv(k); !ok {
+			os.Setenv(k, v)
+			exported[k] = true
+		}
+	}
+	return exported
+}
+
+// readFile reads the flat YAML file into strings keyed by variable name
+func (l *loader) readFile(path string) map[string]string {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		l.problems = append(l.problems, err.Error())
+		return nil
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		l.problems = append(l.problems, fmt.Sprintf("%s: %v", path, err))
+		return nil
+	}
+
+	known := make(map[string]bool, len(l.settings))
+	for _, s := range l.settings {
+		known[s.key] = true
+	}
+
+	values := make(map[string]string, len(doc))
+	for k, v := range doc {
+		key := strings.ToUpper(k)
+		if !known[key] {
+			l.problems = append(l.problems, fmt.Sprintf("%s: unknown key %q", path, k))
+			continue
+		}
+		switch v := v.(type) {
+		case nil:
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		case map[string]any:
+			l.problems = append(l.problems, fmt.Sprintf("%s: %s must be a scalar or a list", path, k))
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return values
+}
+
+// validate calls Validate on v and every struct below it
+func (l *loader) validate(v reflect.Value) {
+	if val, ok := v.Interface().(interface{ Validate() error }); ok {
+		if err := val.Validate(); err != nil {
+			if joined, ok := err.(interface{ Unwrap() []error }); ok {
+				for _, e := range joined.Unwrap() {
+					l.problems = append(l.problems, e.Error())
+				}
+			} else {
+				l.problems = append(l.problems, err.Error())
+			}
+		}
+	}
+
+	e := v
+	if e.Kind() == reflect.Pointer {
+		e = e.Elem()
+	}
+	for i := range e.NumField() {
+		f := e.Field(i)
+		if e.Type().Field(i).IsExported() && f.Kind() == reflect.Struct {
+			l.validate(f.Addr())
+		}
+	}
+}
+
+var (
+	durationType        = reflect.TypeFor[time.Duration]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+func set(v reflect.Value, raw string) error {
+	if v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(raw))
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		var items []string
+		for item := range strings.SplitSeq(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// print writes one line per setting with its value and source
+func (l *loader) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, s := range l.settings {
+		value := format(s.value)
+		if s.secret && value != "" {
+			value = redact(value)
+		}
+		fmt.Fprintf(tw, "%s=%s\t# %s\n", s.key, value, s.source)
+	}
+	tw.Flush()
+}
+
+func format(v reflect.Value) string {
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		if err != nil {
+			return err.Error()
+		}
+		return string(text)
+	}
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if v.Kind() == reflect.Slice {
+		return strings.Join(v.Interface().([]string), ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// redact hides a secret. URLs keep everything but their password.
+func redact(value string) string {
+	if u, err := url.Parse(value); err == nil && u.Host != "" {
+		if _, ok := u.User.Password(); ok {
+			return u.Redacted()
+		}
+		if u.User == nil {
+			return value
+		}
+	}
+	return "[redacted]"
+}
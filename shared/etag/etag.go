@@ -0,0 +1,94 @@
+// Package etag implements conditional requests: ETag headers built from
+// aggregate versions, If-None-Match on reads and If-Match on writes.
+package etag
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"shared/problem"
+)
+
+// Any is the version IfMatch returns for "If-Match: *", which matches
+// whatever version the resource is at
+const Any int64 = -1
+
+// Version returns the strong ETag of an aggregate at version
+func Version(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// Hash returns a strong ETag derived from a representation, for responses
+// such as lists that have no version of their own
+func Hash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// JSON answers a read: it sets the ETag header to tag, or to the Hash of the
+// encoded v when tag is empty, and sends 304 Not Modified when the request's
+// If-None-Match lists it, v otherwise.
+func JSON(w http.ResponseWriter, r *http.Request, tag string, v any) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	if tag == "" {
+		tag = Hash(body.Bytes())
+	}
+
+	w.Header().Set("ETag", tag)
+	if noneMatch(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body.Bytes())
+}
+
+// noneMatch reports whether an If-None-Match header matches tag. The
+// comparison is weak, as RFC 9110 requires for If-None-Match.
+func noneMatch(header, tag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// IfMatch returns the version named by the request's If-Match header, or Any
+// for "*". Writes must be conditional: without the header it sends 428
+// Precondition Required, and with a header that names no version of ours
+// (a list, a weak or foreign tag) 412 Precondition Failed; ok is then false.
+func IfMatch(w http.ResponseWriter, r *http.Request) (version int64, ok bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		problem.HTTP(w, r, http.StatusPreconditionRequired,
+			"This request requires an If-Match header with the ETag of the resource, or *", nil)
+		return 0, false
+	}
+	if header == "*" {
+		return Any, true
+	}
+
+	unquoted, found := strings.CutPrefix(header, `"`)
+	unquoted, closed := strings.CutSuffix(unquoted, `"`)
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if !found || !closed || err != nil || version < 0 {
+		problem.HTTP(w, r, http.StatusPreconditionFailed,
+			"If-Match must be a single ETag returned by this API, or *", err)
+		return 0, false
+	}
+	return version, true
+}
@@ -0,0 +1,111 @@
+package etag
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		header      string
+		wantVersion int64
+		wantStatus  int
+	}{
+		{header: `"3"`, wantVersion: 3},
+		{header: ` "3" `, wantVersion: 3},
+		{header: `"0"`, wantVersion: 0},
+		{header: `*`, wantVersion: Any},
+		{header: ``, wantStatus: http.StatusPreconditionRequired},
+		{header: `   `, wantStatus: http.StatusPreconditionRequired},
+		{header: `3`, wantStatus: http.StatusPreconditionFailed},
+		{header: `"3`, wantStatus: http.StatusPreconditionFailed},
+		{header: `3"`, wantStatus: http.StatusPreconditionFailed},
+		{header: `""`, wantStatus: http.StatusPreconditionFailed},
+		{header: `W/"3"`, wantStatus: http.StatusPreconditionFailed},
+		{header: `"3", "4"`, wantStatus: http.StatusPreconditionFailed},
+		{header: `"-1"`, wantStatus: http.StatusPreconditionFailed},
+		{header: `"abc"`, wantStatus: http.StatusPreconditionFailed},
+		{header: `"9223372036854775808"`, wantStatus: http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPut, "/", nil)
+		if tt.header != "" {
+			r.Header.Set("If-Match", tt.header)
+		}
+		w := httptest.NewRecorder()
+
+		version, ok := IfMatch(w, r)
+		if wantOK := tt.wantStatus == 0; ok != wantOK {
+			t.Errorf("IfMatch(%s) ok = %v, want %v", tt.header, ok, wantOK)
+			continue
+		}
+		if ok && version != tt.wantVersion {
+			t.Errorf("IfMatch(%s) = %d, want %d", tt.header, version, tt.wantVersion)
+		}
+		if !ok && w.Code != tt.wantStatus {
+			t.Errorf("IfMatch(%s) status = %d, want %d", tt.header, w.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestNoneMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{``, false},
+		{`"3"`, true},
+		{`W/"3"`, true},
+		{`"4"`, false},
+		{`W/"4"`, false},
+		{`"4", "3"`, true},
+		{`"4",W/"3"`, true},
+		{`"4", "5"`, false},
+		{`*`, true},
+		{`3`, false},
+	}
+	for _, tt := range tests {
+		if got := noneMatch(tt.header, `"3"`); got != tt.want {
+			t.Errorf("noneMatch(%s, \"3\") = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	body := map[string]string{"name": "a"}
+	tests := []struct {
+		name        string
+		tag         string
+		ifNoneMatch string
+		wantStatus  int
+		wantTag     string
+	}{
+		{name: "version tag", tag: Version(2), wantStatus: http.StatusOK, wantTag: `"2"`},
+		{name: "cached version", tag: Version(2), ifNoneMatch: `"2"`, wantStatus: http.StatusNotModified, wantTag: `"2"`},
+		{name: "weak cached version", tag: Version(2), ifNoneMatch: `W/"2"`, wantStatus: http.StatusNotModified, wantTag: `"2"`},
+		{name: "stale version", tag: Version(2), ifNoneMatch: `"1"`, wantStatus: http.StatusOK, wantTag: `"2"`},
+		{name: "hash tag", wantStatus: http.StatusOK, wantTag: Hash([]byte("{\"name\":\"a\"}\n"))},
+		{name: "cached hash", ifNoneMatch: Hash([]byte("{\"name\":\"a\"}\n")), wantStatus: http.StatusNotModified, wantTag: Hash([]byte("{\"name\":\"a\"}\n"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			JSON(w, r, tt.tag, body)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("ETag"); got != tt.wantTag {
+				t.Errorf("ETag = %s, want %s", got, tt.wantTag)
+			}
+			if tt.wantStatus == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("304 with body %q", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package grpcserver
+
+import (
+	"context"
+	"strings"
+
+	"shared/auth"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// signatureKey is the metadata key carrying the service signature, the gRPC
+// counterpart of the X-Service-Signature header
+const signatureKey = "x-service-signature"
+
+// public are the services callers need no signature for: health checks and
+// reflection, which tell nothing about the data
+var public = []string{
+	"/grpc.health.v1.Health/",
+	"/grpc.reflection.v1.ServerReflection/",
+	"/grpc.reflection.v1alpha.ServerReflection/",
+}
+
+// UnaryAuth rejects calls without a valid signature of the other services,
+// see auth.ServiceKey.SignCall
+func UnaryAuth(key *auth.ServiceKey) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := checkSignature(ctx, key, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuth is UnaryAuth for streaming calls
+func StreamAuth(key *auth.ServiceKey) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkSignature(ss.Context(), key, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func checkSignature(ctx context.Context, key *auth.ServiceKey, method string) error {
+	for _, prefix := range public {
+		if strings.HasPrefix(method, prefix) {
+			return nil
+		}
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	vals := md.Get(signatureKey)
+	if len(vals) == 0 {
+		return status.Error(codes.Unauthenticated, "missing service signature")
+	}
+	if err := key.VerifyCall(method, vals[0]); err != nil {
+		return status.Error(codes.Unauthenticated, "invalid or expired service signature")
+	}
+	return nil
+}
+
+// SignCalls is the dial option for clients of the services, signing every
+// unary call with key
+func SignCalls(key *auth.ServiceKey) grpc.DialOption {
+	return grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, signatureKey, key.SignCall(method))
+		return invoker(ctx, method, req, reply, cc, opts...)
+	})
+}
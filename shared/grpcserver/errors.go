@@ -0,0 +1,43 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"shared/apperr"
+
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Error converts a use case error into a gRPC status, mapping apperr kinds
+// and validator errors onto their codes. Errors of no kind are logged and
+// become Internal without their message.
+func Error(ctx context.Context, err error) error {
+	var invalid validator.ValidationErrors
+	switch {
+	case errors.As(err, &invalid):
+		return status.Error(codes.InvalidArgument, "Validation failed: "+err.Error())
+	case errors.Is(err, apperr.NotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, apperr.Conflict):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, apperr.Validation):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, apperr.Forbidden):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, apperr.Unauthorized):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, apperr.PreconditionFailed):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, apperr.RateLimited):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, apperr.TooLarge), errors.Is(err, apperr.UnsupportedMediaType):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		slog.ErrorContext(ctx, "gRPC call failed", "error", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+}
@@ -0,0 +1,50 @@
+package grpcserver
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"shared/logging"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDKey is the metadata key carrying the request ID, the gRPC
+// counterpart of the X-Request-ID header
+const requestIDKey = "x-request-id"
+
+// UnaryLogger reuses or generates the request ID for each call and logs the
+// method, status code and latency once it completes
+func UnaryLogger(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(requestIDKey); len(vals) > 0 {
+			id = vals[0]
+		}
+	}
+	if !logging.ValidRequestID(id) {
+		id = logging.NewRequestID()
+	}
+	ctx = logging.WithRequestID(ctx, id)
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, id))
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	st := status.Convert(err)
+
+	level := slog.LevelInfo
+	attrs := []slog.Attr{
+		slog.String("method", info.FullMethod),
+		slog.String("code", st.Code().String()),
+		slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+	}
+	if err != nil {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.String("error", st.Message()))
+	}
+	slog.Default().LogAttrs(ctx, level, "grpc call", attrs...)
+	return resp, err
+}
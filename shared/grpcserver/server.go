@@ -0,0 +1,49 @@
+// Package grpcserver holds the gRPC setup shared by every service: the
+// standard gRPC health service, tracing, call logging and the check that
+// callers are other services.
+package grpcserver
+
+import (
+	"net"
+
+	"shared/auth"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// New creates a gRPC server with the health and reflection services
+// registered, a span per call and every unary call logged with its request
+// ID. Calls other than health checks and reflection must be signed with key.
+func New(key *auth.ServiceKey, opts ...grpc.ServerOption) (*grpc.Server, *health.Server) {
+	defaults := []grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(UnaryLogger, UnaryAuth(key)),
+		grpc.ChainStreamInterceptor(StreamAuth(key)),
+	}
+	s := grpc.NewServer(append(defaults, opts...)...)
+	h := health.NewServer()
+	healthpb.RegisterHealthServer(s, h)
+	reflection.Register(s)
+	return s, h
+}
+
+// MarkServing reports every service registered on s, and the server as a whole, as SERVING
+func MarkServing(s *grpc.Server, h *health.Server) {
+	for name := range s.GetServiceInfo() {
+		h.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+	}
+	h.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+}
+
+// Serve listens on port and serves s until it is stopped
+func Serve(s *grpc.Server, port string) error {
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+	return s.Serve(lis)
+}
@@ -0,0 +1,66 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"shared/client"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Mongo pings the primary of the deployment behind c
+func Mongo(c *mongo.Client) Check {
+	return func(ctx context.Context) error {
+		return c.Ping(ctx, readpref.Primary())
+	}
+}
+
+// Upstream checks that the service behind c answers its /healthz endpoint.
+// Liveness rather than readiness is probed so one failing database does
+// not cascade into every caller of the service reporting unready.
+func Upstream(c *client.Client) Check {
+	return func(ctx context.Context) error {
+		req, err := c.NewRequest(ctx, http.MethodGet, "/healthz", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s /healthz responded with %d", c.Name(), resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// WaitFor runs check until it passes, backing off from one second up to 30
+// seconds between attempts and logging each failure. It gives up only
+// when ctx is done, e.g. because the service is being stopped.
+func WaitFor(ctx context.Context, name string, check Check) error {
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err := check(attemptCtx)
+		cancel()
+		if err == nil {
+			slog.Info("Dependency is available", "dependency", name, "attempts", attempt)
+			return nil
+		}
+
+		slog.Warn("Dependency not available yet, retrying", "dependency", name, "attempt", attempt, "retry_in", delay.String(), "error", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %s: %w", name, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, 30*time.Second)
+	}
+}
@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"shared/client"
+)
+
+// ClientHooks propagates the request ID to upstream services and logs every
+// outgoing attempt: failures at warn level, successes at debug level
+var ClientHooks = client.Hooks{
+	BeforeAttempt: func(ctx context.Context, req *http.Request) context.Context {
+		if id := RequestIDFromContext(ctx); id != "" {
+			req.Header.Set(HeaderRequestID, id)
+		}
+		return ctx
+	},
+	AfterAttempt: func(ctx context.Context, info client.CallInfo) {
+		level := slog.LevelDebug
+		attrs := []slog.Attr{
+			slog.String("upstream", info.Upstream),
+			slog.String("method", info.Method),
+			slog.String("path", info.Path),
+			slog.Int("attempt", info.Attempt),
+			slog.Float64("duration_ms", float64(info.Duration.Microseconds())/1000),
+		}
+		if info.Err != nil {
+			level = slog.LevelWarn
+			attrs = append(attrs, slog.String("error", info.Err.Error()))
+		} else {
+			if info.StatusCode >= http.StatusInternalServerError {
+				level = slog.LevelWarn
+			}
+			attrs = append(attrs, slog.Int("status", info.StatusCode))
+		}
+		slog.Default().LogAttrs(ctx, level, "upstream call", attrs...)
+	},
+	BreakerStateChange: func(upstream string, from, to client.BreakerState) {
+		slog.Warn("circuit breaker state changed", "upstream", upstream, "from", from.String(), "to", to.String())
+	},
+}
@@ -0,0 +1,74 @@
+// Package logging sets up structured JSON logging with log/slog and the HTTP
+// plumbing around it: request IDs and access logs.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Config selects the log level and output format
+type Config struct {
+	Level slog.Level `env:"LOG_LEVEL" default:"info"`
+	// Format is "json" or "text" for human-readable output during local development
+	Format string `env:"LOG_FORMAT" default:"json"`
+}
+
+func (c Config) Validate() error {
+	if c.Format != "json" && c.Format != "text" {
+		return fmt.Errorf("LOG_FORMAT must be json or text, got %q", c.Format)
+	}
+	return nil
+}
+
+// Setup installs a logger tagged with service as the slog default and
+// returns it. The standard log package is routed through it as well.
+func Setup(service string, cfg Config) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+
+	var h slog.Handler = slog.NewJSONHandler(os.Stdout, opts)
+	if cfg.Format == "text" {
+		h = slog.NewTextHandler(os.Stdout, opts)
+	}
+
+	logger := slog.New(contextHandler{h}).With("service", service)
+	slog.SetDefault(logger)
+	return logger
+}
+
+// Fatal logs msg at error level and exits
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// contextHandler adds the request ID, user and trace of the request being
+// served to every record logged with a context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	if e := entryFrom(ctx); e != nil {
+		r.AddAttrs(slog.String("request_id", e.requestID))
+		if userID := e.user(); userID != "" {
+			r.AddAttrs(slog.String("user_id", userID))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+)
+
+// HeaderRequestID carries the request ID between clients, the gateway and services
+const HeaderRequestID = "X-Request-ID"
+
+// entry is the per-request logging state stored in the context. It is a
+// pointer so that middlewares further down the chain (authentication) can
+// annotate it and the access log still sees the result.
+type entry struct {
+	requestID string
+
+	mu     sync.Mutex
+	userID string
+}
+
+func (e *entry) user() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.userID
+}
+
+type ctxKey struct{}
+
+func entryFrom(ctx context.Context) *entry {
+	e, _ := ctx.Value(ctxKey{}).(*entry)
+	return e
+}
+
+// WithRequestID returns a copy of ctx carrying id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, &entry{requestID: id})
+}
+
+// RequestIDFromContext returns the ID of the request being served, or ""
+func RequestIDFromContext(ctx context.Context) string {
+	if e := entryFrom(ctx); e != nil {
+		return e.requestID
+	}
+	return ""
+}
+
+// SetUser records the authenticated caller for the logs of the current request
+func SetUser(ctx context.Context, userID string) {
+	if e := entryFrom(ctx); e != nil {
+		e.mu.Lock()
+		e.userID = userID
+		e.mu.Unlock()
+	}
+}
+
+// NewRequestID returns a random 128-bit hex ID
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID reports whether an ID received from a caller is safe to
+// reuse: short and made of URL-safe characters only
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// RequestID reuses the caller's X-Request-ID, or generates one, stores it in
+// the request context and echoes it in the response
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HeaderRequestID)
+		if !ValidRequestID(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(HeaderRequestID, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
@@ -0,0 +1,126 @@
+// Package mergepatch implements PATCH requests with RFC 7396 JSON merge
+// patches: members of the patch replace those of the resource, null removes
+// them and omitted members are left unchanged.
+package mergepatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"shared/apperr"
+	"shared/problem"
+)
+
+// ContentType is the media type of merge patches. PATCH requests sent as
+// application/json are accepted as well.
+const ContentType = "application/merge-patch+json"
+
+// maxPatchSize bounds the request body
+const maxPatchSize = 1 << 20
+
+// Read returns the merge patch in the body of r. When the body is not a
+// JSON object, or is sent with another media type, it writes the problem
+// response and returns false.
+func Read(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || (mt != ContentType && mt != "application/json") {
+			w.Header().Set("Accept-Patch", ContentType)
+			problem.HTTP(w, r, http.StatusUnsupportedMediaType, "PATCH expects "+ContentType, err)
+			return nil, false
+		}
+	}
+
+	patch, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchSize))
+	if err != nil {
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
+		return nil, false
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &obj); err != nil {
+		problem.HTTP(w, r, http.StatusBadRequest, "The merge patch must be a JSON object", err)
+		return nil, false
+	}
+	return patch, true
+}
+
+// Apply returns doc with patch merged in, as defined by RFC 7396
+func Apply(doc, patch []byte) ([]byte, error) {
+	var target, p any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+	return json.Marshal(merge(target, p))
+}
+
+func merge(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = merge(t[k], v)
+		}
+	}
+	return t
+}
+
+// Struct merges patch into v, a pointer to a struct, through its JSON form.
+// Patch members v has no field for are rejected. It returns the new values
+// of the fields that changed keyed by their bson name, ready for a Mongo
+// $set; fields tagged bson:"-" are not reported.
+func Struct(v any, patch []byte) (map[string]any, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("mergepatch: %T is not a pointer to a struct", v)
+	}
+	before := rv.Elem()
+
+	doc, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := Apply(doc, patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", apperr.Validation, err)
+	}
+
+	after := reflect.New(before.Type())
+	dec := json.NewDecoder(bytes.NewReader(merged))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(after.Interface()); err != nil {
+		return nil, fmt.Errorf("%w: %v", apperr.Validation, strings.TrimPrefix(err.Error(), "json: "))
+	}
+
+	changes := map[string]any{}
+	t := before.Type()
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("bson"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		old, cur := before.Field(i).Interface(), after.Elem().Field(i).Interface()
+		if !reflect.DeepEqual(old, cur) {
+			changes[name] = cur
+		}
+	}
+
+	before.Set(after.Elem())
+	return changes, nil
+}
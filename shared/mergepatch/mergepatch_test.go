@@ -0,0 +1,199 @@
+package mergepatch
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"shared/apperr"
+)
+
+// TestApply runs the examples of RFC 7396 Appendix A
+func TestApply(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("Apply(%s, %s): %v", tt.doc, tt.patch, err)
+			continue
+		}
+		if !jsonEqual(t, got, []byte(tt.want)) {
+			t.Errorf("Apply(%s, %s) = %s, want %s", tt.doc, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestApplyInvalid(t *testing.T) {
+	if _, err := Apply([]byte(`{}`), []byte(`{"a":`)); err == nil {
+		t.Error("Apply of a malformed patch succeeded")
+	}
+}
+
+type resource struct {
+	Name    string   `json:"name" bson:"name"`
+	Price   float64  `json:"price" bson:"price"`
+	Tags    []string `json:"tags" bson:"tags"`
+	Comment string   `json:"comment,omitempty" bson:"-"`
+	Note    string   `json:"note"`
+}
+
+func TestStruct(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		want    resource
+		changes map[string]any
+	}{
+		{
+			name:    "changed fields are reported by bson name",
+			patch:   `{"name":"lamp","price":7.5}`,
+			want:    resource{Name: "lamp", Price: 7.5, Tags: []string{"a"}},
+			changes: map[string]any{"name": "lamp", "price": 7.5},
+		},
+		{
+			name:    "an unchanged value is not reported",
+			patch:   `{"name":"desk","tags":["a"]}`,
+			want:    resource{Name: "desk", Price: 3, Tags: []string{"a"}},
+			changes: map[string]any{},
+		},
+		{
+			name:    "null resets to the zero value",
+			patch:   `{"tags":null}`,
+			want:    resource{Name: "desk", Price: 3},
+			changes: map[string]any{"tags": []string(nil)},
+		},
+		{
+			name:    "bson:\"-\" fields are set but not reported",
+			patch:   `{"comment":"why"}`,
+			want:    resource{Name: "desk", Price: 3, Tags: []string{"a"}, Comment: "why"},
+			changes: map[string]any{},
+		},
+		{
+			name:    "fields without a bson name are not reported",
+			patch:   `{"note":"n"}`,
+			want:    resource{Name: "desk", Price: 3, Tags: []string{"a"}, Note: "n"},
+			changes: map[string]any{},
+		},
+		{
+			name:    "an empty patch changes nothing",
+			patch:   `{}`,
+			want:    resource{Name: "desk", Price: 3, Tags: []string{"a"}},
+			changes: map[string]any{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := resource{Name: "desk", Price: 3, Tags: []string{"a"}}
+			changes, err := Struct(&r, []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(r, tt.want) {
+				t.Errorf("merged %+v, want %+v", r, tt.want)
+			}
+			if !reflect.DeepEqual(changes, tt.changes) {
+				t.Errorf("changes %#v, want %#v", changes, tt.changes)
+			}
+		})
+	}
+}
+
+func TestStructRejects(t *testing.T) {
+	tests := []struct {
+		name, patch string
+	}{
+		{"unknown field", `{"colour":"red"}`},
+		{"wrong type", `{"price":"cheap"}`},
+		{"malformed", `{"name":`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := resource{Name: "desk"}
+			if _, err := Struct(&r, []byte(tt.patch)); !errors.Is(err, apperr.Validation) {
+				t.Errorf("Struct error = %v, want a validation error", err)
+			}
+			if r.Name != "desk" {
+				t.Errorf("a rejected patch changed the struct: %+v", r)
+			}
+		})
+	}
+}
+
+func TestStructNotPointer(t *testing.T) {
+	if _, err := Struct(resource{}, []byte(`{}`)); err == nil {
+		t.Error("Struct of a non-pointer succeeded")
+	}
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"merge patch", ContentType, `{"a":1}`, 0},
+		{"json", "application/json; charset=utf-8", `{"a":1}`, 0},
+		{"no content type", "", `{"a":1}`, 0},
+		{"other media type", "application/json-patch+json", `[]`, http.StatusUnsupportedMediaType},
+		{"not an object", ContentType, `["a"]`, http.StatusBadRequest},
+		{"malformed", ContentType, `{"a":`, http.StatusBadRequest},
+		{"too large", ContentType, `{"a":"` + strings.Repeat("x", maxPatchSize) + `"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/things/1", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			patch, ok := Read(w, r)
+			if tt.status == 0 {
+				if !ok || string(patch) != tt.body {
+					t.Errorf("Read = %s, %v; want the body, true (response %d %s)", patch, ok, w.Code, w.Body)
+				}
+				return
+			}
+			if ok || w.Code != tt.status {
+				t.Errorf("Read ok = %v, status %d; want false, %d", ok, w.Code, tt.status)
+			}
+			if tt.status == http.StatusUnsupportedMediaType && w.Header().Get("Accept-Patch") != ContentType {
+				t.Errorf("Accept-Patch = %q, want %q", w.Header().Get("Accept-Patch"), ContentType)
+			}
+		})
+	}
+}
+
+func jsonEqual(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	var x, y any
+	if err := json.Unmarshal(a, &x); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &y); err != nil {
+		t.Fatal(err)
+	}
+	return reflect.DeepEqual(x, y)
+}
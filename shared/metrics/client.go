@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+
+	"shared/client"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "upstream_request_duration_seconds",
+		Help:    "Latency of calls to other services, by upstream, method and status code (\"error\" when no response arrived).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"upstream", "method", "status"})
+
+	breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "upstream_circuit_breaker_open",
+		Help: "1 while the circuit breaker of the upstream is open, 0.5 while half-open, 0 when closed.",
+	}, []string{"upstream"})
+)
+
+// ClientHooks records every attempt made by a shared/client.Client and the
+// state of its circuit breaker
+var ClientHooks = client.Hooks{
+	AfterAttempt: func(_ context.Context, info client.CallInfo) {
+		status := "error"
+		if info.Err == nil {
+			status = strconv.Itoa(info.StatusCode)
+		}
+		upstreamDuration.WithLabelValues(info.Upstream, info.Method, status).Observe(info.Duration.Seconds())
+	},
+	BreakerStateChange: func(upstream string, _, to client.BreakerState) {
+		v := 0.0
+		switch to {
+		case client.StateOpen:
+			v = 1
+		case client.StateHalfOpen:
+			v = 0.5
+		}
+		breakerState.WithLabelValues(upstream).Set(v)
+	},
+}
@@ -0,0 +1,36 @@
+// Package privacy is the contract of the internal endpoints through which
+// user-ms exports and anonymizes the personal data other services hold about
+// a user, to answer data subject requests. Each service that stores user IDs
+// serves both endpoints; they are not exposed by the gateway.
+package privacy
+
+import "net/url"
+
+// ExportPath is where a service answers GET with an Export of the user's records
+func ExportPath(userID string) string {
+	return "/internal/personal-data/" + url.PathEscape(userID)
+}
+
+// AnonymizePath is where a service answers POST with an AnonymizeRequest
+// by replacing the user's ID in its records, and with an AnonymizeResponse
+func AnonymizePath(userID string) string {
+	return ExportPath(userID) + "/anonymize"
+}
+
+// Export carries a service's records about a user, soft-deleted ones
+// included, in the service's own JSON form
+type Export struct {
+	Records any `json:"records"`
+}
+
+// AnonymizeRequest names the pseudonym that replaces the user's ID. The
+// records stay, since orders and payments are financial records that must
+// be kept, and reviews make up product ratings, but no longer lead to a person.
+type AnonymizeRequest struct {
+	Pseudonym string `json:"pseudonym" validate:"required"`
+}
+
+type AnonymizeResponse struct {
+	// Anonymized counts the records changed; repeating a request changes none
+	Anonymized int64 `json:"anonymized"`
+}
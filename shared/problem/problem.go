@@ -0,0 +1,139 @@
+// Package problem writes error responses as RFC 7807 problem details
+// (application/problem+json) and maps domain errors onto them.
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"shared/apperr"
+	"shared/logging"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// ContentType is the media type of problem responses
+const ContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object
+type Problem struct {
+	// Type is a URI identifying the problem type; "about:blank" means the
+	// title is the HTTP status text
+	Type   string `json:"type" example:"about:blank"`
+	Title  string `json:"title" example:"Not Found"`
+	Status int    `json:"status" example:"404"`
+	Detail string `json:"detail,omitempty" example:"user not found"`
+	// Instance is the request path
+	Instance string `json:"instance,omitempty" example:"/api/users/64b22dd94c77c5b41f5a9b0d"`
+	// RequestID can be quoted in bug reports to find the request in the logs
+	RequestID string `json:"request_id,omitempty" example:"3f1c2a9e8b7d4c60"`
+	// Errors lists the invalid fields of a rejected request body
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes one invalid field
+type FieldError struct {
+	Field   string `json:"field" example:"email"`
+	Message string `json:"message" example:"must be a valid email address"`
+}
+
+// New returns a problem of type about:blank for status
+func New(status int, detail string) Problem {
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// Write logs a failed request and sends p. Server errors are logged at error
+// level, client errors at info. err carries the underlying cause for the log
+// and may be nil.
+func Write(w http.ResponseWriter, r *http.Request, p Problem, err error) {
+	ctx := r.Context()
+
+	level := slog.LevelInfo
+	if p.Status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	attrs := []slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", p.Status),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	msg := p.Detail
+	if msg == "" {
+		msg = p.Title
+	}
+	slog.Default().LogAttrs(ctx, level, msg, attrs...)
+
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.RequestID == "" {
+		p.RequestID = logging.RequestIDFromContext(ctx)
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// HTTP writes a problem with the given status and detail
+func HTTP(w http.ResponseWriter, r *http.Request, status int, detail string, err error) {
+	Write(w, r, New(status, detail), err)
+}
+
+// Error writes the problem matching err: domain errors of an apperr kind get
+// that kind's status and their message as detail, validator errors a 400
+// listing the invalid fields, and anything else a 500 whose detail does not
+// leak the cause.
+func Error(w http.ResponseWriter, r *http.Request, err error) {
+	var invalid validator.ValidationErrors
+	if errors.As(err, &invalid) {
+		p := New(http.StatusBadRequest, "The request has invalid fields")
+		p.Errors = fieldErrors(invalid)
+		Write(w, r, p, err)
+		return
+	}
+
+	status := Status(err)
+	detail := err.Error()
+	if status == http.StatusInternalServerError {
+		detail = "Internal server error"
+	}
+	Write(w, r, New(status, detail), err)
+}
+
+// Status returns the HTTP status for the kind of err, 500 when it has none
+func Status(err error) int {
+	switch {
+	case errors.Is(err, apperr.NotFound):
+		return http.StatusNotFound
+	case errors.Is(err, apperr.Conflict):
+		return http.StatusConflict
+	case errors.Is(err, apperr.Validation):
+		return http.StatusBadRequest
+	case errors.Is(err, apperr.Forbidden):
+		return http.StatusForbidden
+	case errors.Is(err, apperr.Unauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, apperr.PreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, apperr.RateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, apperr.TooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, apperr.UnsupportedMediaType):
+		return http.StatusUnsupportedMediaType
+	default:
+		return http.StatusInternalServerError
+	}
+}
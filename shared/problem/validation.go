@@ -0,0 +1,100 @@
+package problem
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// NewValidator returns a validator that reports fields by their JSON name,
+// so the field errors of a problem match the request body
+func NewValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return f.Name
+		}
+		return name
+	})
+	return v
+}
+
+func fieldErrors(errs validator.ValidationErrors) []FieldError {
+	out := make([]FieldError, 0, len(errs))
+	for _, fe := range errs {
+		out = append(out, FieldError{Field: fieldPath(fe), Message: message(fe)})
+	}
+	return out
+}
+
+// fieldPath drops the struct name from the namespace: "CreateUserRequest.email" becomes "email"
+func fieldPath(fe validator.FieldError) string {
+	if _, path, ok := strings.Cut(fe.Namespace(), "."); ok {
+		return path
+	}
+	return fe.Field()
+}
+
+// message describes a failed validation tag in plain words
+func message(fe validator.FieldError) string {
+	isString := fe.Kind() == reflect.String
+	isList := fe.Kind() == reflect.Slice || fe.Kind() == reflect.Map
+
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "url", "http_url":
+		return "must be a valid URL"
+	case "e164":
+		return "must be a phone number in E.164 form, e.g. +14155550123"
+	case "datetime":
+		if fe.Param() == time.DateOnly {
+			return "must be a date, YYYY-MM-DD"
+		}
+		return "must be a time in the layout " + fe.Param()
+	case "bcp47_language_tag":
+		return "must be a BCP 47 language tag, e.g. en-US"
+	case "iso4217":
+		return "must be an ISO 4217 currency code, e.g. EUR"
+	case "mongodb":
+		return "must be an ID of 24 hexadecimal digits"
+	case "oneof":
+		return "must be one of: " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "min", "gte":
+		switch {
+		case isString:
+			return fmt.Sprintf("must be at least %s characters long", fe.Param())
+		case isList:
+			return fmt.Sprintf("must contain at least %s items", fe.Param())
+		}
+		return "must be at least " + fe.Param()
+	case "max", "lte":
+		switch {
+		case isString:
+			return fmt.Sprintf("must be at most %s characters long", fe.Param())
+		case isList:
+			return fmt.Sprintf("must contain at most %s items", fe.Param())
+		}
+		return "must be at most " + fe.Param()
+	case "len":
+		if isString {
+			return fmt.Sprintf("must be exactly %s characters long", fe.Param())
+		}
+		return "must have length " + fe.Param()
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "lt":
+		return "must be less than " + fe.Param()
+	default:
+		return fmt.Sprintf("failed the %q check", fe.Tag())
+	}
+}
@@ -0,0 +1,17 @@
+// Package purchases is the contract of the internal endpoint through which
+// product-ms asks order-ms whether a customer bought a product, to mark
+// their reviews as verified purchases. It is not exposed by the gateway.
+package purchases
+
+import "net/url"
+
+// Path is where order-ms answers GET with the Purchase of productID by customerID
+func Path(customerID, productID string) string {
+	return "/internal/customers/" + url.PathEscape(customerID) + "/purchases/" + url.PathEscape(productID)
+}
+
+type Purchase struct {
+	// Delivered reports whether an order of the customer for the product,
+	// soft-deleted ones excluded, has been delivered
+	Delivered bool `json:"delivered"`
+}
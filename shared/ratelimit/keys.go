@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"shared/auth"
+)
+
+// KeyFunc identifies the client a request is counted against.
+// It returns false when it cannot tell.
+type KeyFunc func(r *http.Request) (string, bool)
+
+// ByAPIKey identifies clients by the verified API key in the request
+// context. A key that did not verify identifies nobody, so that sending a
+// made-up key does not get a client a fresh bucket.
+func ByAPIKey(r *http.Request) (string, bool) {
+	id, ok := auth.FromContext(r.Context())
+	if !ok || id.KeyID == "" {
+		return "", false
+	}
+	return "key:" + id.KeyID, true
+}
+
+// ByUser identifies clients by the authenticated user in the request context
+func ByUser(r *http.Request) (string, bool) {
+	id, ok := auth.FromContext(r.Context())
+	if !ok || id.UserID == "" {
+		return "", false
+	}
+	return "user:" + id.UserID, true
+}
+
+// ByIP identifies clients by IP address. Set trustForwarded only behind a
+// proxy that overwrites X-Forwarded-For, otherwise clients can pick their own key.
+func ByIP(trustForwarded bool) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		return "ip:" + ClientIP(r, trustForwarded), true
+	}
+}
+
+// FirstOf uses the first key function that identifies the client
+func FirstOf(fns ...KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		for _, fn := range fns {
+			if k, ok := fn(r); ok {
+				return k, true
+			}
+		}
+		return "", false
+	}
+}
+
+// ClientIP returns the caller's IP, taking the first X-Forwarded-For hop when trustForwarded is set
+func ClientIP(r *http.Request, trustForwarded bool) string {
+	if xff := r.Header.Get("X-Forwarded-For"); trustForwarded && xff != "" {
+		ip, _, _ := strings.Cut(xff, ",")
+		return strings.TrimSpace(ip)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
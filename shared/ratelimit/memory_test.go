@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// take is a step of a store scenario: the clock moves by advance, then a
+// token is taken
+type take struct {
+	advance time.Duration
+	want    Result
+}
+
+// storeTests run against every Store, under a policy of 3 tokens refilling
+// at one per second
+var storeTests = []struct {
+	name  string
+	takes []take
+}{
+	{
+		name: "a full bucket allows a burst of Limit",
+		takes: []take{
+			{0, Result{Allowed: true, Limit: 3, Remaining: 2, ResetAfter: time.Second}},
+			{0, Result{Allowed: true, Limit: 3, Remaining: 1, ResetAfter: 2 * time.Second}},
+			{0, Result{Allowed: true, Limit: 3, Remaining: 0, ResetAfter: 3 * time.Second}},
+			{0, Result{Allowed: false, Limit: 3, Remaining: 0, ResetAfter: 3 * time.Second, RetryAfter: time.Second}},
+		},
+	},
+	{
+		name: "tokens refill with time",
+		takes: []take{
+			{0, Result{Allowed: true, Limit: 3, Remaining: 2, ResetAfter: time.Second}},
+			{0, Result{Allowed: true, Limit: 3, Remaining: 1, ResetAfter: 2 * time.Second}},
+			{0, Result{Allowed: true, Limit: 3, Remaining: 0, ResetAfter: 3 * time.Second}},
+			{time.Second, Result{Allowed: true, Limit: 3, Remaining: 0, ResetAfter: 3 * time.Second}},
+			{500 * time.Millisecond, Result{Allowed: false, Limit: 3, Remaining: 0, ResetAfter: 2500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}},
+			{500 * time.Millisecond, Result{Allowed: true, Limit: 3, Remaining: 0, ResetAfter: 3 * time.Second}},
+		},
+	},
+	{
+		name: "a refused take costs nothing",
+		takes: []take{
+			{0, Result{Allowed: true, Limit: 3, Remaining: 2, ResetAfter: time.Second}},
+			{0, Result{Allowed: true, Limit: 3, Remaining: 1, ResetAfter: 2 * time.Second}},
+			{0, Result{Allowed: true, Limit: 3, Remaining: 0, ResetAfter: 3 * time.Second}},
+			{250 * time.Millisecond, Result{Allowed: false, Limit: 3, Remaining: 0, ResetAfter: 2750 * time.Millisecond, RetryAfter: 750 * time.Millisecond}},
+			{250 * time.Millisecond, Result{Allowed: false, Limit: 3, Remaining: 0, ResetAfter: 2500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}},
+			{500 * time.Millisecond, Result{Allowed: true, Limit: 3, Remaining: 0, ResetAfter: 3 * time.Second}},
+		},
+	},
+	{
+		name: "a bucket never holds more than Limit",
+		takes: []take{
+			{0, Result{Allowed: true, Limit: 3, Remaining: 2, ResetAfter: time.Second}},
+			{time.Hour, Result{Allowed: true, Limit: 3, Remaining: 2, ResetAfter: time.Second}},
+			{0, Result{Allowed: true, Limit: 3, Remaining: 1, ResetAfter: 2 * time.Second}},
+		},
+	},
+	{
+		name: "a fractional bucket rounds Remaining down",
+		takes: []take{
+			{0, Result{Allowed: true, Limit: 3, Remaining: 2, ResetAfter: time.Second}},
+			{0, Result{Allowed: true, Limit: 3, Remaining: 1, ResetAfter: 2 * time.Second}},
+			{1500 * time.Millisecond, Result{Allowed: true, Limit: 3, Remaining: 1, ResetAfter: 1500 * time.Millisecond}},
+		},
+	},
+}
+
+var storePolicy = Policy{Name: "test", Limit: 3, Period: 3 * time.Second}
+
+// runStoreTests runs storeTests against the store newStore returns, whose
+// clock reads *now
+func runStoreTests(t *testing.T, newStore func(now *time.Time) Store) {
+	for _, tt := range storeTests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			store := newStore(&now)
+			for i, step := range tt.takes {
+				now = now.Add(step.advance)
+				got, err := store.Take(context.Background(), "client", storePolicy)
+				if err != nil {
+					t.Fatalf("take %d: %v", i, err)
+				}
+				if !resultEqual(got, step.want) {
+					t.Fatalf("take %d = %+v, want %+v", i, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+// resultEqual compares results, allowing for the rounding of the Mongo
+// store's millisecond clock and floating point
+func resultEqual(a, b Result) bool {
+	near := func(x, y time.Duration) bool {
+		return (x - y).Abs() <= time.Millisecond
+	}
+	return a.Allowed == b.Allowed && a.Limit == b.Limit && a.Remaining == b.Remaining &&
+		near(a.ResetAfter, b.ResetAfter) && near(a.RetryAfter, b.RetryAfter)
+}
+
+func TestMemoryStore(t *testing.T) {
+	runStoreTests(t, func(now *time.Time) Store {
+		s := NewMemoryStore()
+		s.now = func() time.Time { return *now }
+		return s
+	})
+}
+
+func TestMemoryStoreKeys(t *testing.T) {
+	s := NewMemoryStore()
+	p := Policy{Name: "test", Limit: 1, Period: time.Minute}
+	for _, key := range []string{"a", "b"} {
+		if res, _ := s.Take(context.Background(), key, p); !res.Allowed {
+			t.Errorf("first take for %q refused", key)
+		}
+	}
+	if res, _ := s.Take(context.Background(), "a", p); res.Allowed {
+		t.Error("second take for a allowed")
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	p := Policy{Name: "test", Limit: 10, Period: 10 * time.Minute}
+
+	s.Take(context.Background(), "idle", p)
+	now = now.Add(30 * time.Second)
+	s.Take(context.Background(), "busy", p)
+	if len(s.buckets) != 2 {
+		t.Fatalf("%d buckets, want 2", len(s.buckets))
+	}
+
+	// idle is full again a minute after its take; busy is not
+	now = now.Add(40 * time.Second)
+	s.Take(context.Background(), "busy", p)
+	if _, ok := s.buckets["idle"]; ok {
+		t.Error("the refilled bucket was not swept")
+	}
+	if _, ok := s.buckets["busy"]; !ok {
+		t.Error("a bucket in use was swept")
+	}
+}
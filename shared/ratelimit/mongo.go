@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore keeps buckets in a MongoDB collection so every replica shares
+// the same limits. Each take is a single atomic findAndModify.
+type MongoStore struct {
+	collection *mongo.Collection
+	now        func() time.Time
+}
+
+func NewMongoStore(col *mongo.Collection) *MongoStore {
+	return &MongoStore{collection: col, now: time.Now}
+}
+
+// EnsureIndexes creates the TTL index that removes buckets once they have refilled
+func (s *MongoStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+type mongoBucket struct {
+	Tokens  float64 `bson:"tokens"`
+	Allowed bool    `bson:"allowed"`
+}
+
+func (s *MongoStore) Take(ctx context.Context, key string, p Policy) (Result, error) {
+	now := s.now()
+	nowMs := now.UnixMilli()
+
+	// tokens = min(limit, tokens + elapsed * rate), starting from a full bucket
+	elapsed := bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{nowMs, bson.M{"$ifNull": bson.A{"$ts", nowMs}}}}}}
+	refilled := bson.M{"$min": bson.A{p.Limit, bson.M{"$add": bson.A{
+		bson.M{"$ifNull": bson.A{"$tokens", p.Limit}},
+		bson.M{"$multiply": bson.A{elapsed, p.rate() / 1000}},
+	}}}}
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{{Key: "tokens", Value: refilled}, {Key: "ts", Value: nowMs}}}},
+		{{Key: "$set", Value: bson.D{{Key: "allowed", Value: bson.M{"$gte": bson.A{"$tokens", 1}}}}}},
+		{{Key: "$set", Value: bson.D{
+			{Key: "tokens", Value: bson.M{"$cond": bson.A{"$allowed", bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}}},
+			{Key: "expires_at", Value: now.Add(p.Period)},
+		}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var b mongoBucket
+	err := s.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&b)
+	if mongo.IsDuplicateKeyError(err) {
+		// two replicas created the bucket at the same time; the document exists now
+		err = s.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&b)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	return p.result(b.Allowed, b.Tokens), nil
+}
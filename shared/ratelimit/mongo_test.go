@@ -0,0 +1,41 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestMongoStore runs the store scenarios against the MongoDB at
+// TEST_MONGO_URI, in a scratch collection it drops afterwards
+func TestMongoStore(t *testing.T) {
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	db := client.Database("ratelimit_test")
+	var n int
+	runStoreTests(t, func(now *time.Time) Store {
+		n++
+		col := db.Collection(fmt.Sprintf("buckets_%d_%d", time.Now().UnixNano(), n))
+		t.Cleanup(func() { col.Drop(context.Background()) })
+
+		s := NewMongoStore(col)
+		s.now = func() time.Time { return *now }
+		return s
+	})
+}
@@ -0,0 +1,135 @@
+// Package ratelimit provides per-client token-bucket rate limiting for HTTP
+// handlers, with in-memory and MongoDB-backed bucket stores.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"shared/problem"
+)
+
+// Policy is a token bucket holding Limit tokens that refills completely over Period.
+// "100/1m" allows bursts of 100 requests and a sustained 100 requests per minute.
+type Policy struct {
+	// Name identifies the route group; buckets are kept per policy and client
+	Name   string
+	Limit  int
+	Period time.Duration
+}
+
+// ParsePolicy parses "<limit>/<period>", e.g. "10/1m" or "1000/1h"
+func ParsePolicy(name, s string) (Policy, error) {
+	limit, period, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Policy{}, fmt.Errorf("rate limit %q: expected <limit>/<period>", s)
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n <= 0 {
+		return Policy{}, fmt.Errorf("rate limit %q: invalid limit", s)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Policy{}, fmt.Errorf("rate limit %q: invalid period", s)
+	}
+	return Policy{Name: name, Limit: n, Period: d}, nil
+}
+
+// UnmarshalText parses "<limit>/<period>", keeping the policy name
+func (p *Policy) UnmarshalText(text []byte) error {
+	parsed, err := ParsePolicy(p.Name, string(text))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+func (p Policy) MarshalText() ([]byte, error) {
+	return fmt.Appendf(nil, "%d/%s", p.Limit, p.Period), nil
+}
+
+// rate returns the refill rate in tokens per second
+func (p Policy) rate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+// Result is the outcome of taking a token
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// ResetAfter is the time until the bucket is full again
+	ResetAfter time.Duration
+	// RetryAfter is the time until the next token is available when not Allowed
+	RetryAfter time.Duration
+}
+
+// result derives a Result from the tokens left in a bucket after a take attempt
+func (p Policy) result(allowed bool, tokens float64) Result {
+	rate := p.rate()
+	res := Result{
+		Allowed:    allowed,
+		Limit:      p.Limit,
+		Remaining:  int(math.Floor(tokens)),
+		ResetAfter: time.Duration((float64(p.Limit) - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		res.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return res
+}
+
+// Store keeps token buckets
+type Store interface {
+	// Take removes one token from the bucket for key under policy p
+	Take(ctx context.Context, key string, p Policy) (Result, error)
+}
+
+// Middleware limits each client, identified by key, according to p. It sets
+// the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy
+// headers on every response and answers 429 with Retry-After once the client
+// runs out of tokens. Requests for which key finds nothing are not limited,
+// and a failing store lets requests through rather than taking the API down.
+func Middleware(store Store, p Policy, key KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client, ok := key(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			res, err := store.Take(r.Context(), p.Name+":"+client, p)
+			if err != nil {
+				slog.WarnContext(r.Context(), "rate limit store unavailable, allowing request", "policy", p.Name, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(seconds(res.ResetAfter)))
+			h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", p.Limit, seconds(p.Period)))
+
+			if !res.Allowed {
+				h.Set("Retry-After", strconv.Itoa(max(1, seconds(res.RetryAfter))))
+				problem.HTTP(w, r, http.StatusTooManyRequests, "Too many requests", nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// seconds rounds d up to whole seconds
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
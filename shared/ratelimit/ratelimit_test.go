@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Policy
+		wantErr bool
+	}{
+		{in: "10/1m", want: Policy{Name: "p", Limit: 10, Period: time.Minute}},
+		{in: " 1000/1h ", want: Policy{Name: "p", Limit: 1000, Period: time.Hour}},
+		{in: "5/30s", want: Policy{Name: "p", Limit: 5, Period: 30 * time.Second}},
+		{in: "10", wantErr: true},
+		{in: "ten/1m", wantErr: true},
+		{in: "0/1m", wantErr: true},
+		{in: "-1/1m", wantErr: true},
+		{in: "10/minute", wantErr: true},
+		{in: "10/0s", wantErr: true},
+		{in: "10/-1m", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePolicy("p", tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePolicy(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParsePolicy(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyText(t *testing.T) {
+	p := Policy{Name: "catalog"}
+	if err := p.UnmarshalText([]byte("100/1m")); err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "catalog" || p.Limit != 100 || p.Period != time.Minute {
+		t.Errorf("UnmarshalText = %+v", p)
+	}
+	if text, _ := p.MarshalText(); string(text) != "100/1m0s" {
+		t.Errorf("MarshalText = %q, want %q", text, "100/1m0s")
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, Policy) (Result, error) {
+	return Result{}, errors.New("store down")
+}
+
+func TestMiddleware(t *testing.T) {
+	p := Policy{Name: "test", Limit: 2, Period: time.Minute}
+	byHeader := func(r *http.Request) (string, bool) {
+		k := r.Header.Get("X-Client")
+		return k, k != ""
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name   string
+		store  Store
+		client string
+		// requests made before the checked one
+		before     int
+		wantStatus int
+		wantHeader map[string]string
+	}{
+		{
+			name: "first request", store: NewMemoryStore(), client: "a",
+			wantStatus: http.StatusOK,
+			wantHeader: map[string]string{"RateLimit-Limit": "2", "RateLimit-Remaining": "1", "RateLimit-Reset": "30", "RateLimit-Policy": "2;w=60"},
+		},
+		{
+			name: "last token", store: NewMemoryStore(), client: "a", before: 1,
+			wantStatus: http.StatusOK,
+			wantHeader: map[string]string{"RateLimit-Remaining": "0", "RateLimit-Reset": "60"},
+		},
+		{
+			name: "out of tokens", store: NewMemoryStore(), client: "a", before: 2,
+			wantStatus: http.StatusTooManyRequests,
+			wantHeader: map[string]string{"RateLimit-Remaining": "0", "Retry-After": "30"},
+		},
+		{
+			name: "unidentified clients are not limited", store: NewMemoryStore(), before: 5,
+			wantStatus: http.StatusOK,
+			wantHeader: map[string]string{"RateLimit-Limit": ""},
+		},
+		{
+			name: "a failing store lets requests through", store: failingStore{}, client: "a", before: 5,
+			wantStatus: http.StatusOK,
+			wantHeader: map[string]string{"RateLimit-Limit": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Middleware(tt.store, p, byHeader)(ok)
+			var rec *httptest.ResponseRecorder
+			for range tt.before + 1 {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				if tt.client != "" {
+					req.Header.Set("X-Client", tt.client)
+				}
+				rec = httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+			}
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			for name, want := range tt.wantHeader {
+				if got := rec.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
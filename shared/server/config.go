@@ -0,0 +1,21 @@
+package server
+
+import "time"
+
+// Config holds the HTTP server timeouts and the shutdown budget
+type Config struct {
+	// ReadHeaderTimeout bounds reading the request headers (slowloris protection)
+	ReadHeaderTimeout time.Duration `env:"HTTP_READ_HEADER_TIMEOUT" default:"5s"`
+	// ReadTimeout bounds reading the whole request, body included
+	ReadTimeout time.Duration `env:"HTTP_READ_TIMEOUT" default:"15s"`
+	// WriteTimeout bounds the time from the end of the request headers to the end of the response
+	WriteTimeout time.Duration `env:"HTTP_WRITE_TIMEOUT" default:"30s"`
+	// IdleTimeout bounds how long keep-alive connections wait for the next request
+	IdleTimeout time.Duration `env:"HTTP_IDLE_TIMEOUT" default:"60s"`
+	// DrainDelay is how long a stopping service keeps serving with failing
+	// readiness, so load balancers take it out of rotation first
+	DrainDelay time.Duration `env:"SHUTDOWN_DRAIN_DELAY" default:"5s"`
+	// ShutdownTimeout bounds draining in-flight requests, stopping workers and
+	// running the shutdown hooks, after DrainDelay
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" default:"20s"`
+}
@@ -0,0 +1,197 @@
+// Package server runs the HTTP and gRPC servers and background workers of a
+// service and shuts them down gracefully.
+//
+// On shutdown the steps run in this order:
+//  1. the BeforeDrain hooks (e.g. failing readiness), then a wait of DrainDelay
+//  2. the servers stop accepting connections and finish in-flight requests
+//  3. the context given to workers is cancelled and the workers are awaited
+//  4. the OnShutdown hooks (e.g. disconnecting Mongo, flushing traces), in registration order
+//
+// Steps 2 to 4 share the ShutdownTimeout budget.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"shared/grpcserver"
+
+	"google.golang.org/grpc"
+)
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Server owns the lifecycle of a service process
+type Server struct {
+	cfg Config
+
+	beforeDrain []func()
+	stoppers    []hook
+	onShutdown  []hook
+
+	workersCtx    context.Context
+	cancelWorkers context.CancelFunc
+	workers       sync.WaitGroup
+
+	// errs receives the first fatal error of a server or worker
+	errs chan error
+}
+
+func New(cfg Config) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		cfg:           cfg,
+		workersCtx:    ctx,
+		cancelWorkers: cancel,
+		errs:          make(chan error, 1),
+	}
+}
+
+// ServeHTTP starts an http.Server with the configured timeouts on addr
+func (s *Server) ServeHTTP(name, addr string, h http.Handler) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: s.cfg.ReadHeaderTimeout,
+		ReadTimeout:       s.cfg.ReadTimeout,
+		WriteTimeout:      s.cfg.WriteTimeout,
+		IdleTimeout:       s.cfg.IdleTimeout,
+	}
+	s.stoppers = append(s.stoppers, hook{name: name, fn: srv.Shutdown})
+
+	go func() {
+		slog.Info("HTTP server running", "server", name, "addr", addr)
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			s.fail(fmt.Errorf("%s: %w", name, err))
+		}
+	}()
+}
+
+// ServeGRPC serves g on port. On shutdown in-flight RPCs may finish until
+// the deadline, after which the remaining ones are cancelled.
+func (s *Server) ServeGRPC(name string, g *grpc.Server, port string) {
+	s.stoppers = append(s.stoppers, hook{name: name, fn: func(ctx context.Context) error {
+		done := make(chan struct{})
+		go func() {
+			g.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			g.Stop()
+			return ctx.Err()
+		}
+	}})
+
+	go func() {
+		slog.Info("gRPC server running", "server", name, "addr", "localhost:"+port)
+		if err := grpcserver.Serve(g, port); err != nil {
+			s.fail(fmt.Errorf("%s: %w", name, err))
+		}
+	}()
+}
+
+// Go runs a background worker, e.g. an outbox relay or a scheduler. Its
+// context is cancelled on shutdown and the worker must then return; a
+// worker returning an error before that stops the service.
+func (s *Server) Go(name string, worker func(ctx context.Context) error) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		if err := worker(s.workersCtx); err != nil && s.workersCtx.Err() == nil {
+			s.fail(fmt.Errorf("worker %s: %w", name, err))
+		}
+	}()
+}
+
+// BeforeDrain registers fn to run as soon as shutdown starts
+func (s *Server) BeforeDrain(fn func()) {
+	s.beforeDrain = append(s.beforeDrain, fn)
+}
+
+// OnShutdown registers fn to run once servers and workers have stopped
+func (s *Server) OnShutdown(name string, fn func(ctx context.Context) error) {
+	s.onShutdown = append(s.onShutdown, hook{name: name, fn: fn})
+}
+
+// Wait blocks until ctx is cancelled (typically by SIGTERM) or a server or
+// worker fails, then shuts everything down. It returns the failure, if any,
+// joined with the errors met during shutdown.
+func (s *Server) Wait(ctx context.Context) error {
+	var cause error
+	select {
+	case <-ctx.Done():
+		slog.Info("Shutdown requested", "drain_delay", s.cfg.DrainDelay.String(), "timeout", s.cfg.ShutdownTimeout.String())
+	case cause = <-s.errs:
+		slog.Error("Stopping after failure", "error", cause)
+	}
+
+	for _, fn := range s.beforeDrain {
+		fn()
+	}
+	if cause == nil {
+		time.Sleep(s.cfg.DrainDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+
+	errs := []error{cause}
+
+	// servers drain in parallel so one slow server does not eat the others' budget
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, h := range s.stoppers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.fn(shutdownCtx); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("stopping %s: %w", h.name, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	s.cancelWorkers()
+	workersDone := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-workersDone:
+	case <-shutdownCtx.Done():
+		errs = append(errs, fmt.Errorf("stopping workers: %w", shutdownCtx.Err()))
+	}
+
+	for _, h := range s.onShutdown {
+		if err := h.fn(shutdownCtx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+		}
+	}
+
+	err := errors.Join(errs...)
+	if err == nil {
+		slog.Info("Shutdown complete")
+	}
+	return err
+}
+
+func (s *Server) fail(err error) {
+	select {
+	case s.errs <- err:
+	default:
+	}
+}
@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"shared/client"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ClientHooks gives every attempt made by a shared/client.Client its own
+// client span and sends the trace context to the upstream in traceparent
+var ClientHooks = client.Hooks{
+	BeforeAttempt: func(ctx context.Context, req *http.Request) context.Context {
+		// renamed to "<method> <upstream>" once the attempt completes
+		ctx, _ = tracer().Start(ctx, req.Method,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(req.Method),
+				semconv.URLFull(req.URL.String()),
+			),
+		)
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+		return ctx
+	},
+	AfterAttempt: func(ctx context.Context, info client.CallInfo) {
+		span := trace.SpanFromContext(ctx)
+		span.SetName(info.Method + " " + info.Upstream)
+		span.SetAttributes(
+			semconv.PeerService(info.Upstream),
+			semconv.HTTPRequestResendCount(info.Attempt-1),
+		)
+		switch {
+		case info.Err != nil:
+			span.RecordError(info.Err)
+			span.SetStatus(codes.Error, info.Err.Error())
+		case info.StatusCode >= http.StatusInternalServerError:
+			span.SetAttributes(semconv.HTTPResponseStatusCode(info.StatusCode))
+			span.SetStatus(codes.Error, http.StatusText(info.StatusCode))
+		default:
+			span.SetAttributes(semconv.HTTPResponseStatusCode(info.StatusCode))
+		}
+		span.End()
+	},
+}
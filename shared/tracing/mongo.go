@@ -0,0 +1,38 @@
+package tracing
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+)
+
+// MongoMonitor returns a command monitor that records a span per MongoDB
+// command and then passes each event on to next, which may be nil.
+// Pass it to options.Client().SetMonitor.
+func MongoMonitor(next *event.CommandMonitor) *event.CommandMonitor {
+	spans := otelmongo.NewMonitor()
+	if next == nil {
+		return spans
+	}
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			spans.Started(ctx, e)
+			if next.Started != nil {
+				next.Started(ctx, e)
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			spans.Succeeded(ctx, e)
+			if next.Succeeded != nil {
+				next.Succeeded(ctx, e)
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			spans.Failed(ctx, e)
+			if next.Failed != nil {
+				next.Failed(ctx, e)
+			}
+		},
+	}
+}
@@ -0,0 +1,80 @@
+// Package tracing sets up OpenTelemetry tracing and instruments incoming
+// HTTP requests, outgoing calls, gRPC and MongoDB commands. Trace context
+// travels between services in the W3C traceparent header.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation is the tracer name used for spans created by this package
+const instrumentation = "shared/tracing"
+
+// Config selects where spans are exported. Sampling follows the standard
+// OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG variables.
+type Config struct {
+	// Exporter is "otlp" to send spans over OTLP/HTTP, "stdout" to print
+	// them for local runs or "none" to disable tracing
+	Exporter string `env:"OTEL_TRACES_EXPORTER" default:"otlp"`
+	// Endpoint is the OTLP/HTTP collector URL
+	Endpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT" default:"http://localhost:4318"`
+}
+
+func (c Config) Validate() error {
+	switch c.Exporter {
+	case "otlp", "stdout", "none":
+		return nil
+	}
+	return fmt.Errorf("OTEL_TRACES_EXPORTER must be otlp, stdout or none, got %q", c.Exporter)
+}
+
+// Setup installs the global tracer provider and W3C propagators for service.
+// The returned function flushes pending spans and must be called on exit.
+func Setup(ctx context.Context, service string, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(service)))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
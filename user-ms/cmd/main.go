@@ -7,43 +7,149 @@
 package main
 
 import (
-	"log"
+	"context"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	usergrpc "user-ms/internal/user/adapter/grpc"
 	userhttp "user-ms/internal/user/adapter/http"
+	"user-ms/internal/user/adapter/mail"
+	usermetrics "user-ms/internal/user/adapter/metrics"
 	"user-ms/internal/user/adapter/mongo"
+	"user-ms/internal/user/adapter/oidc"
+	"user-ms/internal/user/adapter/upstream"
+	"user-ms/internal/user/domain"
 	"user-ms/internal/user/usecase"
 	"user-ms/pkg/config"
 
+	"shared/auth"
+	"shared/client"
+	"shared/grpcserver"
+	"shared/health"
+	"shared/logging"
+	"shared/metrics"
+	userv1 "shared/proto/user/v1"
+	"shared/server"
+	"shared/tracing"
+
 	"github.com/go-chi/chi/v5"
-	"github.com/joho/godotenv"
 
 	_ "user-ms/docs"
 
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
-func init() {
-	// Load environment variables from .env file
-	if err := godotenv.Load(); err != nil {
-		log.Println("⚠️  No .env file found or error loading .env")
+func main() {
+	cfg := config.Load()
+	logger := logging.Setup("user-ms", cfg.Log)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "user-ms", cfg.Tracing)
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "error", err)
 	}
-}
 
-func main() {
-	if err := godotenv.Load(); err != nil {
-		log.Println("⚠️ .env file not found. Using system environment variables.")
+	db := config.ConnectMongo(ctx, cfg.MongoURI)
+	userCol := db.Database(cfg.DBName).Collection("users")
+	tokenCol := db.Database(cfg.DBName).Collection("user_tokens")
+	attemptCol := db.Database(cfg.DBName).Collection("login_attempts")
+	sessionCol := db.Database(cfg.DBName).Collection("sessions")
+	apiKeyCol := db.Database(cfg.DBName).Collection("api_keys")
+	privacyJobCol := db.Database(cfg.DBName).Collection("privacy_jobs")
+	consentCol := db.Database(cfg.DBName).Collection("consents")
+
+	if err := mongo.EnsureIndexes(ctx, userCol); err != nil {
+		logging.Fatal("Failed to create user indexes", "error", err)
+	}
+	if err := mongo.EnsureTokenIndexes(ctx, tokenCol); err != nil {
+		logging.Fatal("Failed to create token indexes", "error", err)
+	}
+	if err := mongo.EnsureAttemptIndexes(ctx, attemptCol); err != nil {
+		logging.Fatal("Failed to create login attempt indexes", "error", err)
+	}
+	if err := mongo.EnsureSessionIndexes(ctx, sessionCol); err != nil {
+		logging.Fatal("Failed to create session indexes", "error", err)
+	}
+	if err := mongo.EnsureAPIKeyIndexes(ctx, apiKeyCol); err != nil {
+		logging.Fatal("Failed to create API key indexes", "error", err)
+	}
+	if err := mongo.EnsurePrivacyJobIndexes(ctx, privacyJobCol); err != nil {
+		logging.Fatal("Failed to create privacy job indexes", "error", err)
+	}
+	if err := mongo.EnsureConsentIndexes(ctx, consentCol); err != nil {
+		logging.Fatal("Failed to create consent indexes", "error", err)
 	}
 
-	db := config.ConnectMongo()
-	userCol := db.Database("userdb").Collection("users")
+	var mailer domain.Mailer = mail.NewSMTP(cfg.SMTPAddr, cfg.MailFrom, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPTimeout)
+	if cfg.MailSender == "memory" {
+		mailer = mail.NewMemory()
+	}
+
+	providers := oidc.NewProviders(cfg.OIDC(), &http.Client{
+		Transport: tracing.Transport(nil),
+		Timeout:   cfg.OIDCTimeout,
+	})
+
+	// The gateway and the other services sign their requests, see auth.ServiceKey
+	serviceKey := auth.NewServiceKey(cfg.Service.Secret)
+
+	// Exports and erasures collect the records other services hold about a user
+	newClient := func(name, baseURL string) *client.Client {
+		return client.New(name, baseURL,
+			client.WithTimeout(cfg.UpstreamTimeout),
+			client.WithBulkhead(10),
+			client.WithHooks(tracing.ClientHooks),
+			client.WithHooks(logging.ClientHooks),
+			client.WithHooks(metrics.ClientHooks),
+			client.WithHooks(serviceKey.ClientHooks()),
+		)
+	}
+	sources := []domain.PersonalDataSource{
+		upstream.NewSource("orders", newClient("order-ms", cfg.OrderURL)),
+		upstream.NewSource("payments", newClient("payment-ms", cfg.PaymentURL)),
+		upstream.NewSource("reviews", newClient("product-ms", cfg.ProductURL)),
+	}
 
 	repo := mongo.NewUserRepository(userCol)
-	uc := usecase.NewUserUseCase(repo)
+	uc := usecase.NewUserUseCase(repo, auth.NewTokenIssuer(cfg.JWTSecret, cfg.JWTTTL),
+		mongo.NewActionTokenRepository(tokenCol), mongo.NewLoginAttemptRepository(attemptCol),
+		mongo.NewSessionRepository(sessionCol), mongo.NewAPIKeyRepository(apiKeyCol), mongo.NewConsentRepository(consentCol),
+		providers, mongo.NewPrivacyJobRepository(privacyJobCol), sources,
+		mailer, usermetrics.NewSecurityEvents(), usecase.Options{
+			AppURL:               cfg.AppURL,
+			VerifyTTL:            cfg.VerifyTokenTTL,
+			ResetTTL:             cfg.ResetTokenTTL,
+			RequireVerifiedEmail: cfg.RequireVerifiedEmail,
+			TOTPIssuer:           cfg.TOTPIssuer,
+			TOTPRoles:            cfg.TOTPRoles,
+			ChallengeTTL:         cfg.ChallengeTTL,
+			Lockout: usecase.LockoutPolicy{
+				MaxFailures:   cfg.LoginMaxFailures,
+				MaxIPFailures: cfg.LoginMaxIPFailures,
+				Window:        cfg.LoginFailureWindow,
+				Lockout:       cfg.LoginLockout,
+				MaxLockout:    cfg.LoginMaxLockout,
+				Delay:         cfg.LoginDelay,
+				MaxDelay:      cfg.LoginMaxDelay,
+			},
+			AccessTTL:    cfg.JWTTTL,
+			RefreshTTL:   cfg.RefreshTokenTTL,
+			APIKeyGrace:  cfg.APIKeyRotationGrace,
+			OIDCStateTTL: cfg.OIDCStateTTL,
+			ExportTTL:    cfg.ExportTTL,
+		})
 	handler := userhttp.NewUserHandler(uc)
 
 	r := chi.NewRouter()
+	r.Use(logging.RequestID, tracing.Middleware, logging.AccessLog(logger), metrics.Middleware, auth.FromHeaders(serviceKey))
+
+	// ✅ Prometheus metrics
+	r.Handle("/metrics", metrics.Handler())
 
 	// ✅ Swagger route
 	r.Get("/swagger/*", httpSwagger.WrapHandler)
@@ -53,12 +159,32 @@ func main() {
 		handler.RegisterRoutes(r)
 	})
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT is not set in the environment")
-	}
-
-	log.Printf("🚀 Server running at http://localhost:%s\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	// ✅ Internal routes, called by the gateway and not exposed by it
+	handler.RegisterInternalRoutes(r)
+
+	// ✅ gRPC server for internal service-to-service calls
+	grpcServer, grpcHealth := grpcserver.New(serviceKey)
+	userv1.RegisterUserServiceServer(grpcServer, usergrpc.NewUserServer(uc))
+	grpcserver.MarkServing(grpcServer, grpcHealth)
+
+	checks := health.NewChecker(2 * time.Second)
+	checks.Add("mongo", health.Mongo(db))
+
+	// On SIGINT/SIGTERM: fail readiness, drain requests, then close Mongo and flush traces
+	srv := server.New(cfg.Server)
+	srv.BeforeDrain(checks.Shutdown)
+	srv.BeforeDrain(grpcHealth.Shutdown)
+	srv.OnShutdown("mongo", db.Disconnect)
+	srv.OnShutdown("tracing", shutdownTracing)
+
+	srv.ServeGRPC("grpc", grpcServer, cfg.GRPCPort)
+	srv.ServeHTTP("http", ":"+cfg.Port, checks.Handler(r))
+	srv.Go("privacy-jobs", func(ctx context.Context) error {
+		uc.RunPrivacyJobs(ctx, cfg.PrivacyJobInterval)
+		return nil
+	})
 
+	if err := srv.Wait(ctx); err != nil {
+		logging.Fatal("Stopped with errors", "error", err)
+	}
 }
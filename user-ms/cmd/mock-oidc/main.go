@@ -0,0 +1,310 @@
+// Command mock-oidc is an OpenID Connect provider for local development and
+// testing of the OIDC login of user-ms, so that no real identity service is
+// needed. Its login page signs in as any email typed into it, so it must
+// never be reachable in production.
+//
+// It implements the authorization code flow with PKCE (S256 only), client
+// authentication by client_secret_basic or client_secret_post, discovery
+// and a key set. The signing key is generated at startup.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	sharedconfig "shared/config"
+	"shared/logging"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Config holds the mock's settings. The names are prefixed so that running
+// it next to user-ms does not pick up user-ms's .env settings.
+type Config struct {
+	Port string `env:"MOCK_OIDC_PORT" default:"9000"`
+	// Issuer is the URL browsers reach the mock at, which user-ms expects
+	// in the ID tokens
+	Issuer       string `env:"MOCK_OIDC_ISSUER" default:"http://localhost:9000"`
+	ClientID     string `env:"MOCK_OIDC_CLIENT_ID" default:"user-ms"`
+	ClientSecret string `env:"MOCK_OIDC_CLIENT_SECRET" default:"dev-secret" secret:"true"`
+	// RedirectURIs are the callbacks the client may ask to be sent back to
+	RedirectURIs []string      `env:"MOCK_OIDC_REDIRECT_URIS" default:"http://localhost:8080/api/auth/oidc/mock/callback,http://localhost:8081/api/auth/oidc/mock/callback"`
+	TokenTTL     time.Duration `env:"MOCK_OIDC_TOKEN_TTL" default:"5m"`
+
+	Log logging.Config
+}
+
+// codeTTL is how long an authorization code can be redeemed
+const codeTTL = time.Minute
+
+// grant is what an authorization code was issued for
+type grant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	email       string
+	verified    bool
+	name        string
+	expiresAt   time.Time
+}
+
+type provider struct {
+	cfg   Config
+	key   *rsa.PrivateKey
+	keyID string
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+func main() {
+	var cfg Config
+	sharedconfig.MustLoad(&cfg)
+	logging.Setup("mock-oidc", cfg.Log)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		logging.Fatal("Failed to generate the signing key", "error", err)
+	}
+	p := &provider{cfg: cfg, key: key, keyID: randomString(8), grants: map[string]grant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	mux.HandleFunc("GET /authorize", p.loginPage)
+	mux.HandleFunc("POST /authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+
+	slog.Info("Mock OIDC provider listening", "port", cfg.Port, "issuer", cfg.Issuer, "client_id", cfg.ClientID)
+	if err := http.ListenAndServe(":"+cfg.Port, mux); err != nil {
+		logging.Fatal("Server stopped", "error", err)
+	}
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.cfg.Issuer,
+		"authorization_endpoint":                p.cfg.Issuer + "/authorize",
+		"token_endpoint":                        p.cfg.Issuer + "/token",
+		"jwks_uri":                              p.cfg.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": p.keyID,
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!doctype html>
+<html>
+<head><title>Mock OIDC login</title></head>
+<body style="font-family: sans-serif; max-width: 28em; margin: 4em auto">
+<h1>Mock OIDC login</h1>
+<p>Sign in as anyone: this provider is for local development only.</p>
+<form method="post" action="/authorize?{{.Query}}">
+<p><label>Email<br><input name="email" type="email" required autofocus></label></p>
+<p><label>Name<br><input name="name"></label></p>
+<p><label><input name="email_verified" type="checkbox" value="true" checked> Email verified</label></p>
+<p><button type="submit">Sign in</button></p>
+</form>
+</body>
+</html>
+`))
+
+// loginPage checks the authorization request and asks who to sign in as
+func (p *provider) loginPage(w http.ResponseWriter, r *http.Request) {
+	if !p.checkAuthorization(w, r.URL.Query()) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	loginTemplate.Execute(w, map[string]string{"Query": r.URL.RawQuery})
+}
+
+// checkAuthorization validates an authorization request. Until the
+// redirect URI is known good, errors are shown rather than redirected.
+func (p *provider) checkAuthorization(w http.ResponseWriter, q url.Values) bool {
+	if q.Get("client_id") != p.cfg.ClientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return false
+	}
+	if !slices.Contains(p.cfg.RedirectURIs, q.Get("redirect_uri")) {
+		http.Error(w, "redirect_uri is not registered", http.StatusBadRequest)
+		return false
+	}
+	switch {
+	case q.Get("response_type") != "code":
+		redirectError(w, q, "unsupported_response_type", "only the code flow is supported")
+	case !slices.Contains(strings.Fields(q.Get("scope")), "openid"):
+		redirectError(w, q, "invalid_scope", "the openid scope is required")
+	case q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256":
+		redirectError(w, q, "invalid_request", "a S256 code_challenge is required")
+	default:
+		return true
+	}
+	return false
+}
+
+// authorize signs in as the submitted user and sends the browser back with a code
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if !p.checkAuthorization(w, q) {
+		return
+	}
+	email := strings.TrimSpace(r.PostFormValue("email"))
+	if email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString(32)
+	p.mu.Lock()
+	p.grants[code] = grant{
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		email:       email,
+		verified:    r.PostFormValue("email_verified") == "true",
+		name:        strings.TrimSpace(r.PostFormValue("name")),
+		expiresAt:   time.Now().Add(codeTTL),
+	}
+	p.mu.Unlock()
+
+	redirect(w, q, url.Values{"code": {code}, "state": {q.Get("state")}})
+}
+
+// token redeems an authorization code for an ID token
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if id != p.cfg.ClientID || subtle.ConstantTimeCompare([]byte(secret), []byte(p.cfg.ClientSecret)) != 1 {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	code := r.PostFormValue("code")
+	p.mu.Lock()
+	g, found := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	switch {
+	case !found || time.Now().After(g.expiresAt):
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "the code is invalid, used or expired")
+		return
+	case g.redirectURI != r.PostFormValue("redirect_uri"):
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri does not match the authorization request")
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge:
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match the code_challenge")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            p.cfg.Issuer,
+		"sub":            subject(g.email),
+		"aud":            p.cfg.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(p.cfg.TokenTTL).Unix(),
+		"email":          g.email,
+		"email_verified": g.verified,
+	}
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	if g.name != "" {
+		claims["name"] = g.name
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.keyID
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{
+		// the mock has no resources to access, but the field is required
+		"access_token": randomString(32),
+		"token_type":   "Bearer",
+		"expires_in":   int64(p.cfg.TokenTTL.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+// subject derives a stable identifier from the email, so that signing in
+// with the same address again is the same provider account
+func subject(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(email)))
+	return "mock-" + hex.EncodeToString(sum[:8])
+}
+
+// redirectError sends the browser back to the client with an error
+func redirectError(w http.ResponseWriter, q url.Values, code, description string) {
+	redirect(w, q, url.Values{"error": {code}, "error_description": {description}, "state": {q.Get("state")}})
+}
+
+func redirect(w http.ResponseWriter, q url.Values, params url.Values) {
+	target, _ := url.Parse(q.Get("redirect_uri"))
+	query := target.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	target.RawQuery = query.Encode()
+	w.Header().Set("Location", target.String())
+	w.WriteHeader(http.StatusFound)
+}
+
+func tokenError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,123 @@
+package grpc
+
+import (
+	"context"
+
+	"user-ms/internal/user/domain"
+
+	"shared/grpcserver"
+	userv1 "shared/proto/user/v1"
+
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// UserServer exposes domain.UserUseCase over gRPC
+type UserServer struct {
+	userv1.UnimplementedUserServiceServer
+	useCase   domain.UserUseCase
+	validator *validator.Validate
+}
+
+func NewUserServer(useCase domain.UserUseCase) *UserServer {
+	return &UserServer{
+		useCase:   useCase,
+		validator: validator.New(),
+	}
+}
+
+func (s *UserServer) CreateUser(ctx context.Context, req *userv1.CreateUserRequest) (*userv1.User, error) {
+	in := domain.CreateUserRequest{
+		Name:     req.GetName(),
+		Email:    req.GetEmail(),
+		Password: req.GetPassword(),
+	}
+	if err := s.validator.Struct(in); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Validation failed: "+err.Error())
+	}
+
+	user, err := s.useCase.CreateUser(ctx, &domain.User{
+		Name:     in.Name,
+		Email:    in.Email,
+		Password: in.Password,
+	}, domain.Client{})
+	if err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+	return toProto(user), nil
+}
+
+func (s *UserServer) GetUser(ctx context.Context, req *userv1.GetUserRequest) (*userv1.User, error) {
+	if _, err := primitive.ObjectIDFromHex(req.GetId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid ID format")
+	}
+
+	user, err := s.useCase.GetUserByID(ctx, req.GetId(), false)
+	if err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+	return toProto(user), nil
+}
+
+func (s *UserServer) ListUsers(ctx context.Context, _ *userv1.ListUsersRequest) (*userv1.ListUsersResponse, error) {
+	users, _, err := s.useCase.GetAllUsers(ctx, domain.UserFilter{})
+	if err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+
+	res := &userv1.ListUsersResponse{Users: make([]*userv1.User, 0, len(users))}
+	for _, u := range users {
+		res.Users = append(res.Users, toProto(u))
+	}
+	return res, nil
+}
+
+func (s *UserServer) UpdateUser(ctx context.Context, req *userv1.UpdateUserRequest) (*userv1.User, error) {
+	objID, err := primitive.ObjectIDFromHex(req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid ID format")
+	}
+
+	in := domain.UpdateUserRequest{
+		Name:  req.GetName(),
+		Email: req.GetEmail(),
+	}
+	if err := s.validator.Struct(in); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Validation failed: "+err.Error())
+	}
+
+	user, err := s.useCase.UpdateUser(ctx, req.GetId(), domain.AnyVersion, &domain.User{
+		ID:    objID,
+		Name:  in.Name,
+		Email: in.Email,
+	}, "")
+	if err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+	return toProto(user), nil
+}
+
+func (s *UserServer) DeleteUser(ctx context.Context, req *userv1.DeleteUserRequest) (*emptypb.Empty, error) {
+	if _, err := primitive.ObjectIDFromHex(req.GetId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid ID format")
+	}
+
+	if err := s.useCase.DeleteUser(ctx, req.GetId(), domain.AnyVersion); err != nil {
+		return nil, grpcserver.Error(ctx, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func toProto(u *domain.User) *userv1.User {
+	return &userv1.User{
+		Id:        u.ID.Hex(),
+		Name:      u.Name,
+		Email:     u.Email,
+		Role:      u.Role,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+	}
+}
@@ -1,13 +1,29 @@
 package http
 
 import (
+	"archive/zip"
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 
 	"user-ms/internal/user/domain"
 
+	"shared/auth"
+	"shared/etag"
+	"shared/mergepatch"
+	"shared/problem"
+	"shared/ratelimit"
+
 	"github.com/go-chi/chi/v5"
 )
 
@@ -19,112 +35,286 @@ type UserHandler struct {
 func NewUserHandler(useCase domain.UserUseCase) *UserHandler {
 	return &UserHandler{
 		useCase:   useCase,
-		validator: validator.New(),
+		validator: problem.NewValidator(),
 	}
 }
 
 func (h *UserHandler) RegisterRoutes(r chi.Router) {
 	r.Route("/users", func(r chi.Router) {
 		r.Post("/", h.CreateUser)
-		r.Get("/", h.GetAllUsers)
+		r.With(auth.RequireRole(auth.RoleAdmin)).Get("/", h.GetAllUsers)
 		r.Get("/{id}", h.GetUserByID)
 		r.Put("/{id}", h.UpdateUser)
+		r.Patch("/{id}", h.PatchUser)
 		r.Delete("/{id}", h.DeleteUser)
+		r.Patch("/{id}/profile", h.UpdateProfile)
+		r.Get("/{id}/consents", h.GetConsents)
+		r.With(auth.RequireRole(auth.RoleAdmin)).Post("/{id}/restore", h.RestoreUser)
+		r.With(auth.RequireRole(auth.RoleAdmin)).Delete("/{id}/2fa", h.ResetTOTP)
+		r.With(auth.RequireRole(auth.RoleAdmin)).Post("/{id}/unlock", h.UnlockUser)
+		r.With(auth.RequireRole(auth.RoleAdmin)).Get("/{id}/sessions", h.GetUserSessions)
+		r.With(auth.RequireRole(auth.RoleAdmin)).Delete("/{id}/sessions", h.RevokeUserSessions)
+		r.Post("/{id}/export", h.RequestExport)
+		r.Post("/{id}/erasure", h.RequestErasure)
+		r.Get("/{id}/privacy-jobs", h.GetPrivacyJobs)
+		r.Get("/{id}/privacy-jobs/{jobID}", h.GetPrivacyJob)
+		r.Get("/{id}/privacy-jobs/{jobID}/archive", h.DownloadArchive)
+	})
+	r.Route("/auth", func(r chi.Router) {
+		r.Post("/login", h.Login)
+		r.Post("/verify-email", h.VerifyEmail)
+		r.Post("/verify-email/resend", h.ResendVerification)
+		r.Post("/password-reset", h.RequestPasswordReset)
+		r.Post("/password-reset/confirm", h.ResetPassword)
+		r.Post("/2fa/verify", h.VerifyTOTP)
+		r.Post("/2fa/totp", h.EnrollTOTP)
+		r.Post("/2fa/totp/confirm", h.ConfirmTOTP)
+		r.Post("/2fa/totp/disable", h.DisableTOTP)
+		r.Post("/2fa/recovery-codes", h.RegenerateRecoveryCodes)
+		r.Post("/refresh", h.Refresh)
+		r.Post("/logout", h.Logout)
+		r.Get("/sessions", h.GetSessions)
+		r.Delete("/sessions", h.RevokeSessions)
+		r.Delete("/sessions/{id}", h.RevokeSession)
+		r.Get("/oidc", h.GetOIDCProviders)
+		r.Get("/oidc/{provider}/login", h.StartOIDCLogin)
+		r.Get("/oidc/{provider}/callback", h.OIDCCallback)
 	})
+	// API keys cannot manage API keys: no key is granted this scope
+	r.With(auth.RequireRole(auth.RoleAdmin), auth.RequireScope("api-keys")).Route("/api-keys", func(r chi.Router) {
+		r.Post("/", h.CreateAPIKey)
+		r.Get("/", h.GetAPIKeys)
+		r.Get("/{id}", h.GetAPIKey)
+		r.Delete("/{id}", h.RevokeAPIKey)
+		r.Post("/{id}/rotate", h.RotateAPIKey)
+	})
+}
+
+// RegisterInternalRoutes registers the routes for other services, which the
+// gateway does not expose
+func (h *UserHandler) RegisterInternalRoutes(r chi.Router) {
+	r.With(auth.RequireService).Get("/internal/revoked-sessions", h.RevokedSessions)
+	r.With(auth.RequireService).Post("/internal/api-keys/verify", h.VerifyAPIKey)
 }
 
 // CreateUser godoc
 // @Summary Create a new user
-// @Description Create user with name, email, and age
+// @Description Signs a user up with name, email and password, and optionally their profile and marketing consent. The consent is recorded with the client's address and user agent.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param request body domain.CreateUserRequest true "Create User"
 // @Success 200 {object} domain.User
-// @Failure 400 {string} string "Invalid input"
-// @Failure 500 {string} string "Internal server error"
+// @Header 200 {string} ETag "Version of the user"
+// @Failure 400 {object} problem.Problem "Invalid input"
+// @Failure 409 {object} problem.Problem "Email already registered"
+// @Failure 500 {object} problem.Problem "Internal server error"
 // @Router /users [post]
 func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	var req domain.CreateUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
 		return
 	}
 
 	if err := h.validator.Struct(req); err != nil {
-		http.Error(w, "Validation failed: "+err.Error(), http.StatusBadRequest)
+		problem.Error(w, r, err)
 		return
 	}
 
 	user, err := h.useCase.CreateUser(r.Context(), &domain.User{
-		Name:  req.Name,
-		Email: req.Email,
-	})
+		Name:     req.Name,
+		Email:    req.Email,
+		Password: req.Password,
+		Profile: domain.Profile{
+			Phone:     req.Phone,
+			BirthDate: req.BirthDate,
+			Locale:    req.Locale,
+			Currency:  req.Currency,
+		},
+		MarketingConsent: domain.MarketingConsent{Granted: req.MarketingConsent},
+	}, client(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Error(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag.Version(user.Version))
 	json.NewEncoder(w).Encode(user)
 }
 
 // GetUserByID godoc
 // @Summary Get a user by ID
+// @Description For the user themself or admins.
 // @Tags users
 // @Produce json
 // @Param id path string true "User ID"
+// @Param include_deleted query bool false "Also find a soft-deleted user (admins only)"
+// @Param If-None-Match header string false "ETag of a cached copy"
 // @Success 200 {object} domain.User
-// @Failure 404 {string} string "User not found"
+// @Header 200 {string} ETag "Version of the user"
+// @Success 304 "Not modified"
+// @Failure 400 {object} problem.Problem "Malformed ID"
+// @Failure 401 {object} problem.Problem "Not authenticated"
+// @Failure 403 {object} problem.Problem "Neither the user nor an admin, or include_deleted without admin role"
+// @Failure 404 {object} problem.Problem "User not found"
 // @Router /users/{id} [get]
 func (h *UserHandler) GetUserByID(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	user, err := h.useCase.GetUserByID(r.Context(), id)
+	id, ok := selfOrAdmin(w, r)
+	if !ok {
+		return
+	}
+	includeDeleted, ok := auth.AdminFlag(w, r, "include_deleted")
+	if !ok {
+		return
+	}
+	user, err := h.useCase.GetUserByID(r.Context(), id, includeDeleted)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		problem.Error(w, r, err)
 		return
 	}
-	json.NewEncoder(w).Encode(user)
+	etag.JSON(w, r, etag.Version(user.Version), user)
 }
 
+// Page sizes of GetAllUsers
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
 // GetAllUsers godoc
-// @Summary Get all users
+// @Summary Search users
+// @Description Lists users in a stable order, a page at a time. When there are more, the Link header holds the URL of the next page (rel="next"). Admins only.
 // @Tags users
 // @Produce json
+// @Param email query string false "Email prefix, case-insensitive"
+// @Param name query string false "Name prefix, case-insensitive"
+// @Param role query string false "Role" Enums(customer, admin)
+// @Param status query string false "active (default), deleted or any" Enums(active, deleted, any)
+// @Param created_after query string false "Created at or after this RFC 3339 time"
+// @Param created_before query string false "Created before this RFC 3339 time"
+// @Param limit query int false "Page size, 1-200" default(50)
+// @Param cursor query string false "Opaque cursor from the next link"
+// @Param include_deleted query bool false "Same as status=any"
+// @Param If-None-Match header string false "ETag of a cached copy"
 // @Success 200 {array} domain.User
-// @Failure 500 {string} string "Internal server error"
+// @Header 200 {string} Link "URL of the next page"
+// @Success 304 "Not modified"
+// @Failure 400 {object} problem.Problem "Invalid query parameter"
+// @Failure 401 {object} problem.Problem "Not authenticated"
+// @Failure 403 {object} problem.Problem "Not an admin"
+// @Failure 500 {object} problem.Problem "Internal server error"
 // @Router /users [get]
 func (h *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := h.useCase.GetAllUsers(r.Context())
+	includeDeleted, ok := auth.AdminFlag(w, r, "include_deleted")
+	if !ok {
+		return
+	}
+	filter, err := userFilter(r.URL.Query())
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	if includeDeleted && filter.Status == "" {
+		filter.Status = domain.StatusAny
+	}
+
+	users, next, err := h.useCase.GetAllUsers(r.Context(), filter)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Error(w, r, err)
 		return
 	}
-	json.NewEncoder(w).Encode(users)
+	if next != "" {
+		q := r.URL.Query()
+		q.Set("cursor", next)
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, q.Encode()))
+	}
+	etag.JSON(w, r, "", users)
+}
+
+// userFilter parses the query parameters of GetAllUsers
+func userFilter(q url.Values) (domain.UserFilter, error) {
+	f := domain.UserFilter{
+		EmailPrefix: q.Get("email"),
+		NamePrefix:  q.Get("name"),
+		Role:        q.Get("role"),
+		Status:      q.Get("status"),
+		After:       q.Get("cursor"),
+		Limit:       defaultPageSize,
+	}
+	switch f.Role {
+	case "", auth.RoleCustomer, auth.RoleAdmin:
+	default:
+		return f, fmt.Errorf("%w: unknown role %q", domain.ErrValidation, f.Role)
+	}
+	switch f.Status {
+	case "", domain.StatusActive, domain.StatusDeleted, domain.StatusAny:
+	default:
+		return f, fmt.Errorf("%w: status must be active, deleted or any", domain.ErrValidation)
+	}
+
+	for name, bound := range map[string]*int64{
+		"created_after":  &f.CreatedAfter,
+		"created_before": &f.CreatedBefore,
+	} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, fmt.Errorf("%w: %s must be an RFC 3339 time", domain.ErrValidation, name)
+			}
+			*bound = t.Unix()
+		}
+	}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			return f, fmt.Errorf("%w: limit must be between 1 and %d", domain.ErrValidation, maxPageSize)
+		}
+		f.Limit = n
+	}
+	return f, nil
 }
 
 // UpdateUser godoc
 // @Summary Update a user by ID
+// @Description For the user themself or admins. A user changing their email must send their current password.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param id path string true "User ID"
-// @Param request body domain.CreateUserRequest true "Update User"
+// @Param If-Match header string true "ETag of the user, or *"
+// @Param request body domain.UpdateUserRequest true "Update User"
 // @Success 200 {object} domain.User
-// @Failure 400 {string} string "Invalid input"
-// @Failure 500 {string} string "Internal server error"
+// @Header 200 {string} ETag "New version of the user"
+// @Failure 400 {object} problem.Problem "Invalid input"
+// @Failure 401 {object} problem.Problem "Not authenticated"
+// @Failure 403 {object} problem.Problem "Neither the user nor an admin, or the current password is missing or wrong"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 409 {object} problem.Problem "Email already registered"
+// @Failure 412 {object} problem.Problem "User modified since it was read"
+// @Failure 428 {object} problem.Problem "If-Match missing"
+// @Failure 500 {object} problem.Problem "Internal server error"
 // @Router /users/{id} [put]
 func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
+	id, ok := selfOrAdmin(w, r)
+	if !ok {
+		return
+	}
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
+		return
+	}
 
-	var req domain.CreateUserRequest
+	var req domain.UpdateUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
 		return
 	}
 
 	// ✅ Validate the request
 	if err := h.validator.Struct(req); err != nil {
-		http.Error(w, "Validation failed: "+err.Error(), http.StatusBadRequest)
+		problem.Error(w, r, err)
 		return
 	}
 
@@ -133,29 +323,1262 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		Email: req.Email,
 	}
 
-	updatedUser, err := h.useCase.UpdateUser(r.Context(), id, user)
+	updatedUser, err := h.useCase.UpdateUser(r.Context(), id, version, user, req.CurrentPassword)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag.Version(updatedUser.Version))
+	json.NewEncoder(w).Encode(updatedUser)
+}
+
+// PatchUser godoc
+// @Summary Partially update a user by ID
+// @Description Applies an RFC 7396 JSON merge patch: present fields are replaced, omitted fields are kept. The merged user must still be valid. For the user themself or admins; a user changing their email must send their current password.
+// @Tags users
+// @Accept application/merge-patch+json
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param If-Match header string true "ETag of the user, or *"
+// @Param request body domain.UpdateUserRequest true "Fields to change"
+// @Success 200 {object} domain.User
+// @Header 200 {string} ETag "New version of the user"
+// @Failure 400 {object} problem.Problem "Invalid patch or result"
+// @Failure 401 {object} problem.Problem "Not authenticated"
+// @Failure 403 {object} problem.Problem "Neither the user nor an admin, or the current password is missing or wrong"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 409 {object} problem.Problem "Email already registered"
+// @Failure 412 {object} problem.Problem "User modified since it was read"
+// @Failure 415 {object} problem.Problem "Unsupported media type"
+// @Failure 428 {object} problem.Problem "If-Match missing"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /users/{id} [patch]
+func (h *UserHandler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := selfOrAdmin(w, r)
+	if !ok {
+		return
+	}
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	patch, ok := mergepatch.Read(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.useCase.GetUserByID(r.Context(), id, false)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	req := domain.UpdateUserRequest{Name: user.Name, Email: user.Email}
+	fields, err := mergepatch.Struct(&req, patch)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Error(w, r, err)
+		return
+	}
+	if err := h.validator.Struct(req); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	updatedUser, err := h.useCase.PatchUser(r.Context(), id, version, fields, req.CurrentPassword)
+	if err != nil {
+		problem.Error(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag.Version(updatedUser.Version))
 	json.NewEncoder(w).Encode(updatedUser)
 }
 
+// UpdateProfile godoc
+// @Summary Update a user's profile
+// @Description Applies an RFC 7396 JSON merge patch to the name, profile and marketing consent; the email and password cannot be changed here. Giving or withdrawing the consent is recorded in the consent history, with the client's address and user agent. For the user themself or admins.
+// @Tags users
+// @Accept application/merge-patch+json
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param If-Match header string true "ETag of the user, or *"
+// @Param request body domain.ProfileRequest true "Fields to change"
+// @Success 200 {object} domain.User
+// @Header 200 {string} ETag "New version of the user"
+// @Failure 400 {object} problem.Problem "Invalid patch or result"
+// @Failure 401 {object} problem.Problem "Not authenticated"
+// @Failure 403 {object} problem.Problem "Neither the user nor an admin"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 412 {object} problem.Problem "User modified since it was read"
+// @Failure 415 {object} problem.Problem "Unsupported media type"
+// @Failure 428 {object} problem.Problem "If-Match missing"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /users/{id}/profile [patch]
+func (h *UserHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	id, ok := selfOrAdmin(w, r)
+	if !ok {
+		return
+	}
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	patch, ok := mergepatch.Read(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.useCase.GetUserByID(r.Context(), id, false)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	req := domain.NewProfileRequest(user)
+	fields, err := mergepatch.Struct(&req, patch)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	if err := h.validator.Struct(req); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	updatedUser, err := h.useCase.UpdateProfile(r.Context(), id, version, fields, req.MarketingConsent, client(r))
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag.Version(updatedUser.Version))
+	json.NewEncoder(w).Encode(updatedUser)
+}
+
+// GetConsents godoc
+// @Summary List a user's consent history
+// @Description Lists every time the user gave or withdrew a consent, newest first. For the user themself or admins.
+// @Tags users
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {array} domain.ConsentRecord
+// @Failure 401 {object} problem.Problem "Not authenticated"
+// @Failure 403 {object} problem.Problem "Neither the user nor an admin"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /users/{id}/consents [get]
+func (h *UserHandler) GetConsents(w http.ResponseWriter, r *http.Request) {
+	id, ok := selfOrAdmin(w, r)
+	if !ok {
+		return
+	}
+	records, err := h.useCase.ListConsents(r.Context(), id)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
 // DeleteUser godoc
 // @Summary Delete a user by ID
+// @Description Soft-deletes the user: it disappears from every query but can be restored by an admin. For the user themself or admins.
 // @Tags users
 // @Produce plain
 // @Param id path string true "User ID"
+// @Param If-Match header string true "ETag of the user, or *"
 // @Success 204 {string} string "No Content"
-// @Failure 500 {string} string "Internal server error"
+// @Failure 401 {object} problem.Problem "Not authenticated"
+// @Failure 403 {object} problem.Problem "Neither the user nor an admin"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 412 {object} problem.Problem "User modified since it was read"
+// @Failure 428 {object} problem.Problem "If-Match missing"
+// @Failure 500 {object} problem.Problem "Internal server error"
 // @Router /users/{id} [delete]
 func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := selfOrAdmin(w, r)
+	if !ok {
+		return
+	}
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
+		return
+	}
+	err := h.useCase.DeleteUser(r.Context(), id, version)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreUser godoc
+// @Summary Restore a deleted user
+// @Description Undoes a soft delete. Admins only.
+// @Tags users
+// @Produce json
+// @Param id path string true "User ID"
+// @Param If-Match header string true "ETag of the deleted user, or *"
+// @Success 200 {object} domain.User
+// @Header 200 {string} ETag "New version of the user"
+// @Failure 403 {object} problem.Problem "Not an admin"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 409 {object} problem.Problem "User is not deleted"
+// @Failure 412 {object} problem.Problem "User modified since it was read"
+// @Failure 428 {object} problem.Problem "If-Match missing"
+// @Router /users/{id}/restore [post]
+func (h *UserHandler) RestoreUser(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	err := h.useCase.DeleteUser(r.Context(), id)
+	version, ok := etag.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.useCase.RestoreUser(r.Context(), id, version)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag.Version(user.Version))
+	json.NewEncoder(w).Encode(user)
+}
+
+// Login godoc
+// @Summary Log in with email and password
+// @Description Returns a JWT access token for the gateway and other services, and a refresh token for /auth/refresh; each login is a session. Users with two-factor authentication, or whose role requires it, get 202 with a challenge instead: complete it at /auth/2fa/verify, or enroll at /auth/2fa/totp.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body domain.LoginRequest true "Credentials"
+// @Success 200 {object} domain.TokenResponse
+// @Success 202 {object} domain.Challenge "Second factor required"
+// @Failure 400 {object} problem.Problem "Invalid input"
+// @Failure 401 {object} problem.Problem "Invalid email or password"
+// @Failure 403 {object} problem.Problem "Email not verified, when verification is required"
+// @Failure 429 {object} problem.Problem "Locked out after too many failures"
+// @Header 429 {integer} Retry-After "Seconds until the lockout ends"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /auth/login [post]
+func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var req domain.LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	result, err := h.useCase.Login(r.Context(), req.Email, req.Password, client(r))
+	if err != nil {
+		loginError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Challenge != nil {
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(result.Challenge)
+		return
+	}
+	json.NewEncoder(w).Encode(result.Token)
+}
+
+// VerifyEmail godoc
+// @Summary Verify an email address
+// @Description Consumes the token from the link mailed at signup or after an email change
+// @Tags auth
+// @Accept json
+// @Param request body domain.VerifyEmailRequest true "Token from the email"
+// @Success 204 "Email verified"
+// @Failure 400 {object} problem.Problem "Invalid, used or expired token"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /auth/verify-email [post]
+func (h *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req domain.VerifyEmailRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	if err := h.useCase.VerifyEmail(r.Context(), req.Token); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResendVerification godoc
+// @Summary Resend the verification email
+// @Description Mails a new verification link if the address belongs to an unverified user. The response is the same either way.
+// @Tags auth
+// @Accept json
+// @Param request body domain.EmailRequest true "Address to verify"
+// @Success 202 "Accepted"
+// @Failure 400 {object} problem.Problem "Invalid input"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /auth/verify-email/resend [post]
+func (h *UserHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req domain.EmailRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	if err := h.useCase.ResendVerification(r.Context(), req.Email); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// RequestPasswordReset godoc
+// @Summary Request a password reset
+// @Description Mails a password reset link if the address is registered. The response is the same either way.
+// @Tags auth
+// @Accept json
+// @Param request body domain.EmailRequest true "Address of the account"
+// @Success 202 "Accepted"
+// @Failure 400 {object} problem.Problem "Invalid input"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /auth/password-reset [post]
+func (h *UserHandler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req domain.EmailRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	if err := h.useCase.RequestPasswordReset(r.Context(), req.Email); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword godoc
+// @Summary Reset a password
+// @Description Sets a new password with the token from a reset email. The token and any older ones stop working.
+// @Tags auth
+// @Accept json
+// @Param request body domain.ResetPasswordRequest true "Token and new password"
+// @Success 204 "Password changed"
+// @Failure 400 {object} problem.Problem "Invalid input, or invalid, used or expired token"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /auth/password-reset/confirm [post]
+func (h *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req domain.ResetPasswordRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	if err := h.useCase.ResetPassword(r.Context(), req.Token, req.Password); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VerifyTOTP godoc
+// @Summary Complete a login with a second factor
+// @Description Exchanges a totp challenge from /auth/login and a code from the authenticator app, or an unused recovery code, for an access token. The challenge is used up even when the code is wrong.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body domain.VerifyTOTPRequest true "Challenge and code"
+// @Success 200 {object} domain.TokenResponse
+// @Failure 400 {object} problem.Problem "Invalid input"
+// @Failure 401 {object} problem.Problem "Invalid challenge or code"
+// @Failure 429 {object} problem.Problem "Locked out after too many failures"
+// @Header 429 {integer} Retry-After "Seconds until the lockout ends"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /auth/2fa/verify [post]
+func (h *UserHandler) VerifyTOTP(w http.ResponseWriter, r *http.Request) {
+	var req domain.VerifyTOTPRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	token, err := h.useCase.VerifyLogin(r.Context(), req.Challenge, req.Code, client(r))
+	if err != nil {
+		loginError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(token)
+}
+
+// EnrollTOTP godoc
+// @Summary Start a TOTP enrollment
+// @Description Generates a TOTP secret for the caller, identified by their bearer token or by a totp_enrollment challenge from /auth/login. Add it to an authenticator app, e.g. by showing otpauth_uri as a QR code, then confirm with a code.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body domain.EnrollTOTPRequest false "Challenge, when enrolling during login"
+// @Success 200 {object} domain.TOTPEnrollment
+// @Failure 401 {object} problem.Problem "Not authenticated, or invalid challenge"
+// @Failure 409 {object} problem.Problem "Already enabled"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /auth/2fa/totp [post]
+func (h *UserHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	var req domain.EnrollTOTPRequest
+	if !h.decodeOptional(w, r, &req) {
+		return
+	}
+	userID, ok := h.enrollingUser(w, r, req.Challenge)
+	if !ok {
+		return
+	}
+
+	enrollment, err := h.useCase.EnrollTOTP(r.Context(), userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// ConfirmTOTP godoc
+// @Summary Confirm a TOTP enrollment
+// @Description Enables two-factor authentication with a code from the new secret. Returns recovery codes, shown only this once, and when enrolling during login also the access token.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body domain.ConfirmTOTPRequest true "Code, and the challenge when enrolling during login"
+// @Success 200 {object} domain.ConfirmTOTPResponse
+// @Failure 400 {object} problem.Problem "Invalid input"
+// @Failure 401 {object} problem.Problem "Not authenticated, or invalid challenge or code"
+// @Failure 409 {object} problem.Problem "No enrollment started, or already enabled"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /auth/2fa/totp/confirm [post]
+func (h *UserHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req domain.ConfirmTOTPRequest
+	if !h.decode(w, r, &req) {
 		return
 	}
+	userID, ok := h.enrollingUser(w, r, req.Challenge)
+	if !ok {
+		return
+	}
+
+	codes, err := h.useCase.ConfirmTOTP(r.Context(), userID, req.Code)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	res := domain.ConfirmTOTPResponse{RecoveryCodes: codes}
+	if req.Challenge != "" {
+		if res.TokenResponse, err = h.useCase.FinishEnrollment(r.Context(), req.Challenge, client(r)); err != nil {
+			problem.Error(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// DisableTOTP godoc
+// @Summary Disable two-factor authentication
+// @Description Turns 2FA off for the caller after checking a code or recovery code. Not allowed for roles that require 2FA.
+// @Tags auth
+// @Accept json
+// @Param request body domain.TOTPCodeRequest true "Current code"
+// @Success 204 "Disabled"
+// @Failure 401 {object} problem.Problem "Not authenticated, or invalid code"
+// @Failure 403 {object} problem.Problem "2FA required for the caller's role"
+// @Failure 409 {object} problem.Problem "Not enabled"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /auth/2fa/totp/disable [post]
+func (h *UserHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+	var req domain.TOTPCodeRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	if err := h.useCase.DisableTOTP(r.Context(), userID, req.Code); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// RegenerateRecoveryCodes godoc
+// @Summary Replace the recovery codes
+// @Description Invalidates the caller's recovery codes and returns new ones after checking a code
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body domain.TOTPCodeRequest true "Current code"
+// @Success 200 {object} domain.RecoveryCodesResponse
+// @Failure 401 {object} problem.Problem "Not authenticated, or invalid code"
+// @Failure 409 {object} problem.Problem "2FA not enabled"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /auth/2fa/recovery-codes [post]
+func (h *UserHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+	var req domain.TOTPCodeRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	codes, err := h.useCase.RegenerateRecoveryCodes(r.Context(), userID, req.Code)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(domain.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// ResetTOTP godoc
+// @Summary Reset a user's two-factor authentication
+// @Description Turns 2FA off for a user who lost their device and recovery codes. If their role requires 2FA, their next login asks them to enroll again. Admins only.
+// @Tags users
+// @Param id path string true "User ID"
+// @Success 204 "Reset"
+// @Failure 400 {object} problem.Problem "Malformed ID"
+// @Failure 403 {object} problem.Problem "Not an admin"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /users/{id}/2fa [delete]
+func (h *UserHandler) ResetTOTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.useCase.ResetTOTP(r.Context(), chi.URLParam(r, "id")); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnlockUser godoc
+// @Summary Unlock a user's login
+// @Description Lifts a lockout of the user's account after failed logins and forgets the failures. Lockouts of client IPs expire on their own. Admins only.
+// @Tags users
+// @Param id path string true "User ID"
+// @Success 204 "Unlocked"
+// @Failure 400 {object} problem.Problem "Malformed ID"
+// @Failure 403 {object} problem.Problem "Not an admin"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /users/{id}/unlock [post]
+func (h *UserHandler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	if err := h.useCase.UnlockUser(r.Context(), chi.URLParam(r, "id")); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Refresh godoc
+// @Summary Refresh an access token
+// @Description Exchanges a refresh token for a new access token and refresh token. Each refresh token works once: presenting one again revokes its whole session, since it must have been copied.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body domain.RefreshRequest true "Refresh token"
+// @Success 200 {object} domain.TokenResponse
+// @Failure 400 {object} problem.Problem "Invalid input"
+// @Failure 401 {object} problem.Problem "Invalid, used, revoked or expired refresh token"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /auth/refresh [post]
+func (h *UserHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req domain.RefreshRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	token, err := h.useCase.Refresh(r.Context(), req.RefreshToken, client(r))
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(token)
+}
+
+// Logout godoc
+// @Summary Log out
+// @Description Revokes the session of the caller's token, along with its refresh token
+// @Tags auth
+// @Success 204 "Logged out"
+// @Failure 401 {object} problem.Problem "Not authenticated"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /auth/logout [post]
+func (h *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	caller, ok := auth.FromContext(r.Context())
+	if !ok {
+		problem.HTTP(w, r, http.StatusUnauthorized, "Authentication required", nil)
+		return
+	}
+
+	// tokens from before sessions have none to revoke
+	if caller.SessionID != "" {
+		err := h.useCase.RevokeSession(r.Context(), caller.UserID, caller.SessionID, domain.RevokedLogout)
+		if err != nil && !errors.Is(err, domain.ErrSessionNotFound) {
+			problem.Error(w, r, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetSessions godoc
+// @Summary List your sessions
+// @Description Lists the caller's live sessions, most recently used first, with the device and address each was last used from. The one of the caller's token is marked current.
+// @Tags auth
+// @Produce json
+// @Success 200 {array} domain.Session
+// @Failure 401 {object} problem.Problem "Not authenticated"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /auth/sessions [get]
+func (h *UserHandler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	caller, ok := auth.FromContext(r.Context())
+	if !ok {
+		problem.HTTP(w, r, http.StatusUnauthorized, "Authentication required", nil)
+		return
+	}
+
+	sessions, err := h.useCase.ListSessions(r.Context(), caller.UserID)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	for _, s := range sessions {
+		s.Current = s.ID.Hex() == caller.SessionID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSession godoc
+// @Summary Revoke one of your sessions
+// @Description Logs a device out: its refresh token stops working at once, and its access token within seconds
+// @Tags auth
+// @Param id path string true "Session ID"
+// @Success 204 "Revoked"
+// @Failure 401 {object} problem.Problem "Not authenticated"
+// @Failure 404 {object} problem.Problem "No such live session of the caller"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /auth/sessions/{id} [delete]
+func (h *UserHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.useCase.RevokeSession(r.Context(), userID, chi.URLParam(r, "id"), domain.RevokedByUser); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeSessions godoc
+// @Summary Revoke all your sessions
+// @Description Logs out everywhere, including the caller's own session
+// @Tags auth
+// @Success 204 "Revoked"
+// @Failure 401 {object} problem.Problem "Not authenticated"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /auth/sessions [delete]
+func (h *UserHandler) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.useCase.RevokeSessions(r.Context(), userID, domain.RevokedByUser); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetUserSessions godoc
+// @Summary List a user's sessions
+// @Tags users
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {array} domain.Session
+// @Failure 403 {object} problem.Problem "Not an admin"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /users/{id}/sessions [get]
+func (h *UserHandler) GetUserSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := h.useCase.ListSessions(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeUserSessions godoc
+// @Summary Revoke a user's sessions
+// @Description Logs the user out everywhere, e.g. after their token was stolen. Admins only.
+// @Tags users
+// @Param id path string true "User ID"
+// @Success 204 "Revoked"
+// @Failure 403 {object} problem.Problem "Not an admin"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /users/{id}/sessions [delete]
+func (h *UserHandler) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	if err := h.useCase.RevokeSessions(r.Context(), chi.URLParam(r, "id"), domain.RevokedByAdmin); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RequestExport godoc
+// @Summary Export a user's data
+// @Description Queues an export of everything stored about the user: the profile, sessions, consents, orders, payments and product reviews. Poll the returned job; once completed, its archive can be downloaded until it expires. While an export is queued, requesting another returns it. For the user themself or admins.
+// @Tags privacy
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 202 {object} domain.PrivacyJob
+// @Header 202 {string} Location "URL of the job"
+// @Failure 401 {object} problem.Problem "Not authenticated"
+// @Failure 403 {object} problem.Problem "Neither the user nor an admin"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /users/{id}/export [post]
+func (h *UserHandler) RequestExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := selfOrAdmin(w, r)
+	if !ok {
+		return
+	}
+	job, err := h.useCase.RequestExport(r.Context(), userID)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	writeJobAccepted(w, job)
+}
+
+// RequestErasure godoc
+// @Summary Erase a user
+// @Description Deletes the user and ends their sessions at once, and queues the erasure of their data: their orders, payments and product reviews are kept but anonymized, then everything user-ms stores about them is deleted for good. Poll the returned job for its progress. For the user themself or admins.
+// @Tags privacy
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 202 {object} domain.PrivacyJob
+// @Header 202 {string} Location "URL of the job"
+// @Failure 401 {object} problem.Problem "Not authenticated"
+// @Failure 403 {object} problem.Problem "Neither the user nor an admin"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /users/{id}/erasure [post]
+func (h *UserHandler) RequestErasure(w http.ResponseWriter, r *http.Request) {
+	userID, ok := selfOrAdmin(w, r)
+	if !ok {
+		return
+	}
+	job, err := h.useCase.RequestErasure(r.Context(), userID)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	writeJobAccepted(w, job)
+}
+
+// writeJobAccepted answers a request queued as job
+func writeJobAccepted(w http.ResponseWriter, job *domain.PrivacyJob) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/users/"+url.PathEscape(job.UserID)+"/privacy-jobs/"+job.ID.Hex())
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetPrivacyJobs godoc
+// @Summary List a user's privacy jobs
+// @Description Lists the user's exports and erasures, newest first. Expired exports are removed. For the user themself or admins.
+// @Tags privacy
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {array} domain.PrivacyJob
+// @Failure 401 {object} problem.Problem "Not authenticated"
+// @Failure 403 {object} problem.Problem "Neither the user nor an admin"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /users/{id}/privacy-jobs [get]
+func (h *UserHandler) GetPrivacyJobs(w http.ResponseWriter, r *http.Request) {
+	userID, ok := selfOrAdmin(w, r)
+	if !ok {
+		return
+	}
+	jobs, err := h.useCase.ListPrivacyJobs(r.Context(), userID)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// GetPrivacyJob godoc
+// @Summary Get a privacy job
+// @Description For the user themself or admins.
+// @Tags privacy
+// @Produce json
+// @Param id path string true "User ID"
+// @Param jobID path string true "Job ID"
+// @Success 200 {object} domain.PrivacyJob
+// @Failure 401 {object} problem.Problem "Not authenticated"
+// @Failure 403 {object} problem.Problem "Neither the user nor an admin"
+// @Failure 404 {object} problem.Problem "Job not found"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /users/{id}/privacy-jobs/{jobID} [get]
+func (h *UserHandler) GetPrivacyJob(w http.ResponseWriter, r *http.Request) {
+	userID, ok := selfOrAdmin(w, r)
+	if !ok {
+		return
+	}
+	job, err := h.useCase.GetPrivacyJob(r.Context(), userID, chi.URLParam(r, "jobID"))
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// DownloadArchive godoc
+// @Summary Download an export
+// @Description Downloads the archive of a completed export: a ZIP with a JSON file per part (user, sessions, consents, orders, payments, reviews), or the parts as one JSON object. For the user themself or admins.
+// @Tags privacy
+// @Produce application/zip
+// @Produce json
+// @Param id path string true "User ID"
+// @Param jobID path string true "Job ID"
+// @Param format query string false "Archive format" Enums(zip, json) default(zip)
+// @Success 200 {file} file "The archive"
+// @Failure 400 {object} problem.Problem "Unknown format"
+// @Failure 401 {object} problem.Problem "Not authenticated"
+// @Failure 403 {object} problem.Problem "Neither the user nor an admin"
+// @Failure 404 {object} problem.Problem "Job not found or expired"
+// @Failure 409 {object} problem.Problem "Not a completed export"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /users/{id}/privacy-jobs/{jobID}/archive [get]
+func (h *UserHandler) DownloadArchive(w http.ResponseWriter, r *http.Request) {
+	userID, ok := selfOrAdmin(w, r)
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "zip" && format != "json" {
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid format", fmt.Errorf("format must be zip or json, got %q", format))
+		return
+	}
+
+	job, err := h.useCase.GetPrivacyJob(r.Context(), userID, chi.URLParam(r, "jobID"))
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+	if !job.HasArchive() {
+		problem.Error(w, r, domain.ErrNoArchive)
+		return
+	}
+
+	body, contentType := job.Archive, "application/json"
+	if format != "json" {
+		format, contentType = "zip", "application/zip"
+		if body, err = zipArchive(job.Archive); err != nil {
+			problem.Error(w, r, err)
+			return
+		}
+	}
+
+	filename := fmt.Sprintf("personal-data-%s-%s.%s", job.UserID, time.Unix(job.CompletedAt, 0).UTC().Format("20060102"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(body)
+}
+
+// zipArchive turns an export's JSON object into a ZIP holding a file per key
+func zipArchive(archive []byte) ([]byte, error) {
+	var parts map[string]json.RawMessage
+	if err := json.Unmarshal(archive, &parts); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range slices.Sorted(maps.Keys(parts)) {
+		f, err := zw.Create(name + ".json")
+		if err != nil {
+			return nil, err
+		}
+		var part bytes.Buffer
+		if err := json.Indent(&part, parts[name], "", "  "); err != nil {
+			return nil, err
+		}
+		if _, err := part.WriteTo(f); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RevokedSessions lists the sessions whose access tokens services must
+// refuse. It is internal, polled by the gateway, so it has no Swagger docs.
+func (h *UserHandler) RevokedSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := h.useCase.RevokedSessions(r.Context())
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(domain.RevokedSessionsResponse{Sessions: sessions})
+}
+
+// CreateAPIKey godoc
+// @Summary Create an API key
+// @Description Creates a key for a machine client. It acts with role, on the services and permissions in scopes: <service>:read for GET requests and <service>:write for the rest, with service one of users, products, orders or payments. Send it in the X-API-Key header or as a bearer token. The key is returned only this once. Admins only.
+// @Tags api-keys
+// @Accept json
+// @Produce json
+// @Param request body domain.CreateAPIKeyRequest true "Key settings"
+// @Success 201 {object} domain.APIKeySecretResponse
+// @Failure 400 {object} problem.Problem "Invalid input or unknown scope"
+// @Failure 403 {object} problem.Problem "Not an admin"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /api-keys [post]
+func (h *UserHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req domain.CreateAPIKeyRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	key, secret, err := h.useCase.CreateAPIKey(r.Context(), &domain.APIKey{
+		Name:      req.Name,
+		Role:      req.Role,
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	})
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(domain.APIKeySecretResponse{APIKey: key, Key: secret})
+}
+
+// GetAPIKeys godoc
+// @Summary List API keys
+// @Description Lists every key, revoked and expired ones included, newest first. Admins only.
+// @Tags api-keys
+// @Produce json
+// @Success 200 {array} domain.APIKey
+// @Failure 403 {object} problem.Problem "Not an admin"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /api-keys [get]
+func (h *UserHandler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.useCase.ListAPIKeys(r.Context())
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// GetAPIKey godoc
+// @Summary Get an API key
+// @Tags api-keys
+// @Produce json
+// @Param id path string true "API key ID"
+// @Success 200 {object} domain.APIKey
+// @Failure 403 {object} problem.Problem "Not an admin"
+// @Failure 404 {object} problem.Problem "API key not found"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /api-keys/{id} [get]
+func (h *UserHandler) GetAPIKey(w http.ResponseWriter, r *http.Request) {
+	key, err := h.useCase.GetAPIKey(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
+
+// RotateAPIKey godoc
+// @Summary Rotate an API key
+// @Description Issues a new key in place of the old one, which keeps working for a grace period while clients switch over. The new key is returned only this once. Admins only.
+// @Tags api-keys
+// @Produce json
+// @Param id path string true "API key ID"
+// @Success 200 {object} domain.APIKeySecretResponse
+// @Failure 403 {object} problem.Problem "Not an admin"
+// @Failure 404 {object} problem.Problem "No such live key"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /api-keys/{id}/rotate [post]
+func (h *UserHandler) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	key, secret, err := h.useCase.RotateAPIKey(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(domain.APIKeySecretResponse{APIKey: key, Key: secret})
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke an API key
+// @Description Ends the key, and the grace period of a key it was rotated from. Services may accept it for a few more seconds while their cache expires. Admins only.
+// @Tags api-keys
+// @Param id path string true "API key ID"
+// @Success 204 "Revoked"
+// @Failure 403 {object} problem.Problem "Not an admin"
+// @Failure 404 {object} problem.Problem "No such live key"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /api-keys/{id} [delete]
+func (h *UserHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if err := h.useCase.RevokeAPIKey(r.Context(), chi.URLParam(r, "id")); err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VerifyAPIKey returns the identity an API key acts with, or 401. It is
+// internal, called by the gateway, so it has no Swagger docs.
+func (h *UserHandler) VerifyAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req domain.VerifyAPIKeyRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	id, err := h.useCase.VerifyAPIKey(r.Context(), req.Key)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(id)
+}
+
+// GetOIDCProviders godoc
+// @Summary List the OIDC login providers
+// @Description Names the providers that /auth/oidc/{provider}/login accepts
+// @Tags auth
+// @Produce json
+// @Success 200 {object} domain.OIDCProvidersResponse
+// @Router /auth/oidc [get]
+func (h *UserHandler) GetOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(domain.OIDCProvidersResponse{Providers: h.useCase.OIDCProviders()})
+}
+
+// StartOIDCLogin godoc
+// @Summary Log in with an OIDC provider
+// @Description Redirects the browser to the provider's login page, with PKCE. The provider sends it back to /auth/oidc/{provider}/callback, which must be requested from the same browser.
+// @Tags auth
+// @Param provider path string true "Provider name"
+// @Success 302 "Redirect to the provider"
+// @Header 302 {string} Location "The provider's login page"
+// @Failure 404 {object} problem.Problem "Unknown provider"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /auth/oidc/{provider}/login [get]
+func (h *UserHandler) StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider := chi.URLParam(r, "provider")
+
+	url, state, err := h.useCase.StartOIDCLogin(r.Context(), provider)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	// binds the callback to this browser, so that nobody can log a victim
+	// into the attacker's account by sending them a callback link
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     oidcCookiePath(provider),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// OIDCCallback godoc
+// @Summary Finish an OIDC login
+// @Description The provider redirects here after the login. The provider account logs into the user it was linked to before; on its first login it is linked to the user with the same email if the provider verified the address, and otherwise gets a new customer account. Like /auth/login, users with two-factor authentication get 202 with a challenge.
+// @Tags auth
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Param state query string true "State of the login"
+// @Param code query string false "Authorization code"
+// @Param error query string false "Error reported by the provider"
+// @Success 200 {object} domain.TokenResponse
+// @Success 202 {object} domain.Challenge "Second factor required"
+// @Failure 401 {object} problem.Problem "Invalid or expired login, or refused by the provider"
+// @Failure 403 {object} problem.Problem "Email not verified, when verification is required"
+// @Failure 404 {object} problem.Problem "Unknown provider"
+// @Failure 409 {object} problem.Problem "The email belongs to an account the provider account cannot be linked to"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /auth/oidc/{provider}/callback [get]
+func (h *UserHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider, q := chi.URLParam(r, "provider"), r.URL.Query()
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || cookie.Value != q.Get("state") {
+		problem.Error(w, r, domain.ErrInvalidOIDCState)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: oidcCookiePath(provider), MaxAge: -1})
+
+	if reason := q.Get("error"); reason != "" {
+		problem.Error(w, r, fmt.Errorf("%w: %s %s", domain.ErrOIDCLoginFailed, reason, q.Get("error_description")))
+		return
+	}
+
+	result, err := h.useCase.FinishOIDCLogin(r.Context(), provider, q.Get("state"), q.Get("code"), client(r))
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Challenge != nil {
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(result.Challenge)
+		return
+	}
+	json.NewEncoder(w).Encode(result.Token)
+}
+
+// oidcStateCookie holds the state of the browser's OIDC login
+const oidcStateCookie = "oidc_state"
+
+// oidcCookiePath limits the state cookie to the login's own provider
+func oidcCookiePath(provider string) string {
+	return "/api/auth/oidc/" + provider
+}
+
+// loginError answers a refused login, telling locked out clients when to retry
+func loginError(w http.ResponseWriter, r *http.Request, err error) {
+	var locked *domain.LockedError
+	if errors.As(err, &locked) {
+		retry := int(math.Ceil(time.Until(locked.Until).Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(max(retry, 1)))
+	}
+	problem.Error(w, r, err)
+}
+
+// client describes the caller. user-ms is only reachable through the
+// gateway, which puts the client's address first in X-Forwarded-For.
+func client(r *http.Request) domain.Client {
+	return domain.Client{
+		IP:        ratelimit.ClientIP(r, true),
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// enrollingUser returns the user a TOTP enrollment is for: the one a login
+// challenge was issued to, or else the authenticated caller
+func (h *UserHandler) enrollingUser(w http.ResponseWriter, r *http.Request, challenge string) (string, bool) {
+	if challenge == "" {
+		return callerID(w, r)
+	}
+	userID, err := h.useCase.EnrollmentUser(r.Context(), challenge)
+	if err != nil {
+		problem.Error(w, r, err)
+		return "", false
+	}
+	return userID, true
+}
+
+// selfOrAdmin returns the {id} path parameter when the caller is that user
+// or an admin, and otherwise writes a 401 or 403
+func selfOrAdmin(w http.ResponseWriter, r *http.Request) (string, bool) {
+	caller, ok := callerID(w, r)
+	if !ok {
+		return "", false
+	}
+	id := chi.URLParam(r, "id")
+	if id != caller && !auth.HasRole(r.Context(), auth.RoleAdmin) {
+		problem.Error(w, r, domain.ErrForbidden)
+		return "", false
+	}
+	return id, true
+}
+
+// callerID returns the authenticated caller, writing a 401 when there is none
+func callerID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	caller, ok := auth.FromContext(r.Context())
+	if !ok {
+		problem.HTTP(w, r, http.StatusUnauthorized, "Authentication required", nil)
+		return "", false
+	}
+	return caller.UserID, true
+}
+
+// decodeOptional is decode for bodies that may be left out
+func (h *UserHandler) decodeOptional(w http.ResponseWriter, r *http.Request, dst any) bool {
+	if r.ContentLength == 0 {
+		return true
+	}
+	return h.decode(w, r, dst)
+}
+
+// decode reads and validates a JSON body into dst, writing the problem
+// response and returning false when it is invalid
+func (h *UserHandler) decode(w http.ResponseWriter, r *http.Request, dst any) bool {
+	if err := json.NewDecoder(r.Body).Decode(dst); err != nil {
+		problem.HTTP(w, r, http.StatusBadRequest, "Invalid request body", err)
+		return false
+	}
+	if err := h.validator.Struct(dst); err != nil {
+		problem.Error(w, r, err)
+		return false
+	}
+	return true
+}
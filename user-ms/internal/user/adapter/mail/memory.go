@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"user-ms/internal/user/domain"
+)
+
+// Memory keeps sent messages in the process instead of delivering them, for
+// tests and for running without a mail server
+type Memory struct {
+	mu   sync.Mutex
+	sent []domain.Message
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Send(ctx context.Context, msg domain.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	slog.InfoContext(ctx, "email kept in memory", "to", msg.To, "subject", msg.Subject)
+	return nil
+}
+
+// Sent returns the messages sent so far, oldest first
+func (m *Memory) Sent() []domain.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]domain.Message(nil), m.sent...)
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"user-ms/internal/user/domain"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type securityEvents struct {
+	loginFailures *prometheus.CounterVec
+	lockouts      *prometheus.CounterVec
+	refreshReuse  prometheus.Counter
+}
+
+// NewSecurityEvents returns domain.SecurityEvents that counts events in
+// Prometheus, for alert rules, and logs each one with an event attribute,
+// for log-based alerting and investigation
+func NewSecurityEvents() domain.SecurityEvents {
+	return &securityEvents{
+		loginFailures: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "user_login_failures_total",
+			Help: "Refused logins, by reason.",
+		}, []string{"reason"}),
+		lockouts: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "user_lockouts_total",
+			Help: "Lockouts after repeated failed logins, by scope (account or ip).",
+		}, []string{"scope"}),
+		refreshReuse: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "user_refresh_token_reuse_total",
+			Help: "Refresh tokens presented after rotation, each revoking its session.",
+		}),
+	}
+}
+
+func (e *securityEvents) LoginFailed(ctx context.Context, email, ip, reason string) {
+	e.loginFailures.WithLabelValues(reason).Inc()
+	slog.WarnContext(ctx, "login failed", "event", "LoginFailed", "email", email, "ip", ip, "reason", reason)
+}
+
+func (e *securityEvents) AccountLocked(ctx context.Context, scope, key string, until time.Time) {
+	e.lockouts.WithLabelValues(scope).Inc()
+	slog.WarnContext(ctx, "login locked", "event", "AccountLocked", "scope", scope, "key", key, "until", until)
+}
+
+func (e *securityEvents) RefreshTokenReused(ctx context.Context, userID, sessionID, ip string) {
+	e.refreshReuse.Inc()
+	slog.WarnContext(ctx, "refresh token reused; session revoked", "event", "RefreshTokenReused",
+		"user_id", userID, "session_id", sessionID, "ip", ip)
+}
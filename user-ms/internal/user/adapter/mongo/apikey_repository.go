@@ -0,0 +1,150 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-ms/internal/user/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureAPIKeyIndexes creates the indexes for looking keys up by their
+// current and their rotated-out hash
+func EnsureAPIKeyIndexes(ctx context.Context, col *mongo.Collection) error {
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetName("hash_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "previous_hash", Value: 1}},
+			Options: options.Index().SetName("previous_hash").SetSparse(true),
+		},
+	})
+	return err
+}
+
+type apiKeyRepository struct {
+	collection *mongo.Collection
+}
+
+func NewAPIKeyRepository(col *mongo.Collection) domain.APIKeyRepository {
+	return &apiKeyRepository{collection: col}
+}
+
+// liveKey matches keys that are neither revoked nor expired
+func liveKey(filter bson.M) bson.M {
+	filter["revoked_at"] = bson.M{"$exists": false}
+	filter["$or"] = bson.A{
+		bson.M{"expires_at": bson.M{"$exists": false}},
+		bson.M{"expires_at": bson.M{"$gt": time.Now().Unix()}},
+	}
+	return filter
+}
+
+func (r *apiKeyRepository) CreateAPIKey(ctx context.Context, key *domain.APIKey) error {
+	key.ID = primitive.NewObjectID()
+	_, err := r.collection.InsertOne(ctx, key)
+	return err
+}
+
+func (r *apiKeyRepository) GetAPIKey(ctx context.Context, id string) (*domain.APIKey, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, domain.ErrAPIKeyNotFound
+	}
+	return decodeAPIKey(r.collection.FindOne(ctx, bson.M{"_id": objID}))
+}
+
+func (r *apiKeyRepository) ListAPIKeys(ctx context.Context) ([]*domain.APIKey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []*domain.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *apiKeyRepository) FindAPIKey(ctx context.Context, hash string) (*domain.APIKey, error) {
+	filter := liveKey(bson.M{})
+	filter["$and"] = bson.A{bson.M{"$or": bson.A{
+		bson.M{"hash": hash},
+		bson.M{"previous_hash": hash, "previous_expires_at": bson.M{"$gt": time.Now().Unix()}},
+	}}}
+	return decodeAPIKey(r.collection.FindOne(ctx, filter))
+}
+
+func (r *apiKeyRepository) RotateAPIKey(ctx context.Context, id, hash, prefix string, previousUntil time.Time) (*domain.APIKey, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, domain.ErrAPIKeyNotFound
+	}
+
+	// a pipeline update, so the current hash can be copied to previous_hash
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"previous_hash":       "$hash",
+		"previous_expires_at": previousUntil.Unix(),
+		"hash":                hash,
+		"prefix":              prefix,
+		"rotated_at":          time.Now().Unix(),
+	}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	return decodeAPIKey(r.collection.FindOneAndUpdate(ctx, liveKey(bson.M{"_id": objID}), update, opts))
+}
+
+func (r *apiKeyRepository) RevokeAPIKey(ctx context.Context, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return domain.ErrAPIKeyNotFound
+	}
+
+	update := bson.M{
+		"$set":   bson.M{"revoked_at": time.Now().Unix()},
+		"$unset": bson.M{"previous_hash": "", "previous_expires_at": ""},
+	}
+	res, err := r.collection.UpdateOne(ctx, bson.M{"_id": objID, "revoked_at": bson.M{"$exists": false}}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return domain.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (r *apiKeyRepository) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return domain.ErrAPIKeyNotFound
+	}
+
+	filter := bson.M{"_id": objID, "$or": bson.A{
+		bson.M{"last_used_at": bson.M{"$exists": false}},
+		bson.M{"last_used_at": bson.M{"$lt": at.Add(-time.Minute).Unix()}},
+	}}
+	_, err = r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"last_used_at": at.Unix()}})
+	return err
+}
+
+func decodeAPIKey(res *mongo.SingleResult) (*domain.APIKey, error) {
+	var key domain.APIKey
+	err := res.Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, domain.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
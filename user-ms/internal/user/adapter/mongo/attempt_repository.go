@@ -0,0 +1,83 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-ms/internal/user/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureAttemptIndexes creates the TTL index that forgets login attempts
+func EnsureAttemptIndexes(ctx context.Context, col *mongo.Collection) error {
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+type attemptRepository struct {
+	collection *mongo.Collection
+}
+
+func NewLoginAttemptRepository(col *mongo.Collection) domain.LoginAttemptRepository {
+	return &attemptRepository{collection: col}
+}
+
+func (r *attemptRepository) GetAttempt(ctx context.Context, key string) (*domain.LoginAttempt, error) {
+	// the TTL monitor runs once a minute, so expiry is checked here too
+	filter := bson.M{"_id": key, "expires_at": bson.M{"$gt": time.Now()}}
+
+	var attempt domain.LoginAttempt
+	err := r.collection.FindOne(ctx, filter).Decode(&attempt)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+func (r *attemptRepository) RecordFailure(ctx context.Context, key string, window time.Duration) (*domain.LoginAttempt, error) {
+	now := time.Now()
+	// an expired record counts as none, whether or not the TTL monitor removed it
+	live := bson.M{"$gt": bson.A{"$expires_at", now}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"failures":   bson.M{"$cond": bson.A{live, bson.M{"$add": bson.A{"$failures", 1}}, 1}},
+		"lockouts":   bson.M{"$cond": bson.A{live, bson.M{"$ifNull": bson.A{"$lockouts", 0}}, 0}},
+		"expires_at": now.Add(window),
+	}}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var attempt domain.LoginAttempt
+	err := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&attempt)
+	if mongo.IsDuplicateKeyError(err) {
+		// a concurrent failure inserted the record first; update it instead
+		err = r.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&attempt)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+func (r *attemptRepository) Lock(ctx context.Context, key string, until time.Time, window time.Duration) error {
+	update := bson.M{
+		"$set": bson.M{"failures": 0, "locked_until": until, "expires_at": until.Add(window)},
+		"$inc": bson.M{"lockouts": 1},
+	}
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": key}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (r *attemptRepository) ResetAttempts(ctx context.Context, key string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
@@ -0,0 +1,61 @@
+package mongo
+
+import (
+	"context"
+	"user-ms/internal/user/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureConsentIndexes creates the index for listing a user's consent history
+func EnsureConsentIndexes(ctx context.Context, col *mongo.Collection) error {
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "_id", Value: -1}},
+		Options: options.Index().SetName("user_consents"),
+	})
+	return err
+}
+
+type consentRepository struct {
+	collection *mongo.Collection
+}
+
+func NewConsentRepository(col *mongo.Collection) domain.ConsentRepository {
+	return &consentRepository{collection: col}
+}
+
+func (r *consentRepository) AddConsent(ctx context.Context, record *domain.ConsentRecord) error {
+	record.ID = primitive.NewObjectID()
+	_, err := r.collection.InsertOne(ctx, record)
+	return err
+}
+
+func (r *consentRepository) ListConsents(ctx context.Context, userID string) ([]*domain.ConsentRecord, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	cursor, err := r.collection.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	records := []*domain.ConsentRecord{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (r *consentRepository) AnonymizeConsents(ctx context.Context, userID, pseudonym string) (int64, error) {
+	update := bson.M{
+		"$set":   bson.M{"user_id": pseudonym},
+		"$unset": bson.M{"ip": "", "user_agent": ""},
+	}
+	res, err := r.collection.UpdateMany(ctx, bson.M{"user_id": userID}, update)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
@@ -0,0 +1,134 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-ms/internal/user/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsurePrivacyJobIndexes creates the indexes of the privacy job collection:
+// one allowing a single active job per user and kind, one for workers to
+// find due jobs, one for listing a user's jobs, and a TTL index so MongoDB
+// removes expired exports by itself.
+func EnsurePrivacyJobIndexes(ctx context.Context, col *mongo.Collection) error {
+	active := bson.M{"active": true}
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "kind", Value: 1}},
+			Options: options.Index().SetName("active_unique").SetUnique(true).SetPartialFilterExpression(active),
+		},
+		{
+			Keys:    bson.D{{Key: "run_at", Value: 1}},
+			Options: options.Index().SetName("due").SetPartialFilterExpression(active),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("user_jobs"),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+type privacyJobRepository struct {
+	collection *mongo.Collection
+}
+
+func NewPrivacyJobRepository(col *mongo.Collection) domain.PrivacyJobRepository {
+	return &privacyJobRepository{collection: col}
+}
+
+func (r *privacyJobRepository) CreateJob(ctx context.Context, job *domain.PrivacyJob) error {
+	job.ID = primitive.NewObjectID()
+	_, err := r.collection.InsertOne(ctx, job)
+	if mongo.IsDuplicateKeyError(err) {
+		return domain.ErrJobActive
+	}
+	return err
+}
+
+func (r *privacyJobRepository) GetJob(ctx context.Context, userID, id string) (*domain.PrivacyJob, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, domain.ErrJobNotFound
+	}
+	return decodeJob(r.collection.FindOne(ctx, bson.M{"_id": objID, "user_id": userID}))
+}
+
+func (r *privacyJobRepository) ListJobs(ctx context.Context, userID string) ([]*domain.PrivacyJob, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetProjection(bson.M{"archive": 0})
+	cursor, err := r.collection.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []*domain.PrivacyJob{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (r *privacyJobRepository) FindActiveJob(ctx context.Context, userID, kind string) (*domain.PrivacyJob, error) {
+	return decodeJob(r.collection.FindOne(ctx, bson.M{"user_id": userID, "kind": kind, "active": true}))
+}
+
+func (r *privacyJobRepository) ClaimJob(ctx context.Context, leaseUntil time.Time) (*domain.PrivacyJob, error) {
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{"status": domain.JobRunning, "run_at": leaseUntil},
+		"$min": bson.M{"started_at": now.Unix()},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "run_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	job, err := decodeJob(r.collection.FindOneAndUpdate(ctx, bson.M{"active": true, "run_at": bson.M{"$lte": now}}, update, opts))
+	if errors.Is(err, domain.ErrJobNotFound) {
+		return nil, nil
+	}
+	return job, err
+}
+
+func (r *privacyJobRepository) SaveJob(ctx context.Context, job *domain.PrivacyJob) error {
+	// the attempt count tells this run from a later one of the same job
+	filter := bson.M{"_id": job.ID, "attempts": job.Attempts, "status": domain.JobRunning}
+	res, err := r.collection.ReplaceOne(ctx, filter, job)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return domain.ErrJobNotFound
+	}
+	return nil
+}
+
+func (r *privacyJobRepository) DeleteArchives(ctx context.Context, userID string) error {
+	filter := bson.M{"user_id": userID, "archive": bson.M{"$exists": true}}
+	_, err := r.collection.UpdateMany(ctx, filter, bson.M{"$unset": bson.M{"archive": ""}})
+	return err
+}
+
+func decodeJob(res *mongo.SingleResult) (*domain.PrivacyJob, error) {
+	var job domain.PrivacyJob
+	err := res.Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, domain.ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
@@ -3,13 +3,101 @@ package mongo
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"time"
 	"user-ms/internal/user/domain"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// EnsureIndexes creates the unique index on email. Its case-insensitive
+// collation backs up the normalization done by the use case. Soft-deleted
+// users keep their address, so it cannot be reused while they can be restored.
+// A provider account can likewise be linked to one user only.
+//
+// Emails stored before normalization are trimmed and lowercased first. Users
+// whose addresses differ only in case or spacing cannot be merged without an
+// operator; they are logged, and the email index is left out until they are
+// resolved rather than failing startup.
+func EnsureIndexes(ctx context.Context, col *mongo.Collection) error {
+	duplicates, err := normalizeEmails(ctx, col)
+	if err != nil {
+		return fmt.Errorf("normalize emails: %w", err)
+	}
+
+	indexes := []mongo.IndexModel{{
+		Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+		Options: options.Index().
+			SetName("identity_unique").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"identities": bson.M{"$exists": true}}),
+	}}
+	if len(duplicates) == 0 {
+		indexes = append(indexes, mongo.IndexModel{
+			Keys: bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().
+				SetName("email_unique").
+				SetUnique(true).
+				SetCollation(&options.Collation{Locale: "en", Strength: 2}),
+		})
+	}
+	for _, d := range duplicates {
+		slog.WarnContext(ctx, "users share an email address; emails are not unique until all but one are changed",
+			"email", d.Email, "user_ids", d.IDs)
+	}
+
+	_, err = col.Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+// emailDuplicate is a normalized address held by more than one user
+type emailDuplicate struct {
+	Email string               `bson:"_id"`
+	IDs   []primitive.ObjectID `bson:"ids"`
+}
+
+// normalizeEmails rewrites every stored email to its domain.NormalizeEmail
+// form, except those that would then collide, which it returns
+func normalizeEmails(ctx context.Context, col *mongo.Collection) ([]emailDuplicate, error) {
+	normalized := bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}}
+
+	cursor, err := col.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"email": bson.M{"$type": "string"}}}},
+		{{Key: "$group", Value: bson.M{"_id": normalized, "ids": bson.M{"$push": "$_id"}}}},
+		{{Key: "$match", Value: bson.M{"ids.1": bson.M{"$exists": true}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var duplicates []emailDuplicate
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return nil, err
+	}
+
+	skip := []primitive.ObjectID{}
+	for _, d := range duplicates {
+		skip = append(skip, d.IDs...)
+	}
+	filter := bson.M{
+		"email": bson.M{"$type": "string"},
+		"$expr": bson.M{"$ne": bson.A{"$email", normalized}},
+		"_id":   bson.M{"$nin": skip},
+	}
+	res, err := col.UpdateMany(ctx, filter, mongo.Pipeline{{{Key: "$set", Value: bson.M{"email": normalized}}}})
+	if err != nil {
+		return nil, err
+	}
+	if res.ModifiedCount > 0 {
+		slog.InfoContext(ctx, "normalized stored emails", "count", res.ModifiedCount)
+	}
+	return duplicates, nil
+}
+
 type userRepository struct {
 	collection *mongo.Collection
 }
@@ -22,6 +110,9 @@ func (r *userRepository) CreateUser(ctx context.Context, user *domain.User) (*do
 	user.ID = primitive.NewObjectID()
 
 	_, err := r.collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, domain.ErrConflict
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -29,14 +120,35 @@ func (r *userRepository) CreateUser(ctx context.Context, user *domain.User) (*do
 	return user, nil
 }
 
-func (r *userRepository) GetUserByID(ctx context.Context, id string) (*domain.User, error) {
-	objID, err := primitive.ObjectIDFromHex(id)
+func (r *userRepository) GetUserByID(ctx context.Context, id string, includeDeleted bool) (*domain.User, error) {
+	objID, err := objectID(id)
 	if err != nil {
 		return nil, err
 	}
 
+	filter := bson.M{"_id": objID}
+	if !includeDeleted {
+		filter["deleted_at"] = notDeleted
+	}
+
+	var user domain.User
+	err = r.collection.FindOne(ctx, filter).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *userRepository) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
 	var user domain.User
-	err = r.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&user)
+	err := r.collection.FindOne(ctx, bson.M{"email": email, "deleted_at": notDeleted}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, domain.ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -44,8 +156,49 @@ func (r *userRepository) GetUserByID(ctx context.Context, id string) (*domain.Us
 	return &user, nil
 }
 
-func (r *userRepository) GetAllUsers(ctx context.Context) ([]*domain.User, error) {
-	cursor, err := r.collection.Find(ctx, bson.M{})
+func (r *userRepository) GetAllUsers(ctx context.Context, f domain.UserFilter) ([]*domain.User, error) {
+	filter := bson.M{}
+	switch f.Status {
+	case domain.StatusAny:
+	case domain.StatusDeleted:
+		filter["deleted_at"] = bson.M{"$exists": true}
+	default:
+		filter["deleted_at"] = notDeleted
+	}
+	if f.EmailPrefix != "" {
+		// emails are stored lowercase, so a case-sensitive anchored regex can use the index
+		filter["email"] = bson.M{"$regex": "^" + regexp.QuoteMeta(domain.NormalizeEmail(f.EmailPrefix))}
+	}
+	if f.NamePrefix != "" {
+		filter["name"] = bson.M{"$regex": "^" + regexp.QuoteMeta(f.NamePrefix), "$options": "i"}
+	}
+	if f.Role != "" {
+		filter["role"] = f.Role
+	}
+	created := bson.M{}
+	if f.CreatedAfter != 0 {
+		created["$gte"] = f.CreatedAfter
+	}
+	if f.CreatedBefore != 0 {
+		created["$lt"] = f.CreatedBefore
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+	if f.After != "" {
+		after, err := primitive.ObjectIDFromHex(f.After)
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed cursor %q", domain.ErrValidation, f.After)
+		}
+		filter["_id"] = bson.M{"$gt": after}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if f.Limit > 0 {
+		opts.SetLimit(int64(f.Limit))
+	}
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -63,30 +216,182 @@ func (r *userRepository) GetAllUsers(ctx context.Context) ([]*domain.User, error
 	return users, nil
 }
 
-func (r *userRepository) UpdateUser(ctx context.Context, id string, user *domain.User) (*domain.User, error) {
-	objID, err := primitive.ObjectIDFromHex(id)
+func (r *userRepository) UpdateUser(ctx context.Context, id string, version int64, user *domain.User) (*domain.User, error) {
+	return r.PatchUser(ctx, id, version, map[string]any{
+		"name":           user.Name,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"updated_at":     user.UpdatedAt,
+	})
+}
+
+func (r *userRepository) PatchUser(ctx context.Context, id string, version int64, fields map[string]any) (*domain.User, error) {
+	objID, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var user domain.User
+	update := bson.M{"$set": fields, "$inc": bson.M{"version": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = r.collection.FindOneAndUpdate(ctx, versioned(objID, version), update, opts).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, r.missed(ctx, objID)
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, domain.ErrConflict
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	return &user, nil
+}
+
+func (r *userRepository) DeleteUser(ctx context.Context, id string, version int64, deletedBy string) error {
+	objID, err := objectID(id)
+	if err != nil {
+		return err
+	}
+
+	deleted := bson.M{"deleted_at": time.Now().Unix()}
+	if deletedBy != "" {
+		deleted["deleted_by"] = deletedBy
+	}
+	update := bson.M{"$set": deleted, "$inc": bson.M{"version": 1}}
+
+	res, err := r.collection.UpdateOne(ctx, versioned(objID, version), update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return r.missed(ctx, objID)
+	}
+
+	return nil
+}
+
+func (r *userRepository) RestoreUser(ctx context.Context, id string, version int64) (*domain.User, error) {
+	objID, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := versioned(objID, version)
+	filter["deleted_at"] = bson.M{"$exists": true}
 	update := bson.M{
-		"$set": bson.M{
-			"name":  user.Name,
-			"email": user.Email,
-			// include other fields explicitly
+		"$unset": bson.M{"deleted_at": "", "deleted_by": ""},
+		"$set":   bson.M{"updated_at": time.Now().Unix()},
+		"$inc":   bson.M{"version": 1},
+	}
+
+	var user domain.User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// the user is gone, live, or at another version
+		if _, err := r.GetUserByID(ctx, id, false); err == nil {
+			return nil, domain.ErrNotDeleted
+		}
+		if _, err := r.GetUserByID(ctx, id, true); err != nil {
+			return nil, err
+		}
+		return nil, domain.ErrVersionMismatch
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, domain.ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *userRepository) UseTOTPStep(ctx context.Context, id string, step int64) (bool, error) {
+	objID, err := objectID(id)
+	if err != nil {
+		return false, err
+	}
+
+	// matching only older steps makes concurrent uses of one code race for a single update
+	filter := bson.M{
+		"_id":        objID,
+		"deleted_at": notDeleted,
+		"$or": bson.A{
+			bson.M{"totp_last_step": bson.M{"$lt": step}},
+			bson.M{"totp_last_step": bson.M{"$exists": false}},
 		},
 	}
+	res, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"totp_last_step": step}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
 
-	_, err = r.collection.UpdateByID(ctx, objID, update)
+func (r *userRepository) UseRecoveryCode(ctx context.Context, id, hash string) (bool, error) {
+	objID, err := objectID(id)
+	if err != nil {
+		return false, err
+	}
+
+	filter := bson.M{"_id": objID, "deleted_at": notDeleted, "recovery_codes": hash}
+	res, err := r.collection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"recovery_codes": hash}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *userRepository) GetUserByIdentity(ctx context.Context, provider, subject string) (*domain.User, error) {
+	filter := bson.M{
+		"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}},
+		"deleted_at": notDeleted,
+	}
+
+	var user domain.User
+	err := r.collection.FindOne(ctx, filter).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, domain.ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return user, nil
+	return &user, nil
 }
 
-func (r *userRepository) DeleteUser(ctx context.Context, id string) error {
-	objID, err := primitive.ObjectIDFromHex(id)
+func (r *userRepository) LinkIdentity(ctx context.Context, id string, identity domain.LinkedIdentity) (*domain.User, error) {
+	objID, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	update := bson.M{
+		"$push": bson.M{"identities": identity},
+		"$set":  bson.M{"updated_at": time.Now().Unix()},
+		"$inc":  bson.M{"version": 1},
+	}
+	var user domain.User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = r.collection.FindOneAndUpdate(ctx, bson.M{"_id": objID, "deleted_at": notDeleted}, update, opts).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, domain.ErrNotFound
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, domain.ErrIdentityLinked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *userRepository) PurgeUser(ctx context.Context, id string) error {
+	objID, err := objectID(id)
 	if err != nil {
 		return err
 	}
@@ -95,10 +400,46 @@ func (r *userRepository) DeleteUser(ctx context.Context, id string) error {
 	if err != nil {
 		return err
 	}
-
 	if res.DeletedCount == 0 {
-		return errors.New("user not found")
+		return domain.ErrNotFound
 	}
-
 	return nil
 }
+
+// notDeleted matches documents without deleted_at, i.e. users that are not soft-deleted
+var notDeleted = bson.M{"$exists": false}
+
+// missed explains a conditional write that matched nothing: the user is
+// gone or soft-deleted, or is at another version
+func (r *userRepository) missed(ctx context.Context, objID primitive.ObjectID) error {
+	n, err := r.collection.CountDocuments(ctx, bson.M{"_id": objID, "deleted_at": notDeleted})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrNotFound
+	}
+	return domain.ErrVersionMismatch
+}
+
+// versioned matches the live user with objID at version. Users stored
+// before versioning have no version field and are at version 0.
+func versioned(objID primitive.ObjectID, version int64) bson.M {
+	filter := bson.M{"_id": objID, "deleted_at": notDeleted}
+	switch version {
+	case domain.AnyVersion:
+	case 0:
+		filter["version"] = bson.M{"$exists": false}
+	default:
+		filter["version"] = version
+	}
+	return filter
+}
+
+func objectID(id string) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return objID, fmt.Errorf("%w: malformed ID %q", domain.ErrValidation, id)
+	}
+	return objID, nil
+}
@@ -0,0 +1,168 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-ms/internal/user/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxUsedHashes bounds the rotated-out refresh tokens a session remembers
+const maxUsedHashes = 50
+
+// EnsureSessionIndexes creates the indexes of the session collection: for
+// lookups by current and rotated-out refresh token, by user, and of recent
+// revocations, plus a TTL index so MongoDB removes ended sessions by itself.
+func EnsureSessionIndexes(ctx context.Context, col *mongo.Collection) error {
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "refresh_hash", Value: 1}},
+			Options: options.Index().SetName("refresh_hash_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "used_hashes", Value: 1}},
+			Options: options.Index().SetName("used_hashes"),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "last_seen_at", Value: -1}},
+			Options: options.Index().SetName("user_last_seen"),
+		},
+		{
+			Keys:    bson.D{{Key: "revoked_at", Value: 1}},
+			Options: options.Index().SetName("revoked_at").SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+type sessionRepository struct {
+	collection *mongo.Collection
+}
+
+func NewSessionRepository(col *mongo.Collection) domain.SessionRepository {
+	return &sessionRepository{collection: col}
+}
+
+// liveSession matches sessions that are neither revoked nor expired. The TTL
+// monitor runs once a minute, so expiry is checked here too.
+func liveSession(filter bson.M) bson.M {
+	filter["revoked_at"] = bson.M{"$exists": false}
+	filter["expires_at"] = bson.M{"$gt": time.Now()}
+	return filter
+}
+
+func (r *sessionRepository) CreateSession(ctx context.Context, session *domain.Session) error {
+	session.ID = primitive.NewObjectID()
+	_, err := r.collection.InsertOne(ctx, session)
+	return err
+}
+
+func (r *sessionRepository) RotateSession(ctx context.Context, hash, newHash string, client domain.Client, expiresAt time.Time) (*domain.Session, error) {
+	update := bson.M{
+		"$set": bson.M{
+			"refresh_hash": newHash,
+			"ip":           client.IP,
+			"user_agent":   client.UserAgent,
+			"last_seen_at": time.Now().Unix(),
+			"expires_at":   expiresAt,
+		},
+		"$push": bson.M{"used_hashes": bson.M{"$each": bson.A{hash}, "$slice": -maxUsedHashes}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var session domain.Session
+	err := r.collection.FindOneAndUpdate(ctx, liveSession(bson.M{"refresh_hash": hash}), update, opts).Decode(&session)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, domain.ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (r *sessionRepository) FindSessionByUsedHash(ctx context.Context, hash string) (*domain.Session, error) {
+	var session domain.Session
+	err := r.collection.FindOne(ctx, bson.M{"used_hashes": hash}).Decode(&session)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, domain.ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (r *sessionRepository) ListSessions(ctx context.Context, userID string) ([]*domain.Session, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "last_seen_at", Value: -1}})
+	return r.find(ctx, liveSession(bson.M{"user_id": userID}), opts)
+}
+
+func (r *sessionRepository) RevokeSession(ctx context.Context, userID, id, reason string, keepUntil time.Time) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return domain.ErrSessionNotFound
+	}
+
+	res, err := r.collection.UpdateOne(ctx, liveSession(bson.M{"_id": objID, "user_id": userID}), revoke(reason, keepUntil))
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return domain.ErrSessionNotFound
+	}
+	return nil
+}
+
+func (r *sessionRepository) RevokeUserSessions(ctx context.Context, userID, reason string, keepUntil time.Time) error {
+	_, err := r.collection.UpdateMany(ctx, liveSession(bson.M{"user_id": userID}), revoke(reason, keepUntil))
+	return err
+}
+
+// revoke ends a session. Its refresh token stops matching live sessions at
+// once; the record stays until keepUntil for the revocation list.
+func revoke(reason string, keepUntil time.Time) bson.M {
+	return bson.M{"$set": bson.M{
+		"revoked_at":     time.Now().Unix(),
+		"revoked_reason": reason,
+		"expires_at":     keepUntil,
+	}}
+}
+
+func (r *sessionRepository) ListRevoked(ctx context.Context, since time.Time) ([]*domain.Session, error) {
+	return r.find(ctx, bson.M{"revoked_at": bson.M{"$gte": since.Unix()}}, options.Find())
+}
+
+func (r *sessionRepository) AnonymizeSessions(ctx context.Context, userID, pseudonym string) (int64, error) {
+	update := bson.M{"$set": bson.M{"user_id": pseudonym, "ip": "", "user_agent": ""}}
+	res, err := r.collection.UpdateMany(ctx, bson.M{"user_id": userID}, update)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+func (r *sessionRepository) find(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]*domain.Session, error) {
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []*domain.Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
@@ -0,0 +1,89 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-ms/internal/user/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureTokenIndexes creates the indexes of the action token collection:
+// a unique one for lookups by hash, one for deleting a user's tokens, and a
+// TTL index so MongoDB removes expired tokens by itself.
+func EnsureTokenIndexes(ctx context.Context, col *mongo.Collection) error {
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetName("hash_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}},
+			Options: options.Index().SetName("user_purpose"),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+type tokenRepository struct {
+	collection *mongo.Collection
+}
+
+func NewActionTokenRepository(col *mongo.Collection) domain.ActionTokenRepository {
+	return &tokenRepository{collection: col}
+}
+
+func (r *tokenRepository) CreateToken(ctx context.Context, token *domain.ActionToken) error {
+	token.ID = primitive.NewObjectID()
+	_, err := r.collection.InsertOne(ctx, token)
+	return err
+}
+
+func (r *tokenRepository) ConsumeToken(ctx context.Context, purpose, hash string) (*domain.ActionToken, error) {
+	return decodeToken(r.collection.FindOneAndDelete(ctx, unexpired(purpose, hash)))
+}
+
+func (r *tokenRepository) FindToken(ctx context.Context, purpose, hash string) (*domain.ActionToken, error) {
+	return decodeToken(r.collection.FindOne(ctx, unexpired(purpose, hash)))
+}
+
+// unexpired matches the token with hash and purpose. The TTL monitor runs
+// once a minute, so expiry is checked here too.
+func unexpired(purpose, hash string) bson.M {
+	return bson.M{
+		"hash":       hash,
+		"purpose":    purpose,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+}
+
+func decodeToken(res *mongo.SingleResult) (*domain.ActionToken, error) {
+	var token domain.ActionToken
+	err := res.Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, domain.ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (r *tokenRepository) DeleteTokens(ctx context.Context, userID, purpose string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID, "purpose": purpose})
+	return err
+}
+
+func (r *tokenRepository) DeleteUserTokens(ctx context.Context, userID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
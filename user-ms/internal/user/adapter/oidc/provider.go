@@ -0,0 +1,45 @@
+// Package oidc implements the domain.IdentityProvider port
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"user-ms/internal/user/domain"
+	"user-ms/pkg/oidc"
+)
+
+type provider struct {
+	*oidc.Provider
+}
+
+// NewProviders returns a domain.IdentityProvider per configured provider.
+// Providers are contacted on first use, so one that is down does not keep
+// the service from starting.
+func NewProviders(configs oidc.Configs, client *http.Client) []domain.IdentityProvider {
+	providers := make([]domain.IdentityProvider, len(configs))
+	for i, cfg := range configs {
+		providers[i] = provider{oidc.NewProvider(cfg, client)}
+	}
+	return providers
+}
+
+func (p provider) Exchange(ctx context.Context, code, verifier, nonce string) (*domain.ExternalIdentity, error) {
+	claims, err := p.Provider.Exchange(ctx, code, verifier, nonce)
+	if errors.Is(err, oidc.ErrProvider) {
+		return nil, fmt.Errorf("%w: %v", domain.ErrOIDCLoginFailed, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.ExternalIdentity{
+		Provider:      p.Name(),
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}, nil
+}
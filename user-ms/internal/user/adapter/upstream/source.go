@@ -0,0 +1,45 @@
+// Package upstream implements the domain.PersonalDataSource port over the
+// internal endpoints of other services, see shared/privacy
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+
+	"user-ms/internal/user/domain"
+
+	"shared/client"
+	"shared/privacy"
+)
+
+type source struct {
+	name   string
+	client *client.Client
+}
+
+// NewSource returns the personal data of the service c calls, keyed by name in exports
+func NewSource(name string, c *client.Client) domain.PersonalDataSource {
+	return &source{name: name, client: c}
+}
+
+func (s *source) Name() string {
+	return s.name
+}
+
+func (s *source) Export(ctx context.Context, userID string) (json.RawMessage, error) {
+	var records json.RawMessage
+	if err := s.client.GetJSON(ctx, privacy.ExportPath(userID), &privacy.Export{Records: &records}); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (s *source) Anonymize(ctx context.Context, userID, pseudonym string) (int64, error) {
+	var res privacy.AnonymizeResponse
+	// anonymizing twice changes nothing the second time, so the POST is safe to retry
+	err := s.client.PostJSON(client.WithIdempotent(ctx), privacy.AnonymizePath(userID), privacy.AnonymizeRequest{Pseudonym: pseudonym}, &res)
+	if err != nil {
+		return 0, err
+	}
+	return res.Anonymized, nil
+}
@@ -0,0 +1,57 @@
+package domain
+
+import (
+	"time"
+
+	"shared/apperr"
+)
+
+var (
+	ErrNotFound   = apperr.New(apperr.NotFound, "user not found")
+	ErrConflict   = apperr.New(apperr.Conflict, "a user with this email already exists")
+	ErrValidation = apperr.New(apperr.Validation, "invalid input")
+	ErrForbidden  = apperr.New(apperr.Forbidden, "not allowed to access this user")
+
+	ErrVersionMismatch = apperr.New(apperr.PreconditionFailed, "the user was modified since it was read; fetch it again")
+	ErrNotDeleted      = apperr.New(apperr.Conflict, "the user is not deleted")
+
+	ErrInvalidCredentials = apperr.New(apperr.Unauthorized, "invalid email or password")
+	ErrPasswordRequired   = apperr.New(apperr.Forbidden, "changing the email requires the current password")
+	ErrEmailNotVerified   = apperr.New(apperr.Forbidden, "the email address is not verified yet; follow the link mailed to it")
+	ErrInvalidToken       = apperr.New(apperr.Validation, "the token is invalid, used or expired")
+
+	ErrInvalidChallenge = apperr.New(apperr.Unauthorized, "the login challenge is invalid, used or expired; log in again")
+	ErrInvalidCode      = apperr.New(apperr.Unauthorized, "invalid two-factor code")
+	ErrTOTPEnabled      = apperr.New(apperr.Conflict, "two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled  = apperr.New(apperr.Conflict, "start a TOTP enrollment first")
+	ErrTOTPNotEnabled   = apperr.New(apperr.Conflict, "two-factor authentication is not enabled")
+	ErrTOTPRequired     = apperr.New(apperr.Forbidden, "two-factor authentication is required for your role")
+
+	ErrInvalidRefreshToken = apperr.New(apperr.Unauthorized, "the refresh token is invalid, revoked or expired; log in again")
+	ErrSessionNotFound     = apperr.New(apperr.NotFound, "session not found")
+
+	ErrAPIKeyNotFound = apperr.New(apperr.NotFound, "API key not found")
+	ErrInvalidAPIKey  = apperr.New(apperr.Unauthorized, "the API key is invalid, expired or revoked")
+
+	ErrUnknownProvider   = apperr.New(apperr.NotFound, "login provider not found")
+	ErrInvalidOIDCState  = apperr.New(apperr.Unauthorized, "the login is invalid, used or expired; start it again")
+	ErrOIDCLoginFailed   = apperr.New(apperr.Unauthorized, "the login at the provider failed")
+	ErrIdentityLinked    = apperr.New(apperr.Conflict, "this provider account is linked to another user")
+	ErrUnverifiedLinking = apperr.New(apperr.Conflict, "an account with this email exists, but the provider has not verified the email; log in with your password")
+
+	ErrJobNotFound = apperr.New(apperr.NotFound, "privacy job not found")
+	ErrJobActive   = apperr.New(apperr.Conflict, "a job of this kind is already queued for the user")
+	ErrNoArchive   = apperr.New(apperr.Conflict, "the job has no archive: it is not a completed export, or the archive was erased")
+)
+
+// LockedError refuses a login while its account or client IP is locked out
+// after too many failures
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return "too many failed logins; try again later"
+}
+
+func (e *LockedError) Unwrap() error { return apperr.RateLimited }
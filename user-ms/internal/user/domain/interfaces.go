@@ -1,19 +1,294 @@
 package domain
 
-import "context"
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"shared/auth"
+)
 
 type UserRepository interface {
 	CreateUser(ctx context.Context, user *User) (*User, error)
-	GetUserByID(ctx context.Context, id string) (*User, error)
-	GetAllUsers(ctx context.Context) ([]*User, error)
-	UpdateUser(ctx context.Context, id string, user *User) (*User, error)
-	DeleteUser(ctx context.Context, id string) error
+	// Reads skip soft-deleted users unless includeDeleted
+	GetUserByID(ctx context.Context, id string, includeDeleted bool) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	// GetAllUsers returns the users matching filter in ID order
+	GetAllUsers(ctx context.Context, filter UserFilter) ([]*User, error)
+	// Writes apply only while the user is at version (see AnyVersion) and
+	// fail with ErrVersionMismatch otherwise
+	UpdateUser(ctx context.Context, id string, version int64, user *User) (*User, error)
+	// PatchUser sets only the given fields, keyed by bson name, and returns the updated user
+	PatchUser(ctx context.Context, id string, version int64, fields map[string]any) (*User, error)
+	// DeleteUser soft-deletes the user, recording who did it
+	DeleteUser(ctx context.Context, id string, version int64, deletedBy string) error
+	// RestoreUser undoes DeleteUser; it fails with ErrNotDeleted for a live user
+	RestoreUser(ctx context.Context, id string, version int64) (*User, error)
+	// UseTOTPStep records step as the user's last TOTP step, reporting false
+	// when a code of that or a later step was used already
+	UseTOTPStep(ctx context.Context, id string, step int64) (bool, error)
+	// UseRecoveryCode removes the recovery code with hash, reporting false
+	// when the user has no such code
+	UseRecoveryCode(ctx context.Context, id, hash string) (bool, error)
+	// GetUserByIdentity returns the live user linked to the provider account
+	GetUserByIdentity(ctx context.Context, provider, subject string) (*User, error)
+	// LinkIdentity adds identity to the user's linked identities; it fails
+	// with ErrIdentityLinked when another user has it already
+	LinkIdentity(ctx context.Context, id string, identity LinkedIdentity) (*User, error)
+	// PurgeUser deletes the user for good, soft-deleted or not
+	PurgeUser(ctx context.Context, id string) error
+}
+
+// ActionTokenRepository stores the tokens of the verification and password reset flows
+type ActionTokenRepository interface {
+	CreateToken(ctx context.Context, token *ActionToken) error
+	// ConsumeToken deletes and returns the unexpired token with hash and
+	// purpose; it fails with ErrInvalidToken when there is none
+	ConsumeToken(ctx context.Context, purpose, hash string) (*ActionToken, error)
+	// FindToken is ConsumeToken without the delete, for tokens used more than once
+	FindToken(ctx context.Context, purpose, hash string) (*ActionToken, error)
+	// DeleteTokens removes every token of the user for purpose
+	DeleteTokens(ctx context.Context, userID, purpose string) error
+	// DeleteUserTokens removes every token of the user
+	DeleteUserTokens(ctx context.Context, userID string) error
+}
+
+// SessionRepository stores login sessions
+type SessionRepository interface {
+	CreateSession(ctx context.Context, session *Session) error
+	// RotateSession replaces the refresh token hash of the live session
+	// holding it by newHash, records client as last seen and extends the
+	// session to expiresAt. It fails with ErrInvalidRefreshToken when no
+	// live session holds hash.
+	RotateSession(ctx context.Context, hash, newHash string, client Client, expiresAt time.Time) (*Session, error)
+	// FindSessionByUsedHash returns the session hash was rotated out of, or
+	// fails with ErrSessionNotFound
+	FindSessionByUsedHash(ctx context.Context, hash string) (*Session, error)
+	// ListSessions returns the live sessions of the user, most recently seen first
+	ListSessions(ctx context.Context, userID string) ([]*Session, error)
+	// RevokeSession revokes a live session of the user, failing with
+	// ErrSessionNotFound when there is none. The record is kept until
+	// keepUntil so that it can be listed as revoked.
+	RevokeSession(ctx context.Context, userID, id, reason string, keepUntil time.Time) error
+	// RevokeUserSessions revokes every live session of the user
+	RevokeUserSessions(ctx context.Context, userID, reason string, keepUntil time.Time) error
+	// ListRevoked returns the sessions revoked since since
+	ListRevoked(ctx context.Context, since time.Time) ([]*Session, error)
+	// AnonymizeSessions moves every session of the user, revoked ones
+	// included, to pseudonym and clears their IP and user agent. Revoked
+	// sessions thus stay on the revocation list until they expire.
+	AnonymizeSessions(ctx context.Context, userID, pseudonym string) (int64, error)
+}
+
+// APIKeyRepository stores the API keys of machine clients
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	GetAPIKey(ctx context.Context, id string) (*APIKey, error)
+	// ListAPIKeys returns every key, revoked ones included, newest first
+	ListAPIKeys(ctx context.Context) ([]*APIKey, error)
+	// FindAPIKey returns the live key with hash, current or still within the
+	// grace period of a rotation, or fails with ErrAPIKeyNotFound
+	FindAPIKey(ctx context.Context, hash string) (*APIKey, error)
+	// RotateAPIKey gives a live key a new hash and prefix. The old hash
+	// keeps working until previousUntil.
+	RotateAPIKey(ctx context.Context, id, hash, prefix string, previousUntil time.Time) (*APIKey, error)
+	// RevokeAPIKey ends a live key and the grace period of its old one
+	RevokeAPIKey(ctx context.Context, id string) error
+	// TouchAPIKey records a use at at, writing at most once a minute per key
+	TouchAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+// LoginAttemptRepository tracks failed logins for lockouts
+type LoginAttemptRepository interface {
+	// GetAttempt returns the record of key, or nil when there is none
+	GetAttempt(ctx context.Context, key string) (*LoginAttempt, error)
+	// RecordFailure counts a failed login of key. Failures older than window
+	// are forgotten.
+	RecordFailure(ctx context.Context, key string, window time.Duration) (*LoginAttempt, error)
+	// Lock locks key out until until and starts counting failures anew
+	Lock(ctx context.Context, key string, until time.Time, window time.Duration) error
+	// ResetAttempts forgets the failures and lockouts of key
+	ResetAttempts(ctx context.Context, key string) error
+}
+
+// ConsentRepository keeps the history of consents. Records are only added,
+// never changed, except to anonymize them.
+type ConsentRepository interface {
+	AddConsent(ctx context.Context, record *ConsentRecord) error
+	// ListConsents returns the records of the user, newest first
+	ListConsents(ctx context.Context, userID string) ([]*ConsentRecord, error)
+	// AnonymizeConsents moves the user's records to pseudonym and clears
+	// their IP and user agent, returning how many changed
+	AnonymizeConsents(ctx context.Context, userID, pseudonym string) (int64, error)
+}
+
+// PrivacyJobRepository stores privacy jobs and hands them out to workers
+type PrivacyJobRepository interface {
+	// CreateJob stores a new job; it fails with ErrJobActive when the user
+	// has an active job of its kind already
+	CreateJob(ctx context.Context, job *PrivacyJob) error
+	// GetJob returns a job of the user, or fails with ErrJobNotFound
+	GetJob(ctx context.Context, userID, id string) (*PrivacyJob, error)
+	// ListJobs returns the jobs of the user without their archives, newest first
+	ListJobs(ctx context.Context, userID string) ([]*PrivacyJob, error)
+	// FindActiveJob returns the user's pending or running job of kind, or
+	// fails with ErrJobNotFound
+	FindActiveJob(ctx context.Context, userID, kind string) (*PrivacyJob, error)
+	// ClaimJob takes the job that is due longest, marks it running, counts
+	// the attempt and leases it until leaseUntil. It returns nil when no job is due.
+	ClaimJob(ctx context.Context, leaseUntil time.Time) (*PrivacyJob, error)
+	// SaveJob stores the outcome of a run. It fails with ErrJobNotFound when
+	// the job was claimed again since, after the run's lease ran out.
+	SaveJob(ctx context.Context, job *PrivacyJob) error
+	// DeleteArchives removes the archives of the user's exports
+	DeleteArchives(ctx context.Context, userID string) error
+}
+
+// PersonalDataSource is another service holding records about users, which
+// exports and erasures must cover
+type PersonalDataSource interface {
+	// Name keys the source's records in exports, e.g. orders
+	Name() string
+	// Export returns the service's records about the user as a JSON array
+	Export(ctx context.Context, userID string) (json.RawMessage, error)
+	// Anonymize replaces the user's ID in the service's records by
+	// pseudonym and returns how many records changed
+	Anonymize(ctx context.Context, userID, pseudonym string) (int64, error)
+}
+
+// SecurityEvents reports security-relevant events for alerting
+type SecurityEvents interface {
+	// LoginFailed reports a refused login; reason is unknown_email,
+	// wrong_password, invalid_code or locked
+	LoginFailed(ctx context.Context, email, ip, reason string)
+	// AccountLocked reports a lockout of an account or, for scope ip, a client
+	AccountLocked(ctx context.Context, scope, key string, until time.Time)
+	// RefreshTokenReused reports a rotated-out refresh token presented
+	// again, which revoked its session
+	RefreshTokenReused(ctx context.Context, userID, sessionID, ip string)
+}
+
+// IdentityProvider runs logins at an OIDC provider
+type IdentityProvider interface {
+	Name() string
+	// AuthCodeURL returns the provider's login page for a login with state,
+	// nonce and PKCE verifier
+	AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error)
+	// Exchange redeems the code the provider redirected back with. It fails
+	// with ErrOIDCLoginFailed when the provider refuses or its ID token is invalid.
+	Exchange(ctx context.Context, code, verifier, nonce string) (*ExternalIdentity, error)
+}
+
+// Mailer delivers email
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
 }
 
 type UserUseCase interface {
-	CreateUser(ctx context.Context, user *User) (*User, error)
-	GetUserByID(ctx context.Context, id string) (*User, error)
-	GetAllUsers(ctx context.Context) ([]*User, error)
-	UpdateUser(ctx context.Context, id string, user *User) (*User, error)
-	DeleteUser(ctx context.Context, id string) error
+	// CreateUser signs a user up; a marketing consent given is recorded
+	// with client
+	CreateUser(ctx context.Context, user *User, client Client) (*User, error)
+	GetUserByID(ctx context.Context, id string, includeDeleted bool) (*User, error)
+	// GetAllUsers returns a page of the users matching filter and the
+	// cursor of the next page, empty on the last one
+	GetAllUsers(ctx context.Context, filter UserFilter) (users []*User, next string, err error)
+	// UpdateUser and PatchUser change the email of a user calling for
+	// themself only with their current password
+	UpdateUser(ctx context.Context, id string, version int64, user *User, currentPassword string) (*User, error)
+	PatchUser(ctx context.Context, id string, version int64, fields map[string]any, currentPassword string) (*User, error)
+	DeleteUser(ctx context.Context, id string, version int64) error
+	RestoreUser(ctx context.Context, id string, version int64) (*User, error)
+	// UpdateProfile sets the given name and profile fields, keyed by bson
+	// name, and gives or withdraws the marketing consent. A change of
+	// consent is recorded with client before it takes effect.
+	UpdateProfile(ctx context.Context, id string, version int64, fields map[string]any, marketingConsent bool, client Client) (*User, error)
+	// ListConsents returns the user's consent history, newest first
+	ListConsents(ctx context.Context, userID string) ([]*ConsentRecord, error)
+	// Login checks a password from client and starts a session for it.
+	// Repeated failures for an email or an IP lock them out with a LockedError.
+	Login(ctx context.Context, email, password string, client Client) (*LoginResult, error)
+	// VerifyLogin completes a totp login challenge with a TOTP or recovery
+	// code. The challenge is used up even when the code is wrong.
+	VerifyLogin(ctx context.Context, challenge, code string, client Client) (*TokenResponse, error)
+	// UnlockUser lifts a lockout of the user's account
+	UnlockUser(ctx context.Context, id string) error
+	// EnrollmentUser returns the user a totp_enrollment challenge was issued to
+	EnrollmentUser(ctx context.Context, challenge string) (string, error)
+	// EnrollTOTP starts an enrollment with a new secret, replacing any pending one
+	EnrollTOTP(ctx context.Context, userID string) (*TOTPEnrollment, error)
+	// ConfirmTOTP enables 2FA with a code from the pending secret and
+	// returns new recovery codes
+	ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error)
+	// FinishEnrollment uses up a totp_enrollment challenge once 2FA is
+	// enabled and issues the token the login was waiting for
+	FinishEnrollment(ctx context.Context, challenge string, client Client) (*TokenResponse, error)
+	// DisableTOTP turns 2FA off after checking a code; roles that require
+	// 2FA fail with ErrTOTPRequired
+	DisableTOTP(ctx context.Context, userID, code string) error
+	// RegenerateRecoveryCodes replaces the recovery codes after checking a code
+	RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error)
+	// ResetTOTP turns 2FA off without a code, for admins helping a locked-out user
+	ResetTOTP(ctx context.Context, userID string) error
+	// VerifyEmail marks the address a verification token was sent to as verified
+	VerifyEmail(ctx context.Context, token string) error
+	// ResendVerification mails a new verification token to an unverified
+	// user. Unknown or verified addresses are ignored, so callers cannot
+	// probe which addresses are registered.
+	ResendVerification(ctx context.Context, email string) error
+	// RequestPasswordReset mails a reset token; unknown addresses are ignored
+	RequestPasswordReset(ctx context.Context, email string) error
+	// ResetPassword sets a new password with a reset token and invalidates
+	// the user's other reset tokens and sessions
+	ResetPassword(ctx context.Context, token, password string) error
+	// Refresh exchanges a refresh token for a new token pair. A refresh
+	// token that was exchanged before revokes its whole session.
+	Refresh(ctx context.Context, refreshToken string, client Client) (*TokenResponse, error)
+	// ListSessions returns the live sessions of the user
+	ListSessions(ctx context.Context, userID string) ([]*Session, error)
+	// RevokeSession ends one session of the user
+	RevokeSession(ctx context.Context, userID, sessionID, reason string) error
+	// RevokeSessions ends every session of the user
+	RevokeSessions(ctx context.Context, userID, reason string) error
+	// RevokedSessions lists the sessions whose access tokens may still be
+	// unexpired, for services to refuse them
+	RevokedSessions(ctx context.Context) ([]auth.RevokedSession, error)
+	// CreateAPIKey stores key, filling in its secret parts, and returns it
+	// with the key itself, which is not stored
+	CreateAPIKey(ctx context.Context, key *APIKey) (*APIKey, string, error)
+	ListAPIKeys(ctx context.Context) ([]*APIKey, error)
+	GetAPIKey(ctx context.Context, id string) (*APIKey, error)
+	// RotateAPIKey replaces the key of id; the old one keeps working for a
+	// grace period
+	RotateAPIKey(ctx context.Context, id string) (*APIKey, string, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	// VerifyAPIKey returns the identity a live key acts with, or fails with
+	// ErrInvalidAPIKey
+	VerifyAPIKey(ctx context.Context, key string) (*auth.Identity, error)
+	// OIDCProviders names the configured OIDC providers
+	OIDCProviders() []string
+	// StartOIDCLogin begins a login at provider and returns the URL of its
+	// login page, and the state the provider will redirect back with
+	StartOIDCLogin(ctx context.Context, provider string) (url, state string, err error)
+	// FinishOIDCLogin completes a login with the state and code the provider
+	// redirected back with. The provider account logs into the user it is
+	// linked to, else is linked to the user with its email if the provider
+	// verified it, else gets a new user.
+	FinishOIDCLogin(ctx context.Context, provider, state, code string, client Client) (*LoginResult, error)
+	// RequestExport queues an export of everything stored about the user,
+	// or returns the one already queued
+	RequestExport(ctx context.Context, userID string) (*PrivacyJob, error)
+	// RequestErasure soft-deletes the user and ends their sessions at once,
+	// and queues the erasure of their data, or returns the one already queued
+	RequestErasure(ctx context.Context, userID string) (*PrivacyJob, error)
+	ListPrivacyJobs(ctx context.Context, userID string) ([]*PrivacyJob, error)
+	// GetPrivacyJob returns a job of the user with its archive, if any
+	GetPrivacyJob(ctx context.Context, userID, jobID string) (*PrivacyJob, error)
+	// RunPrivacyJobs runs queued jobs, looking for new ones every interval,
+	// until ctx is done
+	RunPrivacyJobs(ctx context.Context, interval time.Duration)
+}
+
+// TokenIssuer signs access tokens for authenticated users
+type TokenIssuer interface {
+	Issue(id auth.Identity) (string, time.Duration, error)
 }
@@ -1,12 +1,314 @@
 package domain
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AnyVersion makes a write unconditional. It equals etag.Any, which
+// "If-Match: *" yields; internal gRPC callers use it as well.
+const AnyVersion int64 = -1
 
 type User struct {
-	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Name      string             `json:"name" bson:"name"`
-	Email     string             `json:"email" bson:"email"`
-	Password  string             `json:"password" bson:"password"`
-	CreatedAt int64              `json:"created_at" bson:"created_at"`
-	UpdatedAt int64              `json:"updated_at" bson:"updated_at"`
+	ID       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name     string             `json:"name" bson:"name"`
+	Email    string             `json:"email" bson:"email"`
+	Password string             `json:"-" bson:"password"` // bcrypt hash, never serialized
+	Role     string             `json:"role" bson:"role"`
+	// EmailVerified is set once the user proves they own Email, and cleared
+	// when Email changes
+	EmailVerified bool `json:"email_verified" bson:"email_verified"`
+	// TOTPEnabled is set once the user confirmed a TOTP enrollment; login
+	// then takes a code as a second factor
+	TOTPEnabled bool `json:"totp_enabled" bson:"totp_enabled"`
+	// TOTPSecret is the base32 secret, pending until TOTPEnabled
+	TOTPSecret string `json:"-" bson:"totp_secret,omitempty"`
+	// TOTPLastStep is the time step of the last code accepted, so codes cannot be replayed
+	TOTPLastStep int64 `json:"-" bson:"totp_last_step,omitempty"`
+	// RecoveryCodes holds the SHA-256 hashes of the unused recovery codes
+	RecoveryCodes []string `json:"-" bson:"recovery_codes,omitempty"`
+	// Identities are the accounts at OIDC providers the user can log in with
+	Identities []LinkedIdentity `json:"identities,omitempty" bson:"identities,omitempty"`
+	Profile    Profile          `json:"profile" bson:"profile"`
+	// MarketingConsent is in effect only once recorded as a ConsentRecord
+	MarketingConsent MarketingConsent `json:"marketing_consent" bson:"marketing_consent"`
+	CreatedAt        int64            `json:"created_at" bson:"created_at"`
+	UpdatedAt        int64            `json:"updated_at" bson:"updated_at"`
+	// Version is incremented by every write and served as the ETag
+	Version int64 `json:"version" bson:"version"`
+	// DeletedAt is set when the user is soft-deleted; such users are hidden
+	// from every query but the admin views and can be restored
+	DeletedAt int64  `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	DeletedBy string `json:"deleted_by,omitempty" bson:"deleted_by,omitempty"`
+}
+
+// LinkedIdentity is an account at an OIDC provider, known by the provider's
+// subject identifier, which unlike the email never changes
+type LinkedIdentity struct {
+	Provider string `json:"provider" bson:"provider" example:"google"`
+	Subject  string `json:"subject" bson:"subject"`
+	// Email is the address the provider reported when the identity was linked
+	Email    string `json:"email" bson:"email"`
+	LinkedAt int64  `json:"linked_at" bson:"linked_at"`
+}
+
+// Profile is what a user tells about themself besides name and email; every
+// field is optional
+type Profile struct {
+	// Phone is in E.164 form
+	Phone string `json:"phone,omitempty" bson:"phone,omitempty" example:"+14155550123"`
+	// BirthDate is a calendar date, YYYY-MM-DD
+	BirthDate string `json:"birth_date,omitempty" bson:"birth_date,omitempty" example:"1990-04-23"`
+	// Locale is a BCP 47 language tag, for the language of emails and pages
+	Locale string `json:"locale,omitempty" bson:"locale,omitempty" example:"en-US"`
+	// Currency is the ISO 4217 code prices are shown in
+	Currency string `json:"currency,omitempty" bson:"currency,omitempty" example:"EUR"`
+}
+
+// MarketingConsent is whether the user agreed to marketing messages, and
+// when they last gave and withdrew their consent, in Unix seconds
+type MarketingConsent struct {
+	Granted     bool  `json:"granted" bson:"granted"`
+	GrantedAt   int64 `json:"granted_at,omitempty" bson:"granted_at,omitempty"`
+	WithdrawnAt int64 `json:"withdrawn_at,omitempty" bson:"withdrawn_at,omitempty"`
+}
+
+// Purposes a user consents to
+const ConsentMarketing = "marketing"
+
+// Where a user gave or withdrew a consent
+const (
+	ConsentSignup  = "signup"
+	ConsentProfile = "profile"
+)
+
+// ConsentRecord is one change of a user's consent. Records are never changed
+// or deleted, so that they prove what the user agreed to and when; erasing
+// the user only anonymizes them.
+type ConsentRecord struct {
+	ID      primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID  string             `json:"user_id" bson:"user_id"`
+	Purpose string             `json:"purpose" bson:"purpose" enums:"marketing"`
+	Granted bool               `json:"granted" bson:"granted"`
+	Source  string             `json:"source" bson:"source" enums:"signup,profile"`
+	// ChangedBy is the admin who made the change for the user; empty when
+	// the user made it
+	ChangedBy string `json:"changed_by,omitempty" bson:"changed_by,omitempty"`
+	IP        string `json:"ip,omitempty" bson:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+	CreatedAt int64  `json:"created_at" bson:"created_at"`
+}
+
+// ExternalIdentity is what an OIDC provider reports about a user who logged in there
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// NormalizeEmail returns the form emails are stored and looked up in, so
+// that addresses differing only in case or surrounding spaces are one user
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Statuses a UserFilter can select
+const (
+	StatusActive  = "active"
+	StatusDeleted = "deleted"
+	StatusAny     = "any"
+)
+
+// UserFilter narrows GetAllUsers. The zero value selects every active user.
+type UserFilter struct {
+	EmailPrefix string
+	NamePrefix  string // matched case-insensitively
+	Role        string
+	// Status is StatusActive (when empty), StatusDeleted or StatusAny
+	Status string
+	// CreatedAfter and CreatedBefore bound created_at in Unix seconds,
+	// inclusive and exclusive; 0 leaves the bound open
+	CreatedAfter  int64
+	CreatedBefore int64
+	// After is a page cursor: only users with a greater ID are returned
+	After string
+	// Limit caps the number of users returned; 0 means no cap
+	Limit int
+}
+
+// Purposes of an ActionToken
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+	// Login challenges, issued after the password instead of a JWT
+	PurposeTOTPLogin  = "totp_login"
+	PurposeTOTPEnroll = "totp_enroll"
+	// The state of an OIDC login, waiting for the provider's redirect
+	PurposeOIDCState = "oidc_state"
+)
+
+// ActionToken is a single-use secret mailed to a user. Only the SHA-256 of
+// the secret is stored, so a database leak does not yield usable links.
+type ActionToken struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty"`
+	Hash    string             `bson:"hash"`
+	Purpose string             `bson:"purpose"`
+	UserID  string             `bson:"user_id"`
+	// Email is the address the token was sent to; verifying it fails if
+	// the user has changed address since
+	Email     string    `bson:"email"`
+	CreatedAt int64     `bson:"created_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+	// Data holds what a flow needs back with the token, e.g. the PKCE
+	// verifier of an OIDC login
+	Data map[string]string `bson:"data,omitempty"`
+}
+
+// LoginResult is the outcome of a correct password: a token, or a Challenge
+// when a second factor is needed first
+type LoginResult struct {
+	Token     *TokenResponse
+	Challenge *Challenge
+}
+
+// TOTPEnrollment is a new TOTP secret, to be confirmed with a code from it
+type TOTPEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri" example:"otpauth://totp/ecommerce-ms:jane@example.com?issuer=ecommerce-ms&secret=JBSWY3DPEHPK3PXP"`
+}
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// LoginAttempt counts the recent failed logins of a key: an email address,
+// whether or not it is registered, or a client IP
+type LoginAttempt struct {
+	Key      string `bson:"_id"`
+	Failures int    `bson:"failures"`
+	// Lockouts counts the locks in a row, each longer than the last
+	Lockouts    int       `bson:"lockouts"`
+	LockedUntil time.Time `bson:"locked_until,omitempty"`
+	// ExpiresAt is when the record is forgotten
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Client describes where a request came from
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
+// Session is a login on one device. Each access token names its session, and
+// the session holds the refresh token that renews them; revoking it ends both.
+type Session struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID     string             `json:"user_id" bson:"user_id"`
+	UserAgent  string             `json:"user_agent" bson:"user_agent"`
+	IP         string             `json:"ip" bson:"ip"`
+	CreatedAt  int64              `json:"created_at" bson:"created_at"`
+	LastSeenAt int64              `json:"last_seen_at" bson:"last_seen_at"`
+	// ExpiresAt ends the session unless a refresh extends it. Revoking moves
+	// it to when the session's last access token expires.
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+	// RefreshHash is the SHA-256 of the current refresh token
+	RefreshHash string `json:"-" bson:"refresh_hash"`
+	// UsedHashes are the refresh tokens rotated out. One presented again
+	// was copied, so the whole session is revoked.
+	UsedHashes    []string `json:"-" bson:"used_hashes,omitempty"`
+	RevokedAt     int64    `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	RevokedReason string   `json:"revoked_reason,omitempty" bson:"revoked_reason,omitempty"`
+	// Current marks the session of the caller's token
+	Current bool `json:"current" bson:"-"`
+}
+
+// Reasons a session is revoked
+const (
+	RevokedLogout        = "logout"
+	RevokedByUser        = "revoked"
+	RevokedByAdmin       = "admin"
+	RevokedPasswordReset = "password_reset"
+	RevokedUserDeleted   = "user_deleted"
+	RevokedTokenReuse    = "refresh_token_reuse"
+)
+
+// APIKey lets a machine client call services without a login, within its
+// Scopes. Only the SHA-256 of the key is stored; Prefix, its first
+// characters, tells keys apart in listings and logs.
+type APIKey struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name   string             `json:"name" bson:"name"`
+	Prefix string             `json:"prefix" bson:"prefix" example:"sk_Xq3vT9aB"`
+	Hash   string             `json:"-" bson:"hash"`
+	// PreviousHash is the key replaced by the last rotation, which keeps
+	// working until PreviousExpiresAt so that clients can switch over
+	PreviousHash      string   `json:"-" bson:"previous_hash,omitempty"`
+	PreviousExpiresAt int64    `json:"previous_expires_at,omitempty" bson:"previous_expires_at,omitempty"`
+	Role              string   `json:"role" bson:"role"`
+	Scopes            []string `json:"scopes" bson:"scopes" example:"orders:read,orders:write"`
+	CreatedBy         string   `json:"created_by" bson:"created_by"`
+	CreatedAt         int64    `json:"created_at" bson:"created_at"`
+	// ExpiresAt ends the key, in Unix seconds; 0 means never
+	ExpiresAt  int64 `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	RotatedAt  int64 `json:"rotated_at,omitempty" bson:"rotated_at,omitempty"`
+	LastUsedAt int64 `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	RevokedAt  int64 `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+// Kinds of a PrivacyJob
+const (
+	// JobExport gathers everything the services hold about the user
+	JobExport = "export"
+	// JobErasure anonymizes the user's orders, payments and reviews, then deletes the user
+	JobErasure = "erasure"
+)
+
+// Statuses of a PrivacyJob
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// PrivacyJob answers a data subject request of a user. A worker runs it in
+// the background, retrying failed runs; every step can safely be repeated.
+type PrivacyJob struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Kind        string             `json:"kind" bson:"kind" enums:"export,erasure"`
+	UserID      string             `json:"user_id" bson:"user_id"`
+	RequestedBy string             `json:"requested_by,omitempty" bson:"requested_by,omitempty"`
+	Status      string             `json:"status" bson:"status" enums:"pending,running,completed,failed"`
+	// Active is set while the job is pending or running; a user has at most
+	// one active job of each kind
+	Active bool `json:"-" bson:"active,omitempty"`
+	// Attempts counts the runs started
+	Attempts int `json:"attempts" bson:"attempts"`
+	// Error is why the last run failed
+	Error string `json:"error,omitempty" bson:"error,omitempty"`
+	// Records counts, per source, the records exported or anonymized
+	Records     map[string]int64 `json:"records,omitempty" bson:"records,omitempty" example:"orders:3,payments:2"`
+	CreatedAt   int64            `json:"created_at" bson:"created_at"`
+	StartedAt   int64            `json:"started_at,omitempty" bson:"started_at,omitempty"`
+	CompletedAt int64            `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+	// RunAt is when a worker may claim the job: at once when queued, after
+	// a backoff when a run failed, and when the lease ends while running,
+	// so that a job whose worker died is taken up again
+	RunAt time.Time `json:"-" bson:"run_at"`
+	// Archive is the JSON document of a completed export
+	Archive []byte `json:"-" bson:"archive,omitempty"`
+	// ExpiresAt is when a completed export and its archive are removed
+	ExpiresAt time.Time `json:"expires_at,omitzero" bson:"expires_at,omitempty"`
+}
+
+// HasArchive reports whether the job is an export whose archive can be downloaded
+func (j *PrivacyJob) HasArchive() bool {
+	return j.Kind == JobExport && j.Status == JobCompleted && len(j.Archive) > 0
 }
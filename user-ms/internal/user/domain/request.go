@@ -1,7 +1,157 @@
 package domain
 
+import "shared/auth"
+
 type CreateUserRequest struct {
-	Name  string `json:"name" validate:"required,min=2"`
+	Name     string `json:"name" validate:"required,min=2"`
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+	// The profile is optional, see Profile
+	Phone     string `json:"phone,omitempty" validate:"omitempty,e164" example:"+14155550123"`
+	BirthDate string `json:"birth_date,omitempty" validate:"omitempty,datetime=2006-01-02" example:"1990-04-23"`
+	Locale    string `json:"locale,omitempty" validate:"omitempty,bcp47_language_tag" example:"en-US"`
+	Currency  string `json:"currency,omitempty" validate:"omitempty,iso4217" example:"EUR"`
+	// MarketingConsent agrees to marketing messages; the consent is recorded
+	MarketingConsent bool `json:"marketing_consent"`
+}
+
+// UpdateUserRequest is the body of PUT /users/{id} and the document PATCH
+// merge patches are applied to; bson names the fields a patch may change
+type UpdateUserRequest struct {
+	Name  string `json:"name" bson:"name" validate:"required,min=2"`
+	Email string `json:"email" bson:"email" validate:"required,email"`
+	// CurrentPassword confirms a change of the email, which users must send;
+	// admins need not
+	CurrentPassword string `json:"current_password,omitempty" bson:"-"`
+}
+
+// ProfileRequest is the document PATCH /users/{id}/profile merge patches are
+// applied to; bson names the fields a patch may change. It leaves out the
+// email and password, which log the user in.
+type ProfileRequest struct {
+	Name      string `json:"name" bson:"name" validate:"required,min=2"`
+	Phone     string `json:"phone,omitempty" bson:"profile.phone" validate:"omitempty,e164" example:"+14155550123"`
+	BirthDate string `json:"birth_date,omitempty" bson:"profile.birth_date" validate:"omitempty,datetime=2006-01-02" example:"1990-04-23"`
+	Locale    string `json:"locale,omitempty" bson:"profile.locale" validate:"omitempty,bcp47_language_tag" example:"en-US"`
+	Currency  string `json:"currency,omitempty" bson:"profile.currency" validate:"omitempty,iso4217" example:"EUR"`
+	// MarketingConsent gives or withdraws the consent, which is recorded
+	MarketingConsent bool `json:"marketing_consent" bson:"-"`
+}
+
+// NewProfileRequest returns the document of user, for patches to apply to
+func NewProfileRequest(user *User) ProfileRequest {
+	return ProfileRequest{
+		Name:             user.Name,
+		Phone:            user.Profile.Phone,
+		BirthDate:        user.Profile.BirthDate,
+		Locale:           user.Profile.Locale,
+		Currency:         user.Profile.Currency,
+		MarketingConsent: user.MarketingConsent.Granted,
+	}
+}
+
+type LoginRequest struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// EmailRequest names the account for resending a verification or requesting a password reset
+type EmailRequest struct {
 	Email string `json:"email" validate:"required,email"`
-	Age   int    `json:"age" validate:"gte=0,lte=120"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+}
+
+// Challenge is the answer to a login that needs a second factor. Type
+// totp asks for a code at /auth/2fa/verify; totp_enrollment means the
+// user's role requires 2FA and they must enroll first.
+type Challenge struct {
+	Challenge string `json:"challenge"`
+	Type      string `json:"type" enums:"totp,totp_enrollment"`
+	ExpiresIn int64  `json:"expires_in" example:"300"` // seconds
+}
+
+// Challenge types
+const (
+	ChallengeTOTP       = "totp"
+	ChallengeEnrollment = "totp_enrollment"
+)
+
+type VerifyTOTPRequest struct {
+	Challenge string `json:"challenge" validate:"required"`
+	// Code is a code from the authenticator app or an unused recovery code
+	Code string `json:"code" validate:"required"`
+}
+
+// EnrollTOTPRequest authenticates an enrollment with the challenge of a
+// login, for users whose role requires 2FA; others send a bearer token
+type EnrollTOTPRequest struct {
+	Challenge string `json:"challenge,omitempty"`
+}
+
+type ConfirmTOTPRequest struct {
+	Challenge string `json:"challenge,omitempty"`
+	Code      string `json:"code" validate:"required,len=6,numeric"`
+}
+
+// TOTPCodeRequest proves possession of the second factor
+type TOTPCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// ConfirmTOTPResponse carries the recovery codes, shown only once, and for
+// an enrollment during login the access token as well
+type ConfirmTOTPResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	*TokenResponse
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type" example:"Bearer"`
+	ExpiresIn   int64  `json:"expires_in" example:"3600"` // seconds
+	// RefreshToken gets a new token pair from /auth/refresh; each one works once
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name string `json:"name" validate:"required,max=100" example:"warehouse"`
+	// Role is the role the key acts with; customer when left out
+	Role   string   `json:"role" validate:"omitempty,oneof=customer admin"`
+	Scopes []string `json:"scopes" validate:"required,min=1,dive,required" example:"orders:read,orders:write"`
+	// ExpiresAt ends the key, in Unix seconds; left out, it never expires
+	ExpiresAt int64 `json:"expires_at" validate:"omitempty,gt=0"`
+}
+
+// APIKeySecretResponse carries a new key, shown only this once
+type APIKeySecretResponse struct {
+	*APIKey
+	Key string `json:"key"`
+}
+
+type VerifyAPIKeyRequest struct {
+	Key string `json:"key" validate:"required"`
+}
+
+type OIDCProvidersResponse struct {
+	Providers []string `json:"providers" example:"google,mock"`
+}
+
+type RevokedSessionsResponse struct {
+	Sessions []auth.RevokedSession `json:"sessions"`
 }
@@ -0,0 +1,135 @@
+package usecase
+
+import (
+	"context"
+	"sync"
+	"time"
+	"user-ms/internal/user/domain"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// LockoutPolicy slows down and then locks out repeated failed logins
+type LockoutPolicy struct {
+	// MaxFailures per email and MaxIPFailures per client IP lock it out
+	MaxFailures   int
+	MaxIPFailures int
+	// Window is how long a failure counts
+	Window time.Duration
+	// Lockout is the first lock's duration; each lock in a row doubles it
+	// up to MaxLockout
+	Lockout    time.Duration
+	MaxLockout time.Duration
+	// Delay holds back the answer after the first failure, doubling with
+	// each further one up to MaxDelay
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// attemptKey is something logins are counted against
+type attemptKey struct {
+	scope string // account or ip
+	key   string
+	max   int
+}
+
+func (uc *userUseCase) attemptKeys(email, ip string) []attemptKey {
+	keys := []attemptKey{{scope: "account", key: "email:" + email, max: uc.opts.Lockout.MaxFailures}}
+	if ip != "" {
+		keys = append(keys, attemptKey{scope: "ip", key: "ip:" + ip, max: uc.opts.Lockout.MaxIPFailures})
+	}
+	return keys
+}
+
+// checkLock fails with a LockedError while any key is locked out, and
+// otherwise waits out the delay earned by their failures
+func (uc *userUseCase) checkLock(ctx context.Context, email, ip string, keys []attemptKey) error {
+	failures := 0
+	for _, k := range keys {
+		a, err := uc.attempts.GetAttempt(ctx, k.key)
+		if err != nil {
+			return err
+		}
+		if a == nil {
+			continue
+		}
+		if a.LockedUntil.After(time.Now()) {
+			uc.events.LoginFailed(ctx, email, ip, "locked")
+			return &domain.LockedError{Until: a.LockedUntil}
+		}
+		failures = max(failures, a.Failures)
+	}
+
+	if failures == 0 {
+		return nil
+	}
+	timer := time.NewTimer(backoff(uc.opts.Lockout.Delay, failures-1, uc.opts.Lockout.MaxDelay))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// loginFailed counts a failure against keys, locks those over their limit,
+// and returns the error the caller gets: the same whatever the reason, so
+// that it does not reveal whether the email exists
+func (uc *userUseCase) loginFailed(ctx context.Context, email, ip, reason string, keys []attemptKey) error {
+	uc.events.LoginFailed(ctx, email, ip, reason)
+
+	for _, k := range keys {
+		a, err := uc.attempts.RecordFailure(ctx, k.key, uc.opts.Lockout.Window)
+		if err != nil {
+			return err
+		}
+		if a.Failures < k.max {
+			continue
+		}
+
+		until := time.Now().Add(backoff(uc.opts.Lockout.Lockout, a.Lockouts, uc.opts.Lockout.MaxLockout))
+		if err := uc.attempts.Lock(ctx, k.key, until, uc.opts.Lockout.Window); err != nil {
+			return err
+		}
+		uc.events.AccountLocked(ctx, k.scope, k.key, until)
+	}
+
+	if reason == "invalid_code" {
+		return domain.ErrInvalidCode
+	}
+	return domain.ErrInvalidCredentials
+}
+
+// loginSucceeded clears the account's failures once a token is issued. Those
+// of the IP are kept, so logging into one account does not reset guessing at others.
+func (uc *userUseCase) loginSucceeded(ctx context.Context, email string) error {
+	return uc.attempts.ResetAttempts(ctx, "email:"+email)
+}
+
+func (uc *userUseCase) UnlockUser(ctx context.Context, id string) error {
+	user, err := uc.repo.GetUserByID(ctx, id, false)
+	if err != nil {
+		return err
+	}
+	return uc.loginSucceeded(ctx, user.Email)
+}
+
+// backoff doubles base n times, up to limit
+func backoff(base time.Duration, n int, limit time.Duration) time.Duration {
+	d := base
+	for range n {
+		if d >= limit/2 {
+			return limit
+		}
+		d *= 2
+	}
+	return min(d, limit)
+}
+
+// dummyHash is compared against for unknown emails, so that they take as
+// long to refuse as a wrong password
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+	return hash
+})
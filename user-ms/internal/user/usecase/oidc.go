@@ -0,0 +1,159 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"user-ms/internal/user/domain"
+
+	"shared/auth"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func (uc *userUseCase) OIDCProviders() []string {
+	names := make([]string, len(uc.providers))
+	for i, p := range uc.providers {
+		names[i] = p.Name()
+	}
+	return names
+}
+
+func (uc *userUseCase) StartOIDCLogin(ctx context.Context, name string) (string, string, error) {
+	provider, err := uc.provider(name)
+	if err != nil {
+		return "", "", err
+	}
+
+	var secrets [3]string
+	for i := range secrets {
+		if secrets[i], err = newSecret(); err != nil {
+			return "", "", err
+		}
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	url, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	// the state token binds the redirect back to this login; it carries the
+	// secrets the code exchange needs
+	now := time.Now()
+	err = uc.actionTokens.CreateToken(ctx, &domain.ActionToken{
+		Hash:      hashToken(state),
+		Purpose:   domain.PurposeOIDCState,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(uc.opts.OIDCStateTTL),
+		Data:      map[string]string{"provider": name, "nonce": nonce, "verifier": verifier},
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return url, state, nil
+}
+
+func (uc *userUseCase) FinishOIDCLogin(ctx context.Context, name, state, code string, client domain.Client) (*domain.LoginResult, error) {
+	provider, err := uc.provider(name)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := uc.actionTokens.ConsumeToken(ctx, domain.PurposeOIDCState, hashToken(state))
+	if errors.Is(err, domain.ErrInvalidToken) {
+		return nil, domain.ErrInvalidOIDCState
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.Data["provider"] != name {
+		return nil, domain.ErrInvalidOIDCState
+	}
+
+	external, err := provider.Exchange(ctx, code, t.Data["verifier"], t.Data["nonce"])
+	if err != nil {
+		return nil, err
+	}
+	user, err := uc.oidcUser(ctx, external)
+	if err != nil {
+		return nil, err
+	}
+	return uc.completeLogin(ctx, user, client)
+}
+
+// oidcUser returns the user an external identity logs into, linking or
+// creating one on its first login
+func (uc *userUseCase) oidcUser(ctx context.Context, external *domain.ExternalIdentity) (*domain.User, error) {
+	user, err := uc.repo.GetUserByIdentity(ctx, external.Provider, external.Subject)
+	if !errors.Is(err, domain.ErrNotFound) {
+		return user, err
+	}
+
+	email := domain.NormalizeEmail(external.Email)
+	if email == "" {
+		return nil, fmt.Errorf("%w: the provider did not share an email address", domain.ErrOIDCLoginFailed)
+	}
+	identity := domain.LinkedIdentity{
+		Provider: external.Provider,
+		Subject:  external.Subject,
+		Email:    email,
+		LinkedAt: time.Now().Unix(),
+	}
+
+	user, err = uc.repo.GetUserByEmail(ctx, email)
+	switch {
+	case err == nil && external.EmailVerified:
+		// the provider vouches that whoever logged in there owns the address
+		return uc.repo.LinkIdentity(ctx, user.ID.Hex(), identity)
+	case err == nil:
+		// anyone can claim an address at a provider that does not check it
+		return nil, domain.ErrUnverifiedLinking
+	case !errors.Is(err, domain.ErrNotFound):
+		return nil, err
+	}
+
+	// the account has no password: the hash of a random one, never shown,
+	// refuses password logins until the user sets one through a reset
+	secret, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	password, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	name := external.Name
+	if name == "" {
+		name = email
+	}
+	now := time.Now().Unix()
+	user, err = uc.repo.CreateUser(ctx, &domain.User{
+		Name:          name,
+		Email:         email,
+		Password:      string(password),
+		Role:          auth.RoleCustomer,
+		EmailVerified: external.EmailVerified,
+		Identities:    []domain.LinkedIdentity{identity},
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		Version:       1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !user.EmailVerified {
+		uc.sendVerification(ctx, user)
+	}
+	return user, nil
+}
+
+func (uc *userUseCase) provider(name string) (domain.IdentityProvider, error) {
+	for _, p := range uc.providers {
+		if p.Name() == name {
+			return p, nil
+		}
+	}
+	return nil, domain.ErrUnknownProvider
+}
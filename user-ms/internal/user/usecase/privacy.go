@@ -0,0 +1,250 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+	"user-ms/internal/user/domain"
+
+	"shared/auth"
+)
+
+// Runs of privacy jobs
+const (
+	// jobLease is how long a run may take before another worker takes the job over
+	jobLease = 10 * time.Minute
+	// maxJobAttempts failed runs fail a job for good
+	maxJobAttempts = 5
+	// a failed run is retried after jobBackoff, doubling with each attempt
+	// up to maxJobBackoff
+	jobBackoff    = 30 * time.Second
+	maxJobBackoff = 30 * time.Minute
+)
+
+func (uc *userUseCase) RequestExport(ctx context.Context, userID string) (*domain.PrivacyJob, error) {
+	if _, err := uc.repo.GetUserByID(ctx, userID, true); err != nil {
+		return nil, err
+	}
+	return uc.queueJob(ctx, userID, domain.JobExport)
+}
+
+func (uc *userUseCase) RequestErasure(ctx context.Context, userID string) (*domain.PrivacyJob, error) {
+	user, err := uc.repo.GetUserByID(ctx, userID, true)
+	if err != nil {
+		return nil, err
+	}
+	job, err := uc.queueJob(ctx, userID, domain.JobErasure)
+	if err != nil {
+		return nil, err
+	}
+
+	// the user is gone at once; their data follows once the job has reached
+	// every service
+	if user.DeletedAt == 0 {
+		if err := uc.DeleteUser(ctx, userID, domain.AnyVersion); err != nil && !errors.Is(err, domain.ErrNotFound) {
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+// queueJob creates a job of kind for the user, or returns the active one
+func (uc *userUseCase) queueJob(ctx context.Context, userID, kind string) (*domain.PrivacyJob, error) {
+	job, err := uc.jobs.FindActiveJob(ctx, userID, kind)
+	if !errors.Is(err, domain.ErrJobNotFound) {
+		return job, err
+	}
+
+	now := time.Now()
+	job = &domain.PrivacyJob{
+		Kind:      kind,
+		UserID:    userID,
+		Status:    domain.JobPending,
+		Active:    true,
+		CreatedAt: now.Unix(),
+		RunAt:     now,
+	}
+	if caller, ok := auth.FromContext(ctx); ok {
+		job.RequestedBy = caller.UserID
+	}
+	err = uc.jobs.CreateJob(ctx, job)
+	if errors.Is(err, domain.ErrJobActive) {
+		// a concurrent request queued one first
+		return uc.jobs.FindActiveJob(ctx, userID, kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (uc *userUseCase) ListPrivacyJobs(ctx context.Context, userID string) ([]*domain.PrivacyJob, error) {
+	return uc.jobs.ListJobs(ctx, userID)
+}
+
+func (uc *userUseCase) GetPrivacyJob(ctx context.Context, userID, jobID string) (*domain.PrivacyJob, error) {
+	return uc.jobs.GetJob(ctx, userID, jobID)
+}
+
+func (uc *userUseCase) RunPrivacyJobs(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// run every due job before waiting again
+		for uc.runNextJob(ctx) {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runNextJob runs the job that is due longest and reports whether there was one
+func (uc *userUseCase) runNextJob(ctx context.Context) bool {
+	job, err := uc.jobs.ClaimJob(ctx, time.Now().Add(jobLease))
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.ErrorContext(ctx, "failed to claim a privacy job", "error", err)
+		}
+		return false
+	}
+	if job == nil {
+		return false
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, jobLease)
+	defer cancel()
+	switch job.Kind {
+	case domain.JobExport:
+		err = uc.runExport(runCtx, job)
+	case domain.JobErasure:
+		err = uc.runErasure(runCtx, job)
+	default:
+		err = fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+	uc.finishJob(ctx, job, err)
+	return true
+}
+
+// finishJob records the outcome of a run: the job completes, is retried
+// later, or fails once out of attempts or when retrying cannot help
+func (uc *userUseCase) finishJob(ctx context.Context, job *domain.PrivacyJob, err error) {
+	now := time.Now()
+	log := slog.With("job_id", job.ID.Hex(), "kind", job.Kind, "attempt", job.Attempts)
+	switch {
+	case err == nil:
+		job.Status, job.Active, job.Error = domain.JobCompleted, false, ""
+		job.CompletedAt = now.Unix()
+		log.InfoContext(ctx, "privacy job completed", "records", job.Records)
+	case job.Attempts >= maxJobAttempts || errors.Is(err, domain.ErrNotFound):
+		job.Status, job.Active, job.Error = domain.JobFailed, false, err.Error()
+		job.CompletedAt = now.Unix()
+		log.ErrorContext(ctx, "privacy job failed", "error", err)
+	default:
+		job.Status, job.Error = domain.JobPending, err.Error()
+		job.RunAt = now.Add(backoff(jobBackoff, job.Attempts-1, maxJobBackoff))
+		log.WarnContext(ctx, "privacy job run failed; retrying", "retry_at", job.RunAt, "error", err)
+	}
+
+	// record the outcome even when shutting down, so the job is not run again needlessly
+	if err := uc.jobs.SaveJob(context.WithoutCancel(ctx), job); err != nil {
+		log.ErrorContext(ctx, "failed to save privacy job", "error", err)
+	}
+}
+
+// runExport gathers the user's profile, sessions, consents and the records of every
+// source into the job's archive, a JSON object with a key per part
+func (uc *userUseCase) runExport(ctx context.Context, job *domain.PrivacyJob) error {
+	user, err := uc.repo.GetUserByID(ctx, job.UserID, true)
+	if err != nil {
+		return err
+	}
+	sessions, err := uc.sessions.ListSessions(ctx, job.UserID)
+	if err != nil {
+		return err
+	}
+	consents, err := uc.consents.ListConsents(ctx, job.UserID)
+	if err != nil {
+		return err
+	}
+
+	archive := map[string]any{"user": user, "sessions": sessions, "consents": consents}
+	job.Records = map[string]int64{"user": 1, "sessions": int64(len(sessions)), "consents": int64(len(consents))}
+	for _, source := range uc.sources {
+		data, err := source.Export(ctx, job.UserID)
+		if err != nil {
+			return fmt.Errorf("exporting from %s: %w", source.Name(), err)
+		}
+		var records []json.RawMessage
+		if err := json.Unmarshal(data, &records); err != nil {
+			return fmt.Errorf("exporting from %s: %w", source.Name(), err)
+		}
+		archive[source.Name()] = records
+		job.Records[source.Name()] = int64(len(records))
+	}
+
+	if job.Archive, err = json.Marshal(archive); err != nil {
+		return err
+	}
+	job.ExpiresAt = time.Now().Add(uc.opts.ExportTTL)
+	return nil
+}
+
+// runErasure anonymizes the user's records in every source, then deletes
+// what user-ms holds. Each step changes nothing when repeated, so a failed
+// run is simply run again.
+func (uc *userUseCase) runErasure(ctx context.Context, job *domain.PrivacyJob) error {
+	// The pseudonym derives from the job so that every run uses the same
+	// one. It leads back only to the user's ID, which names nobody once the
+	// user is deleted.
+	pseudonym := "erased-" + job.ID.Hex()
+	if job.Records == nil {
+		job.Records = map[string]int64{}
+	}
+
+	for _, source := range uc.sources {
+		n, err := source.Anonymize(ctx, job.UserID, pseudonym)
+		if err != nil {
+			return fmt.Errorf("anonymizing in %s: %w", source.Name(), err)
+		}
+		job.Records[source.Name()] += n
+	}
+	n, err := uc.sessions.AnonymizeSessions(ctx, job.UserID, pseudonym)
+	if err != nil {
+		return err
+	}
+	job.Records["sessions"] += n
+	// the consent history is kept as evidence, but no longer names the user
+	if n, err = uc.consents.AnonymizeConsents(ctx, job.UserID, pseudonym); err != nil {
+		return err
+	}
+	job.Records["consents"] += n
+	if err := uc.actionTokens.DeleteUserTokens(ctx, job.UserID); err != nil {
+		return err
+	}
+	if err := uc.jobs.DeleteArchives(ctx, job.UserID); err != nil {
+		return err
+	}
+
+	// the user goes last, as the lockout records are found by their email
+	user, err := uc.repo.GetUserByID(ctx, job.UserID, true)
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := uc.loginSucceeded(ctx, user.Email); err != nil {
+		return err
+	}
+	if err := uc.repo.PurgeUser(ctx, job.UserID); err != nil && !errors.Is(err, domain.ErrNotFound) {
+		return err
+	}
+	job.Records["user"] = 1
+	return nil
+}
@@ -0,0 +1,100 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"time"
+	"user-ms/internal/user/domain"
+
+	"shared/auth"
+)
+
+// maxAge bounds birth dates
+const maxAge = 120
+
+func (uc *userUseCase) UpdateProfile(ctx context.Context, id string, version int64, fields map[string]any, marketingConsent bool, client domain.Client) (*domain.User, error) {
+	if date, ok := fields["profile.birth_date"].(string); ok {
+		if err := checkBirthDate(date); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := uc.repo.GetUserByID(ctx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	if marketingConsent != user.MarketingConsent.Granted {
+		// a stale write is refused before it leaves a record of a change
+		// that never took effect
+		if version != domain.AnyVersion && version != user.Version {
+			return nil, domain.ErrVersionMismatch
+		}
+		consentFields, err := uc.recordConsent(ctx, user, marketingConsent, domain.ConsentProfile, client)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(fields, consentFields)
+	}
+	return uc.PatchUser(ctx, id, version, fields, "")
+}
+
+func (uc *userUseCase) ListConsents(ctx context.Context, userID string) ([]*domain.ConsentRecord, error) {
+	return uc.consents.ListConsents(ctx, userID)
+}
+
+// setMarketingConsent gives or withdraws the user's marketing consent
+func (uc *userUseCase) setMarketingConsent(ctx context.Context, user *domain.User, granted bool, source string, client domain.Client) (*domain.User, error) {
+	fields, err := uc.recordConsent(ctx, user, granted, source, client)
+	if err != nil {
+		return nil, err
+	}
+	return uc.PatchUser(ctx, user.ID.Hex(), user.Version, fields, "")
+}
+
+// recordConsent records a change of the user's marketing consent and
+// returns the user fields that put it in effect. The record comes first,
+// so that a consent is never in effect without one.
+func (uc *userUseCase) recordConsent(ctx context.Context, user *domain.User, granted bool, source string, client domain.Client) (map[string]any, error) {
+	now := time.Now().Unix()
+	record := &domain.ConsentRecord{
+		UserID:    user.ID.Hex(),
+		Purpose:   domain.ConsentMarketing,
+		Granted:   granted,
+		Source:    source,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		CreatedAt: now,
+	}
+	if caller, ok := auth.FromContext(ctx); ok && caller.UserID != record.UserID {
+		record.ChangedBy = caller.UserID
+	}
+	if err := uc.consents.AddConsent(ctx, record); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]any{"marketing_consent.granted": granted}
+	if granted {
+		fields["marketing_consent.granted_at"] = now
+	} else {
+		fields["marketing_consent.withdrawn_at"] = now
+	}
+	return fields, nil
+}
+
+// checkBirthDate refuses birth dates in the future or over maxAge years ago;
+// an empty date clears the birth date
+func checkBirthDate(date string) error {
+	if date == "" {
+		return nil
+	}
+	d, err := time.Parse(time.DateOnly, date)
+	if err != nil {
+		return fmt.Errorf("%w: birth_date must be a date, YYYY-MM-DD", domain.ErrValidation)
+	}
+	now := time.Now()
+	if d.After(now) || d.Before(now.AddDate(-maxAge, 0, 0)) {
+		return fmt.Errorf("%w: birth_date must be in the past, at most %d years ago", domain.ErrValidation, maxAge)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-ms/internal/user/domain"
+
+	"shared/auth"
+)
+
+// startSession records a login of user from client and issues its first
+// token pair
+func (uc *userUseCase) startSession(ctx context.Context, user *domain.User, client domain.Client) (*domain.TokenResponse, error) {
+	refresh, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &domain.Session{
+		UserID:      user.ID.Hex(),
+		UserAgent:   client.UserAgent,
+		IP:          client.IP,
+		CreatedAt:   now.Unix(),
+		LastSeenAt:  now.Unix(),
+		ExpiresAt:   now.Add(uc.opts.RefreshTTL),
+		RefreshHash: hashToken(refresh),
+	}
+	if err := uc.sessions.CreateSession(ctx, session); err != nil {
+		return nil, err
+	}
+	return uc.issue(user, session, refresh)
+}
+
+// issue signs an access token for user in session
+func (uc *userUseCase) issue(user *domain.User, session *domain.Session, refresh string) (*domain.TokenResponse, error) {
+	token, ttl, err := uc.tokens.Issue(auth.Identity{
+		UserID:    user.ID.Hex(),
+		Email:     user.Email,
+		Role:      user.Role,
+		SessionID: session.ID.Hex(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.TokenResponse{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(ttl.Seconds()),
+		RefreshToken: refresh,
+	}, nil
+}
+
+func (uc *userUseCase) Refresh(ctx context.Context, refreshToken string, client domain.Client) (*domain.TokenResponse, error) {
+	refresh, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := hashToken(refreshToken)
+	session, err := uc.sessions.RotateSession(ctx, hash, hashToken(refresh), client, time.Now().Add(uc.opts.RefreshTTL))
+	if errors.Is(err, domain.ErrInvalidRefreshToken) {
+		return nil, uc.refreshFailed(ctx, hash, client)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := uc.repo.GetUserByID(ctx, session.UserID, false)
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, domain.ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the role or email may have changed since the last token
+	return uc.issue(user, session, refresh)
+}
+
+// refreshFailed handles a refresh token that no live session holds. One
+// rotated out before was used twice, by the client and by whoever copied
+// it, and there is no telling which is which: the whole session is revoked.
+func (uc *userUseCase) refreshFailed(ctx context.Context, hash string, client domain.Client) error {
+	session, err := uc.sessions.FindSessionByUsedHash(ctx, hash)
+	if errors.Is(err, domain.ErrSessionNotFound) {
+		return domain.ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return err
+	}
+
+	err = uc.sessions.RevokeSession(ctx, session.UserID, session.ID.Hex(), domain.RevokedTokenReuse, uc.revokedUntil())
+	if errors.Is(err, domain.ErrSessionNotFound) {
+		// revoked or expired already
+		return domain.ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return err
+	}
+	uc.events.RefreshTokenReused(ctx, session.UserID, session.ID.Hex(), client.IP)
+	return domain.ErrInvalidRefreshToken
+}
+
+func (uc *userUseCase) ListSessions(ctx context.Context, userID string) ([]*domain.Session, error) {
+	return uc.sessions.ListSessions(ctx, userID)
+}
+
+func (uc *userUseCase) RevokeSession(ctx context.Context, userID, sessionID, reason string) error {
+	return uc.sessions.RevokeSession(ctx, userID, sessionID, reason, uc.revokedUntil())
+}
+
+func (uc *userUseCase) RevokeSessions(ctx context.Context, userID, reason string) error {
+	return uc.sessions.RevokeUserSessions(ctx, userID, reason, uc.revokedUntil())
+}
+
+func (uc *userUseCase) RevokedSessions(ctx context.Context) ([]auth.RevokedSession, error) {
+	sessions, err := uc.sessions.ListRevoked(ctx, time.Now().Add(-uc.opts.AccessTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := make([]auth.RevokedSession, len(sessions))
+	for i, s := range sessions {
+		revoked[i] = auth.RevokedSession{
+			ID:        s.ID.Hex(),
+			ExpiresAt: time.Unix(s.RevokedAt, 0).Add(uc.opts.AccessTTL),
+		}
+	}
+	return revoked, nil
+}
+
+// revokedUntil is when the access tokens of a session revoked now have all
+// expired, so it need no longer be listed as revoked
+func (uc *userUseCase) revokedUntil() time.Time {
+	return time.Now().Add(uc.opts.AccessTTL)
+}
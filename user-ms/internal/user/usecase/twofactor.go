@@ -0,0 +1,264 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+	"user-ms/internal/user/domain"
+	"user-ms/pkg/totp"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets at a time
+const recoveryCodeCount = 10
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func (uc *userUseCase) VerifyLogin(ctx context.Context, challenge, code string, client domain.Client) (*domain.TokenResponse, error) {
+	t, err := uc.actionTokens.ConsumeToken(ctx, domain.PurposeTOTPLogin, hashToken(challenge))
+	if errors.Is(err, domain.ErrInvalidToken) {
+		return nil, domain.ErrInvalidChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := uc.challengedUser(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	ip := client.IP
+	keys := uc.attemptKeys(user.Email, ip)
+	if err := uc.checkLock(ctx, user.Email, ip, keys); err != nil {
+		return nil, err
+	}
+	if err := uc.checkCode(ctx, user, code); errors.Is(err, domain.ErrInvalidCode) {
+		return nil, uc.loginFailed(ctx, user.Email, ip, "invalid_code", keys)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := uc.loginSucceeded(ctx, user.Email); err != nil {
+		return nil, err
+	}
+	return uc.startSession(ctx, user, client)
+}
+
+func (uc *userUseCase) EnrollmentUser(ctx context.Context, challenge string) (string, error) {
+	t, err := uc.actionTokens.FindToken(ctx, domain.PurposeTOTPEnroll, hashToken(challenge))
+	if errors.Is(err, domain.ErrInvalidToken) {
+		return "", domain.ErrInvalidChallenge
+	}
+	if err != nil {
+		return "", err
+	}
+	return t.UserID, nil
+}
+
+func (uc *userUseCase) EnrollTOTP(ctx context.Context, userID string) (*domain.TOTPEnrollment, error) {
+	user, err := uc.repo.GetUserByID(ctx, userID, false)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, domain.ErrTOTPEnabled
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	_, err = uc.repo.PatchUser(ctx, userID, domain.AnyVersion, map[string]any{
+		"totp_secret": secret,
+		"updated_at":  time.Now().Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.TOTPEnrollment{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(uc.opts.TOTPIssuer, user.Email, secret),
+	}, nil
+}
+
+func (uc *userUseCase) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	user, err := uc.repo.GetUserByID(ctx, userID, false)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, domain.ErrTOTPEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, domain.ErrTOTPNotEnrolled
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, domain.ErrInvalidCode
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = uc.repo.PatchUser(ctx, userID, domain.AnyVersion, map[string]any{
+		"totp_enabled":   true,
+		"totp_last_step": step,
+		"recovery_codes": hashes,
+		"updated_at":     time.Now().Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (uc *userUseCase) FinishEnrollment(ctx context.Context, challenge string, client domain.Client) (*domain.TokenResponse, error) {
+	userID, err := uc.EnrollmentUser(ctx, challenge)
+	if err != nil {
+		return nil, err
+	}
+	user, err := uc.repo.GetUserByID(ctx, userID, false)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, domain.ErrTOTPNotEnabled
+	}
+
+	// used up only now, so that a failed confirmation can be retried
+	_, err = uc.actionTokens.ConsumeToken(ctx, domain.PurposeTOTPEnroll, hashToken(challenge))
+	if errors.Is(err, domain.ErrInvalidToken) {
+		return nil, domain.ErrInvalidChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := uc.loginSucceeded(ctx, user.Email); err != nil {
+		return nil, err
+	}
+	return uc.startSession(ctx, user, client)
+}
+
+func (uc *userUseCase) DisableTOTP(ctx context.Context, userID, code string) error {
+	user, err := uc.repo.GetUserByID(ctx, userID, false)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(uc.opts.TOTPRoles, user.Role) {
+		return domain.ErrTOTPRequired
+	}
+	if err := uc.checkCode(ctx, user, code); err != nil {
+		return err
+	}
+	return uc.ResetTOTP(ctx, userID)
+}
+
+func (uc *userUseCase) RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error) {
+	user, err := uc.repo.GetUserByID(ctx, userID, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := uc.checkCode(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	_, err = uc.repo.PatchUser(ctx, userID, domain.AnyVersion, map[string]any{
+		"recovery_codes": hashes,
+		"updated_at":     time.Now().Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (uc *userUseCase) ResetTOTP(ctx context.Context, userID string) error {
+	_, err := uc.repo.PatchUser(ctx, userID, domain.AnyVersion, map[string]any{
+		"totp_enabled":   false,
+		"totp_secret":    "",
+		"recovery_codes": []string{},
+		"updated_at":     time.Now().Unix(),
+	})
+	return err
+}
+
+// challenge answers a correct password with a challenge for the second factor
+func (uc *userUseCase) challenge(ctx context.Context, user *domain.User, purpose, kind string) (*domain.LoginResult, error) {
+	token, err := uc.issueToken(ctx, user, purpose, uc.opts.ChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.LoginResult{Challenge: &domain.Challenge{
+		Challenge: token,
+		Type:      kind,
+		ExpiresIn: int64(uc.opts.ChallengeTTL.Seconds()),
+	}}, nil
+}
+
+// challengedUser returns the user a challenge was issued to, who must still exist
+func (uc *userUseCase) challengedUser(ctx context.Context, t *domain.ActionToken) (*domain.User, error) {
+	user, err := uc.repo.GetUserByID(ctx, t.UserID, false)
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, domain.ErrInvalidChallenge
+	}
+	return user, err
+}
+
+// checkCode accepts a TOTP code that was not used before, or uses up a
+// recovery code
+func (uc *userUseCase) checkCode(ctx context.Context, user *domain.User, code string) error {
+	if !user.TOTPEnabled {
+		return domain.ErrTOTPNotEnabled
+	}
+	code = strings.TrimSpace(code)
+
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		fresh, err := uc.repo.UseTOTPStep(ctx, user.ID.Hex(), step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return domain.ErrInvalidCode
+		}
+		return nil
+	}
+
+	used, err := uc.repo.UseRecoveryCode(ctx, user.ID.Hex(), hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return domain.ErrInvalidCode
+	}
+	return nil
+}
+
+// newRecoveryCodes returns fresh recovery codes, formatted xxxxx-xxxxx,
+// and the hashes to store
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for range recoveryCodeCount {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		c := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+		codes = append(codes, c[:5]+"-"+c[5:])
+		hashes = append(hashes, hashToken(c))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts codes typed in any case, with or without the dash
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
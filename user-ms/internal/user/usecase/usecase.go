@@ -2,37 +2,458 @@ package usecase
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
 	"time"
 	"user-ms/internal/user/domain"
+
+	"shared/auth"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
+// Options configures the email, two-factor, session, API key, OIDC and privacy flows of the use case
+type Options struct {
+	// AppURL prefixes the links in emails, e.g. https://shop.example.com
+	AppURL    string
+	VerifyTTL time.Duration
+	ResetTTL  time.Duration
+	// RequireVerifiedEmail refuses logins until the email is verified
+	RequireVerifiedEmail bool
+
+	// TOTPIssuer names the account in authenticator apps
+	TOTPIssuer string
+	// TOTPRoles must use 2FA: their logins demand enrollment first
+	TOTPRoles []string
+	// ChallengeTTL is how long a login challenge waits for the second factor
+	ChallengeTTL time.Duration
+
+	Lockout LockoutPolicy
+
+	// AccessTTL is the lifetime of access tokens, for which revoked
+	// sessions stay on the revocation list
+	AccessTTL time.Duration
+	// RefreshTTL ends a session that is not refreshed for that long
+	RefreshTTL time.Duration
+
+	// APIKeyGrace is how long a rotated API key keeps working
+	APIKeyGrace time.Duration
+
+	// OIDCStateTTL is how long an OIDC login waits for the provider's redirect
+	OIDCStateTTL time.Duration
+
+	// ExportTTL is how long an export's archive can be downloaded
+	ExportTTL time.Duration
+}
+
 type userUseCase struct {
-	repo domain.UserRepository
+	repo         domain.UserRepository
+	tokens       domain.TokenIssuer
+	actionTokens domain.ActionTokenRepository
+	attempts     domain.LoginAttemptRepository
+	sessions     domain.SessionRepository
+	apiKeys      domain.APIKeyRepository
+	consents     domain.ConsentRepository
+	providers    []domain.IdentityProvider
+	jobs         domain.PrivacyJobRepository
+	sources      []domain.PersonalDataSource
+	mailer       domain.Mailer
+	events       domain.SecurityEvents
+	opts         Options
 }
 
-func NewUserUseCase(repo domain.UserRepository) domain.UserUseCase {
-	return &userUseCase{repo: repo}
+func NewUserUseCase(repo domain.UserRepository, tokens domain.TokenIssuer, actionTokens domain.ActionTokenRepository,
+	attempts domain.LoginAttemptRepository, sessions domain.SessionRepository, apiKeys domain.APIKeyRepository,
+	consents domain.ConsentRepository, providers []domain.IdentityProvider, jobs domain.PrivacyJobRepository, sources []domain.PersonalDataSource,
+	mailer domain.Mailer, events domain.SecurityEvents, opts Options) domain.UserUseCase {
+	return &userUseCase{
+		repo:         repo,
+		tokens:       tokens,
+		actionTokens: actionTokens,
+		attempts:     attempts,
+		sessions:     sessions,
+		apiKeys:      apiKeys,
+		consents:     consents,
+		providers:    providers,
+		jobs:         jobs,
+		sources:      sources,
+		mailer:       mailer,
+		events:       events,
+		opts:         opts,
+	}
 }
 
-func (uc *userUseCase) CreateUser(ctx context.Context, user *domain.User) (*domain.User, error) {
+func (uc *userUseCase) CreateUser(ctx context.Context, user *domain.User, client domain.Client) (*domain.User, error) {
+	if err := checkBirthDate(user.Profile.BirthDate); err != nil {
+		return nil, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = string(hash)
+	user.Email = domain.NormalizeEmail(user.Email)
+	if user.Role == "" {
+		user.Role = auth.RoleCustomer
+	}
 	user.CreatedAt = time.Now().Unix()
 	user.UpdatedAt = time.Now().Unix()
-	return uc.repo.CreateUser(ctx, user)
+	user.Version = 1
+	user.EmailVerified = false
+	// a consent takes effect only once recorded, which needs the user's ID
+	consent := user.MarketingConsent.Granted
+	user.MarketingConsent = domain.MarketingConsent{}
+	created, err := uc.repo.CreateUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if consent {
+		// the user is signed up either way; without a record, the consent is not given
+		updated, err := uc.setMarketingConsent(ctx, created, true, domain.ConsentSignup, client)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to record marketing consent", "user_id", created.ID.Hex(), "error", err)
+		} else {
+			created = updated
+		}
+	}
+	uc.sendVerification(ctx, created)
+	return created, nil
 }
 
-func (uc *userUseCase) GetUserByID(ctx context.Context, id string) (*domain.User, error) {
-	return uc.repo.GetUserByID(ctx, id)
+func (uc *userUseCase) GetUserByID(ctx context.Context, id string, includeDeleted bool) (*domain.User, error) {
+	return uc.repo.GetUserByID(ctx, id, includeDeleted)
 }
 
-func (uc *userUseCase) GetAllUsers(ctx context.Context) ([]*domain.User, error) {
-	return uc.repo.GetAllUsers(ctx)
+func (uc *userUseCase) GetAllUsers(ctx context.Context, filter domain.UserFilter) ([]*domain.User, string, error) {
+	limit := filter.Limit
+	if limit > 0 {
+		// one extra user tells whether there is a next page
+		filter.Limit++
+	}
+	users, err := uc.repo.GetAllUsers(ctx, filter)
+	if err != nil {
+		return nil, "", err
+	}
+	if limit > 0 && len(users) > limit {
+		users = users[:limit]
+		return users, users[limit-1].ID.Hex(), nil
+	}
+	return users, "", nil
 }
 
-func (uc *userUseCase) UpdateUser(ctx context.Context, id string, user *domain.User) (*domain.User, error) {
+func (uc *userUseCase) UpdateUser(ctx context.Context, id string, version int64, user *domain.User, currentPassword string) (*domain.User, error) {
+	current, err := uc.repo.GetUserByID(ctx, id, false)
+	if err != nil {
+		return nil, err
+	}
+
 	user.UpdatedAt = time.Now().Unix()
-	return uc.repo.UpdateUser(ctx, id, user)
+	user.Email = domain.NormalizeEmail(user.Email)
+	emailChanged := user.Email != current.Email
+	if emailChanged {
+		if err := confirmEmailChange(ctx, current, currentPassword); err != nil {
+			return nil, err
+		}
+	}
+	user.EmailVerified = current.EmailVerified && !emailChanged
+
+	updated, err := uc.repo.UpdateUser(ctx, id, version, user)
+	if err != nil {
+		return nil, err
+	}
+	if emailChanged {
+		uc.sendVerification(ctx, updated)
+	}
+	return updated, nil
+}
+
+// PatchUser writes even an empty patch, so that it too checks and bumps the version
+func (uc *userUseCase) PatchUser(ctx context.Context, id string, version int64, fields map[string]any, currentPassword string) (*domain.User, error) {
+	fields["updated_at"] = time.Now().Unix()
+	email, emailChanged := fields["email"].(string)
+	if emailChanged {
+		current, err := uc.repo.GetUserByID(ctx, id, false)
+		if err != nil {
+			return nil, err
+		}
+		if err := confirmEmailChange(ctx, current, currentPassword); err != nil {
+			return nil, err
+		}
+		fields["email"] = domain.NormalizeEmail(email)
+		fields["email_verified"] = false
+	}
+
+	updated, err := uc.repo.PatchUser(ctx, id, version, fields)
+	if err != nil {
+		return nil, err
+	}
+	if emailChanged {
+		uc.sendVerification(ctx, updated)
+	}
+	return updated, nil
+}
+
+// confirmEmailChange checks the current password of a user changing their
+// own email. Whoever controls the email can reset the password, so a
+// stolen token must not be enough to move it. Admins, and internal callers
+// with no identity, are trusted.
+func confirmEmailChange(ctx context.Context, user *domain.User, currentPassword string) error {
+	caller, ok := auth.FromContext(ctx)
+	if !ok || caller.Role == auth.RoleAdmin {
+		return nil
+	}
+	if currentPassword == "" || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)) != nil {
+		return domain.ErrPasswordRequired
+	}
+	return nil
+}
+
+func (uc *userUseCase) DeleteUser(ctx context.Context, id string, version int64) error {
+	var deletedBy string
+	if caller, ok := auth.FromContext(ctx); ok {
+		deletedBy = caller.UserID
+	}
+	if err := uc.repo.DeleteUser(ctx, id, version, deletedBy); err != nil {
+		return err
+	}
+	return uc.RevokeSessions(ctx, id, domain.RevokedUserDeleted)
+}
+
+func (uc *userUseCase) RestoreUser(ctx context.Context, id string, version int64) (*domain.User, error) {
+	return uc.repo.RestoreUser(ctx, id, version)
+}
+
+func (uc *userUseCase) Login(ctx context.Context, email, password string, client domain.Client) (*domain.LoginResult, error) {
+	email, ip := domain.NormalizeEmail(email), client.IP
+	keys := uc.attemptKeys(email, ip)
+	if err := uc.checkLock(ctx, email, ip, keys); err != nil {
+		return nil, err
+	}
+
+	user, err := uc.repo.GetUserByEmail(ctx, email)
+	if errors.Is(err, domain.ErrNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return nil, uc.loginFailed(ctx, email, ip, "unknown_email", keys)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return nil, uc.loginFailed(ctx, email, ip, "wrong_password", keys)
+	}
+	return uc.completeLogin(ctx, user, client)
+}
+
+// completeLogin follows a first factor, a password or an OIDC login, with
+// a challenge for the second one or else a new session
+func (uc *userUseCase) completeLogin(ctx context.Context, user *domain.User, client domain.Client) (*domain.LoginResult, error) {
+	if uc.opts.RequireVerifiedEmail && !user.EmailVerified {
+		return nil, domain.ErrEmailNotVerified
+	}
+
+	switch {
+	case user.TOTPEnabled:
+		return uc.challenge(ctx, user, domain.PurposeTOTPLogin, domain.ChallengeTOTP)
+	case slices.Contains(uc.opts.TOTPRoles, user.Role):
+		return uc.challenge(ctx, user, domain.PurposeTOTPEnroll, domain.ChallengeEnrollment)
+	}
+
+	if err := uc.loginSucceeded(ctx, user.Email); err != nil {
+		return nil, err
+	}
+	token, err := uc.startSession(ctx, user, client)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.LoginResult{Token: token}, nil
+}
+
+func (uc *userUseCase) VerifyEmail(ctx context.Context, token string) error {
+	t, err := uc.actionTokens.ConsumeToken(ctx, domain.PurposeVerifyEmail, hashToken(token))
+	if err != nil {
+		return err
+	}
+
+	user, err := uc.repo.GetUserByID(ctx, t.UserID, false)
+	if errors.Is(err, domain.ErrNotFound) {
+		return domain.ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+	// the link is for an address the user no longer has
+	if user.Email != t.Email {
+		return domain.ErrInvalidToken
+	}
+	if user.EmailVerified {
+		return nil
+	}
+
+	_, err = uc.repo.PatchUser(ctx, t.UserID, domain.AnyVersion, map[string]any{
+		"email_verified": true,
+		"updated_at":     time.Now().Unix(),
+	})
+	return err
+}
+
+func (uc *userUseCase) ResendVerification(ctx context.Context, email string) error {
+	user, err := uc.repo.GetUserByEmail(ctx, domain.NormalizeEmail(email))
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !user.EmailVerified {
+		uc.sendVerification(ctx, user)
+	}
+	return nil
+}
+
+func (uc *userUseCase) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := uc.repo.GetUserByEmail(ctx, domain.NormalizeEmail(email))
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := uc.issueToken(ctx, user, domain.PurposeResetPassword, uc.opts.ResetTTL)
+	if err != nil {
+		return err
+	}
+	uc.send(ctx, domain.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nTo choose a new password, open this link within %s:\n\n%s\n\n"+
+			"If you did not ask to reset your password, ignore this email.\n",
+			user.Name, within(uc.opts.ResetTTL), uc.link("/reset-password", token)),
+	})
+	return nil
+}
+
+func (uc *userUseCase) ResetPassword(ctx context.Context, token, password string) error {
+	t, err := uc.actionTokens.ConsumeToken(ctx, domain.PurposeResetPassword, hashToken(token))
+	if err != nil {
+		return err
+	}
+
+	user, err := uc.repo.GetUserByID(ctx, t.UserID, false)
+	if errors.Is(err, domain.ErrNotFound) {
+		return domain.ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	fields := map[string]any{
+		"password":   string(hash),
+		"updated_at": time.Now().Unix(),
+	}
+	// receiving the link proves the user owns the address it was sent to
+	if user.Email == t.Email {
+		fields["email_verified"] = true
+	}
+	if _, err := uc.repo.PatchUser(ctx, t.UserID, domain.AnyVersion, fields); err != nil {
+		return err
+	}
+	// whoever was locked out by failed guesses can log in with the new password
+	if err := uc.loginSucceeded(ctx, user.Email); err != nil {
+		return err
+	}
+	// the reset may be because the old password leaked: log out everywhere
+	if err := uc.RevokeSessions(ctx, t.UserID, domain.RevokedPasswordReset); err != nil {
+		return err
+	}
+
+	return uc.actionTokens.DeleteTokens(ctx, t.UserID, domain.PurposeResetPassword)
+}
+
+// sendVerification mails user a link to verify their address. Failures are
+// logged rather than returned: the account change has already been made,
+// and the user can ask for another link.
+func (uc *userUseCase) sendVerification(ctx context.Context, user *domain.User) {
+	token, err := uc.issueToken(ctx, user, domain.PurposeVerifyEmail, uc.opts.VerifyTTL)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to issue verification token", "error", err)
+		return
+	}
+	uc.send(ctx, domain.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening this link within %s:\n\n%s\n",
+			user.Name, within(uc.opts.VerifyTTL), uc.link("/verify-email", token)),
+	})
+}
+
+// send delivers msg, logging failures so that responses do not reveal
+// whether an address exists
+func (uc *userUseCase) send(ctx context.Context, msg domain.Message) {
+	if err := uc.mailer.Send(ctx, msg); err != nil {
+		slog.ErrorContext(ctx, "failed to send email", "subject", msg.Subject, "error", err)
+	}
+}
+
+// issueToken stores a new token for user and returns the secret to mail
+func (uc *userUseCase) issueToken(ctx context.Context, user *domain.User, purpose string, ttl time.Duration) (string, error) {
+	token, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = uc.actionTokens.CreateToken(ctx, &domain.ActionToken{
+		Hash:      hashToken(token),
+		Purpose:   purpose,
+		UserID:    user.ID.Hex(),
+		Email:     user.Email,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (uc *userUseCase) link(path, token string) string {
+	return uc.opts.AppURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// within spells out a token lifetime for an email
+func within(d time.Duration) string {
+	n, unit := int64(d/time.Minute), "minute"
+	if d >= time.Hour && d%time.Hour == 0 {
+		n, unit = int64(d/time.Hour), "hour"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
+
+// newSecret returns 256 random bits, encoded for use in URLs
+func newSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
 }
 
-func (uc *userUseCase) DeleteUser(ctx context.Context, id string) error {
-	return uc.repo.DeleteUser(ctx, id)
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"user-ms/pkg/oidc"
+
+	"shared/auth"
+	sharedconfig "shared/config"
+	"shared/logging"
+	"shared/server"
+	"shared/tracing"
+)
+
+// Config holds the user-ms settings, see shared/config for how they are loaded
+type Config struct {
+	Port     string `env:"PORT" default:"8081"`
+	GRPCPort string `env:"GRPC_PORT" default:"9091"`
+	MongoURI string `env:"MONGO_URI" default:"mongodb://localhost:27017" secret:"true"`
+	DBName   string `env:"DB_NAME" default:"userdb"`
+
+	// JWTSecret signs the access tokens and must match the gateway's
+	JWTSecret string        `env:"JWT_SECRET" required:"true" secret:"true"`
+	JWTTTL    time.Duration `env:"JWT_TTL" default:"1h"`
+	// RefreshTokenTTL ends a session that is not refreshed for that long
+	RefreshTokenTTL time.Duration `env:"REFRESH_TOKEN_TTL" default:"720h"`
+	// APIKeyRotationGrace is how long a rotated API key keeps working
+	APIKeyRotationGrace time.Duration `env:"API_KEY_ROTATION_GRACE" default:"24h"`
+
+	// MailSender is smtp, or memory to keep emails in the process
+	MailSender   string        `env:"MAIL_SENDER" default:"smtp"`
+	MailFrom     string        `env:"MAIL_FROM" default:"no-reply@ecommerce.local"`
+	SMTPAddr     string        `env:"SMTP_ADDR" default:"localhost:1025"`
+	SMTPUsername string        `env:"SMTP_USERNAME"`
+	SMTPPassword string        `env:"SMTP_PASSWORD" secret:"true"`
+	SMTPTimeout  time.Duration `env:"SMTP_TIMEOUT" default:"10s"`
+	// AppURL prefixes the verification and reset links in emails
+	AppURL         string        `env:"APP_URL" default:"http://localhost:8080"`
+	VerifyTokenTTL time.Duration `env:"VERIFY_TOKEN_TTL" default:"24h"`
+	ResetTokenTTL  time.Duration `env:"RESET_TOKEN_TTL" default:"1h"`
+	// RequireVerifiedEmail refuses logins until the user verified their email
+	RequireVerifiedEmail bool `env:"REQUIRE_VERIFIED_EMAIL" default:"false"`
+
+	// TOTPRoles must use two-factor authentication; "none" requires it of nobody
+	TOTPRoles    []string      `env:"REQUIRE_2FA_ROLES" default:"admin"`
+	TOTPIssuer   string        `env:"TOTP_ISSUER" default:"ecommerce-ms"`
+	ChallengeTTL time.Duration `env:"LOGIN_CHALLENGE_TTL" default:"5m"`
+
+	// Failed login lockout, per email and per client IP
+	LoginMaxFailures   int           `env:"LOGIN_MAX_FAILURES" default:"5"`
+	LoginMaxIPFailures int           `env:"LOGIN_MAX_IP_FAILURES" default:"20"`
+	LoginFailureWindow time.Duration `env:"LOGIN_FAILURE_WINDOW" default:"15m"`
+	LoginLockout       time.Duration `env:"LOGIN_LOCKOUT" default:"5m"`
+	LoginMaxLockout    time.Duration `env:"LOGIN_MAX_LOCKOUT" default:"24h"`
+	LoginDelay         time.Duration `env:"LOGIN_DELAY" default:"250ms"`
+	LoginMaxDelay      time.Duration `env:"LOGIN_MAX_DELAY" default:"4s"`
+
+	// OIDCProviders is a JSON list of the OIDC providers users can log in
+	// with, see oidc.Config; a provider without redirect_url gets OIDCRedirectURL
+	OIDCProviders oidc.Configs  `env:"OIDC_PROVIDERS" secret:"true"`
+	OIDCStateTTL  time.Duration `env:"OIDC_STATE_TTL" default:"10m"`
+	OIDCTimeout   time.Duration `env:"OIDC_TIMEOUT" default:"10s"`
+
+	// Exports and erasures reach the order, payment and product services
+	// for their records about a user
+	OrderURL        string        `env:"ORDER_SERVICE_URL" default:"http://localhost:8083"`
+	PaymentURL      string        `env:"PAYMENT_SERVICE_URL" default:"http://localhost:8084"`
+	ProductURL      string        `env:"PRODUCT_SERVICE_URL" default:"http://localhost:8082"`
+	UpstreamTimeout time.Duration `env:"UPSTREAM_TIMEOUT" default:"30s"`
+	// PrivacyJobInterval is how often the worker looks for queued jobs
+	PrivacyJobInterval time.Duration `env:"PRIVACY_JOB_INTERVAL" default:"5s"`
+	// ExportTTL is how long an export can be downloaded
+	ExportTTL time.Duration `env:"EXPORT_TTL" default:"168h"`
+
+	Service auth.ServiceConfig
+	Log     logging.Config
+	Tracing tracing.Config
+	Server  server.Config
+}
+
+func (c Config) Validate() error {
+	if c.MailSender != "smtp" && c.MailSender != "memory" {
+		return fmt.Errorf("MAIL_SENDER must be smtp or memory, got %q", c.MailSender)
+	}
+	if c.LoginMaxFailures < 1 || c.LoginMaxIPFailures < 1 {
+		return fmt.Errorf("LOGIN_MAX_FAILURES and LOGIN_MAX_IP_FAILURES must be at least 1")
+	}
+	if c.RefreshTokenTTL <= c.JWTTTL {
+		return fmt.Errorf("REFRESH_TOKEN_TTL must be longer than JWT_TTL")
+	}
+	for _, role := range c.TOTPRoles {
+		if role != auth.RoleCustomer && role != auth.RoleAdmin && role != "none" {
+			return fmt.Errorf("REQUIRE_2FA_ROLES: unknown role %q", role)
+		}
+	}
+	return nil
+}
+
+// OIDCRedirectURL is the default callback of a provider, on the gateway
+func (c Config) OIDCRedirectURL(provider string) string {
+	return strings.TrimSuffix(c.AppURL, "/") + "/api/auth/oidc/" + provider + "/callback"
+}
+
+// OIDC returns the configured providers with their redirect URLs filled in
+func (c Config) OIDC() oidc.Configs {
+	providers := make(oidc.Configs, len(c.OIDCProviders))
+	for i, p := range c.OIDCProviders {
+		if p.RedirectURL == "" {
+			p.RedirectURL = c.OIDCRedirectURL(p.Name)
+		}
+		providers[i] = p
+	}
+	return providers
+}
+
+// Load reads the configuration. It exits listing every invalid setting, or
+// after printing the configuration when started with --print-config.
+func Load() Config {
+	var cfg Config
+	sharedconfig.MustLoad(&cfg)
+	return cfg
+}
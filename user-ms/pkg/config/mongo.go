@@ -2,26 +2,26 @@ package config
 
 import (
 	"context"
-	"log"
-	"os"
-	"time"
+
+	"shared/health"
+	"shared/logging"
+	"shared/metrics"
+	"shared/tracing"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func ConnectMongo() *mongo.Client {
-	mongoURI := os.Getenv("MONGODB_URI")
-	if mongoURI == "" {
-		mongoURI = "mongodb://localhost:27017" // default for local dev
+// ConnectMongo connects to MongoDB and blocks until the server answers a
+// ping, retrying with backoff. It only gives up once ctx is cancelled.
+func ConnectMongo(ctx context.Context, uri string) *mongo.Client {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetMonitor(tracing.MongoMonitor(metrics.MongoMonitor())))
+	if err != nil {
+		logging.Fatal("Invalid MongoDB configuration", "error", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
-	if err != nil {
-		log.Fatal("Failed to connect to MongoDB:", err)
+	if err := health.WaitFor(ctx, "mongo", health.Mongo(client)); err != nil {
+		logging.Fatal("Failed to connect to MongoDB", "error", err)
 	}
 
 	return client
@@ -0,0 +1,234 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID = "shop"
+	testNonce    = "n-0S6_WzA2Mj"
+	testKid      = "key-1"
+)
+
+// testProvider serves a key set with key under testKid and counts its fetches
+type testProvider struct {
+	*Provider
+	meta    *metadata
+	key     *rsa.PrivateKey
+	fetches atomic.Int32
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := &testProvider{key: key}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tp.fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testKid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(srv.Close)
+
+	tp.meta = &metadata{Issuer: "https://id.example.com", JWKSURI: srv.URL + "/jwks"}
+	tp.Provider = NewProvider(Config{Name: "test", Issuer: tp.meta.Issuer, ClientID: testClientID}, srv.Client())
+	return tp
+}
+
+// claims returns valid ID token claims, changed by change
+func (tp *testProvider) claims(change func(jwt.MapClaims)) jwt.MapClaims {
+	c := jwt.MapClaims{
+		"iss":            tp.meta.Issuer,
+		"sub":            "248289761001",
+		"aud":            testClientID,
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          testNonce,
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"name":           "Jane Doe",
+	}
+	if change != nil {
+		change(c)
+	}
+	return c
+}
+
+func (tp *testProvider) sign(t *testing.T, claims jwt.MapClaims, kid string, key *rsa.PrivateKey) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestVerify(t *testing.T) {
+	tp := newTestProvider(t)
+	raw := tp.sign(t, tp.claims(nil), testKid, tp.key)
+
+	claims, err := tp.verify(context.Background(), tp.meta, raw, testNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Claims{Subject: "248289761001", Email: "jane@example.com", EmailVerified: true, Name: "Jane Doe"}
+	if *claims != want {
+		t.Errorf("claims %+v, want %+v", *claims, want)
+	}
+}
+
+func TestVerifyNonce(t *testing.T) {
+	tp := newTestProvider(t)
+	tests := []struct {
+		name       string
+		tokenNonce any // nil leaves the claim out
+		nonce      string
+	}{
+		{"another login's nonce", "n-other", testNonce},
+		{"no nonce in the token", nil, testNonce},
+		{"empty nonce in the token", "", testNonce},
+		{"token nonce, none expected", testNonce, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := tp.sign(t, tp.claims(func(c jwt.MapClaims) {
+				if tt.tokenNonce == nil {
+					delete(c, "nonce")
+				} else {
+					c["nonce"] = tt.tokenNonce
+				}
+			}), testKid, tp.key)
+			if _, err := tp.verify(context.Background(), tp.meta, raw, tt.nonce); !errors.Is(err, ErrProvider) {
+				t.Errorf("verify error = %v, want ErrProvider", err)
+			}
+		})
+	}
+}
+
+func TestVerifyAudience(t *testing.T) {
+	tp := newTestProvider(t)
+	tests := []struct {
+		name string
+		aud  any // nil leaves the claim out
+		ok   bool
+	}{
+		{"our client", testClientID, true},
+		{"list with our client", []string{"other", testClientID}, true},
+		{"another client", "other", false},
+		{"list without our client", []string{"other", "third"}, false},
+		{"no audience", nil, false},
+		{"empty audience", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := tp.sign(t, tp.claims(func(c jwt.MapClaims) {
+				if tt.aud == nil {
+					delete(c, "aud")
+				} else {
+					c["aud"] = tt.aud
+				}
+			}), testKid, tp.key)
+			_, err := tp.verify(context.Background(), tp.meta, raw, testNonce)
+			if tt.ok && err != nil {
+				t.Errorf("verify: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrProvider) {
+				t.Errorf("verify error = %v, want ErrProvider", err)
+			}
+		})
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	tp := newTestProvider(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs256 := jwt.NewWithClaims(jwt.SigningMethodHS256, tp.claims(nil))
+	hs256.Header["kid"] = testKid
+	// the public key as an HMAC secret, the classic algorithm confusion
+	confused, _ := hs256.SignedString(tp.key.N.Bytes())
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, tp.claims(nil)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"another issuer", tp.sign(t, tp.claims(func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }), testKid, tp.key)},
+		{"expired", tp.sign(t, tp.claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() }), testKid, tp.key)},
+		{"no expiry", tp.sign(t, tp.claims(func(c jwt.MapClaims) { delete(c, "exp") }), testKid, tp.key)},
+		{"no subject", tp.sign(t, tp.claims(func(c jwt.MapClaims) { delete(c, "sub") }), testKid, tp.key)},
+		{"signed by another key", tp.sign(t, tp.claims(nil), testKid, otherKey)},
+		{"unknown key", tp.sign(t, tp.claims(nil), "key-2", tp.key)},
+		{"HS256", confused},
+		{"alg none", none},
+		{"malformed", "not.a.token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tp.verify(context.Background(), tp.meta, tt.raw, testNonce); !errors.Is(err, ErrProvider) {
+				t.Errorf("verify error = %v, want ErrProvider", err)
+			}
+		})
+	}
+}
+
+func TestVerifyLeeway(t *testing.T) {
+	tp := newTestProvider(t)
+	raw := tp.sign(t, tp.claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-30 * time.Second).Unix() }), testKid, tp.key)
+	if _, err := tp.verify(context.Background(), tp.meta, raw, testNonce); err != nil {
+		t.Errorf("token expired 30s ago, within the leeway: %v", err)
+	}
+}
+
+// TestVerifyKeyRefetch checks that unknown keys refetch the key set at
+// most once a minute
+func TestVerifyKeyRefetch(t *testing.T) {
+	tp := newTestProvider(t)
+	ctx := context.Background()
+
+	for range 3 {
+		raw := tp.sign(t, tp.claims(nil), "key-2", tp.key)
+		tp.verify(ctx, tp.meta, raw, testNonce)
+	}
+	if n := tp.fetches.Load(); n != 1 {
+		t.Errorf("key set fetched %d times for unknown keys, want 1", n)
+	}
+
+	raw := tp.sign(t, tp.claims(nil), testKid, tp.key)
+	if _, err := tp.verify(ctx, tp.meta, raw, testNonce); err != nil {
+		t.Fatal(err)
+	}
+	if n := tp.fetches.Load(); n != 1 {
+		t.Errorf("key set fetched %d times, want the known key from the cache", n)
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	// RFC 7636 Appendix B
+	if got := Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("Challenge = %s", got)
+	}
+}